@@ -0,0 +1,49 @@
+package z80
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	z80asm "github.com/kiltum/emuz80/z80asm"
+)
+
+// asm assembles instructions separated by ';' or newlines at addr, writes
+// them to memory and returns the addresses of their labels. Labels need a
+// colon, as in "loop: DJNZ loop". PC is left alone.
+func asm(t *testing.T, mem Memory, addr uint16, source string) map[string]uint16 {
+	t.Helper()
+	lines := []string{fmt.Sprintf("ORG $%04X", addr)}
+	for _, line := range strings.FieldsFunc(source, func(r rune) bool { return r == ';' || r == '\n' }) {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	p, err := z80asm.New().Assemble("inline.asm", "\t"+strings.Join(lines, "\n\t"))
+	if err != nil {
+		t.Fatalf("asm %q: %v", source, err)
+	}
+	for _, s := range p.Segments {
+		for i, b := range s.Data {
+			mem.WriteByte(s.Address+uint16(i), b)
+		}
+	}
+	symbols := make(map[string]uint16, len(p.Symbols))
+	for name, value := range p.Symbols {
+		symbols[name] = uint16(value)
+	}
+	return symbols
+}
+
+// TestAsmHelper tests the inline assembler helper
+func TestAsmHelper(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	cpu.B = 3
+	symbols := asm(t, mem, 0x0100, "LD A,5; loop: ADD A,B; DJNZ loop\nHALT")
+	assertEq(t, symbols["loop"], uint16(0x0102), "loop address")
+	assertEq(t, mem.ReadByte(0x0100), byte(0x3E), "LD A,n opcode")
+
+	cpu.PC = 0x0100
+	for !cpu.HALT {
+		mustStep(t, cpu)
+	}
+	assertEq(t, cpu.A, byte(5+3+2+1), "A after loop")
+}
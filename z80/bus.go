@@ -0,0 +1,182 @@
+// Package z80 implements a Z80 CPU emulator with support for all documented
+// and undocumented opcodes, flags, and registers.
+package z80
+
+// CycleType identifies the kind of machine cycle reported to a Bus
+type CycleType byte
+
+// Cycle* constants enumerate the machine cycles the CPU performs
+const (
+	CycleFetch        CycleType = iota // M1 opcode fetch (4 T-states)
+	CycleRead                          // Memory read (3 T-states)
+	CycleWrite                         // Memory write (3 T-states)
+	CycleIORead                        // I/O port read (4 T-states)
+	CycleIOWrite                       // I/O port write (4 T-states)
+	CycleInternal                      // Internal operation, address left on the bus
+	CycleInterruptAck                  // Interrupt acknowledge M1 cycle (6 T-states, 7 in IM 1 and IM 2)
+)
+
+// String returns a short name for the cycle type
+func (t CycleType) String() string {
+	switch t {
+	case CycleFetch:
+		return "M1"
+	case CycleRead:
+		return "MR"
+	case CycleWrite:
+		return "MW"
+	case CycleIORead:
+		return "PR"
+	case CycleIOWrite:
+		return "PW"
+	case CycleInternal:
+		return "INT"
+	case CycleInterruptAck:
+		return "ACK"
+	default:
+		return "??"
+	}
+}
+
+// Cycle describes a single machine cycle performed by the CPU
+type Cycle struct {
+	Type    CycleType // Kind of machine cycle
+	TState  int       // T-state offset from the start of the current instruction
+	Address uint16    // Memory address or port placed on the address bus
+	Data    byte      // Byte transferred on the data bus, 0 for internal cycles
+	Length  int       // Number of T-states the cycle takes
+	Wait    int       // Wait states inserted by contention on top of Length
+	Operand bool      // Read of an operand or displacement of the current instruction
+}
+
+// Bus receives every machine cycle performed by the CPU. It is optional:
+// data always flows through the Memory and IO interfaces, the Bus only
+// observes when and where each access happens.
+type Bus interface {
+	Cycle(c Cycle)
+}
+
+// TState returns the number of T-states elapsed since the start of the
+// instruction currently being executed
+func (cpu *CPU) TState() int {
+	return cpu.tstate
+}
+
+// cycle advances the T-state counter past a machine cycle and its wait
+// states and reports the cycle to the bus
+func (cpu *CPU) cycle(kind CycleType, address uint16, data byte, length, wait int) {
+	cpu.report(Cycle{Type: kind, Address: address, Data: data, Length: length, Wait: wait})
+}
+
+// report stamps a machine cycle with the current T-state, passes it to the
+// bus and advances the T-state counter past it
+func (cpu *CPU) report(c Cycle) {
+	c.TState = cpu.tstate
+	if cpu.Bus != nil {
+		cpu.Bus.Cycle(c)
+	}
+	cpu.tstate += c.Length + c.Wait
+	cpu.wait += c.Wait
+}
+
+// contendMemory returns the wait states inserted before a memory access
+func (cpu *CPU) contendMemory(address uint16) int {
+	if cpu.Contention == nil {
+		return 0
+	}
+	return cpu.Contention.ContendMemory(address, cpu.tstate)
+}
+
+// getIR returns the refresh address (I in the high byte, R in the low byte)
+func (cpu *CPU) getIR() uint16 {
+	return (uint16(cpu.I) << 8) | uint16(cpu.R)
+}
+
+// readByte performs a memory read cycle
+func (cpu *CPU) readByte(address uint16) byte {
+	wait := cpu.contendMemory(address)
+	value := cpu.Memory.ReadByte(address)
+	cpu.cycle(CycleRead, address, value, 3, wait)
+	return value
+}
+
+// writeByte performs a memory write cycle
+func (cpu *CPU) writeByte(address uint16, value byte) {
+	wait := cpu.contendMemory(address)
+	cpu.Memory.WriteByte(address, value)
+	cpu.cycle(CycleWrite, address, value, 3, wait)
+}
+
+// readInstructionByte reads the byte at PC and advances PC. While an IM 0
+// instruction is injected the byte comes from the interrupting device and PC
+// is left alone.
+func (cpu *CPU) readInstructionByte() byte {
+	wait := cpu.contendMemory(cpu.PC)
+	var value byte
+	if cpu.injecting {
+		value = cpu.acknowledgeByte()
+	} else {
+		value = cpu.Memory.ReadByte(cpu.PC)
+	}
+	cpu.report(Cycle{Type: CycleRead, Address: cpu.PC, Data: value, Length: 3, Wait: wait, Operand: true})
+	if !cpu.injecting {
+		cpu.PC++
+	}
+	return value
+}
+
+// acknowledgeByte returns the next byte the interrupting device puts on the bus
+func (cpu *CPU) acknowledgeByte() byte {
+	if device, ok := cpu.IO.(InterruptAcknowledger); ok {
+		return device.AcknowledgeInterrupt()
+	}
+	return 0xFF
+}
+
+// readWord performs two memory read cycles, low byte first
+func (cpu *CPU) readWord(address uint16) uint16 {
+	lo := cpu.readByte(address)
+	hi := cpu.readByte(address + 1)
+	return (uint16(hi) << 8) | uint16(lo)
+}
+
+// writeWord performs two memory write cycles, low byte first
+func (cpu *CPU) writeWord(address uint16, value uint16) {
+	cpu.writeByte(address, byte(value))
+	cpu.writeByte(address+1, byte(value>>8))
+}
+
+// readPort performs an I/O read cycle
+func (cpu *CPU) readPort(port uint16) byte {
+	wait := cpu.contendPort(port)
+	value := cpu.IO.ReadPort(port)
+	cpu.cycle(CycleIORead, port, value, 4, wait)
+	return value
+}
+
+// writePort performs an I/O write cycle
+func (cpu *CPU) writePort(port uint16, value byte) {
+	wait := cpu.contendPort(port)
+	cpu.IO.WritePort(port, value)
+	cpu.cycle(CycleIOWrite, port, value, 4, wait)
+}
+
+// contendPort returns the wait states inserted into an I/O cycle
+func (cpu *CPU) contendPort(port uint16) int {
+	if cpu.Contention == nil {
+		return 0
+	}
+	return cpu.Contention.ContendPort(port, cpu.tstate)
+}
+
+// internal performs n T-states of internal operation with address on the bus.
+// Each T-state is contended on its own, as the address stays on the bus.
+func (cpu *CPU) internal(address uint16, n int) {
+	wait := 0
+	if cpu.Contention != nil {
+		for i := 0; i < n; i++ {
+			wait += cpu.Contention.ContendMemory(address, cpu.tstate+wait+i)
+		}
+	}
+	cpu.cycle(CycleInternal, address, 0, n, wait)
+}
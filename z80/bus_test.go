@@ -0,0 +1,142 @@
+package z80
+
+import "testing"
+
+// recordingBus collects every machine cycle reported by the CPU.
+type recordingBus struct {
+	cycles []Cycle
+}
+
+func (b *recordingBus) Cycle(c Cycle) { b.cycles = append(b.cycles, c) }
+
+func (b *recordingBus) total() int {
+	n := 0
+	for _, c := range b.cycles {
+		n += c.Length + c.Wait
+	}
+	return n
+}
+
+// Every machine cycle of INC (HL) is reported with its T-state offset.
+func TestBus_IncHLCycles(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	cpu.SetHL(0x4000)
+	mem.WriteByte(0x4000, 0x7F)
+	loadProgram(cpu, mem, 0x0000, 0x34) // INC (HL)
+	c := mustStep(t, cpu)
+
+	want := []Cycle{
+		{Type: CycleFetch, TState: 0, Address: 0x0000, Data: 0x34, Length: 4},
+		{Type: CycleRead, TState: 4, Address: 0x4000, Data: 0x7F, Length: 3},
+		{Type: CycleInternal, TState: 7, Address: 0x4000, Length: 1},
+		{Type: CycleWrite, TState: 8, Address: 0x4000, Data: 0x80, Length: 3},
+	}
+	if len(bus.cycles) != len(want) {
+		t.Fatalf("got %d cycles, want %d: %+v", len(bus.cycles), len(want), bus.cycles)
+	}
+	for i := range want {
+		assertEq(t, bus.cycles[i], want[i], "cycle")
+	}
+	assertEq(t, c, 11, "INC (HL) cycles")
+}
+
+// PUSH writes the high byte first and is preceded by an internal cycle on IR.
+func TestBus_PushOrder(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	cpu.SP = 0x8000
+	cpu.I = 0x3F
+	cpu.SetBC(0x1234)
+	loadProgram(cpu, mem, 0x0000, 0xC5) // PUSH BC
+	mustStep(t, cpu)
+
+	if len(bus.cycles) != 4 {
+		t.Fatalf("got %d cycles, want 4: %+v", len(bus.cycles), bus.cycles)
+	}
+	assertEq(t, bus.cycles[1], Cycle{Type: CycleInternal, TState: 4, Address: 0x3F01, Length: 1}, "IR cycle")
+	assertEq(t, bus.cycles[2], Cycle{Type: CycleWrite, TState: 5, Address: 0x7FFF, Data: 0x12, Length: 3}, "high byte")
+	assertEq(t, bus.cycles[3], Cycle{Type: CycleWrite, TState: 8, Address: 0x7FFE, Data: 0x34, Length: 3}, "low byte")
+}
+
+// OUT (n),A reports an I/O write after the operand read.
+func TestBus_OutCycles(t *testing.T) {
+	cpu, mem, io := testCPU()
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	cpu.A = 0x5A
+	loadProgram(cpu, mem, 0x0000, 0xD3, 0xFE) // OUT (FE),A
+	mustStep(t, cpu)
+
+	assertEq(t, io.lastOut[0x5AFE], byte(0x5A), "port written")
+	assertEq(t, len(bus.cycles), 3, "cycle count")
+	assertEq(t, bus.cycles[2], Cycle{Type: CycleIOWrite, TState: 7, Address: 0x5AFE, Data: 0x5A, Length: 4}, "I/O cycle")
+}
+
+// Operand reads are marked apart from the data read of LD A,(nn).
+func TestBus_OperandReads(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	mem.WriteByte(0x4000, 0x99)
+	loadProgram(cpu, mem, 0x0000, 0x3A, 0x00, 0x40) // LD A,($4000)
+	mustStep(t, cpu)
+
+	want := []Cycle{
+		{Type: CycleFetch, TState: 0, Address: 0x0000, Data: 0x3A, Length: 4},
+		{Type: CycleRead, TState: 4, Address: 0x0001, Data: 0x00, Length: 3, Operand: true},
+		{Type: CycleRead, TState: 7, Address: 0x0002, Data: 0x40, Length: 3, Operand: true},
+		{Type: CycleRead, TState: 10, Address: 0x4000, Data: 0x99, Length: 3},
+	}
+	if len(bus.cycles) != len(want) {
+		t.Fatalf("got %d cycles, want %d: %+v", len(bus.cycles), len(want), bus.cycles)
+	}
+	for i := range want {
+		assertEq(t, bus.cycles[i], want[i], "cycle")
+	}
+}
+
+// The reported cycles must add up to the T-states returned for every opcode.
+func TestBus_CyclesMatchTStates(t *testing.T) {
+	prefixes := [][]byte{{}, {0xCB}, {0xDD}, {0xFD}, {0xED}, {0xDD, 0xCB, 0x01}, {0xFD, 0xCB, 0x01}}
+	for _, prefix := range prefixes {
+		for op := 0; op < 256; op++ {
+			if len(prefix) == 0 && (op == 0xCB || op == 0xDD || op == 0xED || op == 0xFD) {
+				continue
+			}
+			cpu, mem, _ := testCPU()
+			bus := &recordingBus{}
+			cpu.Bus = bus
+			cpu.SetBC(0x0102)
+			program := append(append([]byte{}, prefix...), byte(op), 0x00, 0x00)
+			loadProgram(cpu, mem, 0x0100, program...)
+			c := cpu.ExecuteOneInstruction()
+			if c != bus.total() {
+				t.Errorf("% X %02X: returned %d T-states, bus saw %d", prefix, op, c, bus.total())
+			}
+			if c != cpu.TState() {
+				t.Errorf("% X %02X: returned %d T-states, TState() is %d", prefix, op, c, cpu.TState())
+			}
+		}
+	}
+}
+
+// Interrupt acknowledge is reported as its own cycle type.
+func TestBus_InterruptAck(t *testing.T) {
+	cpu, mem, io := testCPU()
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	cpu.IM = 1
+	cpu.IFF1, cpu.IFF2 = true, true
+	cpu.SP = 0x8000
+	io.interrupt = true
+	loadProgram(cpu, mem, 0x1234, 0x00)
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 13, "IM 1 cycles")
+	assertEq(t, bus.total(), c, "bus T-states")
+	assertEq(t, bus.cycles[0].Type, CycleInterruptAck, "first cycle")
+	assertEq(t, bus.cycles[0].Address, uint16(0x1234), "ack address")
+}
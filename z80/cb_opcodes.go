@@ -14,40 +14,41 @@ func (cpu *CPU) ExecuteCBOpcode(opcode byte) int {
 		// Handle (HL) special case
 		if reg == 6 {
 			addr := cpu.GetHL()
-			value := cpu.Memory.ReadByte(addr)
+			value := cpu.readByte(addr)
+			cpu.internal(addr, 1)
 
 			switch opType {
 			case 0: // RLC
 				result := cpu.rlc(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 1: // RRC
 				result := cpu.rrc(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 2: // RL
 				result := cpu.rl(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 3: // RR
 				result := cpu.rr(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 4: // SLA
 				result := cpu.sla(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 5: // SRA
 				result := cpu.sra(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 6: // SLL (Undocumented)
 				result := cpu.sll(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			case 7: // SRL
 				result := cpu.srl(value)
-				cpu.Memory.WriteByte(addr, result)
+				cpu.writeByte(addr, result)
 				return 15
 			}
 		} else {
@@ -208,7 +209,8 @@ func (cpu *CPU) ExecuteCBOpcode(opcode byte) int {
 
 		// Handle (HL) special case
 		if reg == 6 {
-			value := cpu.Memory.ReadByte(cpu.GetHL())
+			value := cpu.readByte(cpu.GetHL())
+			cpu.internal(cpu.GetHL(), 1)
 			cpu.bitMem(bitNum, value, byte(cpu.MEMPTR>>8))
 			return 12
 		} else {
@@ -243,9 +245,10 @@ func (cpu *CPU) ExecuteCBOpcode(opcode byte) int {
 		// Handle (HL) special case
 		if reg == 6 {
 			addr := cpu.GetHL()
-			value := cpu.Memory.ReadByte(addr)
+			value := cpu.readByte(addr)
+			cpu.internal(addr, 1)
 			result := cpu.res(bitNum, value)
-			cpu.Memory.WriteByte(addr, result)
+			cpu.writeByte(addr, result)
 			return 15
 		} else {
 			// Handle regular registers
@@ -277,9 +280,10 @@ func (cpu *CPU) ExecuteCBOpcode(opcode byte) int {
 		// Handle (HL) special case
 		if reg == 6 {
 			addr := cpu.GetHL()
-			value := cpu.Memory.ReadByte(addr)
+			value := cpu.readByte(addr)
+			cpu.internal(addr, 1)
 			result := cpu.set(bitNum, value)
-			cpu.Memory.WriteByte(addr, result)
+			cpu.writeByte(addr, result)
 			return 15
 		} else {
 			// Handle regular registers
@@ -0,0 +1,115 @@
+// Package z80 implements a Z80 CPU emulator with support for all documented
+// and undocumented opcodes, flags, and registers.
+package z80
+
+// Contention lets another bus master stall the CPU. Both methods receive the
+// T-state offset from the start of the current instruction at which the
+// access begins and return the number of wait states to insert.
+type Contention interface {
+	// ContendMemory is called before every memory access and before every
+	// T-state of an internal cycle
+	ContendMemory(address uint16, tstate int) int
+	// ContendPort is called once per I/O cycle and returns the wait states
+	// inserted anywhere within it
+	ContendPort(port uint16, tstate int) int
+}
+
+// Frame timings of the ZX Spectrum models
+const (
+	Spectrum48KFrameLength  = 69888 // T-states per frame on the 48K
+	Spectrum128KFrameLength = 70908 // T-states per frame on the 128K
+)
+
+// spectrumPattern is the delay applied during each 8 T-state group in which
+// the ULA fetches screen data
+var spectrumPattern = [8]int{6, 5, 4, 3, 2, 1, 0, 0}
+
+// SpectrumContention models the ULA contention of the ZX Spectrum 48K and 128K.
+// The host keeps Frame pointing at the frame T-state at which the current
+// instruction starts, usually by calling Advance with the T-states returned
+// by ExecuteOneInstruction.
+type SpectrumContention struct {
+	Frame int  // Frame T-state at the start of the current instruction
+	Bank  byte // RAM bank paged in at 0xC000 (128K only)
+
+	frameLength int  // T-states per frame
+	lineLength  int  // T-states per scanline
+	first       int  // First contended T-state of the frame
+	banked      bool // Odd RAM banks at 0xC000 are contended
+}
+
+// NewSpectrum48KContention creates the contention model of the 48K Spectrum
+func NewSpectrum48KContention() *SpectrumContention {
+	return &SpectrumContention{
+		frameLength: Spectrum48KFrameLength,
+		lineLength:  224,
+		first:       14335,
+	}
+}
+
+// NewSpectrum128KContention creates the contention model of the 128K Spectrum
+func NewSpectrum128KContention() *SpectrumContention {
+	return &SpectrumContention{
+		frameLength: Spectrum128KFrameLength,
+		lineLength:  228,
+		first:       14361,
+		banked:      true,
+	}
+}
+
+// Advance moves Frame forward by tstates, wrapping at the end of the frame
+func (s *SpectrumContention) Advance(tstates int) {
+	s.Frame = (s.Frame + tstates) % s.frameLength
+}
+
+// Contended reports whether address lies in contended memory
+func (s *SpectrumContention) Contended(address uint16) bool {
+	if address >= 0x4000 && address < 0x8000 {
+		return true
+	}
+	return s.banked && address >= 0xC000 && s.Bank&1 == 1
+}
+
+// Delay returns the wait states for a contended access at the given frame T-state
+func (s *SpectrumContention) Delay(frame int) int {
+	t := frame%s.frameLength - s.first
+	if t < 0 || t >= 192*s.lineLength {
+		return 0
+	}
+	t %= s.lineLength
+	if t >= 128 {
+		return 0
+	}
+	return spectrumPattern[t&7]
+}
+
+// ContendMemory implements Contention
+func (s *SpectrumContention) ContendMemory(address uint16, tstate int) int {
+	if !s.Contended(address) {
+		return 0
+	}
+	return s.Delay(s.Frame + tstate)
+}
+
+// ContendPort implements Contention. The high byte of the port is contended
+// like a memory address, and even ports are additionally contended by the ULA
+// from the second T-state of the cycle.
+func (s *SpectrumContention) ContendPort(port uint16, tstate int) int {
+	t := s.Frame + tstate
+	high := s.Contended(port)
+	if high {
+		t += s.Delay(t)
+	}
+	t++
+	switch {
+	case port&1 == 0:
+		t += s.Delay(t) + 3
+	case high:
+		for i := 0; i < 3; i++ {
+			t += s.Delay(t) + 1
+		}
+	default:
+		t += 3
+	}
+	return t - (s.Frame + tstate) - 4
+}
@@ -0,0 +1,127 @@
+package z80
+
+import "testing"
+
+// The 48K ULA delays accesses with the 6,5,4,3,2,1,0,0 pattern during the
+// first 128 T-states of each of the 192 screen lines.
+func TestContention_48KPattern(t *testing.T) {
+	s := NewSpectrum48KContention()
+	want := []int{6, 5, 4, 3, 2, 1, 0, 0, 6}
+	for i, w := range want {
+		assertEq(t, s.Delay(14335+i), w, "delay in first line")
+	}
+	assertEq(t, s.Delay(14334), 0, "delay before screen")
+	assertEq(t, s.Delay(14335+128), 0, "delay in border")
+	assertEq(t, s.Delay(14335+224), 6, "delay in second line")
+	assertEq(t, s.Delay(14335+191*224+125), 1, "delay near end of last line")
+	assertEq(t, s.Delay(14335+191*224+127), 0, "delay at end of last line")
+	assertEq(t, s.Delay(14335+192*224), 0, "delay below screen")
+	assertEq(t, s.Delay(14335+Spectrum48KFrameLength), 6, "delay in next frame")
+}
+
+// Only 0x4000-0x7FFF is contended on the 48K; the 128K adds odd banks at 0xC000.
+func TestContention_Ranges(t *testing.T) {
+	s48 := NewSpectrum48KContention()
+	assertEq(t, s48.Contended(0x3FFF), false, "48K ROM")
+	assertEq(t, s48.Contended(0x4000), true, "48K screen")
+	assertEq(t, s48.Contended(0x7FFF), true, "48K top of bank 5")
+	assertEq(t, s48.Contended(0xC000), false, "48K upper RAM")
+
+	s128 := NewSpectrum128KContention()
+	s128.Bank = 0
+	assertEq(t, s128.Contended(0xC000), false, "128K bank 0")
+	s128.Bank = 7
+	assertEq(t, s128.Contended(0xC000), true, "128K bank 7")
+	assertEq(t, s128.Contended(0x8000), false, "128K bank 2")
+	assertEq(t, s128.Delay(14361), 6, "128K first contended T-state")
+	assertEq(t, s128.Delay(14361+228), 6, "128K second line")
+}
+
+// A NOP fetched from contended memory waits for the ULA and the wait states
+// are reported on the bus and included in the returned T-states.
+func TestContention_FetchWaits(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	s := NewSpectrum48KContention()
+	cpu.Contention = s
+	loadProgram(cpu, mem, 0x4000, 0x00, 0x00) // NOP; NOP
+
+	s.Frame = 14335
+	c := mustStep(t, cpu)
+	assertEq(t, c, 10, "NOP at 14335")
+	assertEq(t, bus.cycles[0], Cycle{Type: CycleFetch, TState: 0, Address: 0x4000, Length: 4, Wait: 6}, "fetch cycle")
+
+	s.Advance(c)
+	assertEq(t, s.Frame, 14345, "frame after NOP")
+	c = mustStep(t, cpu)
+	assertEq(t, c, 4+4, "NOP at 14345")
+}
+
+// Uncontended code is not delayed, even during the screen.
+func TestContention_Uncontended(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	s := NewSpectrum48KContention()
+	cpu.Contention = s
+	cpu.SetHL(0x8000)
+	loadProgram(cpu, mem, 0x9000, 0x34) // INC (HL)
+	s.Frame = 14335
+	assertEq(t, mustStep(t, cpu), 11, "INC (HL) in uncontended memory")
+}
+
+// Internal cycles are contended T-state by T-state on the address they hold.
+func TestContention_InternalCycles(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	s := NewSpectrum48KContention()
+	cpu.Contention = s
+	cpu.SetHL(0x4000)
+	loadProgram(cpu, mem, 0x8000, 0x23) // INC HL: fetch 4, internal on IR 2
+	cpu.I = 0x40
+	s.Frame = 14335 - 4
+	// First internal T-state waits 6, the second lands on the 0 of the pattern
+	assertEq(t, mustStep(t, cpu), 6+6, "INC HL with I in contended memory")
+}
+
+// Even ports are always contended by the ULA; odd ports only when the high
+// byte points to contended memory.
+func TestContention_Ports(t *testing.T) {
+	s := NewSpectrum48KContention()
+	s.Frame = 14335
+
+	assertEq(t, s.ContendPort(0x00FF, 0), 0, "odd port, uncontended")
+	// N:1, C:3 - the ULA check lands on the 5 of the pattern
+	assertEq(t, s.ContendPort(0x00FE, 0), 5, "even port, uncontended high byte")
+	// C:1, C:3 - waits 6, then the ULA check lands on a 0
+	assertEq(t, s.ContendPort(0x40FE, 0), 6, "even port, contended high byte")
+	// C:1 x4 - waits 6, then 0, 6 and 0
+	assertEq(t, s.ContendPort(0x40FF, 0), 6+6, "odd port, contended high byte")
+
+	cpu, mem, _ := testCPU()
+	cpu.Contention = s
+	cpu.A = 0x40
+	loadProgram(cpu, mem, 0x8000, 0xD3, 0xFE) // OUT (FE),A
+	s.Frame = 14335 - 7
+	assertEq(t, mustStep(t, cpu), 11+6, "OUT (FE),A with contended high byte")
+}
+
+// Wait states are counted once in the T-states returned for every opcode.
+func TestContention_CyclesMatchTStates(t *testing.T) {
+	for op := 0; op < 256; op++ {
+		if op == 0xCB || op == 0xDD || op == 0xED || op == 0xFD {
+			continue
+		}
+		cpu, mem, _ := testCPU()
+		bus := &recordingBus{}
+		cpu.Bus = bus
+		s := NewSpectrum48KContention()
+		s.Frame = 14335
+		cpu.Contention = s
+		cpu.SP = 0x6000
+		cpu.SetHL(0x5000)
+		cpu.I = 0x40
+		loadProgram(cpu, mem, 0x4000, byte(op), 0x00, 0x50)
+		c := cpu.ExecuteOneInstruction()
+		assertEq(t, c, bus.total(), "bus T-states")
+		assertEq(t, c, cpu.TState(), "TState()")
+	}
+}
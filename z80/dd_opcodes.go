@@ -8,12 +8,14 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 	// Load instructions
 	case 0x09: // ADD IX, BC
 		oldIX := cpu.IX
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IX(cpu.IX, cpu.GetBC())
 		cpu.MEMPTR = oldIX + 1
 		cpu.IX = result
 		return 15
 	case 0x19: // ADD IX, DE
 		oldIX := cpu.IX
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IX(cpu.IX, cpu.GetDE())
 		cpu.MEMPTR = oldIX + 1
 		cpu.IX = result
@@ -23,10 +25,11 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 		return 14
 	case 0x22: // LD (nn), IX
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.IX)
+		cpu.writeWord(addr, cpu.IX)
 		cpu.MEMPTR = addr + 1
 		return 20
 	case 0x23: // INC IX
+		cpu.internal(cpu.getIR(), 2)
 		cpu.IX++
 		return 10
 	case 0x24: // INC IXH
@@ -40,16 +43,18 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 		return 11
 	case 0x29: // ADD IX, IX
 		oldIX := cpu.IX
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IX(cpu.IX, cpu.IX)
 		cpu.MEMPTR = oldIX + 1
 		cpu.IX = result
 		return 15
 	case 0x2A: // LD IX, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.IX = cpu.Memory.ReadWord(addr)
+		cpu.IX = cpu.readWord(addr)
 		cpu.MEMPTR = addr + 1
 		return 20
 	case 0x2B: // DEC IX
+		cpu.internal(cpu.getIR(), 2)
 		cpu.IX--
 		return 10
 	case 0x2C: // INC IXL
@@ -68,12 +73,14 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 	case 0x36: // LD (IX+d), n
 		displacement := cpu.ReadDisplacement()
 		value := cpu.ReadImmediateByte()
+		cpu.internal(cpu.PC-1, 2)
 		addr := uint16(int32(cpu.IX) + int32(displacement))
-		cpu.Memory.WriteByte(addr, value)
+		cpu.writeByte(addr, value)
 		cpu.MEMPTR = addr
 		return 19
 	case 0x39: // ADD IX, SP
 		oldIX := cpu.IX
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IX(cpu.IX, cpu.SP)
 		cpu.MEMPTR = oldIX + 1
 		cpu.IX = result
@@ -254,18 +261,23 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 		cpu.IX = cpu.Pop()
 		return 14
 	case 0xE3: // EX (SP), IX
-		temp := cpu.Memory.ReadWord(cpu.SP)
-		cpu.Memory.WriteWord(cpu.SP, cpu.IX)
+		temp := cpu.readWord(cpu.SP)
+		cpu.internal(cpu.SP+1, 1)
+		cpu.writeByte(cpu.SP+1, byte(cpu.IX>>8))
+		cpu.writeByte(cpu.SP, byte(cpu.IX))
+		cpu.internal(cpu.SP, 2)
 		cpu.IX = temp
 		cpu.MEMPTR = temp
 		return 23
 	case 0xE5: // PUSH IX
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.IX)
 		return 15
 	case 0xE9: // JP (IX)
 		cpu.PC = cpu.IX
 		return 8
 	case 0xF9: // LD SP, IX
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SP = cpu.IX
 		return 10
 
@@ -273,17 +285,21 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 	case 0xCB: // DD CB prefix
 		return cpu.executeDDCBOpcode()
 
-	case 0xfd:
-		return 8
 	case 0x00: // Extended NOP (undocumented)
 		// DD 00 is an undocumented instruction that acts as an extended NOP
 		// It consumes the DD prefix and the 00 opcode but executes as a NOP
 		// Takes 8 cycles total (4 for DD prefix fetch + 4 for 00 opcode fetch)
 		return 8
-	case 0xdd:
+
+	case 0xDD, 0xFD: // Repeated index prefix, the first one acts as a NOP
+		// and the second one applies to the instruction fetched by the next step
+		cpu.prefix = opcode
 		return 8
+	case 0xED: // The index prefix is ignored and the ED opcode executes
+		return 4 + cpu.ExecuteEDOpcode(cpu.ReadOpcode())
 	default:
-		return cpu.ExecuteOpcode(opcode)
+		// Opcodes that do not involve HL execute unchanged after the 4 T-state prefix
+		return 4 + cpu.ExecuteOpcode(opcode)
 		//panic(fmt.Sprintf("DD unexpected code %x", opcode))
 	}
 }
@@ -291,15 +307,17 @@ func (cpu *CPU) ExecuteDDOpcode(opcode byte) int {
 // executeIncDecIndexed handles INC/DEC (IX+d) instructions
 func (cpu *CPU) executeIncDecIndexed(isInc bool) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IX) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
+	cpu.internal(addr, 1)
 	var result byte
 	if isInc {
 		result = cpu.inc8(value)
 	} else {
 		result = cpu.dec8(value)
 	}
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 	cpu.MEMPTR = addr
 	return 23
 }
@@ -307,8 +325,9 @@ func (cpu *CPU) executeIncDecIndexed(isInc bool) int {
 // executeLoadFromIndexed handles LD r, (IX+d) instructions
 func (cpu *CPU) executeLoadFromIndexed(reg byte) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IX) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
 
 	switch reg {
 	case 0:
@@ -334,8 +353,9 @@ func (cpu *CPU) executeLoadFromIndexed(reg byte) int {
 // executeStoreToIndexed handles LD (IX+d), r instructions
 func (cpu *CPU) executeStoreToIndexed(value byte) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IX) + int32(displacement))
-	cpu.Memory.WriteByte(addr, value)
+	cpu.writeByte(addr, value)
 	cpu.MEMPTR = addr
 	return 19
 }
@@ -343,8 +363,9 @@ func (cpu *CPU) executeStoreToIndexed(value byte) int {
 // executeALUIndexed handles ALU operations with (IX+d) operand
 func (cpu *CPU) executeALUIndexed(opType byte) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IX) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
 
 	switch opType {
 	case 0: // ADD
@@ -7,21 +7,15 @@ package z80
 // This file exists to satisfy the requirement of separating opcodes by prefix
 // executeDDCBOpcode executes a DD CB prefixed opcode
 func (cpu *CPU) executeDDCBOpcode() int {
-	// For DD CB prefixed instructions, R should be incremented by 2 total
-	// We've already incremented R once for the DD prefix and once for the CB prefix
-	// So we need to adjust by -1 to get the correct total increment of 2
-	originalR := cpu.R
-
+	// The displacement and the final opcode are fetched with ordinary memory
+	// reads, not M1 cycles, so R is only incremented for the DD and CB bytes
 	displacement := cpu.ReadDisplacement()
-	opcode := cpu.ReadOpcode()
-
-	// Adjust R register - DD CB instructions should increment R by 2 total
-	// We've already incremented twice (DD and CB), so we need to subtract 1
-	// to get the correct total of 2 increments
-	cpu.R = originalR
+	opcode := cpu.ReadImmediateByte()
+	cpu.internal(cpu.PC-1, 2)
 
 	addr := uint16(int32(cpu.IX) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
+	cpu.internal(addr, 1)
 
 	// Handle rotate and shift instructions (0x00-0x3F)
 	if opcode <= 0x3F {
@@ -81,7 +75,7 @@ func (cpu *CPU) executeRotateShiftIndexed(opcode byte, addr uint16, value byte)
 	}
 
 	// Store result in memory
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 
 	// Store result in register if needed (except for (HL) case)
 	if reg != 6 { // reg 6 is (HL) - no register store needed
@@ -113,7 +107,7 @@ func (cpu *CPU) executeResetBitIndexed(opcode byte, addr uint16, value byte) int
 	reg := opcode & 0x07
 
 	result := cpu.res(bitNum, value)
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 
 	// Store result in register if needed (except for (HL) case)
 	if reg != 6 { // reg 6 is (HL) - no register store needed
@@ -145,7 +139,7 @@ func (cpu *CPU) executeSetBitIndexed(opcode byte, addr uint16, value byte) int {
 	reg := opcode & 0x07
 
 	result := cpu.set(bitNum, value)
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 
 	// Store result in register if needed (except for (HL) case)
 	if reg != 6 { // reg 6 is (HL) - no register store needed
@@ -2,8 +2,6 @@
 // and undocumented opcodes, flags, and registers.
 package z80
 
-import "fmt"
-
 // ExecuteEDOpcode executes an ED-prefixed opcode and returns the number of T-states used
 func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	switch opcode {
@@ -55,12 +53,13 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	case 0x41: // OUT (C), B
 		return cpu.executeOUT(0)
 	case 0x42: // SBC HL, BC
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.sbc16WithMEMPTR(cpu.GetHL(), cpu.GetBC())
 		cpu.SetHL(result)
 		return 15
 	case 0x43: // LD (nn), BC
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.GetBC())
+		cpu.writeWord(addr, cpu.GetBC())
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -70,10 +69,11 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	case 0x45, 0x55, 0x5D, 0x65, 0x6D, 0x75, 0x7D: // RETN (various undocumented versions)
 		cpu.retn()
 		return 14
-	case 0x46, 0x4E, 0x66: // IM 0 (various undocumented versions)
+	case 0x46, 0x4E, 0x66, 0x6E: // IM 0 (various undocumented versions)
 		cpu.IM = 0
 		return 8
 	case 0x47: // LD I, A
+		cpu.internal(cpu.getIR(), 1)
 		cpu.I = cpu.A
 		return 9
 	case 0x48: // IN C, (C)
@@ -81,12 +81,13 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	case 0x49: // OUT (C), C
 		return cpu.executeOUT(1)
 	case 0x4A: // ADC HL, BC
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.adc16WithMEMPTR(cpu.GetHL(), cpu.GetBC())
 		cpu.SetHL(result)
 		return 15
 	case 0x4B: // LD BC, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.SetBC(cpu.Memory.ReadWord(addr))
+		cpu.SetBC(cpu.readWord(addr))
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -94,6 +95,7 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 		cpu.reti()
 		return 14
 	case 0x4F: // LD R, A
+		cpu.internal(cpu.getIR(), 1)
 		// R register is only 7 bits, bit 7 remains unchanged
 		//cpu.R = (cpu.R & 0x80) | (cpu.A & 0x7F)
 		cpu.R = cpu.A // fix zen80 tests
@@ -103,12 +105,13 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	case 0x51: // OUT (C), D
 		return cpu.executeOUT(2)
 	case 0x52: // SBC HL, DE
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.sbc16WithMEMPTR(cpu.GetHL(), cpu.GetDE())
 		cpu.SetHL(result)
 		return 15
 	case 0x53: // LD (nn), DE
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.GetDE())
+		cpu.writeWord(addr, cpu.GetDE())
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -116,19 +119,22 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 		cpu.IM = 1
 		return 8
 	case 0x57: // LD A, I
+		cpu.internal(cpu.getIR(), 1)
 		cpu.ldAI()
+		cpu.ldAIR = true
 		return 9
 	case 0x58: // IN E, (C)
 		return cpu.executeIN(3)
 	case 0x59: // OUT (C), E
 		return cpu.executeOUT(3)
 	case 0x5A: // ADC HL, DE
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.adc16WithMEMPTR(cpu.GetHL(), cpu.GetDE())
 		cpu.SetHL(result)
 		return 15
 	case 0x5B: // LD DE, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.SetDE(cpu.Memory.ReadWord(addr))
+		cpu.SetDE(cpu.readWord(addr))
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -136,19 +142,22 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 		cpu.IM = 2
 		return 8
 	case 0x5F: // LD A, R
+		cpu.internal(cpu.getIR(), 1)
 		cpu.ldAR()
+		cpu.ldAIR = true
 		return 9
 	case 0x60: // IN H, (C)
 		return cpu.executeIN(4)
 	case 0x61: // OUT (C), H
 		return cpu.executeOUT(4)
 	case 0x62: // SBC HL, HL
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.sbc16WithMEMPTR(cpu.GetHL(), cpu.GetHL())
 		cpu.SetHL(result)
 		return 15
 	case 0x63: // LD (nn), HL
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.GetHL())
+		cpu.writeWord(addr, cpu.GetHL())
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -160,12 +169,13 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	case 0x69: // OUT (C), L
 		return cpu.executeOUT(5)
 	case 0x6A: // ADC HL, HL
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.adc16WithMEMPTR(cpu.GetHL(), cpu.GetHL())
 		cpu.SetHL(result)
 		return 15
 	case 0x6B: // LD HL, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.SetHL(cpu.Memory.ReadWord(addr))
+		cpu.SetHL(cpu.readWord(addr))
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -189,12 +199,13 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 		cpu.MEMPTR = cpu.GetBC() + 1
 		return 12
 	case 0x72: // SBC HL, SP
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.sbc16WithMEMPTR(cpu.GetHL(), cpu.SP)
 		cpu.SetHL(result)
 		return 15
 	case 0x73: // LD (nn), SP
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.SP)
+		cpu.writeWord(addr, cpu.SP)
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
@@ -203,22 +214,21 @@ func (cpu *CPU) ExecuteEDOpcode(opcode byte) int {
 	case 0x79: // OUT (C), A
 		return cpu.executeOUT(7)
 	case 0x7A: // ADC HL, SP
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.adc16WithMEMPTR(cpu.GetHL(), cpu.SP)
 		cpu.SetHL(result)
 		return 15
 	case 0x7B: // LD SP, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.SP = cpu.Memory.ReadWord(addr)
+		cpu.SP = cpu.readWord(addr)
 		// MEMPTR = addr + 1
 		cpu.MEMPTR = addr + 1
 		return 20
-	case 0x80: // endefined NOP
-		return 8
-	case 0x6e:
-		return 8
-
 	default:
-		panic(fmt.Sprintf("ED unexpected code %x", opcode))
+		// Undefined: 00-3F, 77, 7F, 80-9F, A4-A7, AC-AF, B4-B7, BC-BF and
+		// C0-FF behave as two NOPs on real silicon
+		cpu.reportUndefined(0xED, opcode)
+		return 8
 	}
 }
 
@@ -288,8 +298,9 @@ func (cpu *CPU) executeOUT(reg byte) int {
 
 // ldi loads byte from (HL) to (DE), increments pointers, decrements BC
 func (cpu *CPU) ldi() {
-	value := cpu.Memory.ReadByte(cpu.GetHL())
-	cpu.Memory.WriteByte(cpu.GetDE(), value)
+	value := cpu.readByte(cpu.GetHL())
+	cpu.writeByte(cpu.GetDE(), value)
+	cpu.internal(cpu.GetDE(), 2)
 
 	cpu.SetDE(cpu.GetDE() + 1)
 	cpu.SetHL(cpu.GetHL() + 1)
@@ -307,7 +318,8 @@ func (cpu *CPU) ldi() {
 
 // cpi compares A with (HL), increments HL, decrements BC
 func (cpu *CPU) cpi() {
-	value := cpu.Memory.ReadByte(cpu.GetHL())
+	value := cpu.readByte(cpu.GetHL())
+	cpu.internal(cpu.GetHL(), 5)
 	result := cpu.A - value
 
 	cpu.SetHL(cpu.GetHL() + 1)
@@ -337,8 +349,9 @@ func (cpu *CPU) cpi() {
 
 // ini inputs byte to (HL), increments HL, decrements B
 func (cpu *CPU) ini() {
-	value := cpu.IO.ReadPort(uint16(cpu.C) | (uint16(cpu.B) << 8))
-	cpu.Memory.WriteByte(cpu.GetHL(), value)
+	cpu.internal(cpu.getIR(), 1)
+	value := cpu.readPort(uint16(cpu.C) | (uint16(cpu.B) << 8))
+	cpu.writeByte(cpu.GetHL(), value)
 	cpu.SetHL(cpu.GetHL() + 1)
 	origbc := cpu.GetBC()
 	cpu.B--
@@ -373,9 +386,10 @@ func parity(val uint8) bool {
 
 // outi outputs byte from (HL) to port, increments HL, decrements B
 func (cpu *CPU) outi() {
-	val := cpu.Memory.ReadByte(cpu.GetHL())
+	cpu.internal(cpu.getIR(), 1)
+	val := cpu.readByte(cpu.GetHL())
 	cpu.B--
-	cpu.IO.WritePort(cpu.GetBC(), val)
+	cpu.writePort(cpu.GetBC(), val)
 	cpu.SetHL(cpu.GetHL() + 1)
 
 	// Enhanced: Accurate flag calculation for OUTI
@@ -397,8 +411,9 @@ func (cpu *CPU) outi() {
 }
 
 func (cpu *CPU) ldd() {
-	value := cpu.Memory.ReadByte(cpu.GetHL())
-	cpu.Memory.WriteByte(cpu.GetDE(), value)
+	value := cpu.readByte(cpu.GetHL())
+	cpu.writeByte(cpu.GetDE(), value)
+	cpu.internal(cpu.GetDE(), 2)
 	cpu.SetHL(cpu.GetHL() - 1)
 	cpu.SetDE(cpu.GetDE() - 1)
 	cpu.SetBC(cpu.GetBC() - 1)
@@ -417,7 +432,7 @@ func (cpu *CPU) ldd() {
 // cpd compares A with (HL), decrements HL, decrements BC
 func (cpu *CPU) cpd() {
 	// HUMAN:Working for fuse test, but failed on zexall
-	// value := cpu.Memory.ReadByte(cpu.GetHL())
+	// value := cpu.readByte(cpu.GetHL())
 	// result := cpu.A - value
 
 	// cpu.SetHL(cpu.GetHL() - 1)
@@ -441,7 +456,8 @@ func (cpu *CPU) cpd() {
 	// }
 	// cpu.MEMPTR--
 
-	val := cpu.Memory.ReadByte(cpu.GetHL())
+	val := cpu.readByte(cpu.GetHL())
+	cpu.internal(cpu.GetHL(), 5)
 	result := int16(cpu.A) - int16(val)
 	cpu.SetHL(cpu.GetHL() - 1)
 	cpu.SetBC(cpu.GetBC() - 1)
@@ -464,8 +480,9 @@ func (cpu *CPU) cpd() {
 
 // ind inputs byte to (HL), decrements HL, decrements B
 func (cpu *CPU) ind() {
-	val := cpu.IO.ReadPort(cpu.GetBC())
-	cpu.Memory.WriteByte(cpu.GetHL(), val)
+	cpu.internal(cpu.getIR(), 1)
+	val := cpu.readPort(cpu.GetBC())
+	cpu.writeByte(cpu.GetHL(), val)
 	cpu.SetHL(cpu.GetHL() - 1)
 	cpu.MEMPTR = cpu.GetBC() - 1
 	cpu.B--
@@ -502,9 +519,10 @@ func (cpu *CPU) ind() {
 
 // outd outputs byte from (HL) to port, decrements HL, decrements B
 func (cpu *CPU) outd() {
-	val := cpu.Memory.ReadByte(cpu.GetHL())
+	cpu.internal(cpu.getIR(), 1)
+	val := cpu.readByte(cpu.GetHL())
 	cpu.B--
-	cpu.IO.WritePort(uint16(cpu.C)|(uint16(cpu.B)<<8), val)
+	cpu.writePort(uint16(cpu.C)|(uint16(cpu.B)<<8), val)
 	cpu.SetHL(cpu.GetHL() - 1)
 
 	k := uint16(val) + uint16(cpu.L)
@@ -529,6 +547,7 @@ func (cpu *CPU) ldir() int {
 
 	// Add T-states for this iteration (21 for continuing, 16 for final)
 	if cpu.GetBC() != 0 {
+		cpu.internal(cpu.GetDE()-1, 5)
 		cpu.PC -= 2
 		cpu.MEMPTR = cpu.PC + 1
 		return 21
@@ -543,7 +562,7 @@ func (cpu *CPU) cpir() int {
 	cpu.cpi()
 
 	if cpu.GetBC() != 0 && !cpu.GetFlag(FLAG_Z) {
-
+		cpu.internal(cpu.GetHL()-1, 5)
 		cpu.PC -= 2 // Repeat instruction
 
 		// Return T-states for continuing iteration
@@ -561,6 +580,7 @@ func (cpu *CPU) inir() int {
 	cpu.ini()
 
 	if cpu.B != 0 {
+		cpu.internal(cpu.GetHL()-1, 5)
 		cpu.PC -= 2 // Repeat instruction
 		// Return T-states for continuing iteration
 		return 21
@@ -578,6 +598,7 @@ func (cpu *CPU) otir() int {
 	cpu.outi()
 
 	if cpu.B != 0 {
+		cpu.internal(cpu.GetBC(), 5)
 		cpu.PC -= 2 // Repeat instruction
 		// Return T-states for continuing iteration
 		return 21
@@ -595,6 +616,7 @@ func (cpu *CPU) lddr() int {
 
 	// Add T-states for this iteration (21 for continuing, 16 for final)
 	if cpu.GetBC() != 0 {
+		cpu.internal(cpu.GetDE()+1, 5)
 		cpu.PC -= 2
 		cpu.MEMPTR = cpu.PC + 1
 		return 21
@@ -610,6 +632,7 @@ func (cpu *CPU) cpdr() int {
 	cpu.cpd()
 
 	if cpu.GetBC() != 0 && !cpu.GetFlag(FLAG_Z) {
+		cpu.internal(cpu.GetHL()+1, 5)
 		cpu.PC -= 2 // Repeat instruction
 		// Return T-states for continuing iteration
 		cpu.MEMPTR = cpu.PC + 1
@@ -626,6 +649,7 @@ func (cpu *CPU) indr() int {
 	cpu.ind()
 
 	if cpu.B != 0 {
+		cpu.internal(cpu.GetHL()+1, 5)
 		cpu.PC -= 2 // Repeat instruction
 		// Return T-states for continuing iteration
 		return 21
@@ -641,6 +665,7 @@ func (cpu *CPU) otdr() int {
 	cpu.outd()
 
 	if cpu.B != 0 {
+		cpu.internal(cpu.GetBC(), 5)
 		cpu.PC -= 2 // Repeat instruction
 		// Return T-states for continuing iteration
 		return 21
@@ -651,12 +676,12 @@ func (cpu *CPU) otdr() int {
 
 // inC reads from port (BC)
 func (cpu *CPU) inC() byte {
-	return cpu.IO.ReadPort(cpu.GetBC())
+	return cpu.readPort(cpu.GetBC())
 }
 
 // outC writes to port (BC)
 func (cpu *CPU) outC(value byte) {
-	cpu.IO.WritePort(cpu.GetBC(), value)
+	cpu.writePort(cpu.GetBC(), value)
 }
 
 // sbc16 subtracts 16-bit value with carry from HL
@@ -766,7 +791,8 @@ func (cpu *CPU) ldAR() {
 
 // rrd rotates digit between A and (HL) right
 func (cpu *CPU) rrd() {
-	value := cpu.Memory.ReadByte(cpu.GetHL())
+	value := cpu.readByte(cpu.GetHL())
+	cpu.internal(cpu.GetHL(), 4)
 	ah := cpu.A & 0xF0
 	al := cpu.A & 0x0F
 	hl := value
@@ -776,7 +802,7 @@ func (cpu *CPU) rrd() {
 	// HL bits 3-0 go to A bits 3-0
 	cpu.A = ah | (hl & 0x0F)
 	newHL := ((hl & 0xF0) >> 4) | (al << 4)
-	cpu.Memory.WriteByte(cpu.GetHL(), newHL)
+	cpu.writeByte(cpu.GetHL(), newHL)
 
 	cpu.UpdateSZXYPVFlags(cpu.A)
 	cpu.ClearFlag(FLAG_H)
@@ -788,7 +814,8 @@ func (cpu *CPU) rrd() {
 
 // rld rotates digit between A and (HL) left
 func (cpu *CPU) rld() {
-	value := cpu.Memory.ReadByte(cpu.GetHL())
+	value := cpu.readByte(cpu.GetHL())
+	cpu.internal(cpu.GetHL(), 4)
 	ah := cpu.A & 0xF0
 	al := cpu.A & 0x0F
 	hl := value
@@ -798,7 +825,7 @@ func (cpu *CPU) rld() {
 	// HL bits 7-4 go to A bits 3-0
 	cpu.A = ah | (hl >> 4)
 	newHL := ((hl & 0x0F) << 4) | al
-	cpu.Memory.WriteByte(cpu.GetHL(), newHL)
+	cpu.writeByte(cpu.GetHL(), newHL)
 
 	cpu.UpdateSZXYPVFlags(cpu.A)
 	cpu.ClearFlag(FLAG_H)
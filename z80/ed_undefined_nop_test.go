@@ -1,6 +1,9 @@
 package z80
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 // ED 80..9F (mostly undefined) act as NOP with 8 cycles per the implementation.
 // We probe one byte to cement the contract.
@@ -13,3 +16,91 @@ func TestED_UndefinedActsAsNOP8Cycles(t *testing.T) {
 	assertEq(t, c, 8, "undefined ED opcode should be 8 cycles")
 	assertEq(t, cpu.PC, pc+2, "PC advanced over ED xx")
 }
+
+// Every undefined ED opcode is an 8 T-state NOP that only advances PC and R,
+// and is reported to the error hook in strict mode.
+func TestED_SweepAllOpcodes(t *testing.T) {
+	for op := 0; op < 256; op++ {
+		cpu, mem, _ := testCPU()
+		var reported []error
+		cpu.ErrorHook = func(err error) { reported = append(reported, err) }
+		cpu.SetBC(0x0102)
+		cpu.SetHL(0x4000)
+		cpu.SP = 0x8000
+		loadProgram(cpu, mem, 0x1000, 0xED, byte(op), 0x00, 0x00)
+		before := cpu.Snapshot()
+		c := cpu.ExecuteOneInstruction()
+
+		if edDefined(byte(op)) {
+			if len(reported) != 0 {
+				t.Errorf("ED %02X: defined opcode reported as %v", op, reported)
+			}
+			continue
+		}
+		if c != 8 {
+			t.Errorf("ED %02X: got %d T-states, want 8", op, c)
+		}
+		want := before
+		want.PC += 2
+		want.R += 2
+		want.TotalTStates += 8
+		if got := cpu.Snapshot(); got != want {
+			t.Errorf("ED %02X: state changed:\n got %+v\nwant %+v", op, got, want)
+		}
+		if len(reported) != 1 {
+			t.Errorf("ED %02X: reported %d errors, want 1", op, len(reported))
+			continue
+		}
+		var undefined *UndefinedOpcodeError
+		if !errors.As(reported[0], &undefined) {
+			t.Errorf("ED %02X: reported %T, want *UndefinedOpcodeError", op, reported[0])
+			continue
+		}
+		assertEq(t, *undefined, UndefinedOpcodeError{PC: 0x1000, Prefix: 0xED, Opcode: byte(op)}, "reported opcode")
+	}
+}
+
+// Without an error hook undefined opcodes are silently skipped.
+func TestED_UndefinedWithoutHook(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	loadProgram(cpu, mem, 0x0000, 0xED, 0xFF, 0xED, 0x00)
+	assertEq(t, mustStep(t, cpu), 8, "ED FF cycles")
+	assertEq(t, mustStep(t, cpu), 8, "ED 00 cycles")
+	assertEq(t, cpu.PC, uint16(0x0004), "PC")
+}
+
+// ED 4E and ED 6E select IM 0 like ED 46.
+func TestED_UndocumentedIM0(t *testing.T) {
+	for _, op := range []byte{0x46, 0x4E, 0x66, 0x6E} {
+		cpu, mem, _ := testCPU()
+		cpu.IM = 2
+		loadProgram(cpu, mem, 0x0000, 0xED, op)
+		assertEq(t, mustStep(t, cpu), 8, "IM 0 cycles")
+		assertEq(t, cpu.IM, byte(0), "IM")
+	}
+}
+
+// edDefined reports whether an ED opcode has a defined instruction.
+func edDefined(op byte) bool {
+	if op >= 0x40 && op <= 0x7F {
+		return op != 0x77 && op != 0x7F
+	}
+	return op >= 0xA0 && op <= 0xBF && op&0x07 <= 3
+}
+
+// An undefined opcode supplied in IM 0 is reported at the interrupted PC.
+func TestED_UndefinedFromInterrupt(t *testing.T) {
+	cpu, _, _ := interruptCPU(0, 0xED, 0x00)
+	var reported []error
+	cpu.ErrorHook = func(err error) { reported = append(reported, err) }
+	mustStep(t, cpu)
+
+	if len(reported) != 1 {
+		t.Fatalf("reported %d errors, want 1", len(reported))
+	}
+	var undefined *UndefinedOpcodeError
+	if !errors.As(reported[0], &undefined) {
+		t.Fatalf("reported %T, want *UndefinedOpcodeError", reported[0])
+	}
+	assertEq(t, *undefined, UndefinedOpcodeError{PC: 0x1234, Prefix: 0xED, Opcode: 0x00}, "reported opcode")
+}
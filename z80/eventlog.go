@@ -0,0 +1,63 @@
+// Package z80 implements a Z80 CPU emulator with support for all documented
+// and undocumented opcodes, flags, and registers.
+package z80
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String formats the cycle as one line of an event log
+func (c Cycle) String() string {
+	s := fmt.Sprintf("%5d %-3s %04X", c.TState, c.Type, c.Address)
+	if c.Type != CycleInternal {
+		s += fmt.Sprintf(" %02X", c.Data)
+	}
+	if c.Wait > 0 {
+		s += fmt.Sprintf(" +%d", c.Wait)
+	}
+	return s
+}
+
+// EventLog is a Bus that records every machine cycle the CPU performs. The
+// TState of each recorded cycle is counted from the last Reset rather than
+// from the start of its instruction, so a log can span many instructions.
+type EventLog struct {
+	Cycles []Cycle
+
+	base int // T-state at which the current instruction started
+	end  int // T-state at which the last recorded cycle ended
+}
+
+// Cycle implements Bus
+func (l *EventLog) Cycle(c Cycle) {
+	// Every instruction, interrupt and NMI starts with a cycle at T-state 0
+	if c.TState == 0 {
+		l.base = l.end
+	}
+	c.TState += l.base
+	l.end = c.TState + c.Length + c.Wait
+	l.Cycles = append(l.Cycles, c)
+}
+
+// Reset clears the log and restarts the T-state count from zero
+func (l *EventLog) Reset() {
+	l.Cycles = l.Cycles[:0]
+	l.base = 0
+	l.end = 0
+}
+
+// TStates returns the number of T-states covered by the log
+func (l *EventLog) TStates() int {
+	return l.end
+}
+
+// String formats the log one cycle per line
+func (l *EventLog) String() string {
+	var sb strings.Builder
+	for _, c := range l.Cycles {
+		sb.WriteString(c.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,35 @@
+package z80
+
+import (
+	"strings"
+	"testing"
+)
+
+// The log keeps counting T-states across instructions.
+func TestEventLog_SpansInstructions(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	log := &EventLog{}
+	cpu.Bus = log
+	cpu.SetHL(0x4000)
+	loadProgram(cpu, mem, 0x0000, 0x00, 0x7E) // NOP; LD A,(HL)
+	mem.WriteByte(0x4000, 0xA5)
+	total := mustStep(t, cpu) + mustStep(t, cpu)
+
+	want := []Cycle{
+		{Type: CycleFetch, TState: 0, Address: 0x0000, Data: 0x00, Length: 4},
+		{Type: CycleFetch, TState: 4, Address: 0x0001, Data: 0x7E, Length: 4},
+		{Type: CycleRead, TState: 8, Address: 0x4000, Data: 0xA5, Length: 3},
+	}
+	if len(log.Cycles) != len(want) {
+		t.Fatalf("got %d cycles, want %d:\n%s", len(log.Cycles), len(want), log)
+	}
+	for i := range want {
+		assertEq(t, log.Cycles[i], want[i], "cycle")
+	}
+	assertEq(t, log.TStates(), total, "log T-states")
+	assertEq(t, strings.Split(log.String(), "\n")[2], "    8 MR  4000 A5", "formatted read")
+
+	log.Reset()
+	assertEq(t, len(log.Cycles), 0, "cycles after reset")
+	assertEq(t, log.TStates(), 0, "T-states after reset")
+}
@@ -8,12 +8,14 @@ func (cpu *CPU) ExecuteFDOpcode(opcode byte) int {
 	// Load instructions
 	case 0x09: // ADD IY, BC
 		oldIY := cpu.IY
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IY(cpu.IY, cpu.GetBC())
 		cpu.MEMPTR = oldIY + 1
 		cpu.IY = result
 		return 15
 	case 0x19: // ADD IY, DE
 		oldIY := cpu.IY
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IY(cpu.IY, cpu.GetDE())
 		cpu.MEMPTR = oldIY + 1
 		cpu.IY = result
@@ -23,10 +25,11 @@ func (cpu *CPU) ExecuteFDOpcode(opcode byte) int {
 		return 14
 	case 0x22: // LD (nn), IY
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.IY)
+		cpu.writeWord(addr, cpu.IY)
 		cpu.MEMPTR = addr + 1
 		return 20
 	case 0x23: // INC IY
+		cpu.internal(cpu.getIR(), 2)
 		cpu.IY++
 		return 10
 	case 0x24: // INC IYH
@@ -40,16 +43,18 @@ func (cpu *CPU) ExecuteFDOpcode(opcode byte) int {
 		return 11
 	case 0x29: // ADD IY, IY
 		oldIY := cpu.IY
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IY(cpu.IY, cpu.IY)
 		cpu.MEMPTR = oldIY + 1
 		cpu.IY = result
 		return 15
 	case 0x2A: // LD IY, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.IY = cpu.Memory.ReadWord(addr)
+		cpu.IY = cpu.readWord(addr)
 		cpu.MEMPTR = addr + 1
 		return 20
 	case 0x2B: // DEC IY
+		cpu.internal(cpu.getIR(), 2)
 		cpu.IY--
 		return 10
 	case 0x2C: // INC IYL
@@ -68,12 +73,14 @@ func (cpu *CPU) ExecuteFDOpcode(opcode byte) int {
 	case 0x36: // LD (IY+d), n
 		displacement := cpu.ReadDisplacement()
 		value := cpu.ReadImmediateByte()
+		cpu.internal(cpu.PC-1, 2)
 		addr := uint16(int32(cpu.IY) + int32(displacement))
-		cpu.Memory.WriteByte(addr, value)
+		cpu.writeByte(addr, value)
 		cpu.MEMPTR = addr
 		return 19
 	case 0x39: // ADD IY, SP
 		oldIY := cpu.IY
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16IY(cpu.IY, cpu.SP)
 		cpu.MEMPTR = oldIY + 1
 		cpu.IY = result
@@ -252,18 +259,23 @@ func (cpu *CPU) ExecuteFDOpcode(opcode byte) int {
 		cpu.IY = cpu.Pop()
 		return 14
 	case 0xE3: // EX (SP), IY
-		temp := cpu.Memory.ReadWord(cpu.SP)
-		cpu.Memory.WriteWord(cpu.SP, cpu.IY)
+		temp := cpu.readWord(cpu.SP)
+		cpu.internal(cpu.SP+1, 1)
+		cpu.writeByte(cpu.SP+1, byte(cpu.IY>>8))
+		cpu.writeByte(cpu.SP, byte(cpu.IY))
+		cpu.internal(cpu.SP, 2)
 		cpu.IY = temp
 		cpu.MEMPTR = cpu.IY
 		return 23
 	case 0xE5: // PUSH IY
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.IY)
 		return 15
 	case 0xE9: // JP (IY)
 		cpu.PC = cpu.IY
 		return 8
 	case 0xF9: // LD SP, IY
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SP = cpu.IY
 		return 10
 
@@ -276,25 +288,34 @@ func (cpu *CPU) ExecuteFDOpcode(opcode byte) int {
 		// It consumes the FD prefix and the 00 opcode but executes as a NOP
 		// Takes 8 cycles total (4 for FD prefix fetch + 4 for 00 opcode fetch)
 		return 8
+
+	case 0xDD, 0xFD: // Repeated index prefix, the first one acts as a NOP
+		// and the second one applies to the instruction fetched by the next step
+		cpu.prefix = opcode
+		return 8
+	case 0xED: // The index prefix is ignored and the ED opcode executes
+		return 4 + cpu.ExecuteEDOpcode(cpu.ReadOpcode())
 	default:
 		// Unimplemented opcode - treat as regular opcode
 		// This handles cases where FD is followed by a normal opcode
-		return cpu.ExecuteOpcode(opcode)
+		return 4 + cpu.ExecuteOpcode(opcode)
 	}
 }
 
 // executeIncDecIndexedIY handles INC/DEC (IY+d) instructions
 func (cpu *CPU) executeIncDecIndexedIY(isInc bool) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IY) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
+	cpu.internal(addr, 1)
 	var result byte
 	if isInc {
 		result = cpu.inc8(value)
 	} else {
 		result = cpu.dec8(value)
 	}
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 	cpu.MEMPTR = addr
 	return 23
 }
@@ -302,8 +323,9 @@ func (cpu *CPU) executeIncDecIndexedIY(isInc bool) int {
 // executeLoadFromIndexedIY handles LD r, (IY+d) instructions
 func (cpu *CPU) executeLoadFromIndexedIY(reg byte) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IY) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
 
 	switch reg {
 	case 0:
@@ -329,8 +351,9 @@ func (cpu *CPU) executeLoadFromIndexedIY(reg byte) int {
 // executeStoreToIndexedIY handles LD (IY+d), r instructions
 func (cpu *CPU) executeStoreToIndexedIY(value byte) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IY) + int32(displacement))
-	cpu.Memory.WriteByte(addr, value)
+	cpu.writeByte(addr, value)
 	cpu.MEMPTR = addr
 	return 19
 }
@@ -338,8 +361,9 @@ func (cpu *CPU) executeStoreToIndexedIY(value byte) int {
 // executeALUIndexedIY handles ALU operations with (IY+d) operand
 func (cpu *CPU) executeALUIndexedIY(opType byte) int {
 	displacement := cpu.ReadDisplacement()
+	cpu.internal(cpu.PC-1, 5)
 	addr := uint16(int32(cpu.IY) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
 
 	switch opType {
 	case 0: // ADD
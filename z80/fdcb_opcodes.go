@@ -5,10 +5,11 @@ package z80
 // ExecuteFDCBOpcode executes a FD CB prefixed opcode
 func (cpu *CPU) ExecuteFDCBOpcode() int {
 	displacement := cpu.ReadDisplacement()
-	opcode := cpu.ReadOpcode()
-	cpu.R--
+	opcode := cpu.ReadImmediateByte()
+	cpu.internal(cpu.PC-1, 2)
 	addr := uint16(int32(cpu.IY) + int32(displacement))
-	value := cpu.Memory.ReadByte(addr)
+	value := cpu.readByte(addr)
+	cpu.internal(addr, 1)
 	cpu.MEMPTR = addr
 
 	// Handle rotate and shift instructions (0x00-0x3F)
@@ -68,7 +69,7 @@ func (cpu *CPU) executeRotateShiftIndexedIY(opcode byte, addr uint16, value byte
 	}
 
 	// Store result in memory
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 
 	// Store result in register if needed (except for (HL) case)
 	if reg != 6 { // reg 6 is (HL) - no register store needed
@@ -99,7 +100,7 @@ func (cpu *CPU) executeResetBitIndexedIY(opcode byte, addr uint16, value byte) i
 	reg := opcode & 0x07
 
 	result := cpu.res(bitNum, value)
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 
 	// Store result in register if needed (except for (HL) case)
 	if reg != 6 { // reg 6 is (HL) - no register store needed
@@ -130,7 +131,7 @@ func (cpu *CPU) executeSetBitIndexedIY(opcode byte, addr uint16, value byte) int
 	reg := opcode & 0x07
 
 	result := cpu.set(bitNum, value)
-	cpu.Memory.WriteByte(addr, result)
+	cpu.writeByte(addr, result)
 
 	// Store result in register if needed (except for (HL) case)
 	if reg != 6 { // reg 6 is (HL) - no register store needed
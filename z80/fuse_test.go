@@ -108,6 +108,143 @@ type Event struct {
 	Data    string
 }
 
+// fuseContention records the memory and port contention events FUSE logs,
+// without delaying the CPU
+type fuseContention struct {
+	base   int // T-states elapsed before the current instruction
+	events []Event
+}
+
+func (c *fuseContention) add(time int, kind string, address uint16) {
+	c.events = append(c.events, Event{
+		Time:    strconv.Itoa(time),
+		Type:    kind,
+		Address: fmt.Sprintf("%04x", address),
+	})
+}
+
+func (c *fuseContention) ContendMemory(address uint16, tstate int) int {
+	c.add(c.base+tstate, "MC", address)
+	return 0
+}
+
+// ContendPort follows the 48K ULA: the high byte is checked first if it
+// points to contended memory, then even ports are checked once and odd
+// ports in contended memory three more times
+func (c *fuseContention) ContendPort(port uint16, tstate int) int {
+	t := c.base + tstate
+	high := port&0xC000 == 0x4000
+	if high {
+		c.add(t, "PC", port)
+	}
+	switch {
+	case port&1 == 0:
+		c.add(t+1, "PC", port)
+	case high:
+		for i := 1; i <= 3; i++ {
+			c.add(t+i, "PC", port)
+		}
+	}
+	return 0
+}
+
+// compareContention compares the recorded contention events with the MC and
+// PC events expected by FUSE and reports the first divergence
+func compareContention(contention *fuseContention, expected []Event) []string {
+	var want []Event
+	for _, e := range expected {
+		if e.Type == "MC" || e.Type == "PC" {
+			want = append(want, e)
+		}
+	}
+	return compareEvents("Contention event", contention.events, want)
+}
+
+// fuseEvents converts the machine cycles recorded by an EventLog into the
+// events FUSE logs: a contention check at the start of each memory access,
+// the access itself once the data is on the bus, and the 48K ULA pattern for
+// port cycles
+func fuseEvents(cycles []Cycle) []Event {
+	var events []Event
+	add := func(time int, kind string, address uint16) {
+		events = append(events, Event{Time: strconv.Itoa(time), Type: kind, Address: fmt.Sprintf("%04x", address)})
+	}
+	addData := func(time int, kind string, address uint16, data byte) {
+		add(time, kind, address)
+		events[len(events)-1].Data = fmt.Sprintf("%02x", data)
+	}
+
+	var opcode byte
+	for i, c := range cycles {
+		t := c.TState
+		switch c.Type {
+		case CycleFetch:
+			add(t, "MC", c.Address)
+			addData(t+4, "MR", c.Address, c.Data)
+			opcode = c.Data
+		case CycleRead:
+			add(t, "MC", c.Address)
+			// FUSE skips the displacement read of a JR or DJNZ that is not
+			// taken, which is the only read not followed by an internal cycle
+			if isRelativeJump(opcode) && (i+1 == len(cycles) || cycles[i+1].Type != CycleInternal) {
+				continue
+			}
+			addData(t+3, "MR", c.Address, c.Data)
+		case CycleWrite:
+			add(t, "MC", c.Address)
+			addData(t+3, "MW", c.Address, c.Data)
+		case CycleInternal:
+			for n := 0; n < c.Length; n++ {
+				add(t+n, "MC", c.Address)
+			}
+		case CycleIORead, CycleIOWrite:
+			high := c.Address&0xC000 == 0x4000
+			if high {
+				add(t, "PC", c.Address)
+			}
+			kind := "PR"
+			if c.Type == CycleIOWrite {
+				kind = "PW"
+			}
+			addData(t+1, kind, c.Address, c.Data)
+			switch {
+			case c.Address&1 == 0:
+				add(t+1, "PC", c.Address)
+			case high:
+				for n := 1; n <= 3; n++ {
+					add(t+n, "PC", c.Address)
+				}
+			}
+		}
+	}
+	return events
+}
+
+// isRelativeJump reports whether opcode is DJNZ or a conditional JR
+func isRelativeJump(opcode byte) bool {
+	return opcode == 0x10 || opcode == 0x20 || opcode == 0x28 || opcode == 0x30 || opcode == 0x38
+}
+
+// compareEvents compares two event traces and reports the first divergence
+func compareEvents(label string, got, want []Event) []string {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			return []string{fmt.Sprintf("%s %d: expected %s, got nothing", label, i, want[i])}
+		case i >= len(want):
+			return []string{fmt.Sprintf("%s %d: expected nothing, got %s", label, i, got[i])}
+		case got[i] != want[i]:
+			return []string{fmt.Sprintf("%s %d: expected %s, got %s", label, i, want[i], got[i])}
+		}
+	}
+	return nil
+}
+
+// String formats the event as it appears in tests.expected
+func (e Event) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%5s %s %s %s", e.Time, e.Type, e.Address, e.Data))
+}
+
 // FlagNames represents the bit names for the F register
 var FlagNames = []string{"S", "Z", "5", "H", "3", "P/V", "N", "C"}
 
@@ -292,9 +429,11 @@ func decodeInstructions(d *disasm.Disassembler, memory *mockMemory, t *testing.T
 }
 
 // executeInstructions executes CPU instructions until reaching the expected T-states
-func executeInstructions(cpu *CPU, memory *mockMemory, d *disasm.Disassembler, expectedTStates int, t *testing.T) {
+func executeInstructions(cpu *CPU, memory *mockMemory, d *disasm.Disassembler, contention *fuseContention, expectedTStates int, t *testing.T) {
 	totalTicks := 0
 	for totalTicks < expectedTStates {
+		contention.base = totalTicks
+
 		// Capture the PC before executing the instruction for proper logging
 		pcBefore := cpu.PC
 
@@ -467,6 +606,10 @@ func executeZ80Test(t *testing.T, test Test) {
 
 	// Create CPU instance
 	cpu := New(memory, io)
+	contention := &fuseContention{}
+	cpu.Contention = contention
+	log := &EventLog{}
+	cpu.Bus = log
 
 	// Capture initial register state for debugging
 	initialRegisters := make([]string, 13)
@@ -501,7 +644,7 @@ func executeZ80Test(t *testing.T, test Test) {
 	}
 
 	// Execute instructions until we reach the input T-states
-	executeInstructions(cpu, memory, d, inputTStates, t)
+	executeInstructions(cpu, memory, d, contention, inputTStates, t)
 
 	// Compare emulator registers with expected values
 	matches := true
@@ -530,6 +673,20 @@ func executeZ80Test(t *testing.T, test Test) {
 		mismatchDetails = append(mismatchDetails, memoryMismatches...)
 	}
 
+	// Compare contention events
+	contentionMismatches := compareContention(contention, test.Expected.Events)
+	if len(contentionMismatches) > 0 {
+		matches = false
+		mismatchDetails = append(mismatchDetails, contentionMismatches...)
+	}
+
+	// Compare every bus event
+	eventMismatches := compareEvents("Event", fuseEvents(log.Cycles), test.Expected.Events)
+	if len(eventMismatches) > 0 {
+		matches = false
+		mismatchDetails = append(mismatchDetails, eventMismatches...)
+	}
+
 	if matches {
 		t.Logf("Test %s PASSED: All registers and memory match expected values", test.Name)
 	} else {
@@ -684,8 +841,9 @@ func readExpectedTests() (map[string]TestExpected, error) {
 			continue
 		}
 
-		// Parse events (lines starting with spaces and numbers)
-		if readingEvents && strings.HasPrefix(line, "    ") && len(strings.Fields(strings.TrimSpace(line))) >= 3 {
+		// Parse events (indented lines starting with the time). The time is
+		// right-aligned, so the indent shrinks as it grows.
+		if readingEvents && strings.HasPrefix(line, " ") && len(strings.Fields(strings.TrimSpace(line))) >= 3 {
 			fields := strings.Fields(strings.TrimSpace(line))
 			if len(fields) >= 3 {
 				event := Event{
@@ -2,6 +2,12 @@ module github.com/kiltum/emuz80/z80
 
 go 1.25.1
 
-require github.com/kiltum/emuz80/z80disasm v0.0.0
+require (
+	github.com/kiltum/emuz80/z80asm v0.0.0
+	github.com/kiltum/emuz80/z80disasm v0.0.0
+)
 
-replace github.com/kiltum/emuz80/z80disasm => ../z80disasm
+replace (
+	github.com/kiltum/emuz80/z80asm => ../z80asm
+	github.com/kiltum/emuz80/z80disasm => ../z80disasm
+)
@@ -0,0 +1,346 @@
+package z80
+
+import "testing"
+
+// ackIO is a port device that raises an interrupt and supplies bytes on the
+// data bus during acknowledge.
+type ackIO struct {
+	*mockIO
+	bus  []byte
+	acks int
+}
+
+func (io *ackIO) AcknowledgeInterrupt() byte {
+	io.acks++
+	if len(io.bus) == 0 {
+		return 0xFF
+	}
+	b := io.bus[0]
+	io.bus = io.bus[1:]
+	return b
+}
+
+// interruptCPU creates a CPU with interrupts enabled in the given mode and
+// the device placing bus on the data bus during acknowledge.
+func interruptCPU(im byte, bus ...byte) (*CPU, *mockMemory, *ackIO) {
+	mem := &mockMemory{}
+	io := &ackIO{mockIO: newMockIO(), bus: bus}
+	io.interrupt = true
+	cpu := New(mem, io)
+	cpu.IM = im
+	cpu.IFF1, cpu.IFF2 = true, true
+	cpu.SP = 0x8000
+	cpu.PC = 0x1234
+	return cpu, mem, io
+}
+
+// Without a device driving the bus IM 0 reads 0xFF, which is RST 38.
+func TestInterrupt_IM0FloatingBus(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	cpu.IM = 0
+	cpu.IFF1, cpu.IFF2 = true, true
+	cpu.SP = 0x8000
+	cpu.PC = 0x1234
+	cpu.IO.(*mockIO).interrupt = true
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 13, "IM 0 RST 38 cycles")
+	assertEq(t, cpu.PC, uint16(0x0038), "PC")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1234), "return address")
+	assertEq(t, cpu.IFF1, false, "IFF1")
+}
+
+// IM 0 executes the RST the device puts on the bus.
+func TestInterrupt_IM0Rst(t *testing.T) {
+	cpu, mem, io := interruptCPU(0, 0xD7) // RST 10
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 13, "IM 0 RST 10 cycles")
+	assertEq(t, bus.total(), c, "bus T-states")
+	assertEq(t, bus.cycles[0], Cycle{Type: CycleInterruptAck, Address: 0x1234, Data: 0xD7, Length: 6}, "ack cycle")
+	assertEq(t, cpu.PC, uint16(0x0010), "PC")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1234), "return address")
+	assertEq(t, io.acks, 1, "acknowledge calls")
+	assertEq(t, cpu.R, byte(1), "R")
+}
+
+// Multi-byte instructions take their operands from the device as well and
+// return to the interrupted PC, as with an 8080 interrupt controller.
+func TestInterrupt_IM0Call(t *testing.T) {
+	cpu, mem, io := interruptCPU(0, 0xCD, 0x00, 0x20) // CALL 2000
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	mem.WriteByte(0x1234, 0x11)
+	mem.WriteByte(0x1235, 0x22)
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 19, "IM 0 CALL cycles")
+	assertEq(t, bus.total(), c, "bus T-states")
+	assertEq(t, cpu.PC, uint16(0x2000), "PC")
+	assertEq(t, cpu.SP, uint16(0x7FFE), "SP")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1234), "return address")
+	assertEq(t, io.acks, 3, "acknowledge calls")
+}
+
+// Prefixed instructions have one acknowledge cycle per M1.
+func TestInterrupt_IM0Prefixed(t *testing.T) {
+	cpu, _, io := interruptCPU(0, 0xED, 0x56) // IM 1
+	bus := &recordingBus{}
+	cpu.Bus = bus
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 12, "IM 0 ED 56 cycles")
+	assertEq(t, bus.total(), c, "bus T-states")
+	assertEq(t, bus.cycles[1].Type, CycleInterruptAck, "second M1")
+	assertEq(t, cpu.IM, byte(1), "IM")
+	assertEq(t, cpu.PC, uint16(0x1234), "PC")
+	assertEq(t, io.acks, 2, "acknowledge calls")
+	assertEq(t, cpu.R, byte(2), "R")
+}
+
+// IM 1 still acknowledges the device but ignores the bus.
+func TestInterrupt_IM1Acknowledges(t *testing.T) {
+	cpu, _, io := interruptCPU(1, 0xD7)
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 13, "IM 1 cycles")
+	assertEq(t, cpu.PC, uint16(0x0038), "PC")
+	assertEq(t, io.acks, 1, "acknowledge calls")
+	assertEq(t, cpu.R, byte(1), "R")
+}
+
+// IM 2 forms the table address from I and the vector byte of the device.
+func TestInterrupt_IM2Vector(t *testing.T) {
+	cpu, mem, io := interruptCPU(2, 0x20)
+	log := &EventLog{}
+	cpu.Bus = log
+	cpu.I = 0x38
+	mem.WriteWord(0x3820, 0x4567)
+	c := mustStep(t, cpu)
+
+	assertEq(t, c, 19, "IM 2 cycles")
+	assertEq(t, log.TStates(), c, "bus T-states")
+	assertEq(t, cpu.PC, uint16(0x4567), "PC")
+	assertEq(t, cpu.MEMPTR, uint16(0x4567), "MEMPTR")
+	assertEq(t, io.acks, 1, "acknowledge calls")
+
+	want := []Cycle{
+		{Type: CycleInterruptAck, TState: 0, Address: 0x1234, Data: 0x20, Length: 7},
+		{Type: CycleWrite, TState: 7, Address: 0x7FFF, Data: 0x12, Length: 3},
+		{Type: CycleWrite, TState: 10, Address: 0x7FFE, Data: 0x34, Length: 3},
+		{Type: CycleRead, TState: 13, Address: 0x3820, Data: 0x67, Length: 3},
+		{Type: CycleRead, TState: 16, Address: 0x3821, Data: 0x45, Length: 3},
+	}
+	if len(log.Cycles) != len(want) {
+		t.Fatalf("got %d cycles, want %d:\n%s", len(log.Cycles), len(want), log)
+	}
+	for i := range want {
+		assertEq(t, log.Cycles[i], want[i], "cycle")
+	}
+}
+
+// An odd vector is used as is, and a floating bus selects entry 0xFF.
+func TestInterrupt_IM2OddAndFloatingVector(t *testing.T) {
+	cpu, mem, _ := interruptCPU(2, 0x21)
+	cpu.I = 0x38
+	mem.WriteWord(0x3821, 0x1111)
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x1111), "odd vector")
+
+	cpu, mem, _ = interruptCPU(2)
+	cpu.I = 0x38
+	mem.WriteWord(0x38FF, 0x2222)
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x2222), "floating bus")
+}
+
+// The instruction after EI always runs before an interrupt is accepted, so
+// EI; RET cannot be interrupted in between.
+func TestInterrupt_EIDelay(t *testing.T) {
+	cpu, mem, _ := interruptCPU(1)
+	cpu.IFF1, cpu.IFF2 = false, false
+	mem.WriteWord(0x8000, 0x2000)
+	loadProgram(cpu, mem, 0x1000, 0xFB, 0xC9) // EI; RET
+
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x1001), "PC after EI")
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x2000), "PC after RET")
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x0038), "PC after interrupt")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x2000), "return address")
+}
+
+// A run of EIs keeps interrupts blocked until the first other instruction.
+func TestInterrupt_RepeatedEI(t *testing.T) {
+	cpu, mem, _ := interruptCPU(1)
+	loadProgram(cpu, mem, 0x1000, 0xFB, 0xFB, 0x00) // EI; EI; NOP
+	cpu.IFF1 = false
+
+	mustStep(t, cpu)
+	mustStep(t, cpu)
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x1003), "PC after EI; EI; NOP")
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x0038), "PC after interrupt")
+}
+
+// A repeated index prefix leaves the last prefix pending for the next step,
+// which cannot be interrupted.
+func TestInterrupt_PrefixChain(t *testing.T) {
+	cpu, mem, _ := interruptCPU(1)
+	cpu.IFF1 = false
+	loadProgram(cpu, mem, 0x1000, 0xDD, 0xFD, 0x21, 0x34, 0x12) // DD; LD IY,1234
+	bus := &recordingBus{}
+	cpu.Bus = bus
+
+	assertEq(t, mustStep(t, cpu), 8, "DD FD cycles")
+	cpu.IFF1 = true
+	assertEq(t, mustStep(t, cpu), 10, "LD IY,nn cycles after the prefix")
+	assertEq(t, bus.total(), 18, "bus T-states")
+	assertEq(t, cpu.IY, uint16(0x1234), "IY")
+	assertEq(t, cpu.GetHL(), uint16(0x0000), "HL")
+	assertEq(t, cpu.PC, uint16(0x1005), "PC")
+	assertEq(t, cpu.R, byte(3), "R")
+}
+
+// On NMOS parts an interrupt right after LD A,I clears P/V; CMOS parts keep IFF2.
+func TestInterrupt_LDAIParityQuirk(t *testing.T) {
+	for _, tc := range []struct {
+		model Model
+		pv    bool
+	}{{ModelNMOS, false}, {ModelCMOS, true}} {
+		for _, op := range []byte{0x57, 0x5F} { // LD A,I; LD A,R
+			cpu, mem, io := interruptCPU(1)
+			cpu.Model = tc.model
+			io.interrupt = false
+			loadProgram(cpu, mem, 0x1000, 0xED, op, 0x00)
+			mustStep(t, cpu)
+			assertFlag(t, cpu, FLAG_PV, true, "P/V after LD A,I/R")
+
+			io.interrupt = true
+			mustStep(t, cpu)
+			assertEq(t, cpu.PC, uint16(0x0038), "PC after interrupt")
+			assertFlag(t, cpu, FLAG_PV, tc.pv, "P/V after interrupt")
+		}
+	}
+}
+
+// The quirk only applies when the interrupt immediately follows LD A,I.
+func TestInterrupt_LDAIParityQuirkOnlyImmediately(t *testing.T) {
+	cpu, mem, io := interruptCPU(1)
+	io.interrupt = false
+	loadProgram(cpu, mem, 0x1000, 0xED, 0x57, 0x00) // LD A,I; NOP
+	mustStep(t, cpu)
+	mustStep(t, cpu)
+	io.interrupt = true
+	mustStep(t, cpu)
+	assertFlag(t, cpu, FLAG_PV, true, "P/V after interrupt")
+}
+
+// nmiIO drives the NMI line from a field.
+type nmiIO struct {
+	*mockIO
+	nmi bool
+}
+
+func (io *nmiIO) CheckNMI() bool { return io.nmi }
+
+// An NMI edge is serviced before the next instruction and keeps IFF2 for RETN.
+func TestNMI_Edge(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	cpu.SP = 0x8000
+	cpu.IFF1, cpu.IFF2 = true, true
+	loadProgram(cpu, mem, 0x1234, 0x00, 0x00)
+	mem.WriteByte(0x0066, 0x00)
+
+	cpu.SetNMI(true)
+	c := mustStep(t, cpu)
+	assertEq(t, c, 11, "NMI cycles")
+	assertEq(t, cpu.PC, uint16(0x0066), "PC")
+	assertEq(t, cpu.MEMPTR, uint16(0x0066), "MEMPTR")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1234), "return address")
+	assertEq(t, cpu.IFF1, false, "IFF1")
+	assertEq(t, cpu.IFF2, true, "IFF2")
+	assertEq(t, cpu.R, byte(1), "R")
+
+	// Holding the line does not retrigger
+	cpu.SetNMI(true)
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x0067), "PC with line held")
+
+	// A new edge does
+	cpu.SetNMI(false)
+	cpu.SetNMI(true)
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x0066), "PC after second edge")
+}
+
+// NMI leaves HALT with the return address after the HALT, and RETN restores IFF1.
+func TestNMI_LeavesHALT(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	cpu.SP = 0x8000
+	cpu.IFF1, cpu.IFF2 = true, true
+	loadProgram(cpu, mem, 0x1000, 0x76, 0x00) // HALT; NOP
+	mem.WriteByte(0x0066, 0xED)               // RETN
+	mem.WriteByte(0x0067, 0x45)
+
+	mustStep(t, cpu)
+	mustStep(t, cpu)
+	assertEq(t, cpu.HALT, true, "halted")
+
+	cpu.SetNMI(true)
+	assertEq(t, mustStep(t, cpu), 11, "NMI cycles")
+	assertEq(t, cpu.HALT, false, "HALT after NMI")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1001), "return address")
+
+	assertEq(t, mustStep(t, cpu), 14, "RETN cycles")
+	assertEq(t, cpu.PC, uint16(0x1001), "PC after RETN")
+	assertEq(t, cpu.IFF1, true, "IFF1 after RETN")
+}
+
+// Maskable interrupts also resume after the HALT instruction.
+func TestInterrupt_LeavesHALT(t *testing.T) {
+	cpu, mem, io := interruptCPU(1)
+	io.interrupt = false
+	loadProgram(cpu, mem, 0x1000, 0x76, 0x00) // HALT; NOP
+	mustStep(t, cpu)
+	io.interrupt = true
+	mustStep(t, cpu)
+	assertEq(t, cpu.HALT, false, "HALT after interrupt")
+	assertEq(t, cpu.PC, uint16(0x0038), "PC")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1001), "return address")
+}
+
+// The NMI line can be driven by the IO device, and NMI wins over INT.
+func TestNMI_FromDevice(t *testing.T) {
+	mem := &mockMemory{}
+	io := &nmiIO{mockIO: newMockIO()}
+	cpu := New(mem, io)
+	cpu.SP = 0x8000
+	cpu.IM = 1
+	cpu.IFF1, cpu.IFF2 = true, true
+	io.interrupt = true
+	io.nmi = true
+	loadProgram(cpu, mem, 0x1000, 0x00)
+
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x0066), "PC")
+	assertEq(t, cpu.IFF2, true, "IFF2")
+}
+
+// NMI is not accepted between an index prefix and its instruction.
+func TestNMI_AfterPrefix(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	cpu.SP = 0x8000
+	loadProgram(cpu, mem, 0x1000, 0xDD, 0xFD, 0x21, 0x34, 0x12) // DD; LD IY,1234
+	mustStep(t, cpu)
+	cpu.SetNMI(true)
+	mustStep(t, cpu)
+	assertEq(t, cpu.IY, uint16(0x1234), "IY")
+	mustStep(t, cpu)
+	assertEq(t, cpu.PC, uint16(0x0066), "PC")
+	assertEq(t, mem.ReadWord(cpu.SP), uint16(0x1005), "return address")
+}
@@ -15,16 +15,7 @@ func TestLD_Register_Matrix_And_Immediates(t *testing.T) {
 	flagsStart := cpu.F
 
 	// LD B,C ; LD D,E ; LD A,B ; LD L,H ; (skip HALT 0x76)
-	loadProgram(cpu, mem, 0x0000,
-		0x41,       // LD B,C
-		0x53,       // LD D,E
-		0x78,       // LD A,B
-		0x6C,       // LD L,H
-		0x06, 0x99, // LD B,99
-		0x36, 0xFE, // LD (HL),FE
-		0x7E, // LD A,(HL)
-		0x70, // LD (HL),B
-	)
+	asm(t, mem, 0x0000, "LD B,C; LD D,E; LD A,B; LD L,H; LD B,$99; LD (HL),$FE; LD A,(HL); LD (HL),B")
 
 	// LD B,C
 	c := mustStep(t, cpu)
@@ -72,12 +63,7 @@ func TestLD_A_BC_DE_Basics(t *testing.T) {
 	mem.WriteByte(0x1234, 0xAA)
 	mem.WriteByte(0x5678, 0xBB)
 
-	loadProgram(cpu, mem, 0x0000,
-		0x0A, // LD A,(BC)
-		0x1A, // LD A,(DE)
-		0x02, // LD (BC),A
-		0x12, // LD (DE),A
-	)
+	asm(t, mem, 0x0000, "LD A,(BC); LD A,(DE); LD (BC),A; LD (DE),A")
 
 	c := mustStep(t, cpu)
 	assertEq(t, c, 7, "LD A,(BC) cycles")
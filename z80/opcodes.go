@@ -14,10 +14,11 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.SetBC(cpu.ReadImmediateWord())
 		return 10
 	case 0x02: // LD (BC), A
-		cpu.Memory.WriteByte(cpu.GetBC(), cpu.A)
+		cpu.writeByte(cpu.GetBC(), cpu.A)
 		cpu.MEMPTR = (uint16(cpu.A) << 8) | (uint16(cpu.GetBC()+1) & 0xff)
 		return 7
 	case 0x03: // INC BC
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SetBC(cpu.GetBC() + 1)
 		return 6
 	case 0x04: // INC B
@@ -38,15 +39,17 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.SetAF_(temp)
 		return 4
 	case 0x09: // ADD HL, BC
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16(cpu.GetHL(), cpu.GetBC())
 		cpu.MEMPTR = cpu.GetHL() + 1
 		cpu.SetHL(result)
 		return 11
 	case 0x0A: // LD A, (BC)
-		cpu.A = cpu.Memory.ReadByte(cpu.GetBC())
+		cpu.A = cpu.readByte(cpu.GetBC())
 		cpu.MEMPTR = cpu.GetBC() + 1
 		return 7
 	case 0x0B: // DEC BC
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SetBC(cpu.GetBC() - 1)
 		return 6
 	case 0x0C: // INC C
@@ -62,22 +65,24 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.rrca()
 		return 4
 	case 0x10: // DJNZ e
+		cpu.internal(cpu.getIR(), 1)
 		cpu.B--
+		offset := cpu.ReadDisplacement()
 		if cpu.B != 0 {
-			offset := cpu.ReadDisplacement()
+			cpu.internal(cpu.PC-1, 5)
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			return 13
 		}
-		cpu.PC++ // Skip the offset byte
 		return 8
 	case 0x11: // LD DE, nn
 		cpu.SetDE(cpu.ReadImmediateWord())
 		return 10
 	case 0x12: // LD (DE), A
-		cpu.Memory.WriteByte(cpu.GetDE(), cpu.A)
+		cpu.writeByte(cpu.GetDE(), cpu.A)
 		cpu.MEMPTR = (uint16(cpu.A) << 8) | (uint16(cpu.GetDE()+1) & 0xff)
 		return 7
 	case 0x13: // INC DE
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SetDE(cpu.GetDE() + 1)
 		return 6
 	case 0x14: // INC D
@@ -94,19 +99,22 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		return 4
 	case 0x18: // JR e
 		offset := cpu.ReadDisplacement()
+		cpu.internal(cpu.PC-1, 5)
 		cpu.MEMPTR = cpu.PC + uint16(int32(offset))
 		cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 		return 12
 	case 0x19: // ADD HL, DE
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16(cpu.GetHL(), cpu.GetDE())
 		cpu.MEMPTR = cpu.GetHL() + 1
 		cpu.SetHL(result)
 		return 11
 	case 0x1A: // LD A, (DE)
-		cpu.A = cpu.Memory.ReadByte(cpu.GetDE())
+		cpu.A = cpu.readByte(cpu.GetDE())
 		cpu.MEMPTR = cpu.GetDE() + 1
 		return 7
 	case 0x1B: // DEC DE
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SetDE(cpu.GetDE() - 1)
 		return 6
 	case 0x1C: // INC E
@@ -124,21 +132,23 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0x20: // JR NZ, e
 		if !cpu.GetFlag(FLAG_Z) {
 			offset := cpu.ReadDisplacement()
+			cpu.internal(cpu.PC-1, 5)
 			cpu.MEMPTR = cpu.PC + uint16(int32(offset))
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			return 12
 		}
-		cpu.PC++ // Skip the offset byte
+		_ = cpu.ReadDisplacement() // Offset is read but not used
 		return 7
 	case 0x21: // LD HL, nn
 		cpu.SetHL(cpu.ReadImmediateWord())
 		return 10
 	case 0x22: // LD (nn), HL
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteWord(addr, cpu.GetHL())
+		cpu.writeWord(addr, cpu.GetHL())
 		cpu.MEMPTR = addr + 1
 		return 16
 	case 0x23: // INC HL
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SetHL(cpu.GetHL() + 1)
 		return 6
 	case 0x24: // INC H
@@ -156,23 +166,26 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0x28: // JR Z, e
 		if cpu.GetFlag(FLAG_Z) {
 			offset := int8(cpu.ReadDisplacement())
+			cpu.internal(cpu.PC-1, 5)
 			cpu.MEMPTR = uint16(int32(cpu.PC) + int32(offset))
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			return 12
 		}
-		_ = cpu.ReadDisplacement()
+		_ = cpu.ReadDisplacement() // Offset is read but not used
 		return 7
 	case 0x29: // ADD HL, HL
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16(cpu.GetHL(), cpu.GetHL())
 		cpu.MEMPTR = cpu.GetHL() + 1
 		cpu.SetHL(result)
 		return 11
 	case 0x2A: // LD HL, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.SetHL(cpu.Memory.ReadWord(addr))
+		cpu.SetHL(cpu.readWord(addr))
 		cpu.MEMPTR = addr + 1
 		return 16
 	case 0x2B: // DEC HL
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SetHL(cpu.GetHL() - 1)
 		return 6
 	case 0x2C: // INC L
@@ -190,36 +203,40 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0x30: // JR NC, e
 		if !cpu.GetFlag(FLAG_C) {
 			offset := cpu.ReadDisplacement()
+			cpu.internal(cpu.PC-1, 5)
 			cpu.MEMPTR = cpu.PC + uint16(int32(offset))
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			return 12
 		}
-		cpu.PC++ // Skip the offset byte
+		_ = cpu.ReadDisplacement() // Offset is read but not used
 		return 7
 	case 0x31: // LD SP, nn
 		cpu.SP = cpu.ReadImmediateWord()
 		return 10
 	case 0x32: // LD (nn), A
 		addr := cpu.ReadImmediateWord()
-		cpu.Memory.WriteByte(addr, cpu.A)
+		cpu.writeByte(addr, cpu.A)
 		cpu.MEMPTR = (uint16(cpu.A) << 8) | ((addr + 1) & 0xFF)
 		return 13
 	case 0x33: // INC SP
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SP++
 		return 6
 	case 0x34: // INC (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
+		cpu.internal(cpu.GetHL(), 1)
 		result := cpu.inc8(value)
-		cpu.Memory.WriteByte(cpu.GetHL(), result)
+		cpu.writeByte(cpu.GetHL(), result)
 		return 11
 	case 0x35: // DEC (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
+		cpu.internal(cpu.GetHL(), 1)
 		result := cpu.dec8(value)
-		cpu.Memory.WriteByte(cpu.GetHL(), result)
+		cpu.writeByte(cpu.GetHL(), result)
 		return 11
 	case 0x36: // LD (HL), n
 		value := cpu.ReadImmediateByte()
-		cpu.Memory.WriteByte(cpu.GetHL(), value)
+		cpu.writeByte(cpu.GetHL(), value)
 		return 10
 	case 0x37: // SCF
 		cpu.scf()
@@ -227,23 +244,26 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0x38: // JR C, e
 		if cpu.GetFlag(FLAG_C) {
 			offset := cpu.ReadDisplacement()
+			cpu.internal(cpu.PC-1, 5)
 			cpu.MEMPTR = cpu.PC + uint16(int32(offset))
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			return 12
 		}
-		cpu.PC++ // Skip the offset byte
+		_ = cpu.ReadDisplacement() // Offset is read but not used
 		return 7
 	case 0x39: // ADD HL, SP
+		cpu.internal(cpu.getIR(), 7)
 		result := cpu.add16(cpu.GetHL(), cpu.SP)
 		cpu.MEMPTR = cpu.GetHL() + 1
 		cpu.SetHL(result)
 		return 11
 	case 0x3A: // LD A, (nn)
 		addr := cpu.ReadImmediateWord()
-		cpu.A = cpu.Memory.ReadByte(addr)
+		cpu.A = cpu.readByte(addr)
 		cpu.MEMPTR = addr + 1
 		return 13
 	case 0x3B: // DEC SP
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SP--
 		return 6
 	case 0x3C: // INC A
@@ -278,7 +298,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.B = cpu.L
 		return 4
 	case 0x46: // LD B, (HL)
-		cpu.B = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.B = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x47: // LD B, A
 		cpu.B = cpu.A
@@ -301,7 +321,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.C = cpu.L
 		return 4
 	case 0x4E: // LD C, (HL)
-		cpu.C = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.C = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x4F: // LD C, A
 		cpu.C = cpu.A
@@ -324,7 +344,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.D = cpu.L
 		return 4
 	case 0x56: // LD D, (HL)
-		cpu.D = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.D = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x57: // LD D, A
 		cpu.D = cpu.A
@@ -347,7 +367,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.E = cpu.L
 		return 4
 	case 0x5E: // LD E, (HL)
-		cpu.E = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.E = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x5F: // LD E, A
 		cpu.E = cpu.A
@@ -370,7 +390,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.H = cpu.L
 		return 4
 	case 0x66: // LD H, (HL)
-		cpu.H = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.H = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x67: // LD H, A
 		cpu.H = cpu.A
@@ -393,35 +413,35 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0x6D: // LD L, L
 		return 4
 	case 0x6E: // LD L, (HL)
-		cpu.L = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.L = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x6F: // LD L, A
 		cpu.L = cpu.A
 		return 4
 	case 0x70: // LD (HL), B
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.B)
+		cpu.writeByte(cpu.GetHL(), cpu.B)
 		return 7
 	case 0x71: // LD (HL), C
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.C)
+		cpu.writeByte(cpu.GetHL(), cpu.C)
 		return 7
 	case 0x72: // LD (HL), D
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.D)
+		cpu.writeByte(cpu.GetHL(), cpu.D)
 		return 7
 	case 0x73: // LD (HL), E
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.E)
+		cpu.writeByte(cpu.GetHL(), cpu.E)
 		return 7
 	case 0x74: // LD (HL), H
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.H)
+		cpu.writeByte(cpu.GetHL(), cpu.H)
 		return 7
 	case 0x75: // LD (HL), L
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.L)
+		cpu.writeByte(cpu.GetHL(), cpu.L)
 		return 7
 	case 0x76: // HALT
 		cpu.HALT = true
 		cpu.PC--
 		return 4
 	case 0x77: // LD (HL), A
-		cpu.Memory.WriteByte(cpu.GetHL(), cpu.A)
+		cpu.writeByte(cpu.GetHL(), cpu.A)
 		return 7
 	case 0x78: // LD A, B
 		cpu.A = cpu.B
@@ -442,7 +462,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.A = cpu.L
 		return 4
 	case 0x7E: // LD A, (HL)
-		cpu.A = cpu.Memory.ReadByte(cpu.GetHL())
+		cpu.A = cpu.readByte(cpu.GetHL())
 		return 7
 	case 0x7F: // LD A, A
 		return 4
@@ -467,7 +487,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.add8(cpu.L)
 		return 4
 	case 0x86: // ADD A, (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.add8(value)
 		return 7
 	case 0x87: // ADD A, A
@@ -492,7 +512,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.adc8(cpu.L)
 		return 4
 	case 0x8E: // ADC A, (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.adc8(value)
 		return 7
 	case 0x8F: // ADC A, A
@@ -517,7 +537,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.sub8(cpu.L)
 		return 4
 	case 0x96: // SUB (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.sub8(value)
 		return 7
 	case 0x97: // SUB A
@@ -542,7 +562,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.sbc8(cpu.L)
 		return 4
 	case 0x9E: // SBC A, (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.sbc8(value)
 		return 7
 	case 0x9F: // SBC A, A
@@ -567,7 +587,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.and8(cpu.L)
 		return 4
 	case 0xA6: // AND (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.and8(value)
 		return 7
 	case 0xA7: // AND A
@@ -592,7 +612,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.xor8(cpu.L)
 		return 4
 	case 0xAE: // XOR (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.xor8(value)
 		return 7
 	case 0xAF: // XOR A
@@ -617,7 +637,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.or8(cpu.L)
 		return 4
 	case 0xB6: // OR (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.or8(value)
 		return 7
 	case 0xB7: // OR A
@@ -642,7 +662,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.cp8(cpu.L)
 		return 4
 	case 0xBE: // CP (HL)
-		value := cpu.Memory.ReadByte(cpu.GetHL())
+		value := cpu.readByte(cpu.GetHL())
 		cpu.cp8(value)
 		return 7
 	case 0xBF: // CP A
@@ -651,6 +671,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 
 	// RET cc instructions
 	case 0xC0: // RET NZ
+		cpu.internal(cpu.getIR(), 1)
 		if !cpu.GetFlag(FLAG_Z) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -677,12 +698,14 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if !cpu.GetFlag(FLAG_Z) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
 		}
 		return 10
 	case 0xC5: // PUSH BC
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.GetBC())
 		return 11
 	case 0xC6: // ADD A, n
@@ -690,11 +713,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.add8(value)
 		return 7
 	case 0xC7: // RST 00H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0000
 		cpu.MEMPTR = 0x0000
 		return 11
 	case 0xC8: // RET Z
+		cpu.internal(cpu.getIR(), 1)
 		if cpu.GetFlag(FLAG_Z) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -720,6 +745,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if cpu.GetFlag(FLAG_Z) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
@@ -727,6 +753,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		return 10
 	case 0xCD: // CALL nn
 		addr := cpu.ReadImmediateWord()
+		cpu.internal(cpu.PC-1, 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = addr
 		cpu.MEMPTR = addr
@@ -736,11 +763,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.adc8(value)
 		return 7
 	case 0xCF: // RST 08H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0008
 		cpu.MEMPTR = 0x0008
 		return 11
 	case 0xD0: // RET NC
+		cpu.internal(cpu.getIR(), 1)
 		if !cpu.GetFlag(FLAG_C) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -761,19 +790,21 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0xD3: // OUT (n), A
 		n := cpu.ReadImmediateByte()
 		port := uint16(n) | (uint16(cpu.A) << 8)
-		cpu.IO.WritePort(port, cpu.A)
+		cpu.writePort(port, cpu.A)
 		cpu.MEMPTR = (uint16(cpu.A) << 8) | uint16((n+1)&0xFF)
 		return 11
 	case 0xD4: // CALL NC, nn
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if !cpu.GetFlag(FLAG_C) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
 		}
 		return 10
 	case 0xD5: // PUSH DE
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.GetDE())
 		return 11
 	case 0xD6: // SUB n
@@ -781,11 +812,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.sub8(value)
 		return 7
 	case 0xD7: // RST 10H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0010
 		cpu.MEMPTR = 0x0010
 		return 11
 	case 0xD8: // RET C
+		cpu.internal(cpu.getIR(), 1)
 		if cpu.GetFlag(FLAG_C) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -814,13 +847,14 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0xDB: // IN A, (n)
 		n := cpu.ReadImmediateByte()
 		port := uint16(n) | (uint16(cpu.A) << 8)
-		cpu.A = cpu.IO.ReadPort(port)
+		cpu.A = cpu.readPort(port)
 		cpu.MEMPTR = (uint16(cpu.A) << 8) | uint16((n+1)&0xFF)
 		return 11
 	case 0xDC: // CALL C, nn
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if cpu.GetFlag(FLAG_C) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
@@ -834,11 +868,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.sbc8(value)
 		return 7
 	case 0xDF: // RST 18H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0018
 		cpu.MEMPTR = 0x0018
 		return 11
 	case 0xE0: // RET PO
+		cpu.internal(cpu.getIR(), 1)
 		if !cpu.GetFlag(FLAG_PV) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -857,8 +893,11 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		}
 		return 10
 	case 0xE3: // EX (SP), HL
-		temp := cpu.Memory.ReadWord(cpu.SP)
-		cpu.Memory.WriteWord(cpu.SP, cpu.GetHL())
+		temp := cpu.readWord(cpu.SP)
+		cpu.internal(cpu.SP+1, 1)
+		cpu.writeByte(cpu.SP+1, cpu.H)
+		cpu.writeByte(cpu.SP, cpu.L)
+		cpu.internal(cpu.SP, 2)
 		cpu.SetHL(temp)
 		cpu.MEMPTR = temp
 		return 19
@@ -866,12 +905,14 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if !cpu.GetFlag(FLAG_PV) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
 		}
 		return 10
 	case 0xE5: // PUSH HL
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.GetHL())
 		return 11
 	case 0xE6: // AND n
@@ -879,11 +920,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.and8(value)
 		return 7
 	case 0xE7: // RST 20H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0020
 		cpu.MEMPTR = 0x0020
 		return 11
 	case 0xE8: // RET PE
+		cpu.internal(cpu.getIR(), 1)
 		if cpu.GetFlag(FLAG_PV) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -910,6 +953,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if cpu.GetFlag(FLAG_PV) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
@@ -923,11 +967,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.xor8(value)
 		return 7
 	case 0xEF: // RST 28H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0028
 		cpu.MEMPTR = 0x0028
 		return 11
 	case 0xF0: // RET P
+		cpu.internal(cpu.getIR(), 1)
 		if !cpu.GetFlag(FLAG_S) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -953,12 +999,14 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if !cpu.GetFlag(FLAG_S) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
 		}
 		return 10
 	case 0xF5: // PUSH AF
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.GetAF())
 		return 11
 	case 0xF6: // OR n
@@ -966,11 +1014,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.or8(value)
 		return 7
 	case 0xF7: // RST 30H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0030
 		cpu.MEMPTR = 0x0030
 		return 11
 	case 0xF8: // RET M
+		cpu.internal(cpu.getIR(), 1)
 		if cpu.GetFlag(FLAG_S) {
 			cpu.PC = cpu.Pop()
 			cpu.MEMPTR = cpu.PC
@@ -978,6 +1028,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		}
 		return 5
 	case 0xF9: // LD SP, HL
+		cpu.internal(cpu.getIR(), 2)
 		cpu.SP = cpu.GetHL()
 		return 6
 	case 0xFA: // JP M, nn
@@ -991,11 +1042,13 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 	case 0xFB: // EI
 		cpu.IFF1 = true
 		cpu.IFF2 = true
+		cpu.eiPending = true
 		return 4
 	case 0xFC: // CALL M, nn
 		addr := cpu.ReadImmediateWord()
 		cpu.MEMPTR = addr
 		if cpu.GetFlag(FLAG_S) {
+			cpu.internal(cpu.PC-1, 1)
 			cpu.Push(cpu.PC)
 			cpu.PC = addr
 			return 17
@@ -1009,6 +1062,7 @@ func (cpu *CPU) ExecuteOpcode(opcode byte) int {
 		cpu.cp8(value)
 		return 7
 	case 0xFF: // RST 38H
+		cpu.internal(cpu.getIR(), 1)
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0038
 		cpu.MEMPTR = 0x0038
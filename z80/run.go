@@ -0,0 +1,105 @@
+// Package z80 implements a Z80 CPU emulator with support for all documented
+// and undocumented opcodes, flags, and registers.
+package z80
+
+// StopReason tells why Run returned
+type StopReason byte
+
+// Stop* constants enumerate the reasons Run returns
+const (
+	StopBudget     StopReason = iota // The T-state budget was used up
+	StopBreakpoint                   // The Breaker asked to stop before the instruction at PC
+	StopRequested                    // Stop was called
+)
+
+// String returns a short name for the stop reason
+func (r StopReason) String() string {
+	switch r {
+	case StopBudget:
+		return "budget"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopRequested:
+		return "stop requested"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker decides whether Run stops before executing the instruction at PC
+type Breaker interface {
+	Break(cpu *CPU) bool
+}
+
+// Breakpoints is a Breaker that stops at a set of addresses
+type Breakpoints map[uint16]bool
+
+// Break implements Breaker
+func (b Breakpoints) Break(cpu *CPU) bool {
+	return b[cpu.PC]
+}
+
+// Run executes instructions until at least budget T-states have been used,
+// the Breaker asks to stop, or Stop is called. The last instruction may take
+// the total slightly over budget; the caller should carry the excess into the
+// next frame. The Breaker is consulted before every instruction, so a frame
+// that ends on a breakpoint stops at it when the next Run starts; use
+// Continue to resume from a breakpoint.
+//
+// While the CPU is halted with no Bus, Contention or Tracer attached and no
+// interrupt pending, the rest of the budget is skipped in one go instead of
+// executing NOPs one by one.
+func (cpu *CPU) Run(budget int) (used int, reason StopReason) {
+	return cpu.run(budget, false)
+}
+
+// Continue is Run resuming from a breakpoint: the Breaker is not consulted
+// for the instruction at PC when Continue is called, and is for every one
+// after it
+func (cpu *CPU) Continue(budget int) (used int, reason StopReason) {
+	return cpu.run(budget, true)
+}
+
+// run implements Run and Continue
+func (cpu *CPU) run(budget int, resume bool) (used int, reason StopReason) {
+	for ; used < budget; resume = false {
+		if cpu.stop.Swap(false) {
+			return used, StopRequested
+		}
+		if !resume && !cpu.HALT && cpu.Breaker != nil && cpu.Breaker.Break(cpu) {
+			return used, StopBreakpoint
+		}
+		if cpu.HALT && cpu.Bus == nil && cpu.Contention == nil && cpu.Tracer == nil {
+			// Interrupts are polled once per step, here or in step
+			kind := cpu.poll()
+			if kind == StepInstruction {
+				used += cpu.skipHALT(budget - used)
+				continue
+			}
+			used += cpu.step(kind)
+			continue
+		}
+		used += cpu.ExecuteOneInstruction()
+	}
+	return used, StopBudget
+}
+
+// Stop makes Run return before the next instruction. It may be called from
+// another goroutine; a Stop issued while Run is not running makes the next
+// Run return immediately.
+func (cpu *CPU) Stop() {
+	cpu.stop.Store(true)
+}
+
+// skipHALT fast-forwards the halted CPU by whole NOP cycles covering at
+// least budget T-states and returns the T-states skipped. It is only used
+// when nothing observes the NOPs and no interrupt is about to be accepted.
+func (cpu *CPU) skipHALT(budget int) int {
+	// Every halted NOP is an M1 cycle, so R keeps counting
+	nops := (budget + 3) / 4
+	cpu.R = (cpu.R & 0x80) | (byte(int(cpu.R)+nops) & 0x7F)
+	cpu.eiPending = false
+	cpu.ldAIR = false
+	cpu.TotalTStates += uint64(nops * 4)
+	return nops * 4
+}
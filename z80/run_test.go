@@ -0,0 +1,192 @@
+package z80
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Run executes whole instructions until the budget is used and may overshoot
+// by the last instruction.
+func TestRun_Budget(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	loadProgram(cpu, mem, 0x0000, 0x00, 0x00, 0x01, 0x34, 0x12, 0x00) // NOP; NOP; LD BC,1234; NOP
+
+	used, reason := cpu.Run(9)
+	assertEq(t, reason, StopBudget, "reason")
+	assertEq(t, used, 18, "used")
+	assertEq(t, cpu.PC, uint16(0x0005), "PC")
+	assertEq(t, cpu.TotalTStates, uint64(18), "total T-states")
+
+	used, _ = cpu.Run(1)
+	assertEq(t, used, 4, "used by second run")
+	assertEq(t, cpu.TotalTStates, uint64(22), "total T-states after second run")
+}
+
+// Breakpoints stop before the instruction, and Continue resumes from them.
+func TestRun_Breakpoint(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	loadProgram(cpu, mem, 0x0000, 0x00, 0x00, 0x00, 0x18, 0xFB) // NOP x3; JR -5
+	cpu.Breaker = Breakpoints{0x0002: true}
+
+	used, reason := cpu.Run(1000)
+	assertEq(t, reason, StopBreakpoint, "reason")
+	assertEq(t, used, 8, "used")
+	assertEq(t, cpu.PC, uint16(0x0002), "PC")
+
+	used, reason = cpu.Run(1000)
+	assertEq(t, reason, StopBreakpoint, "reason when running again")
+	assertEq(t, used, 0, "used when running again")
+
+	used, reason = cpu.Continue(1000)
+	assertEq(t, reason, StopBreakpoint, "reason after resume")
+	assertEq(t, used, 4+12+4+4, "used for one loop")
+	assertEq(t, cpu.PC, uint16(0x0002), "PC after resume")
+}
+
+// A breakpoint reached exactly when a budget runs out stops the next Run.
+func TestRun_BreakpointAtBudgetBoundary(t *testing.T) {
+	cpu, _, _ := testCPU() // NOPs everywhere
+	cpu.Breaker = Breakpoints{0x0003: true}
+
+	used, reason := cpu.Run(12)
+	assertEq(t, reason, StopBudget, "reason")
+	assertEq(t, used, 12, "used")
+	used, reason = cpu.Run(12)
+	assertEq(t, reason, StopBreakpoint, "reason at boundary")
+	assertEq(t, used, 0, "used at boundary")
+	assertEq(t, cpu.PC, uint16(0x0003), "PC")
+}
+
+// Stop makes the next instruction boundary return, even from a callback.
+func TestRun_Stop(t *testing.T) {
+	cpu, mem, io := testCPU()
+	loadProgram(cpu, mem, 0x0000, 0xD3, 0x10, 0x00, 0x00) // OUT (10),A; NOP; NOP
+	stopper := &stopOnWrite{mockIO: io, cpu: cpu}
+	cpu.IO = stopper
+
+	used, reason := cpu.Run(1000)
+	assertEq(t, reason, StopRequested, "reason")
+	assertEq(t, used, 11, "used")
+	assertEq(t, cpu.PC, uint16(0x0002), "PC")
+
+	// The request is consumed
+	used, reason = cpu.Run(8)
+	assertEq(t, reason, StopBudget, "reason after stop")
+	assertEq(t, used, 8, "used after stop")
+}
+
+// stopOnWrite stops the CPU when a port is written.
+type stopOnWrite struct {
+	*mockIO
+	cpu *CPU
+}
+
+func (io *stopOnWrite) WritePort(port uint16, value byte) {
+	io.mockIO.WritePort(port, value)
+	io.cpu.Stop()
+}
+
+// A halted CPU skips the rest of the budget but keeps R and the clock running.
+func TestRun_HALTFastForward(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	loadProgram(cpu, mem, 0x0000, 0x76) // HALT
+	cpu.R = 0x80
+
+	used, reason := cpu.Run(69888)
+	assertEq(t, reason, StopBudget, "reason")
+	assertEq(t, used, 69888, "used")
+	assertEq(t, cpu.TotalTStates, uint64(69888), "total T-states")
+	assertEq(t, cpu.R, byte(0x80|(69888/4)&0x7F), "R")
+	assertEq(t, cpu.PC, uint16(0x0000), "PC")
+
+	// Same result as stepping one NOP at a time
+	ref, refMem, _ := testCPU()
+	loadProgram(ref, refMem, 0x0000, 0x76)
+	ref.R = 0x80
+	ref.Bus = &recordingBus{}
+	refUsed, _ := ref.Run(69888)
+	assertEq(t, refUsed, used, "used when stepping")
+	assertEq(t, ref.Snapshot(), cpu.Snapshot(), "state when stepping")
+}
+
+// A pending interrupt is taken instead of fast-forwarding.
+func TestRun_HALTInterrupt(t *testing.T) {
+	cpu, mem, io := interruptCPU(1)
+	loadProgram(cpu, mem, 0x1000, 0x76) // HALT
+	io.interrupt = false
+	cpu.Run(4)
+	assertEq(t, cpu.HALT, true, "halted")
+
+	io.interrupt = true
+	mem.WriteByte(0x0038, 0x00)
+	used, _ := cpu.Run(14)
+	assertEq(t, cpu.HALT, false, "HALT after interrupt")
+	assertEq(t, used, 13+4, "used")
+	assertEq(t, cpu.PC, uint16(0x0039), "PC")
+}
+
+// countingIO counts interrupt polls.
+type countingIO struct {
+	*mockIO
+	polls int
+}
+
+func (io *countingIO) CheckInterrupt() bool {
+	io.polls++
+	return io.mockIO.CheckInterrupt()
+}
+
+// A halted CPU polls the interrupt line once per step, also when it takes
+// the interrupt instead of fast-forwarding.
+func TestRun_HALTPollsOnce(t *testing.T) {
+	cpu, mem, io := interruptCPU(1)
+	loadProgram(cpu, mem, 0x1000, 0x76) // HALT
+	counter := &countingIO{mockIO: io.mockIO}
+	cpu.IO = counter
+	io.interrupt = false
+	cpu.Run(4)
+	assertEq(t, counter.polls, 1, "polls for HALT")
+
+	cpu.Run(4)
+	assertEq(t, counter.polls, 2, "polls while fast-forwarding")
+
+	io.interrupt = true
+	cpu.Run(1)
+	assertEq(t, counter.polls, 3, "polls when taking the interrupt")
+	assertEq(t, cpu.HALT, false, "HALT after interrupt")
+}
+
+// stepLog is a Tracer recording the kind, PC and T-states of every step.
+type stepLog struct {
+	steps []string
+}
+
+func (l *stepLog) BeforeStep(cpu *CPU, kind StepKind) {
+	l.steps = append(l.steps, fmt.Sprintf("%s %04X", kind, cpu.PC))
+}
+
+func (l *stepLog) AfterStep(cpu *CPU, tstates int) {
+	l.steps[len(l.steps)-1] += fmt.Sprintf(" %d", tstates)
+}
+
+// The Tracer sees instructions, interrupts and NMIs, and HALT is not
+// fast-forwarded past it.
+func TestTracer(t *testing.T) {
+	cpu, mem, io := interruptCPU(1)
+	loadProgram(cpu, mem, 0x1000, 0x76) // HALT
+	mem.WriteByte(0x0038, 0x00)
+	log := &stepLog{}
+	cpu.Tracer = log
+
+	io.interrupt = false
+	cpu.Run(8)
+	io.interrupt = true
+	cpu.ExecuteOneInstruction()
+	io.interrupt = false
+	cpu.SetNMI(true)
+	cpu.ExecuteOneInstruction()
+
+	want := []string{"instruction 1000 4", "instruction 1000 4", "interrupt 1000 13", "nmi 0038 11"}
+	assertEq(t, strings.Join(log.steps, ", "), strings.Join(want, ", "), "steps")
+}
@@ -0,0 +1,195 @@
+// Package z80 implements a Z80 CPU emulator with support for all documented
+// and undocumented opcodes, flags, and registers.
+package z80
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// State is a copy of everything needed to resume a CPU exactly where it
+// stopped, including the internal latches that are not visible as registers.
+// It is a plain value, so it can be stored and compared directly.
+type State struct {
+	AF     uint16 `json:"af"`
+	BC     uint16 `json:"bc"`
+	DE     uint16 `json:"de"`
+	HL     uint16 `json:"hl"`
+	AF_    uint16 `json:"af_"`
+	BC_    uint16 `json:"bc_"`
+	DE_    uint16 `json:"de_"`
+	HL_    uint16 `json:"hl_"`
+	IX     uint16 `json:"ix"`
+	IY     uint16 `json:"iy"`
+	SP     uint16 `json:"sp"`
+	PC     uint16 `json:"pc"`
+	MEMPTR uint16 `json:"memptr"`
+	I      byte   `json:"i"`
+	R      byte   `json:"r"`
+	IM     byte   `json:"im"`
+	IFF1   bool   `json:"iff1"`
+	IFF2   bool   `json:"iff2"`
+	HALT   bool   `json:"halt"`
+	Model  Model  `json:"model"`
+
+	TotalTStates uint64 `json:"total_tstates"` // T-states executed so far
+
+	EIPending  bool `json:"ei_pending"`  // EI was the last instruction
+	Prefix     byte `json:"prefix"`      // Pending DD or FD prefix, 0 if none
+	AfterLDAIR bool `json:"after_ldair"` // LD A,I or LD A,R was the last instruction
+	NMILine    bool `json:"nmi_line"`    // Level of the NMI input
+	NMIPending bool `json:"nmi_pending"` // NMI edge latched but not serviced yet
+}
+
+// stateVersion is the first byte of the binary encoding of a State. Version
+// 1 had no TotalTStates and is still decoded.
+const stateVersion = 2
+
+// Lengths of the binary encodings of a State
+const (
+	stateSizeV1 = 1 + 13*2 + 5 + 1
+	stateSize   = stateSizeV1 + 8
+)
+
+// State flag bits packed into the last byte of the binary encoding
+const (
+	stateIFF1 = 1 << iota
+	stateIFF2
+	stateHALT
+	stateEIPending
+	stateAfterLDAIR
+	stateNMILine
+	stateNMIPending
+)
+
+// Snapshot returns the current state of the CPU. It should be taken between
+// instructions, not from inside a Bus or Contention callback.
+func (cpu *CPU) Snapshot() State {
+	return State{
+		AF:     cpu.GetAF(),
+		BC:     cpu.GetBC(),
+		DE:     cpu.GetDE(),
+		HL:     cpu.GetHL(),
+		AF_:    cpu.GetAF_(),
+		BC_:    cpu.GetBC_(),
+		DE_:    cpu.GetDE_(),
+		HL_:    cpu.GetHL_(),
+		IX:     cpu.IX,
+		IY:     cpu.IY,
+		SP:     cpu.SP,
+		PC:     cpu.PC,
+		MEMPTR: cpu.MEMPTR,
+		I:      cpu.I,
+		R:      cpu.R,
+		IM:     cpu.IM,
+		IFF1:   cpu.IFF1,
+		IFF2:   cpu.IFF2,
+		HALT:   cpu.HALT,
+		Model:  cpu.Model,
+
+		TotalTStates: cpu.TotalTStates,
+
+		EIPending:  cpu.eiPending,
+		Prefix:     cpu.prefix,
+		AfterLDAIR: cpu.ldAIR,
+		NMILine:    cpu.nmiLine,
+		NMIPending: cpu.nmiPending,
+	}
+}
+
+// Restore loads a state previously returned by Snapshot. Memory, IO and the
+// optional Bus and Contention hooks are left untouched.
+func (cpu *CPU) Restore(s State) {
+	cpu.SetAF(s.AF)
+	cpu.SetBC(s.BC)
+	cpu.SetDE(s.DE)
+	cpu.SetHL(s.HL)
+	cpu.SetAF_(s.AF_)
+	cpu.SetBC_(s.BC_)
+	cpu.SetDE_(s.DE_)
+	cpu.SetHL_(s.HL_)
+	cpu.IX = s.IX
+	cpu.IY = s.IY
+	cpu.SP = s.SP
+	cpu.PC = s.PC
+	cpu.MEMPTR = s.MEMPTR
+	cpu.I = s.I
+	cpu.R = s.R
+	cpu.IM = s.IM
+	cpu.IFF1 = s.IFF1
+	cpu.IFF2 = s.IFF2
+	cpu.HALT = s.HALT
+	cpu.Model = s.Model
+	cpu.TotalTStates = s.TotalTStates
+
+	cpu.eiPending = s.EIPending
+	cpu.prefix = s.Prefix
+	cpu.ldAIR = s.AfterLDAIR
+	cpu.nmiLine = s.NMILine
+	cpu.nmiPending = s.NMIPending
+}
+
+// MarshalBinary encodes the state in a compact little-endian format
+func (s State) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, stateSize)
+	data = append(data, stateVersion)
+	for _, w := range []uint16{s.AF, s.BC, s.DE, s.HL, s.AF_, s.BC_, s.DE_, s.HL_, s.IX, s.IY, s.SP, s.PC, s.MEMPTR} {
+		data = binary.LittleEndian.AppendUint16(data, w)
+	}
+	data = append(data, s.I, s.R, s.IM, byte(s.Model), s.Prefix)
+
+	flags := boolToByte(s.IFF1)*stateIFF1 |
+		boolToByte(s.IFF2)*stateIFF2 |
+		boolToByte(s.HALT)*stateHALT |
+		boolToByte(s.EIPending)*stateEIPending |
+		boolToByte(s.AfterLDAIR)*stateAfterLDAIR |
+		boolToByte(s.NMILine)*stateNMILine |
+		boolToByte(s.NMIPending)*stateNMIPending
+	data = append(data, flags)
+	return binary.LittleEndian.AppendUint64(data, s.TotalTStates), nil
+}
+
+// UnmarshalBinary decodes a state encoded by MarshalBinary
+func (s *State) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty state")
+	}
+	size := stateSize
+	switch data[0] {
+	case 1:
+		size = stateSizeV1
+	case stateVersion:
+	default:
+		return fmt.Errorf("unsupported state version %d", data[0])
+	}
+	if len(data) != size {
+		return fmt.Errorf("invalid state length %d, expected %d", len(data), size)
+	}
+
+	words := []*uint16{&s.AF, &s.BC, &s.DE, &s.HL, &s.AF_, &s.BC_, &s.DE_, &s.HL_, &s.IX, &s.IY, &s.SP, &s.PC, &s.MEMPTR}
+	for i, w := range words {
+		*w = binary.LittleEndian.Uint16(data[1+i*2:])
+	}
+	rest := data[1+len(words)*2:]
+	s.I, s.R, s.IM, s.Model, s.Prefix = rest[0], rest[1], rest[2], Model(rest[3]), rest[4]
+	if s.Model > ModelCMOS {
+		return fmt.Errorf("invalid CPU model %d", s.Model)
+	}
+	if s.Prefix != 0 && s.Prefix != 0xDD && s.Prefix != 0xFD {
+		return fmt.Errorf("invalid pending prefix %02X", s.Prefix)
+	}
+
+	flags := rest[5]
+	s.IFF1 = flags&stateIFF1 != 0
+	s.IFF2 = flags&stateIFF2 != 0
+	s.HALT = flags&stateHALT != 0
+	s.EIPending = flags&stateEIPending != 0
+	s.AfterLDAIR = flags&stateAfterLDAIR != 0
+	s.NMILine = flags&stateNMILine != 0
+	s.NMIPending = flags&stateNMIPending != 0
+	s.TotalTStates = 0
+	if data[0] == stateVersion {
+		s.TotalTStates = binary.LittleEndian.Uint64(rest[6:])
+	}
+	return nil
+}
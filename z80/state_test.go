@@ -0,0 +1,130 @@
+package z80
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// sampleState returns a state with every field set to something distinctive.
+func sampleState() State {
+	return State{
+		AF: 0x1122, BC: 0x3344, DE: 0x5566, HL: 0x7788,
+		AF_: 0x99AA, BC_: 0xBBCC, DE_: 0xDDEE, HL_: 0xFF01,
+		IX: 0x2345, IY: 0x6789, SP: 0xABCD, PC: 0xEF01, MEMPTR: 0x1357,
+		I: 0x3F, R: 0x85, IM: 2,
+		IFF1: true, IFF2: true, HALT: false, Model: ModelCMOS,
+		TotalTStates: 0x0123456789ABCDEF,
+		EIPending:    true, Prefix: 0xFD, AfterLDAIR: true, NMILine: true, NMIPending: true,
+	}
+}
+
+// Restoring a snapshot reproduces the CPU state field by field.
+func TestState_SnapshotRestore(t *testing.T) {
+	cpu, _, _ := testCPU()
+	want := sampleState()
+	cpu.Restore(want)
+	assertEq(t, cpu.Snapshot(), want, "snapshot after restore")
+	assertEq(t, cpu.GetHL_(), uint16(0xFF01), "HL'")
+	assertEq(t, cpu.Model, ModelCMOS, "model")
+}
+
+// Execution after a restore follows the same path, including a pending prefix.
+func TestState_DeterministicReplay(t *testing.T) {
+	cpu, mem, _ := testCPU()
+	// DD; LD IY,1234; INC HL; EI
+	loadProgram(cpu, mem, 0x0000, 0xDD, 0xFD, 0x21, 0x34, 0x12, 0x23, 0xFB, 0x00)
+	mustStep(t, cpu) // DD FD, leaving FD pending
+	saved := cpu.Snapshot()
+	assertEq(t, saved.Prefix, byte(0xFD), "pending prefix")
+
+	run := func() State {
+		for i := 0; i < 3; i++ {
+			mustStep(t, cpu)
+		}
+		return cpu.Snapshot()
+	}
+	first := run()
+	assertEq(t, first.IY, uint16(0x1234), "IY")
+	assertEq(t, first.HL, uint16(0x0001), "HL")
+	assertEq(t, first.EIPending, true, "EI pending")
+
+	cpu.Restore(saved)
+	assertEq(t, run(), first, "state after replay")
+}
+
+// The binary encoding round-trips and rejects malformed data.
+func TestState_Binary(t *testing.T) {
+	want := sampleState()
+	data, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	assertEq(t, len(data), stateSize, "encoded length")
+
+	var got State
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	assertEq(t, got, want, "decoded state")
+
+	if err := got.UnmarshalBinary(data[:10]); err == nil {
+		t.Errorf("short data accepted")
+	}
+	bad := append([]byte{}, data...)
+	bad[0] = 99
+	if err := got.UnmarshalBinary(bad); err == nil {
+		t.Errorf("unknown version accepted")
+	}
+	bad = append([]byte{}, data...)
+	bad[stateSize-10] = 0x12 // prefix
+	if err := got.UnmarshalBinary(bad); err == nil {
+		t.Errorf("invalid prefix accepted")
+	}
+
+	if err := got.UnmarshalBinary(nil); err == nil {
+		t.Errorf("empty data accepted")
+	}
+}
+
+// Version 1 encodings, which had no T-state counter, still decode.
+func TestState_BinaryVersion1(t *testing.T) {
+	want := sampleState()
+	data, _ := want.MarshalBinary()
+	old := append([]byte{1}, data[1:stateSizeV1]...)
+
+	var got State
+	if err := got.UnmarshalBinary(old); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	want.TotalTStates = 0
+	assertEq(t, got, want, "decoded version 1 state")
+
+	if err := got.UnmarshalBinary(append(old, 0)); err == nil {
+		t.Errorf("version 1 with the wrong length accepted")
+	}
+}
+
+// The JSON encoding round-trips and names the model.
+func TestState_JSON(t *testing.T) {
+	want := sampleState()
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal into map: %v", err)
+	}
+	assertEq(t, fields["model"], any("cmos"), "model field")
+	assertEq(t, fields["pc"], any(float64(0xEF01)), "pc field")
+
+	var got State
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	assertEq(t, got, want, "decoded state")
+
+	if err := json.Unmarshal([]byte(`{"model":"z180"}`), &got); err == nil {
+		t.Errorf("unknown model accepted")
+	}
+}
@@ -2,6 +2,11 @@
 // and undocumented opcodes, flags, and registers.
 package z80
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // Memory interface for memory operations
 type Memory interface {
 	ReadByte(address uint16) byte
@@ -17,6 +22,24 @@ type IO interface {
 	CheckInterrupt() bool
 }
 
+// NMIChecker is an optional extension of IO for devices wired to the NMI input
+type NMIChecker interface {
+	// CheckNMI reports whether the NMI line is active. It is sampled before
+	// every instruction and only the change from inactive to active
+	// triggers an NMI.
+	CheckNMI() bool
+}
+
+// InterruptAcknowledger is an optional extension of IO for devices that put
+// data on the bus while an interrupt is acknowledged. Without it the bus
+// floats and reads as 0xFF.
+type InterruptAcknowledger interface {
+	// AcknowledgeInterrupt returns the next byte the device puts on the data
+	// bus. In IM 0 it is called once for every byte of the instruction the
+	// device supplies, in IM 2 once for the low byte of the vector address.
+	AcknowledgeInterrupt() byte
+}
+
 // FLAG_* constants represent the bit positions of the FLAGS register
 const (
 	FLAG_C  = 0x01 // Carry flag
@@ -35,6 +58,48 @@ const (
 	FLAG_Y = FLAG_5
 )
 
+// Model selects between the behaviours of different Z80 implementations
+type Model byte
+
+// Model* constants enumerate the supported Z80 implementations
+const (
+	ModelNMOS Model = iota // Original NMOS Z80
+	ModelCMOS              // CMOS Z84C00
+)
+
+// String returns the name of the model
+func (m Model) String() string {
+	switch m {
+	case ModelNMOS:
+		return "nmos"
+	case ModelCMOS:
+		return "cmos"
+	default:
+		return fmt.Sprintf("Model(%d)", byte(m))
+	}
+}
+
+// MarshalText encodes the model by name
+func (m Model) MarshalText() ([]byte, error) {
+	if m > ModelCMOS {
+		return nil, fmt.Errorf("invalid CPU model %d", byte(m))
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes a model name produced by MarshalText
+func (m *Model) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "nmos":
+		*m = ModelNMOS
+	case "cmos":
+		*m = ModelCMOS
+	default:
+		return fmt.Errorf("unknown CPU model %q", text)
+	}
+	return nil
+}
+
 // CPU represents the state of a Z80 processor
 type CPU struct {
 	A      byte   // Accumulator
@@ -57,9 +122,61 @@ type CPU struct {
 	IFF2   bool   // Interrupt flip-flop 2
 	HALT   bool   // HALT state flag
 	MEMPTR uint16 // MEMPTR register (undocumented)
+	Model  Model  // Implementation to emulate, NMOS by default
+
+	TotalTStates uint64 // T-states executed since the CPU was created
 
-	Memory Memory // Memory interface
-	IO     IO     // IO interface
+	Memory     Memory      // Memory interface
+	IO         IO          // IO interface
+	Bus        Bus         // Optional machine cycle observer
+	Contention Contention  // Optional source of wait states
+	ErrorHook  func(error) // Optional strict mode, receives undefined opcodes
+	Breaker    Breaker     // Optional breakpoints checked by Run
+	Tracer     Tracer      // Optional observer of every step
+
+	tstate     int    // T-states elapsed in the current instruction
+	wait       int    // Wait states inserted in the current instruction
+	start      uint16 // PC at the start of the instruction, for error reports
+	injecting  bool   // Instruction bytes come from the interrupting device (IM 0)
+	eiPending  bool   // EI was the last instruction, interrupts wait one more
+	prefix     byte   // DD or FD prefix fetched by the last step, 0 if none
+	ldAIR      bool   // LD A,I or LD A,R was the last instruction
+	nmiLine    bool   // Level of the NMI input
+	nmiPending bool   // NMI edge latched but not serviced yet
+
+	stop atomic.Bool // Stop was requested, Run returns at the next instruction
+}
+
+// StepKind tells what a step of ExecuteOneInstruction does
+type StepKind byte
+
+// Step* constants enumerate the kinds of steps
+const (
+	StepInstruction StepKind = iota // Executes the instruction at PC (or a HALT NOP)
+	StepInterrupt                   // Accepts a maskable interrupt
+	StepNMI                         // Accepts a non-maskable interrupt
+)
+
+// String returns a short name for the step kind
+func (k StepKind) String() string {
+	switch k {
+	case StepInstruction:
+		return "instruction"
+	case StepInterrupt:
+		return "interrupt"
+	case StepNMI:
+		return "nmi"
+	default:
+		return "unknown"
+	}
+}
+
+// Tracer observes every step ExecuteOneInstruction takes
+type Tracer interface {
+	// BeforeStep is called before the step changes any state
+	BeforeStep(cpu *CPU, kind StepKind)
+	// AfterStep is called with the T-states the step took
+	AfterStep(cpu *CPU, tstates int)
 }
 
 // New creates a new Z80 CPU instance
@@ -178,48 +295,60 @@ func (cpu *CPU) ClearAllFlags() {
 
 // ReadImmediateByte reads the next byte from memory at PC and increments PC
 func (cpu *CPU) ReadImmediateByte() byte {
-	value := cpu.Memory.ReadByte(cpu.PC)
-	cpu.PC++
-	return value
+	return cpu.readInstructionByte()
 }
 
 // ReadImmediateWord reads the next word from memory at PC and increments PC by 2
 func (cpu *CPU) ReadImmediateWord() uint16 {
-	lo := cpu.Memory.ReadByte(cpu.PC)
-	cpu.PC++
-	hi := cpu.Memory.ReadByte(cpu.PC)
-	cpu.PC++
+	lo := cpu.readInstructionByte()
+	hi := cpu.readInstructionByte()
 	return (uint16(hi) << 8) | uint16(lo)
 }
 
 // ReadDisplacement reads an 8-bit signed displacement value
 func (cpu *CPU) ReadDisplacement() int8 {
-	value := int8(cpu.Memory.ReadByte(cpu.PC))
-	cpu.PC++
-	return value
+	return int8(cpu.readInstructionByte())
 }
 
 // ReadOpcode reads the next opcode from memory at PC and increments PC
 func (cpu *CPU) ReadOpcode() byte {
+	if cpu.injecting {
+		// Every M1 cycle of an injected instruction is an acknowledge cycle
+		// with two automatic wait states, and PC stays where it is
+		opcode := cpu.acknowledgeByte()
+		cpu.cycle(CycleInterruptAck, cpu.PC, opcode, 6, 0)
+		cpu.incrementR()
+		return opcode
+	}
+
+	wait := cpu.contendMemory(cpu.PC)
 	opcode := cpu.Memory.ReadByte(cpu.PC)
+	cpu.cycle(CycleFetch, cpu.PC, opcode, 4, wait)
 	cpu.PC++
-	// Increment R register (memory refresh) for each opcode fetch
+	cpu.incrementR()
+	return opcode
+}
+
+// incrementR advances the memory refresh counter once per M1 cycle
+func (cpu *CPU) incrementR() {
 	// Note: R is a 7-bit register, bit 7 remains unchanged
 	cpu.R = (cpu.R & 0x80) | ((cpu.R + 1) & 0x7F)
-	return opcode
 }
 
 // Push pushes a 16-bit value onto the stack
 func (cpu *CPU) Push(value uint16) {
-	cpu.SP -= 2
-	cpu.Memory.WriteWord(cpu.SP, value)
+	// High byte goes out first, as on real hardware
+	cpu.SP--
+	cpu.writeByte(cpu.SP, byte(value>>8))
+	cpu.SP--
+	cpu.writeByte(cpu.SP, byte(value))
 }
 
 // Pop pops a 16-bit value from the stack
 func (cpu *CPU) Pop() uint16 {
 	// Read low byte first, then high byte (little-endian)
-	lo := cpu.Memory.ReadByte(cpu.SP)
-	hi := cpu.Memory.ReadByte(cpu.SP + 1)
+	lo := cpu.readByte(cpu.SP)
+	hi := cpu.readByte(cpu.SP + 1)
 	cpu.SP += 2
 	return (uint16(hi) << 8) | uint16(lo)
 }
@@ -282,19 +411,84 @@ func boolToByte(b bool) byte {
 	return 0
 }
 
-// ExecuteOneInstruction executes a single instruction and returns the number of T-states used
+// ExecuteOneInstruction executes a single instruction and returns the number of T-states used,
+// including any wait states inserted by contention
 func (cpu *CPU) ExecuteOneInstruction() int {
-	// Handle interrupts first if enabled
-	if cpu.IFF1 && cpu.IO.CheckInterrupt() {
-		return cpu.HandleInterrupt()
+	return cpu.step(cpu.poll())
+}
+
+// poll samples the NMI and interrupt lines and returns what the next step
+// does. Devices are asked once per step.
+func (cpu *CPU) poll() StepKind {
+	if device, ok := cpu.IO.(NMIChecker); ok {
+		cpu.SetNMI(device.CheckNMI())
 	}
 
+	switch {
+	// A latched NMI takes priority over everything else, but like maskable
+	// interrupts it is not accepted between a prefix and its instruction
+	case cpu.nmiPending && cpu.prefix == 0:
+		return StepNMI
+	// Interrupts are not accepted right after EI or between an index prefix
+	// and the instruction it modifies
+	case cpu.IFF1 && !cpu.eiPending && cpu.prefix == 0 && cpu.IO.CheckInterrupt():
+		return StepInterrupt
+	}
+	return StepInstruction
+}
+
+// step performs a step of the kind poll returned and returns its T-states
+func (cpu *CPU) step(kind StepKind) int {
+	if cpu.Tracer != nil {
+		cpu.Tracer.BeforeStep(cpu, kind)
+	}
+	var tstates int
+	switch kind {
+	case StepNMI:
+		cpu.nmiPending = false
+		tstates = cpu.HandleNMI()
+	case StepInterrupt:
+		tstates = cpu.HandleInterrupt()
+	default:
+		cpu.tstate = 0
+		cpu.wait = 0
+		cpu.eiPending = false
+		cpu.ldAIR = false
+		tstates = cpu.executeInstruction() // Evaluated before cpu.wait is read
+		tstates += cpu.wait
+		cpu.TotalTStates += uint64(tstates)
+	}
+	if cpu.Tracer != nil {
+		cpu.Tracer.AfterStep(cpu, tstates)
+	}
+	return tstates
+}
+
+// executeInstruction fetches and executes one instruction and returns its
+// T-states without wait states
+func (cpu *CPU) executeInstruction() int {
 	// Handle HALT state
 	if cpu.HALT {
+		// The CPU keeps running NOP M1 cycles without advancing PC
+		cpu.ReadOpcode()
+		cpu.PC--
 		return 4 // 4 T-states for HALT
 	}
 
-	// Read the next opcode
+	// Finish an instruction whose index prefix was fetched by the last step
+	if cpu.prefix != 0 {
+		prefix := cpu.prefix
+		cpu.prefix = 0
+		opcode := cpu.ReadOpcode()
+		// The prefix fetch was already counted by the last step
+		if prefix == 0xDD {
+			return cpu.ExecuteDDOpcode(opcode) - 4
+		}
+		return cpu.ExecuteFDOpcode(opcode) - 4
+	}
+
+	// Read the next opcode. PC does not move while the device supplies it.
+	cpu.start = cpu.PC
 	opcode := cpu.ReadOpcode()
 
 	// Handle prefixed opcodes
@@ -318,10 +512,24 @@ func (cpu *CPU) ExecuteOneInstruction() int {
 
 // HandleInterrupt handles interrupt processing
 func (cpu *CPU) HandleInterrupt() int {
-	// Exit HALT state if in HALT
-	if cpu.HALT {
-		cpu.HALT = false
+	tstates := cpu.handleInterrupt()
+	cpu.TotalTStates += uint64(tstates)
+	return tstates
+}
+
+// handleInterrupt accepts a maskable interrupt and returns its T-states
+func (cpu *CPU) handleInterrupt() int {
+	cpu.tstate = 0
+	cpu.wait = 0
+
+	cpu.leaveHALT()
+
+	// NMOS parts copy IFF2 into P/V too late when the interrupt is accepted
+	// right after LD A,I or LD A,R, so the flag reads as 0
+	if cpu.ldAIR && cpu.Model == ModelNMOS {
+		cpu.F &^= FLAG_PV
 	}
+	cpu.ldAIR = false
 
 	// Reset interrupt flip-flops
 	cpu.IFF1 = false
@@ -329,35 +537,117 @@ func (cpu *CPU) HandleInterrupt() int {
 
 	// Handle interrupt based on mode
 	switch cpu.IM {
-	case 0, 1:
-		// Mode 0/1: Restart at address 0x0038
+	case 0:
+		// Mode 0: execute the instruction the device puts on the bus, usually
+		// an RST. Its M1 cycles take two extra T-states each, so the elapsed
+		// T-states are taken from the counter.
+		cpu.injecting = true
+		cpu.executeInstruction()
+		cpu.injecting = false
+		return cpu.tstate
+	case 1:
+		// Mode 1: Restart at address 0x0038
+		cpu.acknowledge()
 		cpu.Push(cpu.PC)
 		cpu.PC = 0x0038
-		return 13 // 13 T-states for interrupt handling
+		return 13 + cpu.wait // 13 T-states for interrupt handling
 	case 2:
-		// Mode 2: Call interrupt vector
+		// Mode 2: Call through the table entry selected by I and the vector
+		// the device puts on the bus during acknowledge. All 8 bits of the
+		// vector are used, even though peripherals keep bit 0 clear.
+		vector := cpu.acknowledge()
 		cpu.Push(cpu.PC)
-		vectorAddr := (uint16(cpu.I) << 8) | 0xFF // Use 0xFF as vector for non-maskable interrupt
-		cpu.PC = cpu.Memory.ReadWord(vectorAddr)
-		return 19 // 19 T-states for interrupt handling
+		vectorAddr := (uint16(cpu.I) << 8) | uint16(vector)
+		cpu.PC = cpu.readWord(vectorAddr)
+		cpu.MEMPTR = cpu.PC
+		return 19 + cpu.wait // 19 T-states for interrupt handling
 	default:
 		// Should not happen, but handle gracefully
 		return 0
 	}
 }
 
+// acknowledge performs the IM 1 and IM 2 acknowledge cycle and returns the
+// byte on the data bus. The cycle is an M1 with two automatic wait states
+// and one more T-state to decrement SP. There is no memory request, so it is
+// never contended.
+func (cpu *CPU) acknowledge() byte {
+	data := cpu.acknowledgeByte()
+	cpu.cycle(CycleInterruptAck, cpu.PC, data, 7, 0)
+	cpu.incrementR()
+	return data
+}
+
+// SetNMI drives the NMI input. An NMI is triggered when the line becomes
+// active and is serviced before the next instruction; holding the line active
+// does not trigger it again.
+func (cpu *CPU) SetNMI(active bool) {
+	if active && !cpu.nmiLine {
+		cpu.nmiPending = true
+	}
+	cpu.nmiLine = active
+}
+
 // HandleNMI handles non-maskable interrupt
 func (cpu *CPU) HandleNMI() int {
+	tstates := cpu.handleNMI()
+	cpu.TotalTStates += uint64(tstates)
+	return tstates
+}
+
+// handleNMI accepts a non-maskable interrupt and returns its T-states
+func (cpu *CPU) handleNMI() int {
+	cpu.tstate = 0
+	cpu.wait = 0
+	cpu.eiPending = false
+	cpu.ldAIR = false
+
+	cpu.leaveHALT()
+
+	// Dummy opcode fetch, the byte read is discarded
+	cpu.ReadOpcode()
+	cpu.PC--
+	cpu.internal(cpu.getIR(), 1)
+
 	// Save current PC on stack
 	cpu.Push(cpu.PC)
 
 	// Jump to NMI handler
 	cpu.PC = 0x0066
+	cpu.MEMPTR = cpu.PC
 
-	// Disable interrupts
+	// Disable interrupts. IFF2 keeps the previous state for RETN.
 	cpu.IFF1 = false
 
-	return 11 // 11 T-states for NMI handling
+	return 11 + cpu.wait // 11 T-states for NMI handling
+}
+
+// leaveHALT resumes execution after HALT when an interrupt is accepted, so
+// the return address points to the following instruction
+func (cpu *CPU) leaveHALT() {
+	if cpu.HALT {
+		cpu.HALT = false
+		cpu.PC++
+	}
+}
+
+// UndefinedOpcodeError reports an undefined opcode, which the CPU executes as
+// a NOP just like real silicon does
+type UndefinedOpcodeError struct {
+	PC     uint16 // Address of the prefix byte, or PC when the device supplied it in IM 0
+	Prefix byte   // Prefix byte
+	Opcode byte   // Opcode following the prefix
+}
+
+func (e *UndefinedOpcodeError) Error() string {
+	return fmt.Sprintf("undefined opcode %02X %02X at %04X", e.Prefix, e.Opcode, e.PC)
+}
+
+// reportUndefined passes an undefined opcode to the error hook in strict mode
+func (cpu *CPU) reportUndefined(prefix, opcode byte) {
+	if cpu.ErrorHook != nil {
+		cpu.ErrorHook(&UndefinedOpcodeError{PC: cpu.start, Prefix: prefix, Opcode: opcode})
+	}
 }
 
 // GetAF returns the combined value of the A and F registers
@@ -0,0 +1,635 @@
+// Package asm provides a Z80 assembler
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Assembler turns Z80 source code into bytes
+type Assembler struct {
+	ReadFile func(name string) ([]byte, error) // Reads INCLUDE files, os.ReadFile if nil
+}
+
+// New creates a new assembler
+func New() *Assembler {
+	return &Assembler{}
+}
+
+// Segment is a run of bytes assembled to consecutive addresses
+type Segment struct {
+	Address uint16
+	Data    []byte
+}
+
+// Line is one source line of the listing
+type Line struct {
+	File    string
+	Number  int
+	Address uint16
+	Bytes   []byte
+	Source  string
+}
+
+// Program is the result of assembling source code
+type Program struct {
+	Segments []Segment
+	Symbols  map[string]int // Labels and constants
+	Lines    []Line
+}
+
+// Image returns the program as one block from its lowest to its highest
+// address, with gaps between segments filled with zeros
+func (p *Program) Image() (uint16, []byte) {
+	if len(p.Segments) == 0 {
+		return 0, nil
+	}
+	low, high := 0x10000, 0
+	for _, s := range p.Segments {
+		low = min(low, int(s.Address))
+		high = max(high, int(s.Address)+len(s.Data))
+	}
+	data := make([]byte, high-low)
+	for _, s := range p.Segments {
+		copy(data[int(s.Address)-low:], s.Data)
+	}
+	return uint16(low), data
+}
+
+// Error is an error in a line of source code
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+// Error formats the error as file:line: message
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// macro is a macro definition
+type macro struct {
+	params []string
+	body   []string
+}
+
+// state is the state of one assembly
+type state struct {
+	a         *Assembler
+	final     bool // Second pass, where undefined symbols are errors
+	pc        int
+	symbols   map[string]int
+	defined   map[string]bool // Symbols defined in this pass
+	scope     string          // Last global label, the scope of local labels
+	macros    map[string]*macro
+	expanded  int  // Macro expansions, numbering their scopes
+	depth     int  // Nesting of includes and macros
+	ended     bool // END seen
+	undefined bool // An expression used a symbol not defined yet
+	program   *Program
+}
+
+// maxDepth limits nested includes and macro expansions
+const maxDepth = 32
+
+// Assemble assembles source code. The name is used in errors and to find
+// included files.
+func (a *Assembler) Assemble(name, source string) (*Program, error) {
+	s := &state{a: a, symbols: make(map[string]int)}
+	for _, final := range []bool{false, true} {
+		s.final, s.pc, s.scope, s.expanded, s.ended = final, 0, "", 0, false
+		s.defined = make(map[string]bool)
+		s.macros = make(map[string]*macro)
+		s.program = &Program{Symbols: s.symbols}
+		if err := s.source(name, source); err != nil {
+			return nil, err
+		}
+	}
+	return s.program, nil
+}
+
+// AssembleFile assembles a source file
+func (a *Assembler) AssembleFile(name string) (*Program, error) {
+	source, err := a.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return a.Assemble(name, string(source))
+}
+
+// read reads a file with ReadFile
+func (a *Assembler) read(name string) ([]byte, error) {
+	if a.ReadFile != nil {
+		return a.ReadFile(name)
+	}
+	return os.ReadFile(name)
+}
+
+// source assembles the lines of a file
+func (s *state) source(name, source string) error {
+	return s.lines(name, strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n"), nil)
+}
+
+// lines assembles lines. Numbers gives the line number of each line when
+// they come from a macro, nil for lines of a file.
+func (s *state) lines(file string, lines []string, numbers []int) error {
+	s.depth++
+	defer func() { s.depth-- }()
+	if s.depth > maxDepth {
+		return fmt.Errorf("includes or macros nested too deep")
+	}
+	for i := 0; i < len(lines) && !s.ended; i++ {
+		number := i + 1
+		if numbers != nil {
+			number = numbers[i]
+		}
+		st, err := s.parseLine(lines[i])
+		if err == nil && st.op == "MACRO" {
+			i, err = s.defineMacro(st, lines, i)
+		} else if err == nil {
+			err = s.statement(file, number, lines[i], st)
+		}
+		var lineErr *Error
+		if err != nil && !errors.As(err, &lineErr) {
+			err = &Error{File: file, Line: number, Err: err}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statement is a parsed source line
+type statement struct {
+	label string   // Label, without its colon
+	op    string   // Operation or directive, upper case
+	name  string   // Operation as written, for macro names
+	args  []string // Operands
+}
+
+// Patterns of source lines
+var (
+	labelPattern = regexp.MustCompile(`^([A-Za-z_.@][A-Za-z0-9_.@]*)(:?)`)
+	namePattern  = regexp.MustCompile(`^[A-Za-z_.@][A-Za-z0-9_.@]*$`)
+)
+
+// parseLine splits a line into label, operation and operands. A label ends
+// with a colon or starts in the first column; a word in the first column
+// that is an operation, directive or macro is taken as one.
+func (s *state) parseLine(line string) (statement, error) {
+	var st statement
+	text := strings.TrimRight(stripComment(line), " \t")
+	rest := strings.TrimLeft(text, " \t")
+	if m := labelPattern.FindStringSubmatch(rest); m != nil {
+		after := strings.TrimLeft(rest[len(m[0]):], " \t")
+		first := strings.ToUpper(strings.Fields(after + " ;")[0])
+		switch {
+		case m[2] == ":":
+			st.label, rest = m[1], after
+		case first == "EQU" || first == "MACRO" || strings.HasPrefix(after, "="):
+			st.label, rest = m[1], after
+		case text == rest && !isOperation(m[1]) && s.macros[m[1]] == nil:
+			st.label, rest = m[1], after
+		}
+	}
+	if rest == "" {
+		return st, nil
+	}
+	if rest[0] == '=' {
+		st.name, rest = "=", rest[1:]
+	} else if end := strings.IndexAny(rest, " \t"); end >= 0 {
+		st.name, rest = rest[:end], rest[end:]
+	} else {
+		st.name, rest = rest, ""
+	}
+	st.op = strings.ToUpper(st.name)
+	if st.op == "=" {
+		st.op = "EQU"
+	}
+	args, err := splitArgs(strings.TrimSpace(rest))
+	st.args = args
+	return st, err
+}
+
+// isOperation reports whether a word is an instruction or directive
+func isOperation(word string) bool {
+	upper := strings.ToUpper(word)
+	return mnemonics[upper] || directives[upper]
+}
+
+// directives lists the directive names
+var directives = map[string]bool{
+	"ORG": true, "DB": true, "DEFB": true, "DM": true, "DEFM": true, "DW": true, "DEFW": true,
+	"DS": true, "DEFS": true, "EQU": true, "INCLUDE": true, "MACRO": true, "ENDM": true, "END": true,
+}
+
+// isQuote reports whether a quote character at i starts or ends a string,
+// which it does not in AF'
+func isQuote(text string, i int) bool {
+	c := text[i]
+	if c == '"' {
+		return true
+	}
+	if c != '\'' {
+		return false
+	}
+	return i < 2 || strings.ToUpper(text[i-2:i]) != "AF" || i > 2 && isSymbolChar(text[i-3])
+}
+
+// scan calls f for every character outside strings, stopping when it
+// returns false
+func scan(text string, f func(i int, depth int) bool) error {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		if isQuote(text, i) {
+			quote := text[i]
+			for i++; i < len(text); i++ {
+				if text[i] == quote {
+					if i+1 < len(text) && text[i+1] == quote {
+						i++ // Doubled quote
+						continue
+					}
+					break
+				}
+				if text[i] == '\\' && quote == '"' {
+					i++ // Escaped character
+				}
+			}
+			if i >= len(text) {
+				return fmt.Errorf("unterminated string")
+			}
+			continue
+		}
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if !f(i, depth) {
+			break
+		}
+	}
+	return nil
+}
+
+// stripComment removes a ; comment
+func stripComment(line string) string {
+	cut := len(line)
+	scan(line, func(i, depth int) bool {
+		if line[i] == ';' {
+			cut = i
+			return false
+		}
+		return true
+	})
+	return line[:cut]
+}
+
+// splitArgs splits operands at commas outside strings and parentheses
+func splitArgs(text string) ([]string, error) {
+	if text == "" {
+		return nil, nil
+	}
+	var args []string
+	start := 0
+	err := scan(text, func(i, depth int) bool {
+		if text[i] == ',' && depth == 0 {
+			args = append(args, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+		return true
+	})
+	return append(args, strings.TrimSpace(text[start:])), err
+}
+
+// defineMacro records a macro and returns the index of its ENDM line. Both
+// "name MACRO params" and "MACRO name params" are accepted.
+func (s *state) defineMacro(st statement, lines []string, i int) (int, error) {
+	name, params := st.label, st.args
+	if name == "" {
+		if len(params) == 0 {
+			return i, fmt.Errorf("macro without a name")
+		}
+		first, rest, _ := strings.Cut(params[0], " ")
+		name, params = first, params[1:]
+		if rest = strings.TrimSpace(rest); rest != "" {
+			params = append([]string{rest}, params...)
+		}
+	}
+	if !namePattern.MatchString(name) {
+		return i, fmt.Errorf("invalid macro name %q", name)
+	}
+	m := &macro{params: params}
+	for j := i + 1; j < len(lines); j++ {
+		words := strings.Fields(strings.ToUpper(stripComment(lines[j])))
+		if len(words) > 0 && (words[0] == "ENDM" || len(words) > 1 && words[1] == "ENDM") {
+			s.macros[name] = m
+			return j, nil
+		}
+		m.body = append(m.body, lines[j])
+	}
+	return i, fmt.Errorf("macro %s without ENDM", name)
+}
+
+// expand assembles the body of a macro with its parameters replaced. Local
+// labels in the body are local to each expansion.
+func (s *state) expand(file string, number int, m *macro, args []string) error {
+	if len(args) != len(m.params) {
+		return fmt.Errorf("macro takes %d arguments, got %d", len(m.params), len(args))
+	}
+	body := make([]string, len(m.body))
+	numbers := make([]int, len(m.body))
+	for i, line := range m.body {
+		for j, param := range m.params {
+			line = regexp.MustCompile(`\b`+regexp.QuoteMeta(param)+`\b`).ReplaceAllLiteralString(line, args[j])
+		}
+		body[i], numbers[i] = line, number
+	}
+	s.expanded++
+	scope := s.scope
+	s.scope = fmt.Sprintf("%s.macro%d", scope, s.expanded)
+	err := s.lines(file, body, numbers)
+	s.scope = scope
+	return err
+}
+
+// statement assembles one parsed line
+func (s *state) statement(file string, number int, source string, st statement) error {
+	if st.label != "" && st.op != "EQU" {
+		if err := s.define(file, st.label, s.pc); err != nil {
+			return err
+		}
+	}
+	start := s.pc
+	var code []byte
+	var err error
+	switch st.op {
+	case "":
+	case "EQU":
+		if st.label == "" || len(st.args) != 1 {
+			return fmt.Errorf("EQU needs a name and a value")
+		}
+		value, err := s.eval(st.args[0])
+		if err != nil {
+			return err
+		}
+		if err := s.define(file, st.label, value); err != nil {
+			return err
+		}
+	case "ORG":
+		if len(st.args) != 1 {
+			return fmt.Errorf("ORG needs an address")
+		}
+		value, err := s.known(st.args[0])
+		if err != nil {
+			return err
+		}
+		if value < 0 || value > 0xFFFF {
+			return fmt.Errorf("address %d out of range", value)
+		}
+		s.pc = value
+		start = value
+	case "DB", "DEFB", "DM", "DEFM":
+		code, err = s.bytes(st.args)
+	case "DW", "DEFW":
+		code, err = s.words(st.args)
+	case "DS", "DEFS":
+		code, err = s.space(st.args)
+	case "INCLUDE":
+		s.list(file, number, start, nil, source)
+		return s.include(file, st.args)
+	case "END":
+		s.ended = true
+	case "ENDM":
+		return fmt.Errorf("ENDM without MACRO")
+	default:
+		if m, ok := s.macros[st.name]; ok {
+			s.list(file, number, start, nil, source)
+			return s.expand(file, number, m, st.args)
+		}
+		code, err = s.encode(st.op, st.args)
+	}
+	if err != nil {
+		return err
+	}
+	s.list(file, number, start, code, source)
+	return s.emit(code)
+}
+
+// list adds a line to the listing in the final pass
+func (s *state) list(file string, number, address int, code []byte, source string) {
+	if s.final {
+		s.program.Lines = append(s.program.Lines, Line{File: file, Number: number, Address: uint16(address), Bytes: code, Source: source})
+	}
+}
+
+// emit stores bytes at the current address
+func (s *state) emit(code []byte) error {
+	if len(code) == 0 {
+		return nil
+	}
+	if s.pc+len(code) > 0x10000 {
+		return fmt.Errorf("code beyond $FFFF")
+	}
+	p := s.program
+	if n := len(p.Segments); n > 0 && int(p.Segments[n-1].Address)+len(p.Segments[n-1].Data) == s.pc {
+		p.Segments[n-1].Data = append(p.Segments[n-1].Data, code...)
+	} else {
+		p.Segments = append(p.Segments, Segment{Address: uint16(s.pc), Data: append([]byte(nil), code...)})
+	}
+	s.pc += len(code)
+	return nil
+}
+
+// define defines a symbol. A name starting with a dot is local to the last
+// global label.
+func (s *state) define(file, name string, value int) error {
+	if strings.HasPrefix(name, ".") {
+		name = s.scope + name
+	} else {
+		s.scope = name
+	}
+	if s.defined[name] {
+		return fmt.Errorf("symbol %s defined twice", name)
+	}
+	s.defined[name] = true
+	s.symbols[name] = value
+	return nil
+}
+
+// symbol returns the value of a symbol
+func (s *state) symbol(name string) (int, error) {
+	full := name
+	if strings.HasPrefix(name, ".") {
+		full = s.scope + name
+		// Inside a macro, fall back to the labels of the enclosing scope
+		for scope := s.scope; !s.has(full) && strings.Contains(scope, ".macro"); {
+			scope = scope[:strings.LastIndex(scope, ".macro")]
+			if s.has(scope + name) {
+				full = scope + name
+			}
+		}
+	}
+	if value, ok := s.symbols[full]; ok {
+		return value, nil
+	}
+	if s.final {
+		return 0, fmt.Errorf("undefined symbol %s", name)
+	}
+	s.undefined = true
+	return 0, nil
+}
+
+// has reports whether a symbol is known
+func (s *state) has(name string) bool {
+	_, ok := s.symbols[name]
+	return ok
+}
+
+// known evaluates an expression that must not depend on symbols defined
+// later, because it changes addresses
+func (s *state) known(text string) (int, error) {
+	s.undefined = false
+	value, err := s.eval(text)
+	if err == nil && s.undefined {
+		return 0, fmt.Errorf("%q uses a symbol defined later", text)
+	}
+	return value, err
+}
+
+// include assembles another file, relative to the including file
+func (s *state) include(file string, args []string) error {
+	if len(args) != 1 || len(args[0]) < 2 || !strings.ContainsRune(`"'<`, rune(args[0][0])) {
+		return fmt.Errorf("INCLUDE needs a quoted file name")
+	}
+	name := args[0][1 : len(args[0])-1]
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(file), name)
+	}
+	source, err := s.a.read(name)
+	if err != nil {
+		return err
+	}
+	return s.source(name, string(source))
+}
+
+// bytes assembles the operands of DB: numbers and strings
+func (s *state) bytes(args []string) ([]byte, error) {
+	var code []byte
+	for _, arg := range args {
+		if text, ok, err := unquote(arg); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			code = append(code, text...)
+			continue
+		}
+		value, err := s.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		if value < -128 || value > 255 {
+			return nil, fmt.Errorf("byte %d out of range", value)
+		}
+		code = append(code, byte(value))
+	}
+	return code, nil
+}
+
+// unquote returns the text of a string operand. A single character in
+// single quotes is a number, not a string. A doubled quote stands for one.
+func unquote(arg string) (string, bool, error) {
+	if len(arg) < 2 || arg[0] != arg[len(arg)-1] || arg[0] != '"' && arg[0] != '\'' {
+		return "", false, nil
+	}
+	if arg[0] == '\'' && len(arg) == 3 {
+		return "", false, nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(arg)-1; i++ {
+		c := arg[i]
+		if c == arg[0] && i+1 < len(arg)-1 && arg[i+1] == c {
+			i++
+		} else if c == '\\' && arg[0] == '"' && i+1 < len(arg)-1 {
+			i++
+			switch arg[i] {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case '0':
+				c = 0
+			default:
+				c = arg[i]
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), true, nil
+}
+
+// words assembles the operands of DW
+func (s *state) words(args []string) ([]byte, error) {
+	var code []byte
+	for _, arg := range args {
+		value, err := s.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		if value < -32768 || value > 0xFFFF {
+			return nil, fmt.Errorf("word %d out of range", value)
+		}
+		code = append(code, byte(value), byte(value>>8))
+	}
+	return code, nil
+}
+
+// space assembles DS count[, fill]
+func (s *state) space(args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("DS needs a size and an optional fill byte")
+	}
+	count, err := s.known(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || s.pc+count > 0x10000 {
+		return nil, fmt.Errorf("DS size %d out of range", count)
+	}
+	fill := 0
+	if len(args) == 2 {
+		if fill, err = s.eval(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	code := make([]byte, count)
+	for i := range code {
+		code[i] = byte(fill)
+	}
+	return code, nil
+}
+
+// SymbolNames returns the symbol names in alphabetical order
+func (p *Program) SymbolNames() []string {
+	names := make([]string, 0, len(p.Symbols))
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,237 @@
+// Package asm provides tests for the Z80 assembler implementation
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// TestLabels tests labels, forward references and local labels
+func TestLabels(t *testing.T) {
+	p, err := New().Assemble("test.asm", `
+	ORG $8000
+start:	LD B, 3
+.loop	CALL fill
+	DJNZ .loop
+	JP end
+fill	LD HL, start
+.loop	INC (HL)
+	JR NZ, .loop
+	RET
+end:	HALT
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"start": 0x8000, "start.loop": 0x8002, "fill": 0x800A, "fill.loop": 0x800D, "end": 0x8011}
+	for name, value := range want {
+		if p.Symbols[name] != value {
+			t.Errorf("%s: got $%04X, want $%04X", name, p.Symbols[name], value)
+		}
+	}
+	address, data := p.Image()
+	if address != 0x8000 {
+		t.Errorf("address: got $%04X, want $8000", address)
+	}
+	code := []byte{0x06, 0x03, 0xCD, 0x0A, 0x80, 0x10, 0xFB, 0xC3, 0x11, 0x80,
+		0x21, 0x00, 0x80, 0x34, 0x20, 0xFD, 0xC9, 0x76}
+	if !bytes.Equal(data, code) {
+		t.Errorf("got % X, want % X", data, code)
+	}
+}
+
+// TestExpressions tests operators, precedence and number formats
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-4-3", 3},
+		{"$FF & %1010", 10},
+		{"0x10 | 0b1", 17},
+		{"0FFh >> 4", 15},
+		{"1 << 8", 256},
+		{"-5 + 10", 5},
+		{"~0 & 255", 255},
+		{"'A' + 1", 66},
+		{"17 % 5", 2},
+		{"3 == 3", 1},
+		{"2 > 3", 0},
+		{"!0", 1},
+		{"value * 2", 84},
+		{"$ + 1", 0x101},
+	}
+	for _, tt := range tests {
+		p, err := New().Assemble("test.asm", fmt.Sprintf("value EQU 42\n\tORG $100\nresult EQU %s", tt.expr))
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := p.Symbols["result"]; got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// TestDirectives tests ORG, DB, DW, DS and END
+func TestDirectives(t *testing.T) {
+	p, err := New().Assemble("test.asm", `
+	ORG $4000
+	DB 1, "AB", 'C', "\n"
+	DEFW $1234, table
+table:	DS 3, $FF
+	DEFS 2
+	ORG $4100
+	DM "x"
+	END
+	DB 99
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Segment{
+		{0x4000, []byte{1, 'A', 'B', 'C', '\n', 0x34, 0x12, 0x09, 0x40, 0xFF, 0xFF, 0xFF, 0, 0}},
+		{0x4100, []byte{'x'}},
+	}
+	if len(p.Segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(p.Segments), len(want))
+	}
+	for i, s := range p.Segments {
+		if s.Address != want[i].Address || !bytes.Equal(s.Data, want[i].Data) {
+			t.Errorf("segment %d: got $%04X % X, want $%04X % X", i, s.Address, s.Data, want[i].Address, want[i].Data)
+		}
+	}
+	if _, data := p.Image(); len(data) != 0x101 {
+		t.Errorf("image: got %d bytes, want 257", len(data))
+	}
+}
+
+// TestDoubledQuotes tests that a doubled quote in a string is one quote
+func TestDoubledQuotes(t *testing.T) {
+	p, err := New().Assemble("test.asm", "\tDB 'it''s',0\n\tDB \"a\"\"b\", ';''' ; comment\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data := p.Image()
+	if want := []byte{0x69, 0x74, 0x27, 0x73, 0x00, 'a', '"', 'b', ';', '\''}; !bytes.Equal(data, want) {
+		t.Errorf("got % X, want % X", data, want)
+	}
+}
+
+// TestMacros tests macros with parameters and local labels
+func TestMacros(t *testing.T) {
+	data := assemble(t, `
+delay	MACRO count
+	LD B, count
+.wait	DJNZ .wait
+	ENDM
+	MACRO store value, address
+	LD A, value
+	LD (address), A
+	ENDM
+main:	delay 5
+	delay 2
+	store 7, $C000
+`)
+	want := []byte{0x06, 0x05, 0x10, 0xFE, 0x06, 0x02, 0x10, 0xFE, 0x3E, 0x07, 0x32, 0x00, 0xC0}
+	if !bytes.Equal(data, want) {
+		t.Errorf("got % X, want % X", data, want)
+	}
+}
+
+// TestInclude tests INCLUDE with files relative to the including file
+func TestInclude(t *testing.T) {
+	files := map[string]string{
+		"src/main.asm":      "\tINCLUDE \"lib/defs.asm\"\n\tLD A, SCREEN\n",
+		"src/lib/defs.asm":  "SCREEN EQU 7\n\tINCLUDE 'more.asm'\n",
+		"src/lib/more.asm":  "\tNOP\n",
+		"src/lib/error.asm": "\tNOP\n\tLD A,\n",
+	}
+	a := &Assembler{ReadFile: func(name string) ([]byte, error) {
+		if text, ok := files[name]; ok {
+			return []byte(text), nil
+		}
+		return nil, fmt.Errorf("%s not found", name)
+	}}
+	p, err := a.AssembleFile("src/main.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, data := p.Image(); !bytes.Equal(data, []byte{0x00, 0x3E, 0x07}) {
+		t.Errorf("got % X, want 00 3E 07", data)
+	}
+
+	_, err = a.Assemble("src/main.asm", "\tNOP\n\tINCLUDE \"lib/error.asm\"\n")
+	var lineErr *Error
+	if !errors.As(err, &lineErr) || lineErr.File != "src/lib/error.asm" || lineErr.Line != 2 {
+		t.Errorf("got error %v, want one in src/lib/error.asm line 2", err)
+	}
+}
+
+// TestErrors tests that errors name the file and line
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"\tNOP\n\tLD A, (HL\n", "test.asm:2: "},
+		{"\tJP nowhere", "test.asm:1: undefined symbol nowhere"},
+		{"a:\tNOP\na:\tNOP", "test.asm:2: symbol a defined twice"},
+		{"\tORG later\nlater:", "test.asm:1: "},
+		{"\tFOO", "test.asm:1: unknown instruction FOO"},
+		{"m MACRO\n\tNOP\n", "test.asm:1: macro m without ENDM"},
+		{"\tENDM", "test.asm:1: ENDM without MACRO"},
+		{"\tDB 1/0", "test.asm:1: division by zero"},
+		{"\tINCLUDE missing.asm", "test.asm:1: INCLUDE needs a quoted file name"},
+	}
+	for _, tt := range tests {
+		_, err := New().Assemble("test.asm", tt.source)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %q", tt.source, err, tt.want)
+		}
+	}
+}
+
+// TestListing tests the listing and symbol file
+func TestListing(t *testing.T) {
+	p, err := New().Assemble("test.asm", "\tORG $8000\nstart:\tLD HL, $1234\n\tDB 1, 2, 3, 4, 5, 6\nsize EQU $ - start\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := p.WriteListing(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "" +
+		"    1  8000              \tORG $8000\n" +
+		"    2  8000  21 34 12    start:\tLD HL, $1234\n" +
+		"    3  8003  01 02 03 04 \tDB 1, 2, 3, 4, 5, 6\n" +
+		"       8007  05 06\n" +
+		"    4  8009              size EQU $ - start\n" +
+		"    5  8009              \n"
+	if b.String() != want {
+		t.Errorf("listing:\ngot:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := p.WriteSymbols(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := "size = $0009\nstart = $8000\n"; b.String() != want {
+		t.Errorf("symbols: got %q, want %q", b.String(), want)
+	}
+	table := disasm.NewSymbolTable()
+	if err := table.Load(strings.NewReader(b.String())); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := table.Label(0x8000); !ok || name != "start" {
+		t.Errorf("loaded label: got %q, want start", name)
+	}
+}
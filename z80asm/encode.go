@@ -0,0 +1,604 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// operandKind classifies a parsed operand
+type operandKind int
+
+// Operand kinds
+const (
+	kindRegister  operandKind = iota // A, HL, IXH, AF'
+	kindCondition                    // NZ, Z, NC, PO, PE, P, M; C is a register
+	kindIndirect                     // (HL), (BC), (SP), (C), (IX)
+	kindIndexed                      // (IX+d), (IY+d)
+	kindMemory                       // (nn), also the port of IN A,(n)
+	kindValue                        // nn
+)
+
+// operand is a parsed operand with its value evaluated
+type operand struct {
+	kind  operandKind
+	reg   string // Upper case register, condition or index register
+	value int    // Value, address or displacement
+}
+
+// Register names, with the aliases other assemblers use for index halves
+var (
+	registerNames = map[string]string{
+		"A": "A", "B": "B", "C": "C", "D": "D", "E": "E", "H": "H", "L": "L", "F": "F", "I": "I", "R": "R",
+		"AF": "AF", "AF'": "AF'", "BC": "BC", "DE": "DE", "HL": "HL", "SP": "SP", "IX": "IX", "IY": "IY",
+		"IXH": "IXH", "IXL": "IXL", "IYH": "IYH", "IYL": "IYL",
+		"HX": "IXH", "XH": "IXH", "LX": "IXL", "XL": "IXL", "HY": "IYH", "YH": "IYH", "LY": "IYL", "YL": "IYL",
+	}
+	conditionCodes = map[string]int{"NZ": 0, "Z": 1, "NC": 2, "C": 3, "PO": 4, "PE": 5, "P": 6, "M": 7}
+	indirectNames  = map[string]bool{"BC": true, "DE": true, "HL": true, "SP": true, "C": true, "IX": true, "IY": true}
+)
+
+// parseOperand classifies an operand and evaluates its value
+func (s *state) parseOperand(text string) (operand, error) {
+	upper := strings.ToUpper(text)
+	if reg, ok := registerNames[upper]; ok {
+		return operand{kind: kindRegister, reg: reg}, nil
+	}
+	if _, ok := conditionCodes[upper]; ok {
+		return operand{kind: kindCondition, reg: upper}, nil
+	}
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") && enclosed(text) {
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		upperInner := strings.ToUpper(inner)
+		if indirectNames[upperInner] {
+			return operand{kind: kindIndirect, reg: upperInner}, nil
+		}
+		if len(inner) > 2 && (strings.HasPrefix(upperInner, "IX") || strings.HasPrefix(upperInner, "IY")) {
+			if rest := strings.TrimSpace(inner[2:]); rest[0] == '+' || rest[0] == '-' {
+				value, err := s.eval(rest)
+				return operand{kind: kindIndexed, reg: upperInner[:2], value: value}, err
+			}
+		}
+		value, err := s.eval(inner)
+		return operand{kind: kindMemory, value: value}, err
+	}
+	value, err := s.eval(text)
+	return operand{kind: kindValue, value: value}, err
+}
+
+// enclosed reports whether the opening parenthesis of text closes at its end,
+// so (1+2)*(3+4) is an expression and not a memory operand
+func enclosed(text string) bool {
+	closed := -1
+	scan(text, func(i, depth int) bool {
+		if depth == 0 {
+			closed = i
+			return false
+		}
+		return true
+	})
+	return closed == len(text)-1
+}
+
+// code is an instruction being encoded
+type code struct {
+	prefix  byte // DD, FD or 0
+	indexed bool // Has a displacement
+	disp    int
+	op      []byte // Opcode bytes after the prefix, with ED or CB
+	tail    []byte // Immediate bytes
+}
+
+// bytes returns the encoded instruction. DD CB and FD CB instructions put the
+// displacement before the opcode.
+func (c *code) bytes() []byte {
+	var b []byte
+	if c.prefix != 0 {
+		b = append(b, c.prefix)
+	}
+	if c.indexed && c.op[0] == 0xCB {
+		return append(append(b, 0xCB, byte(c.disp)), c.op[1:]...)
+	}
+	b = append(b, c.op...)
+	if c.indexed {
+		b = append(b, byte(c.disp))
+	}
+	return append(b, c.tail...)
+}
+
+// Operation tables
+var (
+	implied = map[string][]byte{
+		"NOP": {0x00}, "HALT": {0x76}, "DI": {0xF3}, "EI": {0xFB}, "EXX": {0xD9},
+		"DAA": {0x27}, "CPL": {0x2F}, "SCF": {0x37}, "CCF": {0x3F},
+		"RLCA": {0x07}, "RRCA": {0x0F}, "RLA": {0x17}, "RRA": {0x1F},
+		"NEG": {0xED, 0x44}, "RETN": {0xED, 0x45}, "RETI": {0xED, 0x4D}, "RRD": {0xED, 0x67}, "RLD": {0xED, 0x6F},
+		"LDI": {0xED, 0xA0}, "CPI": {0xED, 0xA1}, "INI": {0xED, 0xA2}, "OUTI": {0xED, 0xA3},
+		"LDD": {0xED, 0xA8}, "CPD": {0xED, 0xA9}, "IND": {0xED, 0xAA}, "OUTD": {0xED, 0xAB},
+		"LDIR": {0xED, 0xB0}, "CPIR": {0xED, 0xB1}, "INIR": {0xED, 0xB2}, "OTIR": {0xED, 0xB3},
+		"LDDR": {0xED, 0xB8}, "CPDR": {0xED, 0xB9}, "INDR": {0xED, 0xBA}, "OTDR": {0xED, 0xBB},
+	}
+	aluOps    = map[string]int{"ADD": 0, "ADC": 1, "SUB": 2, "SBC": 3, "AND": 4, "XOR": 5, "OR": 6, "CP": 7}
+	rotateOps = map[string]int{"RLC": 0, "RRC": 1, "RL": 2, "RR": 3, "SLA": 4, "SRA": 5, "SLL": 6, "SL1": 6, "SLI": 6, "SRL": 7}
+	bitOps    = map[string]byte{"BIT": 0x40, "RES": 0x80, "SET": 0xC0}
+	mnemonics = map[string]bool{
+		"LD": true, "PUSH": true, "POP": true, "EX": true, "INC": true, "DEC": true,
+		"JP": true, "JR": true, "DJNZ": true, "CALL": true, "RET": true, "RST": true, "IM": true, "IN": true, "OUT": true,
+	}
+)
+
+func init() {
+	for _, table := range []map[string]int{aluOps, rotateOps} {
+		for name := range table {
+			mnemonics[name] = true
+		}
+	}
+	for name := range implied {
+		mnemonics[name] = true
+	}
+	for name := range bitOps {
+		mnemonics[name] = true
+	}
+}
+
+// Register fields
+var (
+	reg8Fields  = map[string]int{"B": 0, "C": 1, "D": 2, "E": 3, "H": 4, "L": 5, "A": 7, "IXH": 4, "IXL": 5, "IYH": 4, "IYL": 5}
+	reg16Fields = map[string]int{"BC": 0, "DE": 1, "HL": 2, "SP": 3, "IX": 2, "IY": 2}
+	indexPrefix = map[string]byte{"IX": 0xDD, "IY": 0xFD, "IXH": 0xDD, "IXL": 0xDD, "IYH": 0xFD, "IYL": 0xFD}
+)
+
+// errOperands reports operands an operation does not take
+var errOperands = fmt.Errorf("invalid operands")
+
+// reg8 sets the prefix and displacement for an 8-bit operand and returns its
+// register field. Plain H and L cannot be combined with a prefix from an
+// index register half, and index halves of IX and IY cannot be mixed.
+func (c *code) reg8(o operand) (int, bool) {
+	var field int
+	var prefix byte
+	switch o.kind {
+	case kindRegister:
+		f, ok := reg8Fields[o.reg]
+		if !ok {
+			return 0, false
+		}
+		field, prefix = f, indexPrefix[o.reg]
+	case kindIndirect:
+		if o.reg != "HL" && o.reg != "IX" && o.reg != "IY" {
+			return 0, false
+		}
+		field, prefix = 6, indexPrefix[o.reg]
+		c.indexed = prefix != 0 // (IX) is (IX+0)
+	case kindIndexed:
+		field, prefix = 6, indexPrefix[o.reg]
+		c.indexed, c.disp = true, o.value
+	default:
+		return 0, false
+	}
+	if prefix != 0 {
+		if c.prefix != 0 && c.prefix != prefix {
+			return 0, false
+		}
+		c.prefix = prefix
+	}
+	return field, true
+}
+
+// reg16 sets the prefix for a 16-bit register and returns its field, with
+// AF in place of SP if stack is set
+func (c *code) reg16(o operand, stack bool) (int, bool) {
+	if o.kind != kindRegister {
+		return 0, false
+	}
+	if stack && o.reg == "AF" {
+		return 3, true
+	}
+	field, ok := reg16Fields[o.reg]
+	if !ok || stack && o.reg == "SP" {
+		return 0, false
+	}
+	if prefix := indexPrefix[o.reg]; prefix != 0 {
+		if c.prefix != 0 && c.prefix != prefix {
+			return 0, false
+		}
+		c.prefix = prefix
+	}
+	return field, true
+}
+
+// isPointer reports whether an operand is HL, IX or IY, setting the prefix
+func (c *code) isPointer(o operand) bool {
+	if o.kind != kindRegister || o.reg != "HL" && o.reg != "IX" && o.reg != "IY" {
+		return false
+	}
+	c.prefix = indexPrefix[o.reg]
+	return true
+}
+
+// is reports whether an operand is a given register
+func is(o operand, reg string) bool {
+	return o.kind == kindRegister && o.reg == reg
+}
+
+// byte8 returns an 8-bit value
+func byte8(value int) ([]byte, error) {
+	if value < -128 || value > 255 {
+		return nil, fmt.Errorf("value %d out of range", value)
+	}
+	return []byte{byte(value)}, nil
+}
+
+// word sets a 16-bit operand, low byte first
+func (c *code) word(value int) error {
+	if value < -32768 || value > 0xFFFF {
+		return fmt.Errorf("value %d out of range", value)
+	}
+	c.tail = []byte{byte(value), byte(value >> 8)}
+	return nil
+}
+
+// encode assembles one instruction at the current address
+func (s *state) encode(op string, args []string) ([]byte, error) {
+	ops := make([]operand, len(args))
+	for i, arg := range args {
+		o, err := s.parseOperand(arg)
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = o
+	}
+	c := &code{}
+	if err := s.instruction(c, op, ops); err != nil {
+		if err == errOperands {
+			return nil, fmt.Errorf("invalid operands for %s: %s", op, strings.Join(args, ", "))
+		}
+		return nil, err
+	}
+	if c.indexed && (c.disp < -128 || c.disp > 127) {
+		return nil, fmt.Errorf("displacement %d out of range", c.disp)
+	}
+	// Plain H or L under a prefix from an index half would mean IXH or IXL
+	if c.prefix != 0 && !c.indexed {
+		for _, o := range ops {
+			if is(o, "H") || is(o, "L") || o.kind == kindIndirect && o.reg == "HL" {
+				return nil, fmt.Errorf("invalid operands for %s: %s", op, strings.Join(args, ", "))
+			}
+		}
+	}
+	return c.bytes(), nil
+}
+
+// instruction fills in the encoding of an operation
+func (s *state) instruction(c *code, op string, ops []operand) error {
+	if bytes, ok := implied[op]; ok {
+		if len(ops) != 0 {
+			return errOperands
+		}
+		c.op = bytes
+		return nil
+	}
+	if alu, ok := aluOps[op]; ok {
+		return c.alu(op, alu, ops)
+	}
+	if rotate, ok := rotateOps[op]; ok {
+		return c.rotate(rotate, ops)
+	}
+	if base, ok := bitOps[op]; ok {
+		return c.bit(op, base, ops)
+	}
+	switch op {
+	case "LD":
+		return c.load(ops)
+	case "PUSH", "POP":
+		if len(ops) != 1 {
+			return errOperands
+		}
+		p, ok := c.reg16(ops[0], true)
+		if !ok {
+			return errOperands
+		}
+		c.op = []byte{map[string]byte{"PUSH": 0xC5, "POP": 0xC1}[op] | byte(p)<<4}
+	case "EX":
+		switch {
+		case len(ops) != 2:
+			return errOperands
+		case is(ops[0], "DE") && is(ops[1], "HL"):
+			c.op = []byte{0xEB}
+		case is(ops[0], "AF") && is(ops[1], "AF'"):
+			c.op = []byte{0x08}
+		case ops[0].kind == kindIndirect && ops[0].reg == "SP" && c.isPointer(ops[1]):
+			c.op = []byte{0xE3}
+		default:
+			return errOperands
+		}
+	case "INC", "DEC":
+		if len(ops) != 1 {
+			return errOperands
+		}
+		if p, ok := c.reg16(ops[0], false); ok {
+			c.op = []byte{map[string]byte{"INC": 0x03, "DEC": 0x0B}[op] | byte(p)<<4}
+		} else if r, ok := c.reg8(ops[0]); ok {
+			c.op = []byte{map[string]byte{"INC": 0x04, "DEC": 0x05}[op] | byte(r)<<3}
+		} else {
+			return errOperands
+		}
+	case "JP", "CALL":
+		return c.jump(op, ops)
+	case "JR", "DJNZ":
+		return s.relative(c, op, ops)
+	case "RET":
+		switch {
+		case len(ops) == 0:
+			c.op = []byte{0xC9}
+		case len(ops) == 1 && condition(ops[0]) >= 0:
+			c.op = []byte{0xC0 | byte(condition(ops[0]))<<3}
+		default:
+			return errOperands
+		}
+	case "RST":
+		if len(ops) != 1 || ops[0].kind != kindValue {
+			return errOperands
+		}
+		if v := ops[0].value; v < 0 || v > 0x38 || v%8 != 0 {
+			return fmt.Errorf("invalid restart address %d", v)
+		}
+		c.op = []byte{0xC7 | byte(ops[0].value)}
+	case "IM":
+		if len(ops) != 1 || ops[0].kind != kindValue || ops[0].value < 0 || ops[0].value > 2 {
+			return errOperands
+		}
+		c.op = []byte{0xED, []byte{0x46, 0x56, 0x5E}[ops[0].value]}
+	case "IN", "OUT":
+		return c.io(op, ops)
+	default:
+		return fmt.Errorf("unknown instruction %s", op)
+	}
+	return nil
+}
+
+// condition returns the field of a condition operand, -1 if it is not one
+func condition(o operand) int {
+	if o.kind == kindCondition || is(o, "C") {
+		return conditionCodes[o.reg]
+	}
+	return -1
+}
+
+// alu encodes ADD, ADC, SUB, SBC, AND, XOR, OR and CP. The accumulator may
+// be given or left out; ADD, ADC and SBC also work on HL, IX and IY.
+func (c *code) alu(op string, alu int, ops []operand) error {
+	if len(ops) == 2 && !is(ops[0], "A") {
+		left := ops[0]
+		if !c.isPointer(left) {
+			return errOperands
+		}
+		p, ok := c.reg16(ops[1], false)
+		if !ok || ops[1].kind == kindRegister && reg16Fields[ops[1].reg] == 2 && ops[1].reg != left.reg {
+			return errOperands
+		}
+		switch {
+		case op == "ADD":
+			c.op = []byte{0x09 | byte(p)<<4}
+		case c.prefix == 0 && op == "ADC":
+			c.op = []byte{0xED, 0x4A | byte(p)<<4}
+		case c.prefix == 0 && op == "SBC":
+			c.op = []byte{0xED, 0x42 | byte(p)<<4}
+		default:
+			return errOperands
+		}
+		return nil
+	}
+	if len(ops) == 2 {
+		ops = ops[1:]
+	}
+	if len(ops) != 1 {
+		return errOperands
+	}
+	if r, ok := c.reg8(ops[0]); ok {
+		c.op = []byte{0x80 | byte(alu)<<3 | byte(r)}
+		return nil
+	}
+	if ops[0].kind != kindValue {
+		return errOperands
+	}
+	n, err := byte8(ops[0].value)
+	c.op, c.tail = []byte{0xC6 | byte(alu)<<3}, n
+	return err
+}
+
+// cb sets a CB opcode on an 8-bit operand, with an optional register that
+// receives a copy of the result in the undocumented DD CB forms
+func (c *code) cb(base byte, ops []operand) error {
+	r, ok := c.reg8(ops[0])
+	if !ok || c.prefix != 0 && !c.indexed {
+		return errOperands
+	}
+	if len(ops) == 2 {
+		copyTo, ok := reg8Fields[ops[1].reg]
+		if !ok || !c.indexed || ops[1].kind != kindRegister || indexPrefix[ops[1].reg] != 0 {
+			return errOperands
+		}
+		r = copyTo
+	}
+	c.op = []byte{0xCB, base | byte(r)}
+	return nil
+}
+
+// rotate encodes the CB rotates and shifts
+func (c *code) rotate(rotate int, ops []operand) error {
+	if len(ops) < 1 || len(ops) > 2 {
+		return errOperands
+	}
+	return c.cb(byte(rotate)<<3, ops)
+}
+
+// bit encodes BIT, RES and SET
+func (c *code) bit(op string, base byte, ops []operand) error {
+	if len(ops) < 2 || len(ops) > 3 || op == "BIT" && len(ops) != 2 || ops[0].kind != kindValue {
+		return errOperands
+	}
+	if b := ops[0].value; b < 0 || b > 7 {
+		return fmt.Errorf("bit number %d out of range", b)
+	}
+	return c.cb(base|byte(ops[0].value)<<3, ops[1:])
+}
+
+// load encodes LD
+func (c *code) load(ops []operand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	dst, src := ops[0], ops[1]
+	switch {
+	case is(dst, "A") && (is(src, "I") || is(src, "R")):
+		c.op = []byte{0xED, map[string]byte{"I": 0x57, "R": 0x5F}[src.reg]}
+	case (is(dst, "I") || is(dst, "R")) && is(src, "A"):
+		c.op = []byte{0xED, map[string]byte{"I": 0x47, "R": 0x4F}[dst.reg]}
+	case is(dst, "A") && src.kind == kindIndirect && (src.reg == "BC" || src.reg == "DE"):
+		c.op = []byte{map[string]byte{"BC": 0x0A, "DE": 0x1A}[src.reg]}
+	case dst.kind == kindIndirect && (dst.reg == "BC" || dst.reg == "DE") && is(src, "A"):
+		c.op = []byte{map[string]byte{"BC": 0x02, "DE": 0x12}[dst.reg]}
+	case is(dst, "A") && src.kind == kindMemory:
+		c.op = []byte{0x3A}
+		return c.word(src.value)
+	case dst.kind == kindMemory && is(src, "A"):
+		c.op = []byte{0x32}
+		return c.word(dst.value)
+	case is(dst, "SP") && (is(src, "HL") || is(src, "IX") || is(src, "IY")):
+		c.isPointer(src)
+		c.op = []byte{0xF9}
+	case isReg16(dst):
+		p, _ := c.reg16(dst, false)
+		switch src.kind {
+		case kindValue:
+			c.op = []byte{0x01 | byte(p)<<4}
+			return c.word(src.value)
+		case kindMemory:
+			if p == 2 {
+				c.op = []byte{0x2A}
+			} else {
+				c.op = []byte{0xED, 0x4B | byte(p)<<4}
+			}
+			return c.word(src.value)
+		default:
+			return errOperands
+		}
+	case dst.kind == kindMemory && isReg16(src):
+		p, _ := c.reg16(src, false)
+		if p == 2 {
+			c.op = []byte{0x22}
+		} else {
+			c.op = []byte{0xED, 0x43 | byte(p)<<4}
+		}
+		return c.word(dst.value)
+	default:
+		rd, ok := c.reg8(dst)
+		if !ok {
+			return errOperands
+		}
+		if src.kind == kindValue {
+			n, err := byte8(src.value)
+			c.op, c.tail = []byte{0x06 | byte(rd)<<3}, n
+			return err
+		}
+		// (IX+d) with IXH, or IXH with plain H, cannot share one prefix
+		if (dst.kind == kindIndexed || src.kind == kindIndexed) && (isHalf(dst) || isHalf(src)) {
+			return errOperands
+		}
+		rs, ok := c.reg8(src)
+		if !ok || rd == 6 && rs == 6 {
+			return errOperands
+		}
+		c.op = []byte{0x40 | byte(rd)<<3 | byte(rs)}
+	}
+	return nil
+}
+
+// isReg16 reports whether an operand is BC, DE, HL, SP, IX or IY
+func isReg16(o operand) bool {
+	_, ok := reg16Fields[o.reg]
+	return o.kind == kindRegister && ok
+}
+
+// isHalf reports whether an operand is IXH, IXL, IYH or IYL
+func isHalf(o operand) bool {
+	return o.kind == kindRegister && len(o.reg) == 3 && o.reg[0] == 'I'
+}
+
+// jump encodes JP and CALL
+func (c *code) jump(op string, ops []operand) error {
+	base := map[string][2]byte{"JP": {0xC3, 0xC2}, "CALL": {0xCD, 0xC4}}[op]
+	switch {
+	case len(ops) == 1 && ops[0].kind == kindValue:
+		c.op = []byte{base[0]}
+		return c.word(ops[0].value)
+	case len(ops) == 2 && condition(ops[0]) >= 0 && ops[1].kind == kindValue:
+		c.op = []byte{base[1] | byte(condition(ops[0]))<<3}
+		return c.word(ops[1].value)
+	case op == "JP" && len(ops) == 1 && ops[0].kind == kindIndirect && (ops[0].reg == "HL" || ops[0].reg == "IX" || ops[0].reg == "IY"):
+		c.prefix = indexPrefix[ops[0].reg]
+		c.op = []byte{0xE9}
+	default:
+		return errOperands
+	}
+	return nil
+}
+
+// relative encodes JR and DJNZ, whose operand is the target address
+func (s *state) relative(c *code, op string, ops []operand) error {
+	if len(ops) == 0 {
+		return errOperands
+	}
+	target := ops[len(ops)-1]
+	switch {
+	case target.kind != kindValue:
+		return errOperands
+	case op == "DJNZ" && len(ops) == 1:
+		c.op = []byte{0x10}
+	case op == "JR" && len(ops) == 1:
+		c.op = []byte{0x18}
+	case op == "JR" && len(ops) == 2 && condition(ops[0]) >= 0 && condition(ops[0]) < 4:
+		c.op = []byte{0x20 | byte(condition(ops[0]))<<3}
+	default:
+		return errOperands
+	}
+	offset := target.value - (s.pc + 2)
+	if s.final && (offset < -128 || offset > 127) {
+		return fmt.Errorf("relative jump to %d out of range", target.value)
+	}
+	c.tail = []byte{byte(offset)}
+	return nil
+}
+
+// io encodes IN and OUT
+func (c *code) io(op string, ops []operand) error {
+	if op == "IN" && len(ops) == 1 {
+		ops = []operand{{kind: kindRegister, reg: "F"}, ops[0]}
+	}
+	if len(ops) != 2 {
+		return errOperands
+	}
+	reg, port := ops[0], ops[1]
+	if op == "OUT" {
+		reg, port = ops[1], ops[0]
+	}
+	switch {
+	case port.kind == kindMemory && is(reg, "A"):
+		n, err := byte8(port.value)
+		c.op, c.tail = []byte{map[string]byte{"IN": 0xDB, "OUT": 0xD3}[op]}, n
+		return err
+	case port.kind != kindIndirect || port.reg != "C":
+		return errOperands
+	case op == "IN" && is(reg, "F"):
+		c.op = []byte{0xED, 0x70}
+	case op == "OUT" && reg.kind == kindValue && reg.value == 0:
+		c.op = []byte{0xED, 0x71}
+	default:
+		r, ok := reg8Fields[reg.reg]
+		if reg.kind != kindRegister || !ok || indexPrefix[reg.reg] != 0 {
+			return errOperands
+		}
+		c.op = []byte{0xED, map[string]byte{"IN": 0x40, "OUT": 0x41}[op] | byte(r)<<3}
+	}
+	return nil
+}
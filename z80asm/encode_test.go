@@ -0,0 +1,177 @@
+// Package asm provides tests for the Z80 assembler implementation
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// assemble assembles source and returns the bytes from its lowest address
+func assemble(t *testing.T, source string) []byte {
+	t.Helper()
+	p, err := New().Assemble("test.asm", source)
+	if err != nil {
+		t.Fatalf("%q: %v", source, err)
+	}
+	_, data := p.Image()
+	return data
+}
+
+// TestEncode tests instruction encodings
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []byte
+	}{
+		{"NOP", []byte{0x00}},
+		{"LD A, 5", []byte{0x3E, 0x05}},
+		{"ld b,c", []byte{0x41}},
+		{"LD (HL), $FF", []byte{0x36, 0xFF}},
+		{"LD A, (BC)", []byte{0x0A}},
+		{"LD ($1234), A", []byte{0x32, 0x34, 0x12}},
+		{"LD HL, ($1234)", []byte{0x2A, 0x34, 0x12}},
+		{"LD DE, ($1234)", []byte{0xED, 0x5B, 0x34, 0x12}},
+		{"LD ($1234), IX", []byte{0xDD, 0x22, 0x34, 0x12}},
+		{"LD SP, IY", []byte{0xFD, 0xF9}},
+		{"LD A, I", []byte{0xED, 0x57}},
+		{"LD R, A", []byte{0xED, 0x4F}},
+		{"LD (IX-5), A", []byte{0xDD, 0x77, 0xFB}},
+		{"LD (IY+2), $12", []byte{0xFD, 0x36, 0x02, 0x12}},
+		{"LD A, (IX)", []byte{0xDD, 0x7E, 0x00}},
+		{"LD H, (IX+1)", []byte{0xDD, 0x66, 0x01}},
+		{"LD IXH, 1", []byte{0xDD, 0x26, 0x01}},
+		{"LD XL, A", []byte{0xDD, 0x6F}},
+		{"LD IYH, IYL", []byte{0xFD, 0x65}},
+		{"PUSH AF", []byte{0xF5}},
+		{"POP IX", []byte{0xDD, 0xE1}},
+		{"EX AF, AF'", []byte{0x08}},
+		{"EX (SP), IY", []byte{0xFD, 0xE3}},
+		{"ADD A, B", []byte{0x80}},
+		{"SUB (HL)", []byte{0x96}},
+		{"AND $0F", []byte{0xE6, 0x0F}},
+		{"CP -1", []byte{0xFE, 0xFF}},
+		{"ADD IX, IX", []byte{0xDD, 0x29}},
+		{"ADC HL, SP", []byte{0xED, 0x7A}},
+		{"SBC HL, DE", []byte{0xED, 0x52}},
+		{"INC IXL", []byte{0xDD, 0x2C}},
+		{"DEC (IY-1)", []byte{0xFD, 0x35, 0xFF}},
+		{"INC SP", []byte{0x33}},
+		{"JP (IX)", []byte{0xDD, 0xE9}},
+		{"JP PE, $1234", []byte{0xEA, 0x34, 0x12}},
+		{"CALL C, $1234", []byte{0xDC, 0x34, 0x12}},
+		{"RET NZ", []byte{0xC0}},
+		{"RST 38H", []byte{0xFF}},
+		{"IM 2", []byte{0xED, 0x5E}},
+		{"IN A, ($FE)", []byte{0xDB, 0xFE}},
+		{"IN E, (C)", []byte{0xED, 0x58}},
+		{"IN F, (C)", []byte{0xED, 0x70}},
+		{"IN (C)", []byte{0xED, 0x70}},
+		{"OUT (C), 0", []byte{0xED, 0x71}},
+		{"OUT ($FE), A", []byte{0xD3, 0xFE}},
+		{"RLC B", []byte{0xCB, 0x00}},
+		{"SLL A", []byte{0xCB, 0x37}},
+		{"SL1 A", []byte{0xCB, 0x37}},
+		{"SRL (IX+3)", []byte{0xDD, 0xCB, 0x03, 0x3E}},
+		{"RLC (IX+1), B", []byte{0xDD, 0xCB, 0x01, 0x00}},
+		{"BIT 7, (HL)", []byte{0xCB, 0x7E}},
+		{"BIT 0, (IY-2)", []byte{0xFD, 0xCB, 0xFE, 0x46}},
+		{"SET 1, (IX+0), A", []byte{0xDD, 0xCB, 0x00, 0xCF}},
+		{"RES 2, C", []byte{0xCB, 0x91}},
+		{"LDIR", []byte{0xED, 0xB0}},
+		{"JR $", []byte{0x18, 0xFE}},
+		{"JR NC, $+5", []byte{0x30, 0x03}},
+		{"DJNZ $-2", []byte{0x10, 0xFC}},
+	}
+	for _, tt := range tests {
+		if got := assemble(t, "\t"+tt.source); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % X, want % X", tt.source, got, tt.want)
+		}
+	}
+}
+
+// TestEncodeErrors tests operands that do not form an instruction
+func TestEncodeErrors(t *testing.T) {
+	for _, source := range []string{
+		"LD (HL), (HL)",
+		"LD IXH, IYL",
+		"LD IXH, H",
+		"LD H, IXL",
+		"LD IXH, (IX+1)",
+		"LD BC, HL",
+		"ADD IX, HL",
+		"ADC IX, BC",
+		"PUSH SP",
+		"JR PO, $",
+		"JR $+200",
+		"RST 3",
+		"IM 3",
+		"BIT 8, A",
+		"BIT 0, (IX+1), B",
+		"RLC IXH",
+		"LD A, 256",
+		"LD BC, 70000",
+		"LD HL, -32769",
+		"JP $10000",
+		"LD A, (65536)",
+		"LD (IX+128), A",
+		"OUT (C), 1",
+		"FOO A",
+		"LD A, missing",
+	} {
+		if _, err := New().Assemble("test.asm", "\t"+source); err == nil {
+			t.Errorf("%s: no error", source)
+		}
+	}
+}
+
+// TestRoundTrip tests that every instruction the disassembler writes in
+// sjasmplus, pasmo or z88dk syntax assembles back to the same bytes
+func TestRoundTrip(t *testing.T) {
+	var encodings [][]byte
+	for op := range 256 {
+		encodings = append(encodings, []byte{byte(op)})
+	}
+	for _, prefix := range []byte{0xCB, 0xED, 0xDD, 0xFD} {
+		for op := range 256 {
+			encodings = append(encodings, []byte{prefix, byte(op)})
+		}
+	}
+	for _, prefix := range []byte{0xDD, 0xFD} {
+		for op := range 256 {
+			encodings = append(encodings, []byte{prefix, 0xCB, 0xF0, byte(op)})
+		}
+	}
+	d := disasm.New()
+	count := 0
+	for _, operands := range [][]byte{{0x12, 0x34, 0x56}, {0xFB, 0x80, 0x7F}} {
+		for _, encoding := range encodings {
+			data := append(append([]byte(nil), encoding...), operands...)
+			inst, err := d.DecodeAt(data, 0x8000)
+			if err != nil {
+				t.Fatalf("% X: %v", encoding, err)
+			}
+			for _, dl := range []*disasm.Dialect{disasm.Sjasmplus, disasm.Pasmo, disasm.Z88dk} {
+				if !dl.Supports(inst) {
+					continue
+				}
+				text := dl.Format(inst, nil)
+				source := fmt.Sprintf("\t%s\n\t%s\n", fmt.Sprintf(dl.Origin, "$8000"), text)
+				p, err := New().Assemble("test.asm", source)
+				if err != nil {
+					t.Errorf("%s %q: %v", dl.Name, text, err)
+					continue
+				}
+				if _, got := p.Image(); !bytes.Equal(got, inst.Bytes) {
+					t.Errorf("%s %q: got % X, want % X", dl.Name, text, got, inst.Bytes)
+				}
+				count++
+			}
+		}
+	}
+	if count < 3000 {
+		t.Errorf("only %d instructions checked", count)
+	}
+}
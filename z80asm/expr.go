@@ -0,0 +1,240 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Binary operators by precedence, lowest first
+var precedence = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parser evaluates one expression
+type parser struct {
+	s    *state
+	text string
+	pos  int
+}
+
+// eval evaluates an expression. Symbols not defined yet are zero in the
+// first pass and an error in the second.
+func (s *state) eval(text string) (int, error) {
+	p := &parser{s: s, text: text}
+	value, err := p.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	p.space()
+	if p.pos < len(p.text) {
+		return 0, fmt.Errorf("unexpected %q in expression %q", p.text[p.pos:], text)
+	}
+	return value, nil
+}
+
+// space skips blanks
+func (p *parser) space() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// operator consumes one of the operators of a precedence level
+func (p *parser) operator(ops []string) string {
+	p.space()
+	for _, op := range ops {
+		if strings.HasPrefix(p.text[p.pos:], op) {
+			// < and > must not match the start of << and >>
+			if len(op) == 1 && (op == "<" || op == ">") && strings.HasPrefix(p.text[p.pos+1:], op) {
+				continue
+			}
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// binary parses operators of a precedence level and above
+func (p *parser) binary(level int) (int, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.operator(precedence[level])
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "==":
+			left = truth(left == right)
+		case "!=":
+			left = truth(left != right)
+		case "<":
+			left = truth(left < right)
+		case ">":
+			left = truth(left > right)
+		case "<=":
+			left = truth(left <= right)
+		case ">=":
+			left = truth(left >= right)
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				if p.s.final {
+					return 0, fmt.Errorf("division by zero")
+				}
+				return 0, nil
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+// truth converts a comparison to 1 or 0
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unary parses a value with its unary operators
+func (p *parser) unary() (int, error) {
+	p.space()
+	if p.pos >= len(p.text) {
+		return 0, fmt.Errorf("missing value in expression %q", p.text)
+	}
+	switch c := p.text[p.pos]; {
+	case c == '-' || c == '+' || c == '~' || c == '!':
+		p.pos++
+		value, err := p.unary()
+		switch c {
+		case '-':
+			value = -value
+		case '~':
+			value = ^value
+		case '!':
+			value = truth(value == 0)
+		}
+		return value, err
+	case c == '(':
+		p.pos++
+		value, err := p.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		p.space()
+		if p.pos >= len(p.text) || p.text[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in expression %q", p.text)
+		}
+		p.pos++
+		return value, nil
+	case c == '\'' || c == '"':
+		if p.pos+2 < len(p.text) && p.text[p.pos+2] == c {
+			p.pos += 3
+			return int(p.text[p.pos-2]), nil
+		}
+		return 0, fmt.Errorf("invalid character constant in %q", p.text)
+	case c == '$' && !p.hexAt(p.pos+1):
+		p.pos++
+		return int(p.s.pc), nil
+	case c == '%' && p.pos+1 < len(p.text) && (p.text[p.pos+1] == '0' || p.text[p.pos+1] == '1'):
+		p.pos++
+		return p.number(2, p.word())
+	case c == '$':
+		p.pos++
+		return p.number(16, p.word())
+	case c >= '0' && c <= '9':
+		return p.literal(p.word())
+	case isSymbolStart(c):
+		return p.s.symbol(p.word())
+	}
+	return 0, fmt.Errorf("unexpected %q in expression %q", p.text[p.pos:], p.text)
+}
+
+// hexAt reports whether a hex digit is at a position
+func (p *parser) hexAt(pos int) bool {
+	if pos >= len(p.text) {
+		return false
+	}
+	c := p.text[pos] | 0x20
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f'
+}
+
+// word consumes a run of symbol characters
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.text) && isSymbolChar(p.text[p.pos]) {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// literal converts a number starting with a digit: 123, 0x7F, 7FH, 0b101
+func (p *parser) literal(word string) (int, error) {
+	lower := strings.ToLower(word)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		return p.number(16, word[2:])
+	case strings.HasPrefix(lower, "0b") && !strings.HasSuffix(lower, "h"):
+		return p.number(2, word[2:])
+	case strings.HasSuffix(lower, "h"):
+		return p.number(16, word[:len(word)-1])
+	}
+	return p.number(10, word)
+}
+
+// number converts digits in a base
+func (p *parser) number(base int, digits string) (int, error) {
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", digits)
+	}
+	return int(value), nil
+}
+
+// isSymbolStart reports whether a character can start a symbol
+func isSymbolStart(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_' || c == '.' || c == '@'
+}
+
+// isSymbolChar reports whether a character can be part of a symbol
+func isSymbolChar(c byte) bool {
+	return isSymbolStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,7 @@
+module github.com/kiltum/emuz80/z80asm
+
+go 1.25.1
+
+require github.com/kiltum/emuz80/z80disasm v0.0.0
+
+replace github.com/kiltum/emuz80/z80disasm => ../z80disasm
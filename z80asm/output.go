@@ -0,0 +1,49 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listingBytes is the most bytes shown on one listing line
+const listingBytes = 4
+
+// WriteListing writes the listing: line number, address, bytes and source,
+// with bytes that do not fit on continuation lines
+func (p *Program) WriteListing(w io.Writer) error {
+	var b strings.Builder
+	for _, line := range p.Lines {
+		data := line.Bytes
+		first := data[:min(len(data), listingBytes)]
+		fmt.Fprintf(&b, "%5d  %04X  %-12s%s\n", line.Number, line.Address, hexBytes(first), line.Source)
+		for i := listingBytes; i < len(data); i += listingBytes {
+			fmt.Fprintf(&b, "%5s  %04X  %s\n", "", int(line.Address)+i, hexBytes(data[i:min(len(data), i+listingBytes)]))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// hexBytes formats bytes separated by spaces
+func hexBytes(data []byte) string {
+	parts := make([]string, len(data))
+	for i, v := range data {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// WriteSymbols writes the symbols as "name = $XXXX" lines, which the
+// disassembler and debugger load with their symbol tables. Labels local to
+// macro expansions are left out.
+func (p *Program) WriteSymbols(w io.Writer) error {
+	var b strings.Builder
+	for _, name := range p.SymbolNames() {
+		if !strings.Contains(name, ".macro") {
+			fmt.Fprintf(&b, "%s = $%04X\n", name, uint16(p.Symbols[name]))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
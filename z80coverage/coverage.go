@@ -0,0 +1,212 @@
+// Package coverage records which memory a z80.CPU executes, reads and
+// writes, and which way its conditional branches go, for coverage reports
+// of guest programs.
+package coverage
+
+import (
+	"sort"
+
+	"github.com/kiltum/emuz80/z80"
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// Flags tells how an address was used
+type Flags byte
+
+// Flag constants are the uses recorded per address
+const (
+	Executed Flags = 1 << iota // Byte of an executed instruction
+	Read                       // Read as data
+	Written                    // Written
+)
+
+// String shows the flags as "xrw" with unused ones as dashes
+func (f Flags) String() string {
+	b := []byte("---")
+	for i, c := range "xrw" {
+		if f&(1<<i) != 0 {
+			b[i] = byte(c)
+		}
+	}
+	return string(b)
+}
+
+// Branch counts the outcomes of a conditional JR, JP, CALL, RET or DJNZ
+type Branch struct {
+	Address  uint16
+	Taken    int64
+	NotTaken int64
+}
+
+// cell is what was recorded for one address
+type cell struct {
+	flags  Flags
+	count  int64   // Executions of an instruction starting here
+	length uint8   // Length of the instruction decoded here, 0 if none
+	code   [4]byte // Bytes the length was decoded from
+}
+
+// Coverage is a z80.Tracer and z80.Bus that records the use of every address.
+// Opcode fetches and operand reads of the instruction being executed count as
+// execution; every other memory read counts as data.
+type Coverage struct {
+	Symbols map[uint16]string // Optional labels shown in the listing and lcov functions
+	Next    z80.Tracer        // Optional tracer that keeps receiving every step
+
+	cpu      *z80.CPU
+	bus      z80.Bus // Bus that was attached before, still receiving cycles
+	disasm   *disasm.Disassembler
+	cells    []cell
+	branches map[uint16]*Branch
+
+	// Instruction in progress
+	start, end int // Bytes [start, end) belong to it, empty for interrupts
+	next       int // Next instruction byte expected to be read
+	branch     *Branch
+	taken      bool
+}
+
+// New starts recording the CPU. It installs itself as cpu.Tracer and
+// cpu.Bus, chaining the tracer and bus that were attached before.
+func New(cpu *z80.CPU) *Coverage {
+	c := &Coverage{Next: cpu.Tracer, cpu: cpu, bus: cpu.Bus, disasm: disasm.New()}
+	c.Reset()
+	cpu.Tracer = c
+	cpu.Bus = c
+	return c
+}
+
+// Detach stops recording and restores the original tracer and bus
+func (c *Coverage) Detach() {
+	if c.cpu.Tracer == c {
+		c.cpu.Tracer = c.Next
+	}
+	if c.cpu.Bus == c {
+		c.cpu.Bus = c.bus
+	}
+}
+
+// Reset forgets everything recorded
+func (c *Coverage) Reset() {
+	c.cells = make([]cell, 0x10000)
+	c.branches = make(map[uint16]*Branch)
+}
+
+// conditions lists the flag tested by each condition code NZ Z NC C PO PE P M
+var conditions = [4]byte{z80.FLAG_Z, z80.FLAG_C, z80.FLAG_PV, z80.FLAG_S}
+
+// condition evaluates condition code cc against the flags
+func condition(cc byte, f byte) bool {
+	set := f&conditions[cc>>1] != 0
+	return set == (cc&1 != 0)
+}
+
+// conditional reports whether an opcode is a conditional branch and whether
+// the CPU is about to take it
+func conditional(cpu *z80.CPU, opcode byte) (branch, taken bool) {
+	switch {
+	case opcode == 0x10: // DJNZ
+		return true, cpu.B != 1
+	case opcode&0xE7 == 0x20: // JR cc
+		return true, condition((opcode>>3)&3, cpu.F)
+	case opcode&0xC7 == 0xC0, opcode&0xC7 == 0xC2, opcode&0xC7 == 0xC4: // RET cc, JP cc, CALL cc
+		return true, condition((opcode>>3)&7, cpu.F)
+	}
+	return false, false
+}
+
+// length decodes the length of the instruction at an address, reusing the
+// last result while the bytes there are unchanged
+func (c *Coverage) length(address uint16) int {
+	cl := &c.cells[address]
+	var code [4]byte
+	for i := range code {
+		code[i] = c.cpu.Memory.ReadByte(address + uint16(i))
+	}
+	if cl.length == 0 || code != cl.code {
+		cl.code, cl.length = code, 1
+		if inst, err := c.disasm.Decode(code[:]); err == nil && inst.Length > 0 {
+			cl.length = uint8(inst.Length)
+		}
+	}
+	return int(cl.length)
+}
+
+// BeforeStep implements z80.Tracer
+func (c *Coverage) BeforeStep(cpu *z80.CPU, kind z80.StepKind) {
+	c.branch = nil
+	c.start, c.end, c.next = int(cpu.PC), int(cpu.PC), int(cpu.PC)
+	if kind == z80.StepInstruction {
+		cl := &c.cells[cpu.PC]
+		c.end += c.length(cpu.PC)
+		cl.count++
+		for a := c.start; a < c.end; a++ {
+			c.cells[uint16(a)].flags |= Executed
+		}
+		if branch, taken := conditional(cpu, cl.code[0]); branch {
+			c.branch = c.branches[cpu.PC]
+			if c.branch == nil {
+				c.branch = &Branch{Address: cpu.PC}
+				c.branches[cpu.PC] = c.branch
+			}
+			c.taken = taken
+		}
+	}
+	if c.Next != nil {
+		c.Next.BeforeStep(cpu, kind)
+	}
+}
+
+// AfterStep implements z80.Tracer
+func (c *Coverage) AfterStep(cpu *z80.CPU, tstates int) {
+	if c.branch != nil {
+		if c.taken {
+			c.branch.Taken++
+		} else {
+			c.branch.NotTaken++
+		}
+	}
+	if c.Next != nil {
+		c.Next.AfterStep(cpu, tstates)
+	}
+}
+
+// Cycle implements z80.Bus
+func (c *Coverage) Cycle(cycle z80.Cycle) {
+	if c.bus != nil {
+		c.bus.Cycle(cycle)
+	}
+	switch cycle.Type {
+	case z80.CycleFetch:
+		c.next = int(cycle.Address) + 1
+	case z80.CycleRead:
+		// Instruction bytes are read in order; anything else is data
+		if int(cycle.Address) == c.next&0xFFFF && c.next < c.end {
+			c.next++
+			return
+		}
+		c.cells[cycle.Address].flags |= Read
+	case z80.CycleWrite:
+		c.cells[cycle.Address].flags |= Written
+	}
+}
+
+// Flags returns how an address was used
+func (c *Coverage) Flags(address uint16) Flags {
+	return c.cells[address].flags
+}
+
+// Count returns how many times the instruction starting at an address ran
+func (c *Coverage) Count(address uint16) int64 {
+	return c.cells[address].count
+}
+
+// Branches returns the conditional branches executed, by address
+func (c *Coverage) Branches() []Branch {
+	list := make([]Branch, 0, len(c.branches))
+	for _, b := range c.branches {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
@@ -0,0 +1,135 @@
+package coverage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// ram is a flat 64K memory.
+type ram [65536]byte
+
+func (m *ram) ReadByte(address uint16) byte         { return m[address] }
+func (m *ram) WriteByte(address uint16, value byte) { m[address] = value }
+func (m *ram) ReadWord(address uint16) uint16 {
+	return uint16(m[address]) | uint16(m[address+1])<<8
+}
+func (m *ram) WriteWord(address uint16, value uint16) {
+	m[address] = byte(value)
+	m[address+1] = byte(value >> 8)
+}
+
+// ports ignores all I/O.
+type ports struct{}
+
+func (ports) ReadPort(port uint16) byte         { return 0xFF }
+func (ports) WritePort(port uint16, value byte) {}
+func (ports) CheckInterrupt() bool              { return false }
+
+func assertEq[T comparable](t *testing.T, got, want T, msg string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %v, want %v", msg, got, want)
+	}
+}
+
+// testCPU runs a loop incrementing a counter twice, then halts:
+//
+//	0000 start: LD B,2
+//	0002 loop:  LD A,(8000); INC A; LD (8000),A; DJNZ loop
+//	000B        JR Z,never; HALT; DB 0
+//	000F never: LD A,1; HALT
+func testCPU(t *testing.T) (*z80.CPU, *Coverage) {
+	t.Helper()
+	mem := &ram{}
+	copy(mem[:], []byte{0x06, 0x02, 0x3A, 0x00, 0x80, 0x3C, 0x32, 0x00, 0x80, 0x10, 0xF7,
+		0x28, 0x02, 0x76, 0x00, 0x3E, 0x01, 0x76})
+	cpu := z80.New(mem, ports{})
+	c := New(cpu)
+	c.Symbols = map[uint16]string{0x0000: "start", 0x000F: "never"}
+	for !cpu.HALT {
+		cpu.ExecuteOneInstruction()
+	}
+	return cpu, c
+}
+
+func TestFlags(t *testing.T) {
+	_, c := testCPU(t)
+	assertEq(t, c.Flags(0x0002), Executed, "opcode")
+	assertEq(t, c.Flags(0x0004), Executed, "operand")
+	assertEq(t, c.Flags(0x8000), Read|Written, "counter")
+	assertEq(t, c.Flags(0x8001), Flags(0), "unused")
+	assertEq(t, c.Flags(0x000F), Flags(0), "never executed")
+	assertEq(t, c.Flags(0x8000).String(), "-rw", "flags string")
+	assertEq(t, c.Count(0x0005), int64(2), "INC A count")
+	assertEq(t, c.Count(0x0004), int64(0), "operand count")
+}
+
+func TestBranches(t *testing.T) {
+	_, c := testCPU(t)
+	branches := c.Branches()
+	assertEq(t, len(branches), 2, "branches")
+	assertEq(t, branches[0], Branch{Address: 0x0009, Taken: 1, NotTaken: 1}, "DJNZ")
+	assertEq(t, branches[1], Branch{Address: 0x000B, Taken: 0, NotTaken: 1}, "JR Z")
+}
+
+func TestListing(t *testing.T) {
+	_, c := testCPU(t)
+	var out bytes.Buffer
+	if err := c.WriteListing(&out, 0x0000, 0x0011); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"start:",
+		"     1  0000  06 02        LD B, $02",
+		"     2  0002  3A 00 80     LD A, ($8000)",
+		"     2  0005  3C           INC A",
+		"     2  0006  32 00 80     LD ($8000), A",
+		"     2  0009  10 F7        DJNZ $0002            taken 1, not taken 1",
+		"     1  000B  28 02        JR Z, $000F           taken 0, not taken 1",
+		"     1  000D  76           HALT",
+		" #####  000E  00           NOP",
+		"never:",
+		" #####  000F  3E 01        LD A, $01",
+		" #####  0011  76           HALT",
+	}
+	assertEq(t, out.String(), strings.Join(want, "\n")+"\n", "listing")
+
+	out.Reset()
+	c.WriteListing(&out, 0x8000, 0x8001)
+	assertEq(t, out.String(), "     -  8000  02           DB $02                -rw\n #####  8001  00           NOP\n", "data listing")
+}
+
+func TestLCOV(t *testing.T) {
+	_, c := testCPU(t)
+	var out bytes.Buffer
+	if err := c.WriteLCOV(&out, "rom.lst", 0x0000, 0x0011); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"SF:rom.lst\n",
+		"FN:2,start\nFN:11,never\nFNDA:1,start\nFNDA:0,never\nFNF:2\nFNH:1\n",
+		"BRDA:6,0,0,1\nBRDA:6,0,1,1\nBRDA:7,0,0,0\nBRDA:7,0,1,1\nBRF:4\nBRH:3\n",
+		"DA:2,1\n", "DA:9,0\n", "DA:12,0\n",
+		"LF:10\nLH:7\nend_of_record\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("lcov lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	s := c.Summarize(0x0000, 0x0011)
+	assertEq(t, s, Summary{Instructions: 10, InstructionsRun: 7, Branches: 4, BranchesTaken: 3}, "summary")
+	assertEq(t, s.String(), "coverage: 70.0% of instructions, 75.0% of branches", "summary string")
+}
+
+func TestDetach(t *testing.T) {
+	cpu, c := testCPU(t)
+	c.Detach()
+	assertEq(t, cpu.Tracer, z80.Tracer(nil), "tracer after detach")
+	assertEq(t, cpu.Bus, z80.Bus(nil), "bus after detach")
+	c.Reset()
+	assertEq(t, c.Count(0x0000), int64(0), "count after reset")
+}
@@ -0,0 +1,272 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Line is one instruction or run of data bytes in a listing
+type Line struct {
+	Address  uint16
+	Bytes    []byte
+	Mnemonic string  // Empty for data
+	Label    string  // Symbol at the address, if any
+	Count    int64   // Executions, for instructions
+	Flags    Flags   // Union of the flags of the bytes
+	Branch   *Branch // Outcomes if a conditional branch, nil otherwise
+}
+
+// Code reports whether the line is an instruction
+func (l *Line) Code() bool {
+	return l.Mnemonic != ""
+}
+
+// dataLine is the most data bytes shown on one line
+const dataLine = 8
+
+// isData reports whether an address holds data: it was read or written but
+// never executed
+func (c *Coverage) isData(address int) bool {
+	f := c.cells[uint16(address)].flags
+	return f&Executed == 0 && f&(Read|Written) != 0
+}
+
+// Lines disassembles the inclusive range. Executed instructions are taken
+// from what ran; the bytes in between are decoded as code that never ran,
+// except bytes that were only read or written, which are listed as data.
+func (c *Coverage) Lines(start, end uint16) []Line {
+	var lines []Line
+	last := int(end)
+	for a := int(start); a <= last; {
+		cl := &c.cells[uint16(a)]
+		n := 0
+		mnemonic := ""
+		if cl.count > 0 {
+			n = int(cl.length)
+		} else if !c.isData(a) {
+			n, mnemonic = c.decode(a, last)
+		}
+		if n == 0 {
+			// Data runs until code, a label or a full line
+			for n = 1; n < dataLine && a+n <= last && c.isData(a+n) && c.Symbols[uint16(a+n)] == ""; n++ {
+			}
+		}
+
+		line := Line{Address: uint16(a), Label: c.Symbols[uint16(a)], Count: cl.count, Mnemonic: mnemonic}
+		for i := range n {
+			address := uint16(a + i)
+			line.Bytes = append(line.Bytes, c.cpu.Memory.ReadByte(address))
+			line.Flags |= c.cells[address].flags
+		}
+		if cl.count > 0 {
+			line.Mnemonic = c.mnemonic(line.Address, line.Bytes)
+		}
+		if line.Code() {
+			line.Branch = c.branches[line.Address]
+			if line.Branch == nil {
+				if branch, _ := conditional(c.cpu, line.Bytes[0]); branch {
+					line.Branch = &Branch{Address: line.Address}
+				}
+			}
+		}
+		lines = append(lines, line)
+		a += n
+	}
+	return lines
+}
+
+// decode decodes an instruction that never ran. It returns 0 if the bytes
+// do not form an instruction within the range without overlapping data or an
+// instruction that ran.
+func (c *Coverage) decode(address, last int) (int, string) {
+	var code [4]byte
+	for i := range code {
+		code[i] = c.cpu.Memory.ReadByte(uint16(address + i))
+	}
+	inst, err := c.disasm.DecodeAt(code[:], uint16(address))
+	if err != nil || inst.Length == 0 || address+inst.Length-1 > last {
+		return 0, ""
+	}
+	for i := 1; i < inst.Length; i++ {
+		if cl := &c.cells[uint16(address+i)]; cl.count > 0 || c.isData(address+i) {
+			return 0, ""
+		}
+	}
+	return inst.Length, inst.Mnemonic
+}
+
+// mnemonic decodes the bytes of an instruction that ran at address
+func (c *Coverage) mnemonic(address uint16, code []byte) string {
+	var padded [4]byte
+	copy(padded[:], code)
+	if inst, err := c.disasm.DecodeAt(padded[:], address); err == nil && inst.Length == len(code) {
+		return inst.Mnemonic
+	}
+	return dataMnemonic(code)
+}
+
+// dataMnemonic formats bytes as a DB directive
+func dataMnemonic(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("$%02X", b)
+	}
+	return "DB " + strings.Join(parts, ", ")
+}
+
+// String formats the line for a listing. The count column shows executions,
+// ##### for code that never ran and - for data:
+//
+//	    2  0009  10 F7        DJNZ $0002            taken 1, not taken 1
+//	#####  000F  3E 01        LD A, $01
+//	    -  8000  02           DB $02                -rw
+func (l *Line) String() string {
+	count := "-"
+	text := l.Mnemonic
+	if l.Code() {
+		count = "#####"
+		if l.Count > 0 {
+			count = fmt.Sprint(l.Count)
+		}
+	} else {
+		text = dataMnemonic(l.Bytes)
+	}
+	note := ""
+	switch {
+	case l.Branch != nil:
+		note = fmt.Sprintf("taken %d, not taken %d", l.Branch.Taken, l.Branch.NotTaken)
+	case !l.Code():
+		note = l.Flags.String()
+	}
+	bytes := fmt.Sprintf("% X", l.Bytes)
+	if len(l.Bytes) > 4 {
+		bytes = fmt.Sprintf("% X", l.Bytes[:4]) + "..."
+	}
+	return strings.TrimRight(fmt.Sprintf("%6s  %04X  %-12s %-21s %s", count, l.Address, bytes, text, note), " ")
+}
+
+// WriteListing writes an annotated disassembly of the inclusive range, with
+// a label line before every address that has a symbol
+func (c *Coverage) WriteListing(w io.Writer, start, end uint16) error {
+	for _, l := range c.Lines(start, end) {
+		if l.Label != "" {
+			if _, err := fmt.Fprintf(w, "%s:\n", l.Label); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, l.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLCOV writes an lcov tracefile for the inclusive range. Line numbers
+// refer to the listing WriteListing writes for the same range, which should
+// be saved as source so genhtml can show it. Every instruction is a line,
+// every labelled instruction a function, and every conditional branch has a
+// taken and a not taken block.
+func (c *Coverage) WriteLCOV(w io.Writer, source string, start, end uint16) error {
+	var b strings.Builder
+	var da, brda, fn, fnda strings.Builder
+	var lf, lh, brf, brh, fnf, fnh int
+	number := 0
+	for _, l := range c.Lines(start, end) {
+		if l.Label != "" {
+			number++
+		}
+		number++
+		if !l.Code() {
+			continue
+		}
+		lf++
+		if l.Count > 0 {
+			lh++
+		}
+		fmt.Fprintf(&da, "DA:%d,%d\n", number, l.Count)
+		if l.Label != "" {
+			fnf++
+			if l.Count > 0 {
+				fnh++
+			}
+			fmt.Fprintf(&fn, "FN:%d,%s\n", number, l.Label)
+			fmt.Fprintf(&fnda, "FNDA:%d,%s\n", l.Count, l.Label)
+		}
+		if l.Branch != nil {
+			for i, taken := range []int64{l.Branch.Taken, l.Branch.NotTaken} {
+				brf++
+				count := "-" // Never evaluated
+				if l.Count > 0 {
+					count = fmt.Sprint(taken)
+				}
+				if taken > 0 {
+					brh++
+				}
+				fmt.Fprintf(&brda, "BRDA:%d,0,%d,%s\n", number, i, count)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "TN:\nSF:%s\n", source)
+	b.WriteString(fn.String())
+	b.WriteString(fnda.String())
+	fmt.Fprintf(&b, "FNF:%d\nFNH:%d\n", fnf, fnh)
+	b.WriteString(brda.String())
+	fmt.Fprintf(&b, "BRF:%d\nBRH:%d\n", brf, brh)
+	b.WriteString(da.String())
+	fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", lf, lh)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Summary counts the use of an address range
+type Summary struct {
+	Instructions, InstructionsRun int // Instructions in the listing, and those that ran
+	Branches, BranchesTaken       int // Branch outcomes in the listing, and those that happened
+	Read, Written                 int // Bytes read and written as data
+}
+
+// Summarize counts the use of the inclusive range
+func (c *Coverage) Summarize(start, end uint16) Summary {
+	var s Summary
+	for _, l := range c.Lines(start, end) {
+		if l.Code() {
+			s.Instructions++
+			if l.Count > 0 {
+				s.InstructionsRun++
+			}
+		}
+		if l.Branch != nil {
+			s.Branches += 2
+			for _, n := range []int64{l.Branch.Taken, l.Branch.NotTaken} {
+				if n > 0 {
+					s.BranchesTaken++
+				}
+			}
+		}
+	}
+	for a := int(start); a <= int(end); a++ {
+		f := c.cells[uint16(a)].flags
+		if f&Read != 0 {
+			s.Read++
+		}
+		if f&Written != 0 {
+			s.Written++
+		}
+	}
+	return s
+}
+
+// String formats the summary like go test -cover
+func (s Summary) String() string {
+	return fmt.Sprintf("coverage: %.1f%% of instructions, %.1f%% of branches",
+		percent(s.InstructionsRun, s.Instructions), percent(s.BranchesTaken, s.Branches))
+}
+
+// percent returns part of total as a percentage, 0 for an empty total
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
@@ -0,0 +1,333 @@
+// Package debugger adds execution breakpoints, memory and I/O watchpoints
+// and conditional expressions on top of a z80.CPU.
+package debugger
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// Kind identifies what a breakpoint watches
+type Kind byte
+
+// Kind constants enumerate the breakpoint types
+const (
+	Exec      Kind = iota // Instruction about to execute at the address
+	MemRead               // Memory data read from the range (opcode and operand fetches excluded)
+	MemWrite              // Memory write to the range
+	PortRead              // I/O read from a port in the range
+	PortWrite             // I/O write to a port in the range
+)
+
+// String returns a short name for the kind
+func (k Kind) String() string {
+	switch k {
+	case Exec:
+		return "exec"
+	case MemRead:
+		return "read"
+	case MemWrite:
+		return "write"
+	case PortRead:
+		return "in"
+	case PortWrite:
+		return "out"
+	default:
+		return "unknown"
+	}
+}
+
+// Breakpoint is an execution breakpoint or a watchpoint over an inclusive
+// address or port range
+type Breakpoint struct {
+	ID      int
+	Kind    Kind
+	Start   uint16
+	End     uint16
+	Cond    *Expr // Optional condition, nil always matches
+	Hits    int   // Number of times the breakpoint matched with its condition true
+	Enabled bool
+}
+
+// String describes the breakpoint on one line
+func (b *Breakpoint) String() string {
+	s := fmt.Sprintf("#%d %s %04X", b.ID, b.Kind, b.Start)
+	if b.End != b.Start {
+		s += fmt.Sprintf("-%04X", b.End)
+	}
+	if b.Cond != nil {
+		s += " if " + b.Cond.String()
+	}
+	s += fmt.Sprintf(" hits=%d", b.Hits)
+	if !b.Enabled {
+		s += " disabled"
+	}
+	return s
+}
+
+// matches reports whether the address falls in the breakpoint range
+func (b *Breakpoint) matches(address uint16) bool {
+	if b.Start <= b.End {
+		return address >= b.Start && address <= b.End
+	}
+	// The range wraps around the top of the address space
+	return address >= b.Start || address <= b.End
+}
+
+// triggers reports whether the breakpoint is enabled, watches the kind and
+// address, and its condition holds
+func (b *Breakpoint) triggers(cpu *z80.CPU, kind Kind, address uint16, value byte) bool {
+	if !b.Enabled || b.Kind != kind || !b.matches(address) {
+		return false
+	}
+	return b.Cond == nil || b.Cond.True(cpu, address, value)
+}
+
+// Hit describes a triggered breakpoint
+type Hit struct {
+	Breakpoint *Breakpoint
+	PC         uint16 // PC of the instruction that triggered the hit
+	Address    uint16 // Address or port accessed, PC for execution breakpoints
+	Value      byte   // Byte transferred, 0 for execution breakpoints
+}
+
+// String describes the hit on one line
+func (h *Hit) String() string {
+	if h.Breakpoint.Kind == Exec {
+		return fmt.Sprintf("breakpoint #%d at %04X", h.Breakpoint.ID, h.PC)
+	}
+	return fmt.Sprintf("watchpoint #%d %s %04X=%02X at %04X", h.Breakpoint.ID, h.Breakpoint.Kind, h.Address, h.Value, h.PC)
+}
+
+// Debugger wraps a CPU with breakpoints and watchpoints. It installs itself
+// as the CPU Breaker and, while watchpoints exist, as its Bus; a Bus that was
+// already attached keeps receiving every cycle.
+type Debugger struct {
+	CPU *z80.CPU
+
+	// OnHit is called for every hit. Returning false lets execution go on,
+	// for example to only log or count. A nil OnHit always stops.
+	OnHit func(hit *Hit) bool
+
+	breakpoints []*Breakpoint // Ordered by ID
+	nextID      int
+	bus         z80.Bus // Bus attached before the debugger
+	pc          uint16  // PC of the instruction being executed
+	pending     *Hit    // Watchpoint hit waiting for Run to return
+	last        *Hit    // Hit that stopped the last Run or Step
+}
+
+// New attaches a debugger to the CPU
+func New(cpu *z80.CPU) *Debugger {
+	d := &Debugger{
+		CPU:    cpu,
+		nextID: 1,
+		bus:    cpu.Bus,
+	}
+	cpu.Breaker = d
+	return d
+}
+
+// Detach removes the debugger from the CPU and restores the original Bus
+func (d *Debugger) Detach() {
+	if d.CPU.Breaker == d {
+		d.CPU.Breaker = nil
+	}
+	if d.CPU.Bus == d {
+		d.CPU.Bus = d.bus
+	}
+}
+
+// AddBreakpoint adds an execution breakpoint at the address. cond is an
+// optional expression, see Expr; an empty string means unconditional.
+func (d *Debugger) AddBreakpoint(address uint16, cond string) (*Breakpoint, error) {
+	return d.add(Exec, address, address, cond)
+}
+
+// AddWatchpoint adds a memory or I/O watchpoint over the inclusive range
+func (d *Debugger) AddWatchpoint(kind Kind, start, end uint16, cond string) (*Breakpoint, error) {
+	if kind == Exec || kind > PortWrite {
+		return nil, fmt.Errorf("invalid watchpoint kind %s", kind)
+	}
+	return d.add(kind, start, end, cond)
+}
+
+// add compiles the condition and registers a new breakpoint
+func (d *Debugger) add(kind Kind, start, end uint16, cond string) (*Breakpoint, error) {
+	b := &Breakpoint{ID: d.nextID, Kind: kind, Start: start, End: end, Enabled: true}
+	if cond != "" {
+		expr, err := Compile(cond)
+		if err != nil {
+			return nil, err
+		}
+		b.Cond = expr
+	}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+	d.attach()
+	return b, nil
+}
+
+// Remove deletes the breakpoint with the given ID
+func (d *Debugger) Remove(id int) error {
+	i := slices.IndexFunc(d.breakpoints, func(b *Breakpoint) bool { return b.ID == id })
+	if i < 0 {
+		return fmt.Errorf("no breakpoint #%d", id)
+	}
+	d.breakpoints = slices.Delete(d.breakpoints, i, i+1)
+	d.attach()
+	return nil
+}
+
+// Clear deletes all breakpoints and watchpoints
+func (d *Debugger) Clear() {
+	d.breakpoints = nil
+	d.attach()
+}
+
+// Get returns the breakpoint with the given ID, or nil
+func (d *Debugger) Get(id int) *Breakpoint {
+	for _, b := range d.breakpoints {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// Breakpoints returns all breakpoints ordered by ID
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return slices.Clone(d.breakpoints)
+}
+
+// attach installs the debugger as the CPU Bus only while watchpoints exist,
+// so that a CPU without them keeps its HALT fast-forward
+func (d *Debugger) attach() {
+	watching := false
+	for _, b := range d.breakpoints {
+		if b.Kind != Exec {
+			watching = true
+			break
+		}
+	}
+	switch {
+	case watching && d.CPU.Bus != d:
+		d.bus = d.CPU.Bus
+		d.CPU.Bus = d
+	case !watching && d.CPU.Bus == d:
+		d.CPU.Bus = d.bus
+	}
+}
+
+// check evaluates every enabled breakpoint of the kind against the access
+// and returns the first hit that should stop execution
+func (d *Debugger) check(kind Kind, address uint16, value byte) *Hit {
+	var stop *Hit
+	for _, b := range d.breakpoints {
+		if !b.triggers(d.CPU, kind, address, value) {
+			continue
+		}
+		b.Hits++
+		hit := &Hit{Breakpoint: b, PC: d.pc, Address: address, Value: value}
+		if (d.OnHit == nil || d.OnHit(hit)) && stop == nil {
+			stop = hit
+		}
+	}
+	return stop
+}
+
+// peek returns the first breakpoint an access would trigger without
+// counting the hit or calling OnHit
+func (d *Debugger) peek(kind Kind, address uint16, value byte) *Hit {
+	for _, b := range d.breakpoints {
+		if b.triggers(d.CPU, kind, address, value) {
+			return &Hit{Breakpoint: b, PC: d.pc, Address: address, Value: value}
+		}
+	}
+	return nil
+}
+
+// Break implements z80.Breaker
+func (d *Debugger) Break(cpu *z80.CPU) bool {
+	if d.pending != nil {
+		return true
+	}
+	d.pc = cpu.PC
+	if hit := d.check(Exec, cpu.PC, 0); hit != nil {
+		d.last = hit
+		return true
+	}
+	return false
+}
+
+// Cycle implements z80.Bus. A watchpoint hit lets the current instruction
+// finish and Break stops Run before the next one.
+func (d *Debugger) Cycle(c z80.Cycle) {
+	if d.bus != nil {
+		d.bus.Cycle(c)
+	}
+	var kind Kind
+	switch c.Type {
+	case z80.CycleRead:
+		if c.Operand {
+			return
+		}
+		kind = MemRead
+	case z80.CycleWrite:
+		kind = MemWrite
+	case z80.CycleIORead:
+		kind = PortRead
+	case z80.CycleIOWrite:
+		kind = PortWrite
+	default:
+		return
+	}
+	if hit := d.check(kind, c.Address, c.Data); hit != nil && d.pending == nil {
+		d.pending = hit
+	}
+}
+
+// Run executes up to budget T-states like z80.CPU.Run. When a breakpoint or
+// watchpoint stops execution the reason is z80.StopBreakpoint and hit
+// describes what triggered. A breakpoint at PC stops Run before it executes
+// anything; Continue resumes past it.
+func (d *Debugger) Run(budget int) (used int, reason z80.StopReason, hit *Hit) {
+	return d.run(budget, d.CPU.Run)
+}
+
+// Continue is Run resuming from a breakpoint, like z80.CPU.Continue: the
+// breakpoints at PC are not checked before the first instruction
+func (d *Debugger) Continue(budget int) (used int, reason z80.StopReason, hit *Hit) {
+	return d.run(budget, d.CPU.Continue)
+}
+
+// run implements Run and Continue with the CPU method that executes
+func (d *Debugger) run(budget int, execute func(int) (int, z80.StopReason)) (used int, reason z80.StopReason, hit *Hit) {
+	d.last = nil
+	d.pending = nil
+	d.pc = d.CPU.PC
+	used, reason = execute(budget)
+	if d.pending != nil {
+		// The watched access may also have been the last one of the budget
+		reason = z80.StopBreakpoint
+		d.last = d.pending
+	}
+	d.pending = nil
+	if reason != z80.StopBreakpoint {
+		return used, reason, nil
+	}
+	return used, reason, d.last
+}
+
+// Step executes one instruction, ignoring execution breakpoints at the
+// current PC, and returns the T-states used and any watchpoint hit
+func (d *Debugger) Step() (int, *Hit) {
+	d.pending = nil
+	d.pc = d.CPU.PC
+	ticks := d.CPU.ExecuteOneInstruction()
+	hit := d.pending
+	d.pending = nil
+	return ticks, hit
+}
@@ -0,0 +1,205 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// ram is a flat 64K memory.
+type ram [65536]byte
+
+func (m *ram) ReadByte(address uint16) byte         { return m[address] }
+func (m *ram) WriteByte(address uint16, value byte) { m[address] = value }
+func (m *ram) ReadWord(address uint16) uint16 {
+	return uint16(m[address]) | uint16(m[address+1])<<8
+}
+func (m *ram) WriteWord(address uint16, value uint16) {
+	m[address] = byte(value)
+	m[address+1] = byte(value >> 8)
+}
+
+// ports answers every read with 0xFF.
+type ports struct{}
+
+func (ports) ReadPort(port uint16) byte         { return 0xFF }
+func (ports) WritePort(port uint16, value byte) {}
+func (ports) CheckInterrupt() bool              { return false }
+
+// testDebugger loads a program at 0 and attaches a debugger.
+func testDebugger(program ...byte) (*Debugger, *ram) {
+	mem := &ram{}
+	copy(mem[:], program)
+	cpu := z80.New(mem, ports{})
+	cpu.SP = 0xFFFF
+	return New(cpu), mem
+}
+
+func assertEq[T comparable](t *testing.T, got, want T, msg string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %v, want %v", msg, got, want)
+	}
+}
+
+// Execution breakpoints stop before the instruction and count hits.
+func TestBreakpoint_Exec(t *testing.T) {
+	d, _ := testDebugger(0x00, 0x00, 0x00, 0x18, 0xFB) // NOP x3; JR -5
+	bp, err := d.AddBreakpoint(0x0002, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used, reason, hit := d.Run(1000)
+	assertEq(t, reason, z80.StopBreakpoint, "reason")
+	assertEq(t, used, 8, "used")
+	assertEq(t, hit.Breakpoint, bp, "hit breakpoint")
+	assertEq(t, d.CPU.PC, uint16(0x0002), "PC")
+
+	// Running again stops at once, continuing goes round the loop
+	used, _, _ = d.Run(1000)
+	assertEq(t, used, 0, "used when running again")
+	used, _, _ = d.Continue(1000)
+	assertEq(t, used, 4+12+4+4, "used when continuing")
+	assertEq(t, bp.Hits, 3, "hits")
+
+	bp.Enabled = false
+	_, reason, hit = d.Continue(100)
+	assertEq(t, reason, z80.StopBudget, "reason when disabled")
+	if hit != nil {
+		t.Errorf("unexpected hit %v", hit)
+	}
+}
+
+// A condition is checked every time the address is reached.
+func TestBreakpoint_Condition(t *testing.T) {
+	d, _ := testDebugger(0x3C, 0x18, 0xFD) // INC A; JR -3
+	bp, err := d.AddBreakpoint(0x0000, "A==3 && HL<0x8000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, reason, _ := d.Run(10000)
+	assertEq(t, reason, z80.StopBreakpoint, "reason")
+	assertEq(t, d.CPU.A, byte(3), "A")
+	assertEq(t, bp.Hits, 1, "hits")
+}
+
+// Memory watchpoints stop after the accessing instruction completes.
+func TestWatchpoint_Memory(t *testing.T) {
+	// LD HL,8000; LD (HL),A; INC HL; LD B,(HL); JR -5
+	d, _ := testDebugger(0x21, 0x00, 0x80, 0x77, 0x23, 0x46, 0x18, 0xFB)
+	d.CPU.A = 0x42
+	write, _ := d.AddWatchpoint(MemWrite, 0x8000, 0x8000, "VALUE==0x42")
+	read, _ := d.AddWatchpoint(MemRead, 0x8001, 0x80FF, "")
+
+	_, reason, hit := d.Run(1000)
+	assertEq(t, reason, z80.StopBreakpoint, "reason")
+	assertEq(t, hit.Breakpoint, write, "first hit")
+	assertEq(t, hit.PC, uint16(0x0003), "hit PC")
+	assertEq(t, hit.Address, uint16(0x8000), "hit address")
+	assertEq(t, hit.Value, byte(0x42), "hit value")
+	assertEq(t, d.CPU.PC, uint16(0x0004), "PC after write")
+
+	_, _, hit = d.Run(1000)
+	assertEq(t, hit.Breakpoint, read, "second hit")
+	assertEq(t, hit.Address, uint16(0x8001), "read address")
+	assertEq(t, d.CPU.PC, uint16(0x0006), "PC after read")
+
+	// Watchpoints observe the bus only while they exist
+	d.Remove(write.ID)
+	d.Remove(read.ID)
+	if d.CPU.Bus != nil {
+		t.Errorf("bus still attached")
+	}
+}
+
+// Read watchpoints ignore the operand bytes of instructions.
+func TestWatchpoint_MemReadSkipsOperands(t *testing.T) {
+	// LD A,$55; LD A,($0001); NOP
+	d, _ := testDebugger(0x3E, 0x55, 0x3A, 0x01, 0x00, 0x00)
+	read, _ := d.AddWatchpoint(MemRead, 0x0001, 0x0004, "")
+
+	_, reason, hit := d.Run(1000)
+	assertEq(t, reason, z80.StopBreakpoint, "reason")
+	assertEq(t, hit.Breakpoint, read, "hit")
+	assertEq(t, hit.PC, uint16(0x0002), "hit PC")
+	assertEq(t, hit.Address, uint16(0x0001), "hit address")
+	assertEq(t, read.Hits, 1, "hits")
+}
+
+// Port watchpoints report the port and the byte transferred.
+func TestWatchpoint_Port(t *testing.T) {
+	d, _ := testDebugger(0xDB, 0xFE, 0xD3, 0x10, 0x00) // IN A,(FE); OUT (10),A; NOP
+	in, _ := d.AddWatchpoint(PortRead, 0x00FE, 0xFFFE, "(ADDR & 0xFF) == 0xFE")
+	out, _ := d.AddWatchpoint(PortWrite, 0x0000, 0xFFFF, "")
+
+	_, _, hit := d.Run(1000)
+	assertEq(t, hit.Breakpoint, in, "in hit")
+	assertEq(t, hit.Value, byte(0xFF), "in value")
+
+	_, _, hit = d.Run(1000)
+	assertEq(t, hit.Breakpoint, out, "out hit")
+	assertEq(t, hit.Address, uint16(0xFF10), "out port")
+}
+
+// OnHit can veto a stop while the hit is still counted.
+func TestOnHit(t *testing.T) {
+	d, _ := testDebugger(0x00, 0x18, 0xFD) // NOP; JR -3
+	bp, _ := d.AddBreakpoint(0x0001, "")
+	var seen []*Hit
+	d.OnHit = func(hit *Hit) bool {
+		seen = append(seen, hit)
+		return len(seen) == 3
+	}
+
+	_, reason, hit := d.Run(10000)
+	assertEq(t, reason, z80.StopBreakpoint, "reason")
+	assertEq(t, bp.Hits, 3, "hits")
+	assertEq(t, hit, seen[2], "stopping hit")
+}
+
+// Step reports watchpoint hits of a single instruction.
+func TestStep(t *testing.T) {
+	d, _ := testDebugger(0x32, 0x00, 0x90) // LD (9000),A
+	wp, _ := d.AddWatchpoint(MemWrite, 0x9000, 0x9000, "")
+
+	ticks, hit := d.Step()
+	assertEq(t, ticks, 13, "ticks")
+	assertEq(t, hit.Breakpoint, wp, "hit")
+	assertEq(t, hit.PC, uint16(0x0000), "hit PC")
+}
+
+// Detach restores the original bus and breaker.
+func TestDetach(t *testing.T) {
+	mem := &ram{}
+	cpu := z80.New(mem, ports{})
+	log := &z80.EventLog{}
+	cpu.Bus = log
+	d := New(cpu)
+	d.AddWatchpoint(MemWrite, 0, 0xFFFF, "")
+	assertEq(t, cpu.Bus, z80.Bus(d), "bus while watching")
+
+	cpu.ExecuteOneInstruction()
+	assertEq(t, len(log.Cycles), 1, "cycles forwarded")
+
+	d.Detach()
+	assertEq(t, cpu.Bus, z80.Bus(log), "bus after detach")
+	if cpu.Breaker != nil {
+		t.Errorf("breaker still attached")
+	}
+}
+
+func TestAddWatchpoint_Errors(t *testing.T) {
+	d, _ := testDebugger()
+	if _, err := d.AddWatchpoint(Exec, 0, 0, ""); err == nil {
+		t.Errorf("exec watchpoint accepted")
+	}
+	if _, err := d.AddBreakpoint(0, "A =="); err == nil {
+		t.Errorf("invalid condition accepted")
+	}
+	if err := d.Remove(42); err == nil {
+		t.Errorf("removing a missing breakpoint succeeded")
+	}
+	assertEq(t, len(d.Breakpoints()), 0, "breakpoints after errors")
+}
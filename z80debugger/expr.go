@@ -0,0 +1,386 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// Expr is a compiled condition such as `A==0x3F && HL>0x8000`.
+//
+// Operands are numbers (decimal, 0x1F, $1F, 1Fh or 0b101), register names
+// (A, F, B, C, D, E, H, L, I, R, IXH, IXL, IYH, IYL, AF, BC, DE, HL, IX, IY,
+// SP, PC, AF', BC', DE', HL', MEMPTR, IM, IFF1, IFF2, HALT), ADDR and VALUE
+// (the address and data of the access that triggered a watchpoint), and
+// memory reads: [expr] reads a byte, W[expr] reads a little-endian word.
+// Operators follow C precedence: ! ~ - (unary), * / %, + -, << >>,
+// < <= > >=, == !=, &, ^, |, &&, ||. Names are case insensitive.
+type Expr struct {
+	src  string
+	eval func(env *env) int
+}
+
+// env is what an expression is evaluated against
+type env struct {
+	cpu   *z80.CPU
+	addr  uint16
+	value byte
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against the CPU. addr and value describe the
+// access being checked and are 0 for execution breakpoints.
+func (e *Expr) Eval(cpu *z80.CPU, addr uint16, value byte) int {
+	return e.eval(&env{cpu: cpu, addr: addr, value: value})
+}
+
+// True reports whether the expression evaluates to a non-zero value
+func (e *Expr) True(cpu *z80.CPU, addr uint16, value byte) bool {
+	return e.Eval(cpu, addr, value) != 0
+}
+
+// Compile parses an expression
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	eval, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos].text, src)
+	}
+	return &Expr{src: src, eval: eval}, nil
+}
+
+// registers maps register names to accessors
+var registers = map[string]func(cpu *z80.CPU) int{
+	"A":      func(cpu *z80.CPU) int { return int(cpu.A) },
+	"F":      func(cpu *z80.CPU) int { return int(cpu.F) },
+	"B":      func(cpu *z80.CPU) int { return int(cpu.B) },
+	"C":      func(cpu *z80.CPU) int { return int(cpu.C) },
+	"D":      func(cpu *z80.CPU) int { return int(cpu.D) },
+	"E":      func(cpu *z80.CPU) int { return int(cpu.E) },
+	"H":      func(cpu *z80.CPU) int { return int(cpu.H) },
+	"L":      func(cpu *z80.CPU) int { return int(cpu.L) },
+	"I":      func(cpu *z80.CPU) int { return int(cpu.I) },
+	"R":      func(cpu *z80.CPU) int { return int(cpu.R) },
+	"IXH":    func(cpu *z80.CPU) int { return int(cpu.IX >> 8) },
+	"IXL":    func(cpu *z80.CPU) int { return int(cpu.IX & 0xFF) },
+	"IYH":    func(cpu *z80.CPU) int { return int(cpu.IY >> 8) },
+	"IYL":    func(cpu *z80.CPU) int { return int(cpu.IY & 0xFF) },
+	"AF":     func(cpu *z80.CPU) int { return int(cpu.GetAF()) },
+	"BC":     func(cpu *z80.CPU) int { return int(cpu.GetBC()) },
+	"DE":     func(cpu *z80.CPU) int { return int(cpu.GetDE()) },
+	"HL":     func(cpu *z80.CPU) int { return int(cpu.GetHL()) },
+	"AF'":    func(cpu *z80.CPU) int { return int(cpu.GetAF_()) },
+	"BC'":    func(cpu *z80.CPU) int { return int(cpu.GetBC_()) },
+	"DE'":    func(cpu *z80.CPU) int { return int(cpu.GetDE_()) },
+	"HL'":    func(cpu *z80.CPU) int { return int(cpu.GetHL_()) },
+	"IX":     func(cpu *z80.CPU) int { return int(cpu.IX) },
+	"IY":     func(cpu *z80.CPU) int { return int(cpu.IY) },
+	"SP":     func(cpu *z80.CPU) int { return int(cpu.SP) },
+	"PC":     func(cpu *z80.CPU) int { return int(cpu.PC) },
+	"MEMPTR": func(cpu *z80.CPU) int { return int(cpu.MEMPTR) },
+	"IM":     func(cpu *z80.CPU) int { return int(cpu.IM) },
+	"IFF1":   func(cpu *z80.CPU) int { return boolToInt(cpu.IFF1) },
+	"IFF2":   func(cpu *z80.CPU) int { return boolToInt(cpu.IFF2) },
+	"HALT":   func(cpu *z80.CPU) int { return boolToInt(cpu.HALT) },
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// binaryOps lists the binary operators from the lowest precedence up
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// applyBinary evaluates a binary operator
+func applyBinary(op string, a, b int) int {
+	switch op {
+	case "||":
+		return boolToInt(a != 0 || b != 0)
+	case "&&":
+		return boolToInt(a != 0 && b != 0)
+	case "|":
+		return a | b
+	case "^":
+		return a ^ b
+	case "&":
+		return a & b
+	case "==":
+		return boolToInt(a == b)
+	case "!=":
+		return boolToInt(a != b)
+	case "<":
+		return boolToInt(a < b)
+	case "<=":
+		return boolToInt(a <= b)
+	case ">":
+		return boolToInt(a > b)
+	case ">=":
+		return boolToInt(a >= b)
+	case "<<":
+		return a << uint(b&63)
+	case ">>":
+		return a >> uint(b&63)
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	case "%":
+		if b == 0 {
+			return 0
+		}
+		return a % b
+	default:
+		return 0
+	}
+}
+
+// token is a lexical element of an expression
+type token struct {
+	text   string
+	number bool
+	value  int
+}
+
+// parser is a recursive-descent parser over the token list
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+// operators lists all operator tokens, longest first
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "!", "~", "(", ")", "[", "]"}
+
+// tokenize splits the source into tokens
+func (p *parser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '$' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(s) && isWordChar(rune(s[j])) {
+				j++
+			}
+			value, err := parseNumber(s[i:j])
+			if err != nil {
+				return err
+			}
+			p.tokens = append(p.tokens, token{text: s[i:j], number: true, value: value})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && isWordChar(rune(s[j])) {
+				j++
+			}
+			if j < len(s) && s[j] == '\'' {
+				j++
+			}
+			p.tokens = append(p.tokens, token{text: strings.ToUpper(s[i:j])})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected character %q in %q", c, p.src)
+			}
+			p.tokens = append(p.tokens, token{text: op})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func isWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+// parseNumber parses decimal, 0x1F, $1F, 1Fh and 0b101 numbers
+func parseNumber(text string) (int, error) {
+	digits, base := text, 10
+	lower := strings.ToLower(text)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		digits, base = text[2:], 16
+	case strings.HasPrefix(lower, "$"):
+		digits, base = text[1:], 16
+	case strings.HasSuffix(lower, "h"):
+		// Checked before 0b, which is also how hex like 0Bh starts
+		digits, base = text[:len(text)-1], 16
+	case strings.HasPrefix(lower, "0b"):
+		digits, base = text[2:], 2
+	}
+	value, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	return int(value), nil
+}
+
+// peek returns the current token text, or "" at the end
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+// expect consumes the given token or fails
+func (p *parser) expect(text string) error {
+	if p.peek() != text {
+		if p.pos >= len(p.tokens) {
+			return fmt.Errorf("missing %q at end of %q", text, p.src)
+		}
+		return fmt.Errorf("expected %q, found %q in %q", text, p.peek(), p.src)
+	}
+	p.pos++
+	return nil
+}
+
+// parseBinary parses operators of the given precedence level and above
+func (p *parser) parseBinary(level int) (func(*env) int, error) {
+	if level == len(binaryOps) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if !p.isOperator(op, level) {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *env) int { return applyBinary(op, l(e), right(e)) }
+	}
+}
+
+// isOperator reports whether op is a binary operator at the given level.
+// Only operator tokens qualify, so a register can never be mistaken for one.
+func (p *parser) isOperator(op string, level int) bool {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].number {
+		return false
+	}
+	for _, o := range binaryOps[level] {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUnary parses prefix operators
+func (p *parser) parseUnary() (func(*env) int, error) {
+	switch p.peek() {
+	case "!", "~", "-":
+		op := p.peek()
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "!":
+			return func(e *env) int { return boolToInt(operand(e) == 0) }, nil
+		case "~":
+			return func(e *env) int { return ^operand(e) }, nil
+		default:
+			return func(e *env) int { return -operand(e) }, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses numbers, names, parentheses and memory reads
+func (p *parser) parsePrimary() (func(*env) int, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of %q", p.src)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	if t.number {
+		value := t.value
+		return func(*env) int { return value }, nil
+	}
+
+	switch t.text {
+	case "(":
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case "[":
+		address, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return func(e *env) int { return int(e.cpu.Memory.ReadByte(uint16(address(e)))) }, p.expect("]")
+	case "W":
+		if p.peek() == "[" {
+			p.pos++
+			address, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			return func(e *env) int { return int(e.cpu.Memory.ReadWord(uint16(address(e)))) }, p.expect("]")
+		}
+	case "ADDR":
+		return func(e *env) int { return int(e.addr) }, nil
+	case "VALUE":
+		return func(e *env) int { return int(e.value) }, nil
+	}
+
+	if get, ok := registers[t.text]; ok {
+		return func(e *env) int { return get(e.cpu) }, nil
+	}
+	return nil, fmt.Errorf("unknown name %q in %q", t.text, p.src)
+}
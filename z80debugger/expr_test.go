@@ -0,0 +1,67 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+func TestExpr(t *testing.T) {
+	mem := &ram{}
+	mem[0x8000] = 0x34
+	mem[0x8001] = 0x12
+	cpu := z80.New(mem, ports{})
+	cpu.A = 0x3F
+	cpu.SetHL(0x8000)
+	cpu.SetAF_(0x1234)
+	cpu.IX = 0xABCD
+	cpu.IFF1 = true
+
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"A==0x3F && HL>=0x8000", 1},
+		{"a == $3f", 1},
+		{"A == 3Fh || 0", 1},
+		{"A != 0b00111111", 0},
+		{"0Bh", 0x0B},
+		{"0B1h", 0xB1},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"7 / 0", 0},
+		{"7 % 4", 3},
+		{"1 << 4 | 1", 17},
+		{"~0 & 0xFF", 0xFF},
+		{"-1 + 2", 1},
+		{"!IFF2 && IFF1", 1},
+		{"[HL]", 0x34},
+		{"W[HL]", 0x1234},
+		{"[HL+1] == 0x12", 1},
+		{"AF'", 0x1234},
+		{"IXH", 0xAB},
+		{"ixl", 0xCD},
+		{"ADDR + VALUE", 0x1001},
+		{"3 < 2 == 0", 1},
+		{"6 ^ 3", 5},
+	}
+	for _, tt := range tests {
+		e, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if got := e.Eval(cpu, 0x1000, 1); got != tt.want {
+			t.Errorf("%q = %d, want %d", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExpr_Errors(t *testing.T) {
+	for _, src := range []string{"", "A ==", "(A", "[HL", "FOO", "0xZZ", "A # 1", "A B"} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("%q compiled", src)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/kiltum/emuz80/z80debugger
+
+go 1.25.1
+
+require github.com/kiltum/emuz80/z80 v0.0.0
+
+replace (
+	github.com/kiltum/emuz80/z80 => ../z80
+	github.com/kiltum/emuz80/z80asm => ../z80asm
+	github.com/kiltum/emuz80/z80disasm => ../z80disasm
+)
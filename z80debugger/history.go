@@ -0,0 +1,373 @@
+package debugger
+
+import "github.com/kiltum/emuz80/z80"
+
+// History records execution so that it can be stepped backwards. It saves
+// the CPU state every Interval steps, journals the old value of every byte a
+// step writes through z80.Memory and records every answer the IO device
+// gives the CPU. Undoing a step restores memory and the last saved state,
+// then replays the steps after it with the recorded device answers, so both
+// come back exactly. Port output and other device side effects are not
+// undone, and are not repeated by the replay.
+type History struct {
+	Limit    int        // Steps kept; older ones are dropped in batches
+	Interval int        // Steps between saved CPU states, at most a quarter of Limit
+	Next     z80.Tracer // Optional tracer that keeps receiving every step
+
+	cpu       *z80.CPU
+	memory    *journalMemory
+	io        *journalIO
+	device    z80.IO // What was installed as cpu.IO
+	steps     []historyStep
+	states    []historyState
+	writes    []historyWrite
+	inputs    []byte // Device answers in the order the CPU asked for them
+	first     int    // Number of steps dropped, so steps[i] is step first+i
+	base      int    // Journal index of writes[0], counting dropped writes
+	inputBase int    // Index of inputs[0], counting dropped answers
+	mark      int    // Index of the first answer belonging to the next step
+	inStep    bool   // Writes belong to the step in progress
+	replaying bool   // Steps are being replayed from the recorded answers
+	replayed  int    // Next answer to replay
+}
+
+// historyStep is where a step starts in the journals
+type historyStep struct {
+	pc     uint16 // PC before the step
+	writes int    // Journal index of its first write
+	inputs int    // Index of its first device answer
+}
+
+// historyState is the CPU state saved before a step
+type historyState struct {
+	step  int // Number of the step, counting dropped ones
+	state z80.State
+}
+
+// historyWrite is one byte written by a step
+type historyWrite struct {
+	address  uint16
+	old, new byte
+}
+
+// journalMemory passes accesses through and journals writes made by steps
+type journalMemory struct {
+	z80.Memory
+	history *History
+}
+
+// WriteByte journals the byte it replaces
+func (m *journalMemory) WriteByte(address uint16, value byte) {
+	m.history.journal(address, value)
+	m.Memory.WriteByte(address, value)
+}
+
+// WriteWord journals the two bytes it replaces
+func (m *journalMemory) WriteWord(address uint16, value uint16) {
+	m.history.journal(address, byte(value))
+	m.history.journal(address+1, byte(value>>8))
+	m.Memory.WriteWord(address, value)
+}
+
+// journalIO records what the device answers the CPU, and answers from the
+// record while steps are replayed
+type journalIO struct {
+	z80.IO
+	history *History
+}
+
+// ReadPort records the byte read
+func (j *journalIO) ReadPort(port uint16) byte {
+	if value, ok := j.history.replay(); ok {
+		return value
+	}
+	return j.history.record(j.IO.ReadPort(port))
+}
+
+// WritePort passes the write on unless it is replayed
+func (j *journalIO) WritePort(port uint16, value byte) {
+	if !j.history.replaying {
+		j.IO.WritePort(port, value)
+	}
+}
+
+// CheckInterrupt records the interrupt line
+func (j *journalIO) CheckInterrupt() bool {
+	if value, ok := j.history.replay(); ok {
+		return value != 0
+	}
+	return j.history.record(boolByte(j.IO.CheckInterrupt())) != 0
+}
+
+// AcknowledgeInterrupt records the byte the device puts on the bus
+func (j *journalIO) AcknowledgeInterrupt() byte {
+	if value, ok := j.history.replay(); ok {
+		return value
+	}
+	value := byte(0xFF)
+	if device, ok := j.IO.(z80.InterruptAcknowledger); ok {
+		value = device.AcknowledgeInterrupt()
+	}
+	return j.history.record(value)
+}
+
+// journalNMI is a journalIO for devices wired to the NMI input
+type journalNMI struct {
+	*journalIO
+}
+
+// CheckNMI records the NMI line
+func (j journalNMI) CheckNMI() bool {
+	if value, ok := j.history.replay(); ok {
+		return value != 0
+	}
+	return j.history.record(boolByte(j.IO.(z80.NMIChecker).CheckNMI())) != 0
+}
+
+// boolByte encodes a line level as a recorded answer
+func boolByte(active bool) byte {
+	if active {
+		return 1
+	}
+	return 0
+}
+
+// NewHistory starts recording the CPU, keeping at least limit steps. It
+// wraps cpu.Memory and cpu.IO and installs itself as cpu.Tracer, chaining
+// the tracer that was attached before.
+func NewHistory(cpu *z80.CPU, limit int) *History {
+	h := &History{Limit: limit, Interval: 64, Next: cpu.Tracer, cpu: cpu}
+	h.memory = &journalMemory{Memory: cpu.Memory, history: h}
+	h.io = &journalIO{IO: cpu.IO, history: h}
+	h.device = h.io
+	if _, ok := cpu.IO.(z80.NMIChecker); ok {
+		h.device = journalNMI{h.io}
+	}
+	cpu.Memory = h.memory
+	cpu.IO = h.device
+	cpu.Tracer = h
+	return h
+}
+
+// Detach stops recording and restores the original memory, IO and tracer
+func (h *History) Detach() {
+	if h.cpu.Memory == h.memory {
+		h.cpu.Memory = h.memory.Memory
+	}
+	if h.cpu.IO == h.device {
+		h.cpu.IO = h.io.IO
+	}
+	if h.cpu.Tracer == h {
+		h.cpu.Tracer = h.Next
+	}
+}
+
+// interval returns the steps between saved states. It is capped so that
+// trimming always finds a saved state to keep.
+func (h *History) interval() int {
+	n := h.Interval
+	if h.Limit > 0 {
+		n = min(n, h.Limit/4)
+	}
+	return max(n, 1)
+}
+
+// BeforeStep implements z80.Tracer
+func (h *History) BeforeStep(cpu *z80.CPU, kind z80.StepKind) {
+	if h.Limit > 0 && len(h.steps) >= h.Limit+h.Limit/4+1 {
+		h.trim()
+	}
+	step := h.first + len(h.steps)
+	if len(h.states) == 0 || step-h.states[len(h.states)-1].step >= h.interval() {
+		h.states = append(h.states, historyState{step: step, state: cpu.Snapshot()})
+	}
+	h.steps = append(h.steps, historyStep{pc: cpu.PC, writes: h.base + len(h.writes), inputs: h.mark})
+	h.inStep = true
+	if h.Next != nil {
+		h.Next.BeforeStep(cpu, kind)
+	}
+}
+
+// AfterStep implements z80.Tracer
+func (h *History) AfterStep(cpu *z80.CPU, tstates int) {
+	h.inStep = false
+	h.mark = h.inputBase + len(h.inputs)
+	if h.Next != nil {
+		h.Next.AfterStep(cpu, tstates)
+	}
+}
+
+// trim drops the oldest steps down to Limit, or a few more so that the
+// oldest kept step has a saved state. It runs once the history has grown a
+// quarter past Limit, so the copying is spread over many steps.
+func (h *History) trim() {
+	drop := h.first + len(h.steps) - h.Limit
+	kept := 0
+	for i, s := range h.states {
+		if s.step <= drop {
+			kept = i
+		}
+	}
+	drop = h.states[kept].step - h.first
+	if drop <= 0 {
+		return
+	}
+	firstWrite := h.steps[drop].writes - h.base
+	firstInput := h.steps[drop].inputs - h.inputBase
+	h.steps = append(h.steps[:0], h.steps[drop:]...)
+	h.states = append(h.states[:0], h.states[kept:]...)
+	h.writes = append(h.writes[:0], h.writes[firstWrite:]...)
+	h.inputs = append(h.inputs[:0], h.inputs[firstInput:]...)
+	h.first += drop
+	h.base += firstWrite
+	h.inputBase += firstInput
+}
+
+// journal records a write made by the step in progress
+func (h *History) journal(address uint16, value byte) {
+	if !h.inStep {
+		return // Writes from outside the CPU are not undone
+	}
+	h.writes = append(h.writes, historyWrite{address: address, old: h.memory.Memory.ReadByte(address), new: value})
+}
+
+// record keeps a device answer for replaying and returns it
+func (h *History) record(value byte) byte {
+	h.inputs = append(h.inputs, value)
+	return value
+}
+
+// replay returns the next recorded answer while steps are replayed
+func (h *History) replay() (byte, bool) {
+	if !h.replaying || h.replayed >= len(h.inputs) {
+		return 0, false
+	}
+	value := h.inputs[h.replayed]
+	h.replayed++
+	return value, true
+}
+
+// Len returns the number of steps that can be undone
+func (h *History) Len() int {
+	return len(h.steps)
+}
+
+// Clear forgets all recorded steps
+func (h *History) Clear() {
+	h.first += len(h.steps)
+	h.steps = h.steps[:0]
+	h.states = h.states[:0]
+	h.writes = h.writes[:0]
+	h.inputs = h.inputs[:0]
+	h.base, h.inputBase, h.mark = 0, 0, 0
+}
+
+// lastWrites returns the writes of the most recent step
+func (h *History) lastWrites() []historyWrite {
+	if len(h.steps) == 0 {
+		return nil
+	}
+	return h.writes[h.steps[len(h.steps)-1].writes-h.base:]
+}
+
+// StepBack undoes the most recent step, restoring the CPU state and the
+// memory it wrote. It returns false if there is nothing to undo.
+func (h *History) StepBack() bool {
+	if len(h.steps) == 0 {
+		return false
+	}
+	last := len(h.steps) - 1
+	saved := h.states[len(h.states)-1]
+	from := saved.step - h.first
+
+	// Rewind memory and CPU to the saved state, then replay up to the
+	// step being undone
+	start := h.steps[from].writes - h.base
+	for i := len(h.writes) - 1; i >= start; i-- {
+		w := h.writes[i]
+		h.memory.Memory.WriteByte(w.address, w.old)
+	}
+	h.cpu.Restore(saved.state)
+	if from < last {
+		h.replaySteps(h.steps[from].inputs-h.inputBase, last-from)
+	} else {
+		h.states = h.states[:len(h.states)-1]
+	}
+
+	step := h.steps[last]
+	h.writes = h.writes[:step.writes-h.base]
+	h.inputs = h.inputs[:step.inputs-h.inputBase]
+	h.mark = step.inputs
+	h.steps = h.steps[:last]
+	return true
+}
+
+// replaySteps executes steps again from the recorded device answers starting
+// at index input, with nothing else attached to the CPU observing them
+func (h *History) replaySteps(input, steps int) {
+	cpu := h.cpu
+	tracer, bus, hook := cpu.Tracer, cpu.Bus, cpu.ErrorHook
+	cpu.Tracer, cpu.Bus, cpu.ErrorHook = nil, nil, nil
+	h.replaying, h.replayed = true, input
+	for range steps {
+		cpu.ExecuteOneInstruction()
+	}
+	h.replaying = false
+	cpu.Tracer, cpu.Bus, cpu.ErrorHook = tracer, bus, hook
+}
+
+// LastWrite finds the most recent recorded step that wrote the address. It
+// returns the PC of the writing instruction, how many steps ago it ran
+// (1 for the last step) and the value written.
+func (h *History) LastWrite(address uint16) (pc uint16, ago int, value byte, ok bool) {
+	step := len(h.steps) - 1
+	for i := len(h.writes) - 1; i >= 0; i-- {
+		for step > 0 && h.steps[step].writes-h.base > i {
+			step--
+		}
+		if w := h.writes[i]; w.address == address {
+			return h.steps[step].pc, len(h.steps) - step, w.new, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// ReverseStep undoes the most recent step recorded by the history and
+// reports a write watchpoint hit by it. It returns false if the history is
+// empty. Breakpoints are checked without counting hits or calling OnHit.
+func (d *Debugger) ReverseStep(h *History) (*Hit, bool) {
+	writes := append([]historyWrite(nil), h.lastWrites()...)
+	if !h.StepBack() {
+		return nil, false
+	}
+	d.pc = d.CPU.PC
+	var stop *Hit
+	for _, w := range writes {
+		if stop = d.peek(MemWrite, w.address, w.new); stop != nil {
+			break
+		}
+	}
+	return stop, true
+}
+
+// ReverseRun steps backwards until an execution breakpoint matches at PC, a
+// step matching a write watchpoint is undone, or the history runs out, in
+// which case hit is nil. A write watchpoint stops before the instruction
+// that wrote, and its condition sees the CPU state before that instruction.
+// Read and port watchpoints are not checked, since reads are not recorded.
+// Like ReverseStep it leaves hit counts alone and does not call OnHit.
+func (d *Debugger) ReverseRun(h *History) (steps int, hit *Hit) {
+	for {
+		watch, ok := d.ReverseStep(h)
+		if !ok {
+			return steps, nil
+		}
+		steps++
+		if watch != nil {
+			return steps, watch
+		}
+		if hit := d.peek(Exec, d.CPU.PC, 0); hit != nil {
+			return steps, hit
+		}
+	}
+}
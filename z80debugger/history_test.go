@@ -0,0 +1,210 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// counter program: LD HL,8000; loop: INC (HL); LD (9000),HL; PUSH HL; POP HL; JR loop
+var counter = []byte{0x21, 0x00, 0x80, 0x34, 0x22, 0x00, 0x90, 0xE5, 0xE1, 0x18, 0xF8}
+
+// Stepping back restores registers and memory exactly.
+func TestHistory_StepBack(t *testing.T) {
+	d, mem := testDebugger(counter...)
+	h := NewHistory(d.CPU, 1000)
+	start := d.CPU.Snapshot()
+	startMem := *mem
+
+	var states []z80.State
+	var mems []ram
+	for range 40 {
+		states = append(states, d.CPU.Snapshot())
+		mems = append(mems, *mem)
+		d.CPU.ExecuteOneInstruction()
+	}
+	assertEq(t, h.Len(), 40, "history length")
+	assertEq(t, mem[0x8000], byte(8), "counter")
+
+	for i := 39; i >= 0; i-- {
+		if !h.StepBack() {
+			t.Fatalf("step %d: history empty", i)
+		}
+		if d.CPU.Snapshot() != states[i] {
+			t.Fatalf("step %d: state differs", i)
+		}
+		if *mem != mems[i] {
+			t.Fatalf("step %d: memory differs", i)
+		}
+	}
+	if h.StepBack() {
+		t.Errorf("stepped back past the start")
+	}
+	assertEq(t, d.CPU.Snapshot(), start, "state at start")
+	if *mem != startMem {
+		t.Errorf("memory differs at start")
+	}
+
+	// Execution resumes normally after rewinding
+	d.CPU.ExecuteOneInstruction()
+	assertEq(t, d.CPU.GetHL(), uint16(0x8000), "HL after resuming")
+}
+
+// inputDevice answers every port read with a new value and raises an
+// interrupt at every fifth check.
+type inputDevice struct {
+	reads, writes, checks int
+}
+
+func (d *inputDevice) ReadPort(port uint16) byte         { d.reads++; return byte(d.reads * 7) }
+func (d *inputDevice) WritePort(port uint16, value byte) { d.writes++ }
+func (d *inputDevice) CheckInterrupt() bool              { d.checks++; return d.checks%5 == 0 }
+
+// Steps between saved states are replayed with the input the device gave.
+func TestHistory_ReplayInput(t *testing.T) {
+	// 0000 IM 1; EI; loop: IN A,(FE); OUT (10),A; LD (HL),A; INC HL; JR loop
+	// 0038 EI; RET
+	mem := &ram{}
+	copy(mem[:], []byte{0xED, 0x56, 0xFB, 0xDB, 0xFE, 0xD3, 0x10, 0x77, 0x23, 0x18, 0xF8})
+	copy(mem[0x38:], []byte{0xFB, 0xC9})
+	device := &inputDevice{}
+	cpu := z80.New(mem, device)
+	cpu.SP = 0xFFFF
+	cpu.SetHL(0x8000)
+	h := NewHistory(cpu, 1000)
+	h.Interval = 8
+
+	var states []z80.State
+	var mems []ram
+	for range 100 {
+		states = append(states, cpu.Snapshot())
+		mems = append(mems, *mem)
+		cpu.ExecuteOneInstruction()
+	}
+	done := *device
+	for i := 99; i >= 0; i-- {
+		h.StepBack()
+		if cpu.Snapshot() != states[i] {
+			t.Fatalf("step %d: state differs", i)
+		}
+		if *mem != mems[i] {
+			t.Fatalf("step %d: memory differs", i)
+		}
+	}
+	assertEq(t, *device, done, "device accesses after stepping back")
+}
+
+// Reverse run stops before the last instruction that wrote a watched byte.
+func TestHistory_ReverseRunToWrite(t *testing.T) {
+	d, mem := testDebugger(counter...)
+	h := NewHistory(d.CPU, 1000)
+	for range 23 {
+		d.CPU.ExecuteOneInstruction()
+	}
+	assertEq(t, mem[0x8000], byte(5), "counter")
+
+	pc, ago, value, ok := h.LastWrite(0x8000)
+	if !ok {
+		t.Fatal("no write to 8000 found")
+	}
+	assertEq(t, pc, uint16(0x0003), "writer PC")
+	assertEq(t, value, byte(5), "value written")
+
+	wp, _ := d.AddWatchpoint(MemWrite, 0x8000, 0x8000, "")
+	steps, hit := d.ReverseRun(h)
+	if hit == nil {
+		t.Fatal("watchpoint not hit")
+	}
+	assertEq(t, hit.Breakpoint, wp, "hit")
+	assertEq(t, hit.Value, byte(5), "hit value")
+	assertEq(t, steps, ago, "steps back")
+	assertEq(t, d.CPU.PC, uint16(0x0003), "PC before the write")
+	assertEq(t, mem[0x8000], byte(4), "counter before the write")
+
+	// A condition picks an earlier write
+	d.Remove(wp.ID)
+	d.AddWatchpoint(MemWrite, 0x8000, 0x8000, "VALUE == 2")
+	_, hit = d.ReverseRun(h)
+	assertEq(t, hit.Value, byte(2), "conditional hit value")
+	assertEq(t, mem[0x8000], byte(1), "counter before the second write")
+}
+
+// Reverse run stops at execution breakpoints and at the start of history.
+func TestHistory_ReverseRunToBreakpoint(t *testing.T) {
+	d, _ := testDebugger(counter...)
+	h := NewHistory(d.CPU, 1000)
+	for range 12 {
+		d.CPU.ExecuteOneInstruction()
+	}
+	bp, _ := d.AddBreakpoint(0x0007, "")
+	_, hit := d.ReverseRun(h)
+	assertEq(t, hit.Breakpoint, bp, "hit")
+	assertEq(t, d.CPU.PC, uint16(0x0007), "PC")
+
+	d.Remove(bp.ID)
+	_, hit = d.ReverseRun(h)
+	if hit != nil {
+		t.Errorf("unexpected hit %v", hit)
+	}
+	assertEq(t, d.CPU.PC, uint16(0x0000), "PC at start of history")
+	assertEq(t, h.Len(), 0, "history after rewinding all")
+}
+
+// Reverse execution leaves hit counts alone and does not call OnHit.
+func TestHistory_ReverseWithoutSideEffects(t *testing.T) {
+	d, _ := testDebugger(counter...)
+	h := NewHistory(d.CPU, 1000)
+	for range 13 {
+		d.CPU.ExecuteOneInstruction()
+	}
+	calls := 0
+	d.OnHit = func(*Hit) bool { calls++; return true }
+	bp, _ := d.AddBreakpoint(0x0007, "")
+	wp, _ := d.AddWatchpoint(MemWrite, 0x9000, 0x9001, "")
+
+	hit, _ := d.ReverseStep(h)
+	assertEq(t, hit.Breakpoint, wp, "step hit")
+	_, hit = d.ReverseRun(h)
+	assertEq(t, hit.Breakpoint, bp, "run hit")
+	assertEq(t, bp.Hits, 0, "breakpoint hits")
+	assertEq(t, wp.Hits, 0, "watchpoint hits")
+	assertEq(t, calls, 0, "OnHit calls")
+}
+
+// The history keeps at least Limit steps and drops older ones.
+func TestHistory_Limit(t *testing.T) {
+	d, mem := testDebugger(counter...)
+	h := NewHistory(d.CPU, 10)
+	for range 100 {
+		d.CPU.ExecuteOneInstruction()
+	}
+	if h.Len() < 10 || h.Len() > 13 {
+		t.Fatalf("history length %d", h.Len())
+	}
+	want := d.CPU.Snapshot()
+	wantMem := *mem
+	for h.StepBack() {
+	}
+	// Replaying forward from the oldest kept step reaches the same state
+	for d.CPU.Snapshot().TotalTStates < want.TotalTStates {
+		d.CPU.ExecuteOneInstruction()
+	}
+	assertEq(t, d.CPU.Snapshot(), want, "state after replay")
+	if *mem != wantMem {
+		t.Errorf("memory differs after replay")
+	}
+}
+
+// Detach restores the memory and chained tracer.
+func TestHistory_Detach(t *testing.T) {
+	d, mem := testDebugger(counter...)
+	h := NewHistory(d.CPU, 10)
+	if d.CPU.Memory == z80.Memory(mem) {
+		t.Fatal("memory not wrapped")
+	}
+	h.Detach()
+	assertEq(t, d.CPU.Memory, z80.Memory(mem), "memory after detach")
+	if d.CPU.Tracer != nil {
+		t.Errorf("tracer still attached")
+	}
+}
@@ -9,11 +9,33 @@ import (
 type Instruction struct {
 	Mnemonic string // Human-readable instruction mnemonic
 	Length   int    // Number of bytes the instruction occupies
-	Address  uint16 // Address operand for jump/load instructions, 0xFFFF if not applicable
+
+	// Address is the 16-bit address or immediate of the instruction, or
+	// 0xFFFF without one. Byte operands, relative jumps and displacements
+	// are not reported, and 0xFFFF is also a valid operand.
+	//
+	// Deprecated: use Operands, which describe every operand of every
+	// instruction.
+	Address uint16
+
+	// Structured form of the instruction, for tools that analyse code
+	// instead of printing it
+	Bytes        []byte    // Encoded instruction
+	Op           string    // Operation, such as LD or JP
+	Operands     []Operand // Operands in assembler order
+	Prefix       []byte    // Prefix bytes: CB, ED, DD, FD, or DD CB and FD CB
+	Opcode       byte      // Opcode byte following the prefixes
+	FlagsRead    byte      // Flags used, as Flag bits
+	FlagsWritten byte      // Flags changed, as Flag bits
+	Flow         Flow      // Effect on the flow of control, zero for most instructions
+
+	located bool // Decoded with DecodeAt, so relative targets are known
 }
 
 // Disassembler represents a Z80 disassembler
-type Disassembler struct{}
+type Disassembler struct {
+	Symbols *SymbolTable // Optional names shown instead of addresses, ports and displacements
+}
 
 // New creates a new Z80 disassembler
 func New() *Disassembler {
@@ -23,10 +45,38 @@ func New() *Disassembler {
 // Decode decodes a single Z80 instruction from a byte slice
 // It returns the decoded instruction and any error encountered
 func (d *Disassembler) Decode(data []byte) (*Instruction, error) {
+	return d.decodeAt(data, 0, false)
+}
+
+// DecodeAt decodes a single Z80 instruction located at address. Unlike
+// Decode, it resolves JR and DJNZ targets, which the mnemonic shows as an
+// address instead of a displacement.
+func (d *Disassembler) DecodeAt(data []byte, address uint16) (*Instruction, error) {
+	return d.decodeAt(data, address, true)
+}
+
+// decodeAt decodes an instruction, resolving relative targets if located
+func (d *Disassembler) decodeAt(data []byte, address uint16, located bool) (*Instruction, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("no data to decode")
 	}
 
+	inst, err := d.decode(data)
+	if err != nil {
+		return inst, err
+	}
+	d.describe(inst, data)
+	if located {
+		inst.locate(address)
+	}
+	if d.Symbols != nil {
+		d.symbolize(inst)
+	}
+	return inst, nil
+}
+
+// decode dispatches on the prefix of the instruction
+func (d *Disassembler) decode(data []byte) (*Instruction, error) {
 	// Get the first opcode byte
 	opcode := data[0]
 
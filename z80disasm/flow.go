@@ -0,0 +1,99 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flow is a set of ways an instruction changes the flow of control
+type Flow byte
+
+// Flow kinds. RST is both FlowCall and FlowRestart; DJNZ is a conditional jump.
+const (
+	FlowJump        Flow = 1 << iota // JP, JR, DJNZ
+	FlowCall                         // CALL, RST
+	FlowReturn                       // RET, RETI, RETN
+	FlowRestart                      // RST
+	FlowConditional                  // Only taken when a condition holds
+	FlowIndirect                     // Target in a register: JP (HL), JP (IX), JP (IY)
+	FlowRepeat                       // LDIR, CPIR, INIR, OTIR and their decrementing forms
+	FlowHalt                         // HALT
+)
+
+// flowNames lists the flow names in bit order
+var flowNames = []string{"jump", "call", "return", "restart", "conditional", "indirect", "repeat", "halt"}
+
+// String returns the flow kinds separated by commas
+func (f Flow) String() string {
+	var names []string
+	for i, name := range flowNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// operationFlows maps operations to their flow, before conditions
+var operationFlows = map[string]Flow{
+	"JP": FlowJump, "JR": FlowJump, "DJNZ": FlowJump | FlowConditional,
+	"CALL": FlowCall, "RST": FlowCall | FlowRestart,
+	"RET": FlowReturn, "RETI": FlowReturn, "RETN": FlowReturn,
+	"LDIR": FlowRepeat, "LDDR": FlowRepeat, "CPIR": FlowRepeat, "CPDR": FlowRepeat,
+	"INIR": FlowRepeat, "INDR": FlowRepeat, "OTIR": FlowRepeat, "OTDR": FlowRepeat,
+	"HALT": FlowHalt,
+}
+
+// flowOf returns the flow of a described instruction
+func flowOf(inst *Instruction) Flow {
+	f := operationFlows[inst.Op]
+	for _, o := range inst.Operands {
+		switch o.Kind {
+		case OperandCondition:
+			f |= FlowConditional
+		case OperandIndirect:
+			if f&FlowJump != 0 {
+				f |= FlowIndirect
+			}
+		}
+	}
+	return f
+}
+
+// Target returns the address a jump, call or RST goes to. Targets of JR and
+// DJNZ are only known when the instruction was decoded with DecodeAt, and
+// indirect jumps have none.
+func (inst *Instruction) Target() (uint16, bool) {
+	if inst.Flow&(FlowJump|FlowCall) == 0 || inst.Flow&FlowIndirect != 0 {
+		return 0, false
+	}
+	for _, o := range inst.Operands {
+		switch o.Kind {
+		case OperandImmediate16, OperandRestart:
+			return o.Value, true
+		case OperandRelative:
+			return o.Value, inst.located
+		}
+	}
+	return 0, false
+}
+
+// FallsThrough reports whether execution can continue with the next
+// instruction, which is false for unconditional jumps and returns. Calls fall
+// through, assuming they return.
+func (inst *Instruction) FallsThrough() bool {
+	return inst.Flow&(FlowJump|FlowReturn) == 0 || inst.Flow&FlowConditional != 0
+}
+
+// locate resolves relative targets for an instruction at address
+func (inst *Instruction) locate(address uint16) {
+	inst.located = true
+	for i, o := range inst.Operands {
+		if o.Kind == OperandRelative {
+			target := address + uint16(inst.Length) + uint16(o.Displacement)
+			inst.Operands[i].Value = target
+			inst.Address = target
+			inst.setOperand(i, fmt.Sprintf("$%04X", target))
+		}
+	}
+}
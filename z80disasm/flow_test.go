@@ -0,0 +1,95 @@
+// Package disasm provides tests for the Z80 disassembler implementation
+package disasm
+
+import (
+	"testing"
+)
+
+// TestFlow tests the control flow of decoded instructions
+func TestFlow(t *testing.T) {
+	d := New()
+	tests := []struct {
+		name    string
+		data    []byte
+		flow    Flow
+		target  uint16
+		known   bool
+		through bool
+	}{
+		{"NOP", []byte{0x00}, 0, 0, false, true},
+		{"JP nn", []byte{0xC3, 0x00, 0x90}, FlowJump, 0x9000, true, false},
+		{"JP NZ, nn", []byte{0xC2, 0x00, 0x90}, FlowJump | FlowConditional, 0x9000, true, true},
+		{"JP (HL)", []byte{0xE9}, FlowJump | FlowIndirect, 0, false, false},
+		{"JP (IY)", []byte{0xFD, 0xE9}, FlowJump | FlowIndirect, 0, false, false},
+		{"JR d", []byte{0x18, 0xFE}, FlowJump, 0x8000, true, false},
+		{"JR C, d", []byte{0x38, 0x10}, FlowJump | FlowConditional, 0x8012, true, true},
+		{"DJNZ d", []byte{0x10, 0xF7}, FlowJump | FlowConditional, 0x7FF9, true, true},
+		{"CALL nn", []byte{0xCD, 0x34, 0x12}, FlowCall, 0x1234, true, true},
+		{"CALL Z, nn", []byte{0xCC, 0x34, 0x12}, FlowCall | FlowConditional, 0x1234, true, true},
+		{"RST 38H", []byte{0xFF}, FlowCall | FlowRestart, 0x0038, true, true},
+		{"RET", []byte{0xC9}, FlowReturn, 0, false, false},
+		{"RET NC", []byte{0xD0}, FlowReturn | FlowConditional, 0, false, true},
+		{"RETI", []byte{0xED, 0x4D}, FlowReturn, 0, false, false},
+		{"LDIR", []byte{0xED, 0xB0}, FlowRepeat, 0, false, true},
+		{"LDI", []byte{0xED, 0xA0}, 0, 0, false, true},
+		{"HALT", []byte{0x76}, FlowHalt, 0, false, true},
+		{"EX (SP), HL", []byte{0xE3}, 0, 0, false, true},
+	}
+	for _, tt := range tests {
+		inst, err := d.DecodeAt(tt.data, 0x8000)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if inst.Flow != tt.flow {
+			t.Errorf("%s: flow %v, want %v", tt.name, inst.Flow, tt.flow)
+		}
+		target, known := inst.Target()
+		if target != tt.target || known != tt.known {
+			t.Errorf("%s: target %04X %v, want %04X %v", tt.name, target, known, tt.target, tt.known)
+		}
+		if inst.FallsThrough() != tt.through {
+			t.Errorf("%s: falls through %v, want %v", tt.name, inst.FallsThrough(), tt.through)
+		}
+	}
+}
+
+// TestDecodeAt tests that relative targets are resolved from the address
+func TestDecodeAt(t *testing.T) {
+	d := New()
+	tests := []struct {
+		data     []byte
+		address  uint16
+		expected string
+	}{
+		{[]byte{0x18, 0xFE}, 0x8000, "JR $8000"},
+		{[]byte{0x20, 0x05}, 0x8000, "JR NZ, $8007"},
+		{[]byte{0x10, 0xF7}, 0x0009, "DJNZ $0002"},
+		{[]byte{0x18, 0x7F}, 0xFFF0, "JR $0071"},
+		{[]byte{0x38, 0x02}, 0x0000, "JR C, $0004"},
+		{[]byte{0x10, 0x02}, 0x7FFE, "DJNZ $8002"},
+		{[]byte{0xC3, 0x34, 0x12}, 0x8000, "JP $1234"},
+	}
+	for _, tt := range tests {
+		inst, err := d.DecodeAt(tt.data, tt.address)
+		if err != nil {
+			t.Fatalf("% X: %v", tt.data, err)
+		}
+		if inst.Mnemonic != tt.expected {
+			t.Errorf("% X at %04X: got %q, want %q", tt.data, tt.address, inst.Mnemonic, tt.expected)
+		}
+	}
+
+	// Without an address the displacement is kept and the target is unknown
+	inst, _ := d.Decode([]byte{0x18, 0xFE})
+	if _, known := inst.Target(); known || inst.Mnemonic != "JR -$02" {
+		t.Errorf("Decode: got %q, target known %v", inst.Mnemonic, known)
+	}
+
+	// Resolved targets are shown as labels
+	d.Symbols = NewSymbolTable()
+	d.Symbols.AddLabel("loop", 0x8000)
+	inst, _ = d.DecodeAt([]byte{0x10, 0xFE}, 0x8000)
+	if inst.Mnemonic != "DJNZ loop" {
+		t.Errorf("symbol: got %q", inst.Mnemonic)
+	}
+}
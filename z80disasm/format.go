@@ -0,0 +1,306 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// HexStyle is how a dialect writes hexadecimal numbers
+type HexStyle int
+
+// Hexadecimal styles
+const (
+	HexDollar HexStyle = iota // $1F
+	HexSuffix                 // 01FH
+	HexC                      // 0x1F
+)
+
+// Dialect describes the syntax of an assembler, so listings can be written
+// for the assembler a project uses. Instructions a dialect cannot express,
+// and encodings an assembler would not choose, are written as bytes.
+type Dialect struct {
+	Name      string
+	Upper     bool     // Upper case operations and registers
+	Hex       HexStyle // Hexadecimal numbers
+	Immediate string   // Prefix of immediate values, such as # for sdas
+	Here      string   // Address of the current instruction, $ or .
+	Displaced bool     // Indexed operands written d(IX) instead of (IX+d)
+
+	Byte, Word string // Directives for data bytes and words
+	Origin     string // Format of the origin directive, given the address
+	Equate     string // Format of a constant, given the name and value
+
+	IndexHalves bool   // IXH, IXL, IYH and IYL
+	ShiftOne    string // Name of the undocumented SLL, empty if not supported
+	InF         bool   // IN F,(C)
+	OutZero     bool   // OUT (C),0
+	IndexedCopy bool   // RLC (IX+d),B and the other DD CB forms copying to a register
+}
+
+// Dialects of common assemblers
+var (
+	Sjasmplus = &Dialect{Name: "sjasmplus", Upper: true, Hex: HexDollar, Here: "$",
+		Byte: "DB", Word: "DW", Origin: "ORG %s", Equate: "%s EQU %s",
+		IndexHalves: true, ShiftOne: "SLL", InF: true, OutZero: true, IndexedCopy: true}
+	Pasmo = &Dialect{Name: "pasmo", Upper: true, Hex: HexSuffix, Here: "$",
+		Byte: "DEFB", Word: "DEFW", Origin: "ORG %s", Equate: "%s EQU %s",
+		IndexHalves: true, ShiftOne: "SL1"}
+	Z88dk = &Dialect{Name: "z88dk", Hex: HexDollar, Here: "$",
+		Byte: "defb", Word: "defw", Origin: "org %s", Equate: "defc %s = %s",
+		IndexHalves: true, ShiftOne: "sll", InF: true, OutZero: true}
+	Sdas = &Dialect{Name: "sdas", Hex: HexC, Immediate: "#", Here: ".", Displaced: true,
+		Byte: ".db", Word: ".dw", Origin: ".area _CODE (ABS)\n\t.org %s", Equate: "%s = %s"}
+
+	// Dialects lists the dialects above, for lookup by name
+	Dialects = []*Dialect{Sjasmplus, Pasmo, Z88dk, Sdas}
+)
+
+// word applies the dialect's case to an operation, register or condition
+func (dl *Dialect) word(text string) string {
+	if dl.Upper {
+		return strings.ToUpper(text)
+	}
+	return strings.ToLower(text)
+}
+
+// hex formats a number with the given number of digits
+func (dl *Dialect) hex(value uint16, digits int) string {
+	var text string
+	switch dl.Hex {
+	case HexSuffix:
+		text = fmt.Sprintf("%0*XH", digits, value)
+		if text[0] > '9' {
+			text = "0" + text
+		}
+	case HexC:
+		text = fmt.Sprintf("0x%0*X", digits, value)
+	default:
+		text = fmt.Sprintf("$%0*X", digits, value)
+	}
+	if !dl.Upper {
+		return strings.ToLower(text)
+	}
+	return text
+}
+
+// signed formats a displacement with its sign
+func (dl *Dialect) signed(value int) string {
+	if value < 0 {
+		return "-" + dl.hex(uint16(-value), 2)
+	}
+	return dl.hex(uint16(value), 2)
+}
+
+// address formats an address, using its label if it has one
+func (dl *Dialect) address(value uint16, symbols *SymbolTable) string {
+	if symbols != nil {
+		if name, ok := symbols.Label(value); ok {
+			return name
+		}
+	}
+	return dl.hex(value, 4)
+}
+
+// operand formats one operand of an instruction
+func (dl *Dialect) operand(o Operand, inst *Instruction, symbols *SymbolTable) string {
+	switch o.Kind {
+	case OperandRegister, OperandCondition:
+		return dl.word(o.Register + o.Condition)
+	case OperandImmediate8:
+		return dl.Immediate + dl.hex(o.Value, 2)
+	case OperandImmediate16:
+		if inst.Flow&(FlowJump|FlowCall) != 0 {
+			return dl.address(o.Value, symbols) // Targets are not immediate data
+		}
+		return dl.Immediate + dl.address(o.Value, symbols)
+	case OperandIndirect:
+		return "(" + dl.word(o.Register) + ")"
+	case OperandAbsolute:
+		return "(" + dl.address(o.Value, symbols) + ")"
+	case OperandPort:
+		if symbols != nil {
+			if name, ok := symbols.Port(byte(o.Value)); ok {
+				return "(" + name + ")"
+			}
+		}
+		return "(" + dl.hex(o.Value, 2) + ")"
+	case OperandIndexed:
+		offset := dl.signed(int(o.Displacement))
+		if symbols != nil {
+			if name, ok := symbols.Field(o.Displacement); ok {
+				offset = name
+			}
+		}
+		if dl.Displaced {
+			return offset + "(" + dl.word(o.Register) + ")"
+		}
+		if !strings.HasPrefix(offset, "-") {
+			offset = "+" + offset
+		}
+		return "(" + dl.word(o.Register) + offset + ")"
+	case OperandRelative:
+		if inst.located {
+			return dl.address(o.Value, symbols)
+		}
+		return fmt.Sprintf("%s%+d", dl.Here, int(o.Displacement)+inst.Length)
+	case OperandBit, OperandMode:
+		return fmt.Sprint(o.Value)
+	case OperandRestart:
+		return dl.hex(o.Value, 2)
+	}
+	return "?"
+}
+
+// Supports reports whether the dialect can write an instruction so that it
+// assembles back to the same bytes
+func (dl *Dialect) Supports(inst *Instruction) bool {
+	if !canonical(inst) {
+		return false
+	}
+	for _, o := range inst.Operands {
+		if o.Kind == OperandRegister && len(o.Register) == 3 && o.Register[0] == 'I' && !dl.IndexHalves {
+			return false
+		}
+	}
+	switch {
+	case inst.Op == "SLL" && dl.ShiftOne == "":
+		return false
+	case inst.Op == "IN" && inst.Operands[0].Register == "F" && !dl.InF:
+		return false
+	case inst.Op == "OUT" && inst.Operands[1].Kind == OperandImmediate8 && !dl.OutZero:
+		return false
+	case len(inst.Prefix) == 2 && inst.Opcode&7 != 6 && !dl.IndexedCopy:
+		return false
+	}
+	return true
+}
+
+// Format writes an instruction in the dialect, naming addresses, ports and
+// fields from symbols if given. Instructions the dialect cannot express are
+// written as a byte directive. Relative branches are written as labels or
+// addresses when decoded with DecodeAt, and relative to the current address
+// otherwise.
+func (dl *Dialect) Format(inst *Instruction, symbols *SymbolTable) string {
+	if !dl.Supports(inst) {
+		return dl.bytes(inst.Bytes)
+	}
+	op := inst.Op
+	if op == "SLL" {
+		op = dl.ShiftOne
+	}
+	if len(inst.Operands) == 0 {
+		return dl.word(op)
+	}
+	operands := make([]string, len(inst.Operands))
+	for i, o := range inst.Operands {
+		operands[i] = dl.operand(o, inst, symbols)
+	}
+	return dl.word(op) + " " + strings.Join(operands, ", ")
+}
+
+// bytes formats a byte directive
+func (dl *Dialect) bytes(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = dl.hex(uint16(b), 2)
+	}
+	return dl.Byte + " " + strings.Join(parts, ", ")
+}
+
+// Canonical encodings, by operation and operands with their values cleared
+var (
+	canonicalOnce  sync.Once
+	canonicalCodes map[string]string
+)
+
+// canonicalKey identifies an instruction apart from its immediate values,
+// addresses and displacements
+func canonicalKey(inst *Instruction) string {
+	var b strings.Builder
+	b.WriteString(inst.Op)
+	for _, o := range inst.Operands {
+		switch o.Kind {
+		case OperandImmediate8, OperandImmediate16, OperandAbsolute, OperandPort, OperandIndexed, OperandRelative:
+			o.Value, o.Displacement = 0, 0
+		}
+		b.WriteString(" " + o.String())
+	}
+	return b.String()
+}
+
+// canonical reports whether an instruction is encoded the way an assembler
+// encodes it. Prefixes without effect, ED opcodes repeating another
+// instruction such as ED 4C NEG, ED 63 for LD (nn),HL and DD CB forms of
+// BIT with a register are not.
+func canonical(inst *Instruction) bool {
+	canonicalOnce.Do(func() {
+		canonicalCodes = make(map[string]string)
+		var encodings [][]byte
+		for op := range 256 {
+			switch op {
+			case 0xCB, 0xDD, 0xED, 0xFD:
+			default:
+				encodings = append(encodings, []byte{byte(op), 0, 0, 0})
+			}
+		}
+		for _, prefix := range []byte{0xCB, 0xED, 0xDD, 0xFD} {
+			for op := range 256 {
+				encodings = append(encodings, []byte{prefix, byte(op), 0, 0, 0})
+			}
+		}
+		// Assemblers use the documented (IX+d) forms, which end in 6
+		for _, prefix := range []byte{0xDD, 0xFD} {
+			for _, last := range []bool{true, false} {
+				for op := range 256 {
+					if (op&7 == 6) == last {
+						encodings = append(encodings, []byte{prefix, 0xCB, 0, byte(op)})
+					}
+				}
+			}
+		}
+		for _, data := range encodings {
+			inst := &Instruction{}
+			(&Disassembler{}).describe(inst, data)
+			key := canonicalKey(inst)
+			if _, ok := canonicalCodes[key]; !ok {
+				canonicalCodes[key] = string(append(inst.Prefix, inst.Opcode))
+			}
+		}
+	})
+	return canonicalCodes[canonicalKey(inst)] == string(append(append([]byte(nil), inst.Prefix...), inst.Opcode))
+}
+
+// WriteDialect writes the listing as source for the assembler of a dialect
+func (l *Listing) WriteDialect(w io.Writer, dl *Dialect) error {
+	var b strings.Builder
+	for _, e := range l.Equates {
+		value := dl.hex(uint16(e.Value), 4)
+		if e.Value < 0 {
+			value = dl.signed(e.Value)
+		}
+		fmt.Fprintf(&b, dl.Equate+"\n", e.Name, value)
+	}
+	if len(l.Equates) > 0 {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\t"+dl.Origin+"\n", dl.hex(l.Origin, 4))
+	for _, line := range l.Lines {
+		if line.Label != "" {
+			fmt.Fprintf(&b, "%s:\n", line.Label)
+		}
+		var text string
+		switch {
+		case line.Code():
+			text = dl.Format(line.Instruction, l.symbols)
+		case line.Word:
+			text = dl.Word + " " + dl.address(uint16(line.Bytes[1])<<8|uint16(line.Bytes[0]), l.symbols)
+		default:
+			text = dl.bytes(line.Bytes)
+		}
+		fmt.Fprintf(&b, "\t%s\n", text)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
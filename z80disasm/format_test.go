@@ -0,0 +1,161 @@
+// Package disasm provides tests for the Z80 disassembler implementation
+package disasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFormatDialects tests instructions written in every dialect
+func TestFormatDialects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want [4]string // sjasmplus, pasmo, z88dk, sdas
+	}{
+		{"LD A, n", []byte{0x3E, 0xAB}, [4]string{"LD A, $AB", "LD A, 0ABH", "ld a, $ab", "ld a, #0xab"}},
+		{"LD HL, nn", []byte{0x21, 0x34, 0x12}, [4]string{"LD HL, $1234", "LD HL, 1234H", "ld hl, $1234", "ld hl, #0x1234"}},
+		{"LD A, (nn)", []byte{0x3A, 0x00, 0xC0}, [4]string{"LD A, ($C000)", "LD A, (0C000H)", "ld a, ($c000)", "ld a, (0xc000)"}},
+		{"LD (IX-d), A", []byte{0xDD, 0x77, 0xFB}, [4]string{"LD (IX-$05), A", "LD (IX-05H), A", "ld (ix-$05), a", "ld -0x05(ix), a"}},
+		{"BIT b, (IY+d)", []byte{0xFD, 0xCB, 0x02, 0x46}, [4]string{"BIT 0, (IY+$02)", "BIT 0, (IY+02H)", "bit 0, (iy+$02)", "bit 0, 0x02(iy)"}},
+		{"JR d", []byte{0x18, 0xFE}, [4]string{"JR $+0", "JR $+0", "jr $+0", "jr .+0"}},
+		{"JP nn", []byte{0xC3, 0x00, 0x80}, [4]string{"JP $8000", "JP 8000H", "jp $8000", "jp 0x8000"}},
+		{"RST 38H", []byte{0xFF}, [4]string{"RST $38", "RST 38H", "rst $38", "rst 0x38"}},
+		{"EX AF, AF'", []byte{0x08}, [4]string{"EX AF, AF'", "EX AF, AF'", "ex af, af'", "ex af, af'"}},
+		{"IN A, (n)", []byte{0xDB, 0xFE}, [4]string{"IN A, ($FE)", "IN A, (0FEH)", "in a, ($fe)", "in a, (0xfe)"}},
+		{"LD IXH, n", []byte{0xDD, 0x26, 0x01}, [4]string{"LD IXH, $01", "LD IXH, 01H", "ld ixh, $01", ".db 0xdd, 0x26, 0x01"}},
+		{"SLL B", []byte{0xCB, 0x30}, [4]string{"SLL B", "SL1 B", "sll b", ".db 0xcb, 0x30"}},
+		{"IN F, (C)", []byte{0xED, 0x70}, [4]string{"IN F, (C)", "DEFB 0EDH, 70H", "in f, (c)", ".db 0xed, 0x70"}},
+		{"OUT (C), 0", []byte{0xED, 0x71}, [4]string{"OUT (C), $00", "DEFB 0EDH, 71H", "out (c), $00", ".db 0xed, 0x71"}},
+		{"RLC (IX+d), B", []byte{0xDD, 0xCB, 0x01, 0x00}, [4]string{"RLC (IX+$01), B", "DEFB 0DDH, 0CBH, 01H, 00H", "defb $dd, $cb, $01, $00", ".db 0xdd, 0xcb, 0x01, 0x00"}},
+		{"unknown ED", []byte{0xED, 0x00}, [4]string{"DB $ED, $00", "DEFB 0EDH, 00H", "defb $ed, $00", ".db 0xed, 0x00"}},
+		{"repeated NEG", []byte{0xED, 0x4C}, [4]string{"DB $ED, $4C", "DEFB 0EDH, 4CH", "defb $ed, $4c", ".db 0xed, 0x4c"}},
+		{"prefixed NOP", []byte{0xDD, 0x00}, [4]string{"DB $DD, $00", "DEFB 0DDH, 00H", "defb $dd, $00", ".db 0xdd, 0x00"}},
+	}
+	d := New()
+	for _, tt := range tests {
+		inst, err := d.Decode(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for i, dl := range Dialects {
+			if got := dl.Format(inst, nil); got != tt.want[i] {
+				t.Errorf("%s in %s: got %q, want %q", tt.name, dl.Name, got, tt.want[i])
+			}
+		}
+	}
+}
+
+// TestCanonical tests which encodings an assembler would produce
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want bool
+	}{
+		{[]byte{0x22, 0x34, 0x12}, true},        // LD (nn), HL
+		{[]byte{0xED, 0x63, 0x34, 0x12}, false}, // LD (nn), HL the long way
+		{[]byte{0xED, 0x43, 0x34, 0x12}, true},  // LD (nn), BC
+		{[]byte{0xED, 0x44}, true},              // NEG
+		{[]byte{0xED, 0x54}, false},             // NEG again
+		{[]byte{0xED, 0x45}, true},              // RETN
+		{[]byte{0xED, 0x55}, false},             // RETN again
+		{[]byte{0xED, 0x46}, true},              // IM 0
+		{[]byte{0xED, 0x66}, false},             // IM 0 again
+		{[]byte{0xED, 0x77}, false},             // NOP
+		{[]byte{0xDD, 0xEB}, false},             // EX DE, HL with a useless prefix
+		{[]byte{0xDD, 0xE9}, true},              // JP (IX)
+		{[]byte{0xDD, 0xCB, 0x00, 0x46}, true},  // BIT 0, (IX+0)
+		{[]byte{0xDD, 0xCB, 0x00, 0x40}, false}, // BIT 0, (IX+0) again
+		{[]byte{0xDD, 0xCB, 0x00, 0x00}, true},  // RLC (IX+0), B
+	}
+	d := New()
+	for _, tt := range tests {
+		inst, _ := d.Decode(tt.data)
+		if got := canonical(inst); got != tt.want {
+			t.Errorf("% X: canonical %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestWriteDialect tests writing a whole listing for sdas
+func TestWriteDialect(t *testing.T) {
+	d := New()
+	d.Symbols = NewSymbolTable()
+	d.Symbols.AddLabel("bdos", 0x0005)
+	l := d.DisassembleImage(Image{Data: append([]byte{0xCD, 0x05, 0x00}, testImage...), Origin: 0x7FFD, Entries: []uint16{0x7FFD}, Vectors: []uint16{0x8014}})
+	var b strings.Builder
+	if err := l.WriteDialect(&b, Sdas); err != nil {
+		t.Fatal(err)
+	}
+	want := `bdos = 0x0005
+
+	.area _CODE (ABS)
+	.org 0x7ffd
+sub_7FFD:
+	call bdos
+	ld hl, #0x8012
+	call sub_800D
+	ld de, (data_8010)
+loc_800A:
+	jr loc_800A
+	.db 0x3e
+sub_800D:
+	ld a, (hl)
+	ret
+	.db 0x00
+data_8010:
+	.dw 0x1234
+	.db 0x48, 0x69
+	.dw sub_800D
+`
+	if got := b.String(); got != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestWriteSdas tests an sdas listing with immediates, indexed operands and
+// relative jumps in both directions. z80asm cannot read sdas syntax, so this
+// golden listing stands in for the round trip of the other dialects.
+func TestWriteSdas(t *testing.T) {
+	code := []byte{
+		0x3E, 0x7F, // LD A, $7F
+		0x06, 0x03, // LD B, 3
+		0xDD, 0x21, 0x00, 0x90, // LD IX, $9000
+		0xDD, 0x77, 0xFB, // LD (IX-5), A
+		0xFD, 0xCB, 0x02, 0x46, // BIT 0, (IY+2)
+		0xDD, 0x36, 0x04, 0x80, // LD (IX+4), $80
+		0xD6, 0x01, // SUB 1
+		0x28, 0x04, // JR Z, +4
+		0x10, 0xFA, // DJNZ -6
+		0x18, 0xFE, // JR $
+		0xE6, 0x0F, // AND $0F
+		0xC9, // RET
+	}
+	l := New().DisassembleImage(Image{Data: code, Origin: 0x0100, Entries: []uint16{0x0100}})
+	var b strings.Builder
+	if err := l.WriteDialect(&b, Sdas); err != nil {
+		t.Fatal(err)
+	}
+	want := `	.area _CODE (ABS)
+	.org 0x0100
+sub_0100:
+	ld a, #0x7f
+	ld b, #0x03
+	ld ix, #0x9000
+	ld -0x05(ix), a
+	bit 0, 0x02(iy)
+	ld 0x04(ix), #0x80
+loc_0113:
+	sub #0x01
+	jr z, loc_011B
+	djnz loc_0113
+loc_0119:
+	jr loc_0119
+loc_011B:
+	and #0x0f
+	ret
+`
+	if got := b.String(); got != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", got, want)
+	}
+}
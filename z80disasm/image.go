@@ -0,0 +1,320 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Image describes a whole program to disassemble by following control flow
+type Image struct {
+	Data     []byte   // Program bytes
+	Origin   uint16   // Address Data is loaded at
+	Entries  []uint16 // Addresses where execution starts
+	Vectors  []uint16 // Addresses of words holding entry points, such as an IM 2 table
+	Restarts bool     // Also start at the RST vectors and the NMI vector at 0066H
+}
+
+// Line is one instruction or directive of a listing
+type Line struct {
+	Address     uint16
+	Bytes       []byte
+	Label       string       // Label defined at the address, if any
+	Instruction *Instruction // Nil for data
+	Word        bool         // Data word, written as DW
+	Text        string       // Instruction or DB/DW directive, with labels
+}
+
+// Code reports whether the line is an instruction
+func (l *Line) Code() bool {
+	return l.Instruction != nil
+}
+
+// Equate is a symbol defined with EQU because no line is at its value
+type Equate struct {
+	Name  string
+	Value int
+}
+
+// Listing is a disassembled image that assembles back to the same bytes
+type Listing struct {
+	Origin  uint16
+	Equates []Equate
+	Lines   []Line
+
+	symbols *SymbolTable // Labels, ports and fields used by the lines
+}
+
+// Byte classes of an image
+const (
+	byteData  byte = iota // Not reached as code
+	byteCode              // Inside an instruction
+	byteStart             // First byte of an instruction
+	byteWord              // First byte of a data word
+)
+
+// restartVectors are the RST targets and the NMI entry point
+var restartVectors = []uint16{0x00, 0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38, 0x66}
+
+// tracer follows control flow through an image
+type tracer struct {
+	d      *Disassembler
+	image  Image
+	kinds  []byte
+	insts  map[uint16]*Instruction
+	labels map[uint16]string
+	queue  []uint16
+}
+
+// contains reports whether the image holds the bytes from address to
+// address+n-1
+func (t *tracer) contains(address uint16, n int) bool {
+	offset := int(address) - int(t.image.Origin)
+	return offset >= 0 && offset+n <= len(t.image.Data)
+}
+
+// kind returns the class of the byte at an address inside the image
+func (t *tracer) kind(address uint16) *byte {
+	return &t.kinds[int(address)-int(t.image.Origin)]
+}
+
+// label names an address inside the image unless it already has a name
+func (t *tracer) label(address uint16, prefix string) {
+	if _, ok := t.labels[address]; !ok && t.contains(address, 1) {
+		t.labels[address] = fmt.Sprintf("%s_%04X", prefix, address)
+	}
+}
+
+// enter queues an entry point inside the image
+func (t *tracer) enter(address uint16, prefix string) {
+	if t.contains(address, 1) {
+		t.label(address, prefix)
+		t.queue = append(t.queue, address)
+	}
+}
+
+// trace decodes instructions from an entry point until control leaves,
+// the image ends or the code runs into code already traced
+func (t *tracer) trace(address uint16) {
+	for t.contains(address, 1) && *t.kind(address) != byteStart {
+		offset := int(address) - int(t.image.Origin)
+		inst, err := t.d.DecodeAt(t.image.Data[offset:], address)
+		if err != nil || inst.Length == 0 || !t.contains(address, inst.Length) {
+			return
+		}
+		for i := range inst.Length {
+			if *t.kind(address + uint16(i)) != byteData {
+				return // Overlaps an instruction
+			}
+		}
+		*t.kind(address) = byteStart
+		for i := 1; i < inst.Length; i++ {
+			*t.kind(address + uint16(i)) = byteCode
+		}
+		t.insts[address] = inst
+
+		if target, ok := inst.Target(); ok {
+			if inst.Flow&FlowCall != 0 {
+				t.enter(target, "sub")
+			} else {
+				t.enter(target, "loc")
+			}
+		}
+		for _, o := range inst.Operands {
+			if o.Kind == OperandAbsolute {
+				t.label(o.Value, "data")
+			}
+		}
+		if !inst.FallsThrough() {
+			return
+		}
+		address += uint16(inst.Length)
+	}
+}
+
+// markWords marks the data words that 16-bit loads refer to
+func (t *tracer) markWords() {
+	for _, inst := range t.insts {
+		for i, o := range inst.Operands {
+			if o.Kind != OperandAbsolute || !t.contains(o.Value, 2) {
+				continue
+			}
+			other := inst.Operands[1-i]
+			if len(other.Register) == 2 && *t.kind(o.Value) == byteData && *t.kind(o.Value + 1) == byteData {
+				*t.kind(o.Value) = byteWord
+			}
+		}
+	}
+}
+
+// DisassembleImage disassembles a whole program. Code is found by following
+// jumps and calls from the entry points; everything not reached is data,
+// listed as DB, or DW for words read by 16-bit loads and vector tables.
+// Targets get labels: sub_XXXX for calls, loc_XXXX for jumps and data_XXXX
+// for memory operands, unless Symbols names them.
+func (d *Disassembler) DisassembleImage(image Image) *Listing {
+	if len(image.Data) > 0x10000-int(image.Origin) {
+		image.Data = image.Data[:0x10000-int(image.Origin)]
+	}
+	plain := &Disassembler{}
+	t := &tracer{d: plain, image: image, kinds: make([]byte, len(image.Data)),
+		insts: make(map[uint16]*Instruction), labels: make(map[uint16]string)}
+
+	for _, address := range image.Entries {
+		t.enter(address, "sub")
+	}
+	if image.Restarts {
+		for _, address := range restartVectors {
+			t.enter(address, "sub")
+		}
+	}
+	for _, vector := range image.Vectors {
+		if t.contains(vector, 2) {
+			offset := int(vector) - int(image.Origin)
+			t.enter(uint16(image.Data[offset+1])<<8|uint16(image.Data[offset]), "sub")
+		}
+	}
+	for len(t.queue) > 0 {
+		address := t.queue[len(t.queue)-1]
+		t.queue = t.queue[:len(t.queue)-1]
+		t.trace(address)
+	}
+	for _, vector := range image.Vectors {
+		if t.contains(vector, 2) && *t.kind(vector) == byteData && *t.kind(vector + 1) == byteData {
+			*t.kind(vector) = byteWord
+		}
+	}
+	t.markWords()
+
+	return t.listing(d.Symbols)
+}
+
+// dataLine is the most bytes in one DB line
+const dataLine = 8
+
+// listing splits the image into lines and renders them with labels
+func (t *tracer) listing(symbols *SymbolTable) *Listing {
+	l := &Listing{Origin: t.image.Origin}
+	names := func(address uint16) string {
+		if symbols != nil {
+			if name, ok := symbols.Label(address); ok {
+				return name
+			}
+		}
+		return t.labels[address]
+	}
+
+	end := int(t.image.Origin) + len(t.image.Data)
+	for a := int(t.image.Origin); a < end; {
+		address := uint16(a)
+		line := Line{Address: address, Label: names(address), Instruction: t.insts[address]}
+		n := 1
+		switch {
+		case line.Instruction != nil:
+			n = line.Instruction.Length
+		case *t.kind(address) == byteWord:
+			n, line.Word = 2, true
+		default:
+			for a+n < end && n < dataLine && *t.kind(uint16(a + n)) == byteData && names(uint16(a+n)) == "" {
+				n++
+			}
+		}
+		line.Bytes = t.image.Data[a-int(t.image.Origin) : a-int(t.image.Origin)+n]
+		l.Lines = append(l.Lines, line)
+		a += n
+	}
+
+	// Labels that start a line are defined there; the rest become equates
+	table := NewSymbolTable()
+	placed := make(map[uint16]bool)
+	for _, line := range l.Lines {
+		if line.Label != "" {
+			table.AddLabel(line.Label, line.Address)
+			placed[line.Address] = true
+		}
+	}
+	if symbols != nil {
+		for _, address := range symbols.Labels() {
+			name, _ := symbols.Label(address)
+			table.AddLabel(name, address)
+			if !placed[address] {
+				l.Equates = append(l.Equates, Equate{Name: name, Value: int(address)})
+			}
+		}
+		for port := range 256 {
+			if name, ok := symbols.Port(byte(port)); ok {
+				table.AddPort(name, byte(port))
+				l.Equates = append(l.Equates, Equate{Name: name, Value: port})
+			}
+		}
+		for offset := -128; offset < 128; offset++ {
+			if name, ok := symbols.Field(int8(offset)); ok {
+				table.AddField(name, int8(offset))
+				l.Equates = append(l.Equates, Equate{Name: name, Value: offset})
+			}
+		}
+	}
+	sort.SliceStable(l.Equates, func(i, j int) bool { return l.Equates[i].Name < l.Equates[j].Name })
+
+	l.symbols = table
+	d := &Disassembler{Symbols: table}
+	for i := range l.Lines {
+		line := &l.Lines[i]
+		switch {
+		case line.Instruction != nil && reassembles(line.Instruction):
+			inst, _ := d.DecodeAt(line.Bytes, line.Address)
+			line.Instruction, line.Text = inst, inst.Mnemonic
+		case line.Word:
+			value := uint16(line.Bytes[1])<<8 | uint16(line.Bytes[0])
+			line.Text = fmt.Sprintf("DW $%04X", value)
+			if name, ok := table.Label(value); ok {
+				line.Text = "DW " + name
+			}
+		default:
+			line.Text = dataMnemonic(line.Bytes)
+		}
+	}
+	return l
+}
+
+// reassembles reports whether the mnemonic assembles back to the same
+// bytes, which needs the canonical encoding and (IX+d) in DD CB and FD CB
+// forms, which the mnemonic leaves out when they copy to a register
+func reassembles(inst *Instruction) bool {
+	return canonical(inst) && (len(inst.Prefix) != 2 || inst.Opcode&7 == 6)
+}
+
+// dataMnemonic formats bytes as a DB directive
+func dataMnemonic(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("$%02X", b)
+	}
+	return "DB " + strings.Join(parts, ", ")
+}
+
+// Write writes the listing as assembler source: equates, ORG, and one line
+// per instruction or directive with labels on lines of their own
+func (l *Listing) Write(w io.Writer) error {
+	var b strings.Builder
+	for _, e := range l.Equates {
+		if e.Value < 0 {
+			fmt.Fprintf(&b, "%s EQU -$%02X\n", e.Name, -e.Value)
+		} else {
+			fmt.Fprintf(&b, "%s EQU $%04X\n", e.Name, e.Value)
+		}
+	}
+	if len(l.Equates) > 0 {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\tORG $%04X\n", l.Origin)
+	for _, line := range l.Lines {
+		if line.Label != "" {
+			fmt.Fprintf(&b, "%s:\n", line.Label)
+		}
+		fmt.Fprintf(&b, "\t%s\n", line.Text)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,133 @@
+// Package disasm provides tests for the Z80 disassembler implementation
+package disasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// testImage is a program at 8000 with code, data, a word and a vector
+var testImage = []byte{
+	0x21, 0x12, 0x80, // 8000 LD HL, $8012
+	0xCD, 0x0D, 0x80, // 8003 CALL 800D
+	0xED, 0x5B, 0x10, 0x80, // 8006 LD DE, (8010)
+	0x18, 0xFE, // 800A JR 800A
+	0x3E,       // 800C never reached
+	0x7E,       // 800D LD A, (HL)
+	0xC9,       // 800E RET
+	0x00,       // 800F
+	0x34, 0x12, // 8010 word
+	0x48, 0x69, // 8012 "Hi"
+	0x0D, 0x80, // 8014 vector to 800D
+}
+
+// listingText writes a listing to a string
+func listingText(t *testing.T, l *Listing) string {
+	var b strings.Builder
+	if err := l.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// TestDisassembleImage tests following control flow through an image
+func TestDisassembleImage(t *testing.T) {
+	l := New().DisassembleImage(Image{Data: testImage, Origin: 0x8000, Entries: []uint16{0x8000}, Vectors: []uint16{0x8014}})
+	want := `	ORG $8000
+sub_8000:
+	LD HL, $8012
+	CALL sub_800D
+	LD DE, (data_8010)
+loc_800A:
+	JR loc_800A
+	DB $3E
+sub_800D:
+	LD A, (HL)
+	RET
+	DB $00
+data_8010:
+	DW $1234
+	DB $48, $69
+	DW sub_800D
+`
+	if got := listingText(t, l); got != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", got, want)
+	}
+
+	var size int
+	for _, line := range l.Lines {
+		size += len(line.Bytes)
+	}
+	if size != len(testImage) {
+		t.Errorf("lines cover %d bytes, want %d", size, len(testImage))
+	}
+	if !l.Lines[0].Code() || l.Lines[4].Code() {
+		t.Errorf("code and data not separated")
+	}
+}
+
+// TestDisassembleImageSymbols tests names from a symbol table
+func TestDisassembleImageSymbols(t *testing.T) {
+	d := New()
+	d.Symbols = NewSymbolTable()
+	d.Symbols.AddLabel("bdos", 0x0005)
+	d.Symbols.AddLabel("start", 0x0100)
+	d.Symbols.AddPort("ula", 0xFE)
+	data := []byte{
+		0xCD, 0x05, 0x00, // CALL bdos
+		0xD3, 0xFE, // OUT (ula), A
+		0x10, 0xF9, // DJNZ start
+		0xC9, // RET
+	}
+	want := `bdos EQU $0005
+ula EQU $00FE
+
+	ORG $0100
+start:
+	CALL bdos
+	OUT (ula), A
+	DJNZ start
+	RET
+`
+	l := d.DisassembleImage(Image{Data: data, Origin: 0x0100, Entries: []uint16{0x0100}})
+	if got := listingText(t, l); got != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestDisassembleImageRestarts tests RST and NMI vectors as entry points
+// and instructions that would not assemble back to the same bytes
+func TestDisassembleImageRestarts(t *testing.T) {
+	data := make([]byte, 0x68)
+	data[0x00] = 0xC3 // JP 0066
+	data[0x01] = 0x66
+	data[0x38] = 0xDD // DD NOP
+	data[0x3A] = 0xED // ED 00
+	data[0x3C] = 0xDD // RLC (IX+0), B
+	data[0x3D] = 0xCB
+	data[0x3F] = 0x00
+	data[0x40] = 0xFB // EI
+	data[0x41] = 0xC9 // RET
+	data[0x66] = 0xED // RETN
+	data[0x67] = 0x45
+
+	l := New().DisassembleImage(Image{Data: data, Restarts: true})
+	lines := make(map[uint16]string)
+	for _, line := range l.Lines {
+		lines[line.Address] = line.Label + " " + line.Text
+	}
+	want := map[uint16]string{
+		0x0000: "sub_0000 JP sub_0066",
+		0x0008: "sub_0008 NOP",
+		0x0038: "sub_0038 DB $DD, $00",
+		0x003A: " DB $ED, $00",
+		0x003C: " DB $DD, $CB, $00, $00",
+		0x0040: " EI",
+		0x0066: "sub_0066 RETN",
+	}
+	for address, text := range want {
+		if lines[address] != text {
+			t.Errorf("%04X: got %q, want %q", address, lines[address], text)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OperandKind identifies what an operand refers to
+type OperandKind byte
+
+// Operand kinds
+const (
+	OperandRegister    OperandKind = iota + 1 // Register such as A, HL, IXH or AF'
+	OperandImmediate8                         // 8-bit value in Value
+	OperandImmediate16                        // 16-bit value in Value
+	OperandIndirect                           // Memory or port addressed by Register: (HL), (SP), (C)
+	OperandAbsolute                           // Memory at the address in Value: ($8000)
+	OperandPort                               // Port in Value for IN A,(n) and OUT (n),A
+	OperandIndexed                            // Memory at Register (IX or IY) plus Displacement
+	OperandRelative                           // Branch Displacement from the next instruction, target in Value with DecodeAt
+	OperandCondition                          // Condition code in Condition
+	OperandBit                                // Bit number in Value
+	OperandMode                               // Interrupt mode in Value
+	OperandRestart                            // RST target address in Value
+)
+
+// Flag bits of the F register, used by FlagsRead and FlagsWritten
+const (
+	FlagC  byte = 0x01 // Carry
+	FlagN  byte = 0x02 // Add/subtract
+	FlagPV byte = 0x04 // Parity/overflow
+	FlagX  byte = 0x08 // Undocumented bit 3
+	FlagH  byte = 0x10 // Half carry
+	FlagY  byte = 0x20 // Undocumented bit 5
+	FlagZ  byte = 0x40 // Zero
+	FlagS  byte = 0x80 // Sign
+)
+
+// Operand is one decoded operand of an instruction
+type Operand struct {
+	Kind         OperandKind
+	Register     string // Register, Indirect and Indexed operands
+	Condition    string // Condition operands: NZ, Z, NC, C, PO, PE, P or M
+	Value        uint16 // Immediate, Absolute, Port, Bit, Mode and Restart operands, Relative targets
+	Displacement int8   // Indexed and Relative operands
+}
+
+// String formats the operand the way Mnemonic does
+func (o Operand) String() string {
+	switch o.Kind {
+	case OperandRegister:
+		return o.Register
+	case OperandImmediate8:
+		return fmt.Sprintf("$%02X", o.Value)
+	case OperandImmediate16:
+		return fmt.Sprintf("$%04X", o.Value)
+	case OperandIndirect:
+		return "(" + o.Register + ")"
+	case OperandAbsolute:
+		return fmt.Sprintf("($%04X)", o.Value)
+	case OperandPort:
+		return fmt.Sprintf("($%02X)", o.Value)
+	case OperandIndexed:
+		if o.Displacement < 0 {
+			return fmt.Sprintf("(%s-$%02X)", o.Register, -int(o.Displacement))
+		}
+		return fmt.Sprintf("(%s+$%02X)", o.Register, o.Displacement)
+	case OperandRelative:
+		if o.Displacement < 0 {
+			return fmt.Sprintf("-$%02X", -int(o.Displacement))
+		}
+		return fmt.Sprintf("$%02X", o.Displacement)
+	case OperandCondition:
+		return o.Condition
+	case OperandBit, OperandMode:
+		return fmt.Sprint(o.Value)
+	case OperandRestart:
+		return fmt.Sprintf("%02XH", o.Value)
+	}
+	return "?"
+}
+
+// setOperand replaces the text of operand i in the mnemonic
+func (inst *Instruction) setOperand(i int, text string) {
+	op, rest, _ := strings.Cut(inst.Mnemonic, " ")
+	fields := strings.Split(rest, ", ")
+	if i >= len(fields) {
+		return
+	}
+	fields[i] = text
+	inst.Mnemonic = op + " " + strings.Join(fields, ", ")
+}
+
+// Operand tables indexed by the fields of the opcode
+var (
+	registers8  = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	registers16 = [4]string{"BC", "DE", "HL", "SP"}
+	stackPairs  = [4]string{"BC", "DE", "HL", "AF"}
+	conditions  = [8]string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+	aluOps      = [8]string{"ADD", "ADC", "SUB", "SBC", "AND", "XOR", "OR", "CP"}
+	rotateOps   = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SLL", "SRL"}
+	accumOps    = [8]string{"RLCA", "RRCA", "RLA", "RRA", "DAA", "CPL", "SCF", "CCF"}
+	interrupts  = [8]uint16{0, 0, 1, 2, 0, 0, 1, 2}
+	blockOps    = [4][4]string{
+		{"LDI", "CPI", "INI", "OUTI"},
+		{"LDD", "CPD", "IND", "OUTD"},
+		{"LDIR", "CPIR", "INIR", "OTIR"},
+		{"LDDR", "CPDR", "INDR", "OTDR"},
+	}
+)
+
+// conditionFlags is the flag tested by each condition code
+var conditionFlags = map[string]byte{"NZ": FlagZ, "Z": FlagZ, "NC": FlagC, "C": FlagC, "PO": FlagPV, "PE": FlagPV, "P": FlagS, "M": FlagS}
+
+// Operand constructors
+func register(name string) Operand     { return Operand{Kind: OperandRegister, Register: name} }
+func indirect(name string) Operand     { return Operand{Kind: OperandIndirect, Register: name} }
+func condition(cc string) Operand      { return Operand{Kind: OperandCondition, Condition: cc} }
+func immediate8(value byte) Operand    { return Operand{Kind: OperandImmediate8, Value: uint16(value)} }
+func immediate16(value uint16) Operand { return Operand{Kind: OperandImmediate16, Value: value} }
+func absolute(address uint16) Operand  { return Operand{Kind: OperandAbsolute, Value: address} }
+func relative(displacement byte) Operand {
+	return Operand{Kind: OperandRelative, Displacement: int8(displacement)}
+}
+
+// decoder builds the structured form of one instruction
+type decoder struct {
+	data   []byte
+	pos    int    // Next byte to consume
+	index  string // IX or IY under a DD or FD prefix, empty otherwise
+	disp   int8   // Displacement of an indexed operand
+	inst   *Instruction
+	usesHL bool // The instruction uses (IX+d), so H and L stay plain
+}
+
+// next consumes one byte
+func (d *decoder) next() byte {
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+// word consumes a little-endian word
+func (d *decoder) word() uint16 {
+	lo := d.next()
+	return uint16(d.next())<<8 | uint16(lo)
+}
+
+// pair returns a 16-bit register name, replacing HL under an index prefix
+func (d *decoder) pair(name string) string {
+	if name == "HL" && d.index != "" {
+		return d.index
+	}
+	return name
+}
+
+// reg returns an 8-bit register operand for field r. Under an index prefix
+// (HL) becomes (IX+d), consuming the displacement, and H and L become the
+// index halves unless the instruction also uses (IX+d).
+func (d *decoder) reg(r byte) Operand {
+	switch {
+	case r == 6 && d.index != "":
+		return Operand{Kind: OperandIndexed, Register: d.index, Displacement: d.disp}
+	case r == 6:
+		return indirect("HL")
+	case (r == 4 || r == 5) && d.index != "" && !d.usesHL:
+		return register(d.index + registers8[r])
+	}
+	return register(registers8[r])
+}
+
+// displacement consumes the displacement byte if field r is (HL) under an
+// index prefix
+func (d *decoder) displacement(r ...byte) {
+	for _, f := range r {
+		if f == 6 && d.index != "" {
+			d.disp = int8(d.next())
+			d.usesHL = true
+			return
+		}
+	}
+}
+
+// set fills in the operation and operands
+func (d *decoder) set(op string, operands ...Operand) {
+	d.inst.Op = op
+	d.inst.Operands = operands
+}
+
+// describe fills in the structured fields of an instruction from its bytes
+func (d *Disassembler) describe(inst *Instruction, data []byte) {
+	dec := &decoder{data: data, inst: inst}
+	inst.Op, inst.Operands, inst.Prefix = "", nil, nil
+
+	opcode := dec.next()
+	switch opcode {
+	case 0xDD, 0xFD:
+		dec.index = map[byte]string{0xDD: "IX", 0xFD: "IY"}[opcode]
+		inst.Prefix = []byte{opcode}
+		opcode = dec.next()
+		switch opcode {
+		case 0xCB:
+			inst.Prefix = append(inst.Prefix, opcode)
+			dec.disp = int8(dec.next())
+			dec.usesHL = true
+			inst.Opcode = dec.next()
+			dec.indexedCB(inst.Opcode)
+		case 0xDD, 0xED, 0xFD:
+			// The first prefix has no effect, the second starts the next instruction
+			inst.Opcode = opcode
+			dec.set("NOP")
+		default:
+			inst.Opcode = opcode
+			dec.unprefixed(opcode)
+		}
+	case 0xCB:
+		inst.Prefix = []byte{opcode}
+		inst.Opcode = dec.next()
+		dec.cb(inst.Opcode)
+	case 0xED:
+		inst.Prefix = []byte{opcode}
+		inst.Opcode = dec.next()
+		dec.ed(inst.Opcode)
+	default:
+		inst.Opcode = opcode
+		dec.unprefixed(opcode)
+	}
+	inst.Bytes = append([]byte(nil), data[:dec.pos]...)
+	inst.FlagsRead, inst.FlagsWritten = flagsOf(inst)
+	inst.Flow = flowOf(inst)
+}
+
+// unprefixed decodes the main opcode table, also used under DD and FD
+func (d *decoder) unprefixed(op byte) {
+	x, y, z := op>>6, (op>>3)&7, op&7
+	p, q := y>>1, y&1
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				d.set("NOP")
+			case 1:
+				d.set("EX", register("AF"), register("AF'"))
+			case 2:
+				d.set("DJNZ", relative(d.next()))
+			case 3:
+				d.set("JR", relative(d.next()))
+			default:
+				d.set("JR", condition(conditions[y-4]), relative(d.next()))
+			}
+		case 1:
+			if q == 0 {
+				d.set("LD", register(d.pair(registers16[p])), immediate16(d.word()))
+			} else {
+				d.set("ADD", register(d.pair("HL")), register(d.pair(registers16[p])))
+			}
+		case 2:
+			switch y {
+			case 0:
+				d.set("LD", indirect("BC"), register("A"))
+			case 1:
+				d.set("LD", register("A"), indirect("BC"))
+			case 2:
+				d.set("LD", indirect("DE"), register("A"))
+			case 3:
+				d.set("LD", register("A"), indirect("DE"))
+			case 4:
+				d.set("LD", absolute(d.word()), register(d.pair("HL")))
+			case 5:
+				d.set("LD", register(d.pair("HL")), absolute(d.word()))
+			case 6:
+				d.set("LD", absolute(d.word()), register("A"))
+			case 7:
+				d.set("LD", register("A"), absolute(d.word()))
+			}
+		case 3:
+			d.set(map[byte]string{0: "INC", 1: "DEC"}[q], register(d.pair(registers16[p])))
+		case 4, 5:
+			d.displacement(y)
+			d.set(map[byte]string{4: "INC", 5: "DEC"}[z], d.reg(y))
+		case 6:
+			d.displacement(y)
+			target := d.reg(y)
+			d.set("LD", target, immediate8(d.next()))
+		case 7:
+			d.set(accumOps[y])
+		}
+	case 1:
+		if y == 6 && z == 6 {
+			d.set("HALT")
+			return
+		}
+		d.displacement(y, z)
+		d.set("LD", d.reg(y), d.reg(z))
+	case 2:
+		d.displacement(z)
+		d.alu(y, d.reg(z))
+	case 3:
+		d.unprefixedX3(y, z, p, q)
+	}
+}
+
+// alu sets an arithmetic or logic operation on the accumulator
+func (d *decoder) alu(y byte, operand Operand) {
+	switch op := aluOps[y]; op {
+	case "ADD", "ADC", "SBC":
+		d.set(op, register("A"), operand)
+	default:
+		d.set(op, operand)
+	}
+}
+
+// unprefixedX3 decodes the last quarter of the main opcode table
+func (d *decoder) unprefixedX3(y, z, p, q byte) {
+	switch z {
+	case 0:
+		d.set("RET", condition(conditions[y]))
+	case 1:
+		switch {
+		case q == 0:
+			d.set("POP", register(d.pair(stackPairs[p])))
+		case p == 0:
+			d.set("RET")
+		case p == 1:
+			d.set("EXX")
+		case p == 2:
+			d.set("JP", indirect(d.pair("HL")))
+		default:
+			d.set("LD", register("SP"), register(d.pair("HL")))
+		}
+	case 2:
+		d.set("JP", condition(conditions[y]), immediate16(d.word()))
+	case 3:
+		switch y {
+		case 0:
+			d.set("JP", immediate16(d.word()))
+		case 2:
+			d.set("OUT", Operand{Kind: OperandPort, Value: uint16(d.next())}, register("A"))
+		case 3:
+			d.set("IN", register("A"), Operand{Kind: OperandPort, Value: uint16(d.next())})
+		case 4:
+			d.set("EX", indirect("SP"), register(d.pair("HL")))
+		case 5:
+			d.set("EX", register("DE"), register("HL"))
+		case 6:
+			d.set("DI")
+		case 7:
+			d.set("EI")
+		}
+	case 4:
+		d.set("CALL", condition(conditions[y]), immediate16(d.word()))
+	case 5:
+		if q == 0 {
+			d.set("PUSH", register(d.pair(stackPairs[p])))
+		} else {
+			d.set("CALL", immediate16(d.word()))
+		}
+	case 6:
+		d.alu(y, immediate8(d.next()))
+	case 7:
+		d.set("RST", Operand{Kind: OperandRestart, Value: uint16(y) * 8})
+	}
+}
+
+// cb decodes the CB table of rotates, shifts and bit operations
+func (d *decoder) cb(op byte) {
+	x, y, z := op>>6, (op>>3)&7, op&7
+	switch x {
+	case 0:
+		d.set(rotateOps[y], d.reg(z))
+	default:
+		d.set(map[byte]string{1: "BIT", 2: "RES", 3: "SET"}[x], Operand{Kind: OperandBit, Value: uint16(y)}, d.reg(z))
+	}
+}
+
+// indexedCB decodes DD CB d op and FD CB d op. Every form works on (IX+d);
+// the undocumented forms with a register also copy the result into it.
+func (d *decoder) indexedCB(op byte) {
+	x, y, z := op>>6, (op>>3)&7, op&7
+	memory := d.reg(6)
+	bit := Operand{Kind: OperandBit, Value: uint16(y)}
+	switch {
+	case x == 0 && z == 6:
+		d.set(rotateOps[y], memory)
+	case x == 0:
+		d.set(rotateOps[y], memory, register(registers8[z]))
+	case x == 1:
+		d.set("BIT", bit, memory)
+	case z == 6:
+		d.set(map[byte]string{2: "RES", 3: "SET"}[x], bit, memory)
+	default:
+		d.set(map[byte]string{2: "RES", 3: "SET"}[x], bit, memory, register(registers8[z]))
+	}
+}
+
+// ed decodes the ED table. Opcodes without an instruction act as NOP.
+func (d *decoder) ed(op byte) {
+	x, y, z := op>>6, (op>>3)&7, op&7
+	p, q := y>>1, y&1
+	switch {
+	case x == 1:
+		switch z {
+		case 0:
+			if y == 6 {
+				d.set("IN", register("F"), indirect("C"))
+			} else {
+				d.set("IN", register(registers8[y]), indirect("C"))
+			}
+		case 1:
+			if y == 6 {
+				d.set("OUT", indirect("C"), Operand{Kind: OperandImmediate8})
+			} else {
+				d.set("OUT", indirect("C"), register(registers8[y]))
+			}
+		case 2:
+			d.set(map[byte]string{0: "SBC", 1: "ADC"}[q], register("HL"), register(registers16[p]))
+		case 3:
+			if q == 0 {
+				d.set("LD", absolute(d.word()), register(registers16[p]))
+			} else {
+				d.set("LD", register(registers16[p]), absolute(d.word()))
+			}
+		case 4:
+			d.set("NEG")
+		case 5:
+			if y == 1 {
+				d.set("RETI")
+			} else {
+				d.set("RETN")
+			}
+		case 6:
+			d.set("IM", Operand{Kind: OperandMode, Value: interrupts[y]})
+		case 7:
+			switch y {
+			case 0:
+				d.set("LD", register("I"), register("A"))
+			case 1:
+				d.set("LD", register("R"), register("A"))
+			case 2:
+				d.set("LD", register("A"), register("I"))
+			case 3:
+				d.set("LD", register("A"), register("R"))
+			case 4:
+				d.set("RRD")
+			case 5:
+				d.set("RLD")
+			default:
+				d.set("NOP")
+			}
+		}
+	case x == 2 && z <= 3 && y >= 4:
+		d.set(blockOps[y-4][z])
+	default:
+		d.set("NOP")
+	}
+}
+
+// Flags written by groups of operations
+const (
+	allFlags     = 0xFF
+	flagsExceptC = allFlags &^ FlagC                      // 8-bit INC and DEC, BIT, CPI, IN r,(C)
+	rotateFlags  = FlagY | FlagH | FlagX | FlagN | FlagC  // RLCA, ADD HL,rr, SCF
+	blockFlags   = FlagY | FlagH | FlagX | FlagPV | FlagN // LDI and friends
+)
+
+// flagsWritten maps operations to the flags they change, where that does not
+// depend on the operands
+var flagsWritten = map[string]byte{
+	"ADC": allFlags, "SUB": allFlags, "SBC": allFlags, "AND": allFlags, "XOR": allFlags, "OR": allFlags, "CP": allFlags, "NEG": allFlags,
+	"INC": flagsExceptC, "DEC": flagsExceptC, "BIT": flagsExceptC, "RLD": flagsExceptC, "RRD": flagsExceptC,
+	"CPI": flagsExceptC, "CPD": flagsExceptC, "CPIR": flagsExceptC, "CPDR": flagsExceptC,
+	"INI": allFlags, "IND": allFlags, "INIR": allFlags, "INDR": allFlags,
+	"OUTI": allFlags, "OUTD": allFlags, "OTIR": allFlags, "OTDR": allFlags,
+	"RLC": allFlags, "RRC": allFlags, "RL": allFlags, "RR": allFlags, "SLA": allFlags, "SRA": allFlags, "SLL": allFlags, "SRL": allFlags,
+	"RLCA": rotateFlags, "RRCA": rotateFlags, "RLA": rotateFlags, "RRA": rotateFlags, "SCF": rotateFlags, "CCF": rotateFlags,
+	"CPL": FlagY | FlagH | FlagX | FlagN,
+	"DAA": allFlags &^ FlagN,
+	"LDI": blockFlags, "LDD": blockFlags, "LDIR": blockFlags, "LDDR": blockFlags,
+}
+
+// flagsRead maps operations to the flags they use, apart from conditions
+var flagsRead = map[string]byte{
+	"ADC": FlagC, "SBC": FlagC, "RL": FlagC, "RR": FlagC, "RLA": FlagC, "RRA": FlagC, "CCF": FlagC,
+	"DAA": FlagH | FlagN | FlagC,
+}
+
+// flagsOf returns the flags an instruction reads and writes
+func flagsOf(inst *Instruction) (read, written byte) {
+	read, written = flagsRead[inst.Op], flagsWritten[inst.Op]
+	ops := inst.Operands
+	switch inst.Op {
+	case "ADD":
+		written = allFlags
+		if ops[0].Register != "A" {
+			written = rotateFlags // ADD HL,rr
+		}
+	case "INC", "DEC":
+		if ops[0].Kind == OperandRegister && len(ops[0].Register) == 2 {
+			written = 0 // 16-bit registers: BC, DE, HL, SP, IX and IY
+		}
+	case "LD":
+		if ops[0].Register == "A" && (ops[1].Register == "I" || ops[1].Register == "R") {
+			written = flagsExceptC
+		}
+	case "IN":
+		if ops[1].Kind == OperandIndirect {
+			written = flagsExceptC
+		}
+	case "PUSH":
+		if ops[0].Register == "AF" {
+			read = allFlags
+		}
+	case "POP":
+		if ops[0].Register == "AF" {
+			written = allFlags
+		}
+	case "EX":
+		if ops[0].Register == "AF" {
+			read, written = allFlags, allFlags
+		}
+	}
+	if len(ops) > 0 && ops[0].Kind == OperandCondition {
+		read |= conditionFlags[ops[0].Condition]
+	}
+	return read, written
+}
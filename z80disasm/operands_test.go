@@ -0,0 +1,153 @@
+// Package disasm provides tests for the Z80 disassembler implementation
+package disasm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// format renders the structured form of an instruction in Mnemonic syntax
+func format(inst *Instruction) string {
+	if len(inst.Operands) == 0 {
+		return inst.Op
+	}
+	operands := make([]string, len(inst.Operands))
+	for i, o := range inst.Operands {
+		operands[i] = o.String()
+	}
+	return inst.Op + " " + strings.Join(operands, ", ")
+}
+
+// TestOperandsMatchMnemonic decodes every opcode and checks that the
+// structured form renders to the mnemonic and covers exactly Length bytes
+func TestOperandsMatchMnemonic(t *testing.T) {
+	d := New()
+	var sequences [][]byte
+	for op := range 256 {
+		sequences = append(sequences, []byte{byte(op), 0x34, 0x12, 0x56})
+		for _, prefix := range []byte{0xCB, 0xED, 0xDD, 0xFD} {
+			sequences = append(sequences, []byte{prefix, byte(op), 0x34, 0x12})
+		}
+		sequences = append(sequences, []byte{0xDD, 0xCB, 0xFB, byte(op)}, []byte{0xFD, 0xCB, 0x05, byte(op)})
+	}
+
+	for _, data := range sequences {
+		inst, err := d.Decode(data)
+		if err != nil {
+			t.Fatalf("% X: %v", data, err)
+		}
+		// Decoding only the instruction's own bytes must give the same result
+		short, err := d.Decode(data[:inst.Length])
+		if err != nil {
+			t.Fatalf("% X: %v", data[:inst.Length], err)
+		}
+		got := format(short)
+		if len(short.Bytes) != inst.Length {
+			t.Errorf("% X: %d bytes, want %d", data, len(short.Bytes), inst.Length)
+		}
+
+		want := inst.Mnemonic
+		switch {
+		case strings.HasPrefix(want, "ED $"), strings.HasPrefix(want, "PREFIX "):
+			// Opcodes without an instruction act as NOP
+			want = "NOP"
+		case want == "OUT (C), 0":
+			want = "OUT (C), $00"
+		case data[0]&0xDF == 0xDD && data[1] == 0xCB && data[3]&7 != 6:
+			// The mnemonic leaves out (IX+d) for the undocumented register forms
+			if !strings.HasPrefix(got, strings.Fields(want)[0]+" ") || !strings.Contains(got, "(I") {
+				t.Errorf("% X: got %q for %q", data, got, want)
+			}
+			continue
+		}
+		if got != want {
+			t.Errorf("% X: got %q, want %q", data, got, want)
+		}
+	}
+}
+
+// TestOperands tests the structured form of some instructions
+func TestOperands(t *testing.T) {
+	d := New()
+	tests := []struct {
+		data     []byte
+		op       string
+		operands []Operand
+		prefix   []byte
+		opcode   byte
+	}{
+		{[]byte{0x00}, "NOP", nil, nil, 0x00},
+		{[]byte{0x3E, 0x12}, "LD", []Operand{{Kind: OperandRegister, Register: "A"}, {Kind: OperandImmediate8, Value: 0x12}}, nil, 0x3E},
+		{[]byte{0x2A, 0x34, 0x12}, "LD", []Operand{{Kind: OperandRegister, Register: "HL"}, {Kind: OperandAbsolute, Value: 0x1234}}, nil, 0x2A},
+		{[]byte{0x20, 0xFE}, "JR", []Operand{{Kind: OperandCondition, Condition: "NZ"}, {Kind: OperandRelative, Displacement: -2}}, nil, 0x20},
+		{[]byte{0xDB, 0xFE}, "IN", []Operand{{Kind: OperandRegister, Register: "A"}, {Kind: OperandPort, Value: 0xFE}}, nil, 0xDB},
+		{[]byte{0xFF}, "RST", []Operand{{Kind: OperandRestart, Value: 0x38}}, nil, 0xFF},
+		{[]byte{0xDD, 0x74, 0xFB}, "LD", []Operand{{Kind: OperandIndexed, Register: "IX", Displacement: -5}, {Kind: OperandRegister, Register: "H"}}, []byte{0xDD}, 0x74},
+		{[]byte{0xFD, 0x65}, "LD", []Operand{{Kind: OperandRegister, Register: "IYH"}, {Kind: OperandRegister, Register: "IYL"}}, []byte{0xFD}, 0x65},
+		{[]byte{0xFD, 0x36, 0x02, 0x12}, "LD", []Operand{{Kind: OperandIndexed, Register: "IY", Displacement: 2}, {Kind: OperandImmediate8, Value: 0x12}}, []byte{0xFD}, 0x36},
+		{[]byte{0xCB, 0x7E}, "BIT", []Operand{{Kind: OperandBit, Value: 7}, {Kind: OperandIndirect, Register: "HL"}}, []byte{0xCB}, 0x7E},
+		{[]byte{0xDD, 0xCB, 0x03, 0x00}, "RLC", []Operand{{Kind: OperandIndexed, Register: "IX", Displacement: 3}, {Kind: OperandRegister, Register: "B"}}, []byte{0xDD, 0xCB}, 0x00},
+		{[]byte{0xED, 0x5E}, "IM", []Operand{{Kind: OperandMode, Value: 2}}, []byte{0xED}, 0x5E},
+		{[]byte{0xED, 0x71}, "OUT", []Operand{{Kind: OperandIndirect, Register: "C"}, {Kind: OperandImmediate8}}, []byte{0xED}, 0x71},
+		{[]byte{0xED, 0x00}, "NOP", nil, []byte{0xED}, 0x00},
+		{[]byte{0xDD, 0x00}, "NOP", nil, []byte{0xDD}, 0x00},
+	}
+	for _, tt := range tests {
+		inst, err := d.Decode(tt.data)
+		if err != nil {
+			t.Fatalf("% X: %v", tt.data, err)
+		}
+		got := fmt.Sprintf("%s %v % X %02X", inst.Op, inst.Operands, inst.Prefix, inst.Opcode)
+		want := fmt.Sprintf("%s %v % X %02X", tt.op, tt.operands, tt.prefix, tt.opcode)
+		if got != want {
+			t.Errorf("% X: got %s, want %s", tt.data, got, want)
+		}
+	}
+}
+
+// TestFlags tests the flags read and written by some instructions
+func TestFlags(t *testing.T) {
+	d := New()
+	tests := []struct {
+		name          string
+		data          []byte
+		read, written byte
+	}{
+		{"LD A, B", []byte{0x78}, 0, 0},
+		{"ADD A, B", []byte{0x80}, 0, 0xFF},
+		{"ADC A, B", []byte{0x88}, FlagC, 0xFF},
+		{"INC A", []byte{0x3C}, 0, 0xFF &^ FlagC},
+		{"INC HL", []byte{0x23}, 0, 0},
+		{"DEC IX", []byte{0xDD, 0x2B}, 0, 0},
+		{"INC IXH", []byte{0xDD, 0x24}, 0, 0xFF &^ FlagC},
+		{"ADD HL, DE", []byte{0x19}, 0, FlagY | FlagH | FlagX | FlagN | FlagC},
+		{"SBC HL, DE", []byte{0xED, 0x52}, FlagC, 0xFF},
+		{"JP NZ, nn", []byte{0xC2, 0x00, 0x80}, FlagZ, 0},
+		{"RET PE", []byte{0xE8}, FlagPV, 0},
+		{"CALL M, nn", []byte{0xFC, 0x00, 0x80}, FlagS, 0},
+		{"JR C, d", []byte{0x38, 0x00}, FlagC, 0},
+		{"DJNZ d", []byte{0x10, 0x00}, 0, 0},
+		{"PUSH AF", []byte{0xF5}, 0xFF, 0},
+		{"POP AF", []byte{0xF1}, 0, 0xFF},
+		{"EX AF, AF'", []byte{0x08}, 0xFF, 0xFF},
+		{"DAA", []byte{0x27}, FlagH | FlagN | FlagC, 0xFF &^ FlagN},
+		{"RLA", []byte{0x17}, FlagC, FlagY | FlagH | FlagX | FlagN | FlagC},
+		{"BIT 0, B", []byte{0xCB, 0x40}, 0, 0xFF &^ FlagC},
+		{"SET 0, B", []byte{0xCB, 0xC0}, 0, 0},
+		{"LDIR", []byte{0xED, 0xB0}, 0, FlagY | FlagH | FlagX | FlagPV | FlagN},
+		{"LD A, I", []byte{0xED, 0x57}, 0, 0xFF &^ FlagC},
+		{"IN B, (C)", []byte{0xED, 0x40}, 0, 0xFF &^ FlagC},
+		{"IN A, (n)", []byte{0xDB, 0xFE}, 0, 0},
+	}
+	for _, tt := range tests {
+		inst, err := d.Decode(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if inst.FlagsRead != tt.read || inst.FlagsWritten != tt.written {
+			t.Errorf("%s: got read %02X written %02X, want read %02X written %02X",
+				tt.name, inst.FlagsRead, inst.FlagsWritten, tt.read, tt.written)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SymbolTable names addresses, I/O ports and index register displacements
+// so the disassembler can show labels instead of numbers
+type SymbolTable struct {
+	labels    map[uint16]string
+	addresses map[string]uint16
+	ports     map[byte]string
+	fields    map[int8]string
+}
+
+// NewSymbolTable creates an empty symbol table
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		labels:    make(map[uint16]string),
+		addresses: make(map[string]uint16),
+		ports:     make(map[byte]string),
+		fields:    make(map[int8]string),
+	}
+}
+
+// AddLabel names an address. The first name given to an address is the one
+// shown; later names can still be looked up with Address.
+func (s *SymbolTable) AddLabel(name string, address uint16) {
+	if _, ok := s.labels[address]; !ok {
+		s.labels[address] = name
+	}
+	s.addresses[name] = address
+}
+
+// AddPort names an I/O port used by IN A,(n) and OUT (n),A
+func (s *SymbolTable) AddPort(name string, port byte) {
+	s.ports[port] = name
+}
+
+// AddField names an (IX+d) or (IY+d) displacement, such as a structure field
+func (s *SymbolTable) AddField(name string, offset int8) {
+	s.fields[offset] = name
+}
+
+// Label returns the name of an address
+func (s *SymbolTable) Label(address uint16) (string, bool) {
+	name, ok := s.labels[address]
+	return name, ok
+}
+
+// Address returns the address of a label
+func (s *SymbolTable) Address(name string) (uint16, bool) {
+	address, ok := s.addresses[name]
+	return address, ok
+}
+
+// Port returns the name of a port
+func (s *SymbolTable) Port(port byte) (string, bool) {
+	name, ok := s.ports[port]
+	return name, ok
+}
+
+// Field returns the name of a displacement
+func (s *SymbolTable) Field(offset int8) (string, bool) {
+	name, ok := s.fields[offset]
+	return name, ok
+}
+
+// Labels returns the labelled addresses in ascending order
+func (s *SymbolTable) Labels() []uint16 {
+	addresses := make([]uint16, 0, len(s.labels))
+	for address := range s.labels {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
+
+// Symbol file line formats
+var (
+	// z88dk .map: _main = $8000 ; addr, public, , main_c, code_compiler, main.c:5
+	z88dkLine = regexp.MustCompile(`^([^\s=]+)\s*=\s*\$([0-9A-Fa-f]+)\s*;\s*(\w+)`)
+	// sjasmplus .sym: main: EQU 0x00008000
+	sjasmLine = regexp.MustCompile(`^([^\s:]+):?\s+(?i:equ)\s+(\S+)$`)
+	// Simple: [port|field] main = $8000
+	simpleLine = regexp.MustCompile(`^(?:(port|field)\s+)?([^\s=]+)\s*=\s*(\S+)$`)
+)
+
+// LoadFile reads symbols from a file, see Load
+func (s *SymbolTable) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.Load(f); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// Load reads symbols in any of these formats, one per line:
+//
+//	_main = $8000 ; addr, public, , main_c, code_compiler, main.c:5   (z88dk .map)
+//	main: EQU 0x00008000                                             (sjasmplus .sym)
+//	main = $8000                                                     (simple)
+//	port ula = $FE
+//	field sprite.x = 2
+//
+// z88dk constants are skipped, since they are not addresses. Blank lines and
+// lines starting with ; or # are ignored.
+func (s *SymbolTable) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		if err := s.loadLine(strings.TrimSpace(scanner.Text())); err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// loadLine adds the symbol on one line of a symbol file
+func (s *SymbolTable) loadLine(line string) error {
+	if line == "" || line[0] == ';' || line[0] == '#' {
+		return nil
+	}
+	if m := z88dkLine.FindStringSubmatch(line); m != nil {
+		if m[3] != "addr" {
+			return nil
+		}
+		value, err := strconv.ParseUint(m[2], 16, 16)
+		if err != nil {
+			return fmt.Errorf("invalid address %q", m[2])
+		}
+		s.AddLabel(m[1], uint16(value))
+		return nil
+	}
+	if m := sjasmLine.FindStringSubmatch(line); m != nil {
+		value, err := parseSymbolValue(m[2])
+		if err != nil {
+			return err
+		}
+		s.AddLabel(m[1], uint16(value))
+		return nil
+	}
+	m := simpleLine.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("unrecognized symbol %q", line)
+	}
+	value, err := parseSymbolValue(m[3])
+	if err != nil {
+		return err
+	}
+	switch m[1] {
+	case "port":
+		if value < 0 || value > 0xFF {
+			return fmt.Errorf("port %q out of range", m[3])
+		}
+		s.AddPort(m[2], byte(value))
+	case "field":
+		if value < -128 || value > 127 {
+			return fmt.Errorf("field offset %q out of range", m[3])
+		}
+		s.AddField(m[2], int8(value))
+	default:
+		s.AddLabel(m[2], uint16(value))
+	}
+	return nil
+}
+
+// parseSymbolValue parses $FF, 0xFF, 0FFh, -2 and 255 style numbers. Values
+// wider than 16 bits, as sjasmplus writes them, keep their low 16 bits.
+func parseSymbolValue(text string) (int64, error) {
+	digits, base := text, 10
+	negative := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(digits, "-")
+	switch lower := strings.ToLower(digits); {
+	case strings.HasPrefix(lower, "$"):
+		digits, base = digits[1:], 16
+	case strings.HasPrefix(lower, "0x"):
+		digits, base = digits[2:], 16
+	case strings.HasSuffix(lower, "h"):
+		digits, base = digits[:len(digits)-1], 16
+	}
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	if negative {
+		return -value, nil
+	}
+	if value > 0xFFFF {
+		value &= 0xFFFF
+	}
+	return value, nil
+}
+
+// Operand patterns rewritten with symbols
+var (
+	indexedOperand = regexp.MustCompile(`\((I[XY])([+-])\$([0-9A-F]{2})\)`)
+	portOperand    = regexp.MustCompile(`\(\$([0-9A-F]{2})\)`)
+)
+
+// symbolize replaces numbers in the mnemonic with names from the symbol table
+func (d *Disassembler) symbolize(inst *Instruction) {
+	s := d.Symbols
+	for i, o := range inst.Operands {
+		name, ok := s.Label(o.Value)
+		if !ok {
+			continue
+		}
+		switch {
+		case o.Kind == OperandImmediate16, o.Kind == OperandRelative && inst.located:
+			inst.setOperand(i, name)
+		case o.Kind == OperandAbsolute:
+			inst.setOperand(i, "("+name+")")
+		}
+	}
+	inst.Mnemonic = indexedOperand.ReplaceAllStringFunc(inst.Mnemonic, func(operand string) string {
+		m := indexedOperand.FindStringSubmatch(operand)
+		offset, _ := strconv.ParseInt(m[3], 16, 16)
+		if m[2] == "-" {
+			offset = -offset
+		}
+		if name, ok := s.Field(int8(offset)); ok {
+			return "(" + m[1] + "+" + name + ")"
+		}
+		return operand
+	})
+	if strings.HasPrefix(inst.Mnemonic, "IN ") || strings.HasPrefix(inst.Mnemonic, "OUT ") {
+		inst.Mnemonic = portOperand.ReplaceAllStringFunc(inst.Mnemonic, func(operand string) string {
+			port, _ := strconv.ParseUint(operand[2:4], 16, 8)
+			if name, ok := s.Port(byte(port)); ok {
+				return "(" + name + ")"
+			}
+			return operand
+		})
+	}
+}
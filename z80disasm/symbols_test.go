@@ -0,0 +1,120 @@
+// Package disasm provides tests for the Z80 disassembler implementation
+package disasm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSymbols is a symbol file mixing every supported format
+const testSymbols = `; symbols for the tests
+_main                           = $8000 ; addr, public, , main_c, code_compiler, main.c:5
+_MAX                            = $0005 ; const, public, , main_c, , main.c:1
+print: EQU 0x00001234
+buffer: equ $9000
+counter = 0A000h
+# ports and structure fields
+port ula = $FE
+field sprite.x = 2
+field sprite.prev = -1
+`
+
+// TestLoadSymbols tests reading symbol files in all formats
+func TestLoadSymbols(t *testing.T) {
+	s := NewSymbolTable()
+	if err := s.Load(strings.NewReader(testSymbols)); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	labels := map[string]uint16{"_main": 0x8000, "print": 0x1234, "buffer": 0x9000, "counter": 0xA000}
+	for name, want := range labels {
+		if got, ok := s.Address(name); !ok || got != want {
+			t.Errorf("address of %s: got 0x%04X, %v, want 0x%04X", name, got, ok, want)
+		}
+	}
+	if _, ok := s.Address("_MAX"); ok {
+		t.Errorf("z88dk constant loaded as a label")
+	}
+	if got := len(s.Labels()); got != len(labels) {
+		t.Errorf("label count: got %d, want %d", got, len(labels))
+	}
+	if name, _ := s.Port(0xFE); name != "ula" {
+		t.Errorf("port name: got %q", name)
+	}
+	if name, _ := s.Field(-1); name != "sprite.prev" {
+		t.Errorf("field name: got %q", name)
+	}
+}
+
+// TestLoadSymbolErrors tests that bad lines report their line number
+func TestLoadSymbolErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"main = $8000\nwhat is this", "line 2: unrecognized symbol"},
+		{"main = $80G0", "line 1: invalid value"},
+		{"port ula = $1FE", "line 1: port"},
+		{"field x = 200", "line 1: field offset"},
+	}
+	for _, tt := range tests {
+		err := NewSymbolTable().Load(strings.NewReader(tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %q", tt.text, err, tt.want)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "test.sym")
+	os.WriteFile(filename, []byte("main: EQU 0x8000\nbad\n"), 0o644)
+	err := NewSymbolTable().LoadFile(filename)
+	if err == nil || !strings.HasPrefix(err.Error(), filename+": line 2:") {
+		t.Errorf("file error: got %v", err)
+	}
+}
+
+// TestDecodeWithSymbols tests that operands are shown as names
+func TestDecodeWithSymbols(t *testing.T) {
+	d := New()
+	d.Symbols = NewSymbolTable()
+	if err := d.Symbols.Load(strings.NewReader(testSymbols)); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	d.Symbols.AddLabel("top", 0xFFFF)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"CALL nn", []byte{0xCD, 0x34, 0x12}, "CALL print"},
+		{"JP cc, nn", []byte{0xC2, 0x00, 0x80}, "JP NZ, _main"},
+		{"LD HL, nn", []byte{0x21, 0x00, 0x90}, "LD HL, buffer"},
+		{"LD (nn), A", []byte{0x32, 0x00, 0xA0}, "LD (counter), A"},
+		{"LD (nn), HL unknown", []byte{0x22, 0x01, 0xA0}, "LD ($A001), HL"},
+		{"LD A, n", []byte{0x3E, 0x05}, "LD A, $05"},
+		{"OUT (n), A", []byte{0xD3, 0xFE}, "OUT (ula), A"},
+		{"IN A, (n)", []byte{0xDB, 0xFE}, "IN A, (ula)"},
+		{"IN A, (n) unknown", []byte{0xDB, 0x1F}, "IN A, ($1F)"},
+		{"LD A, (IX+d)", []byte{0xDD, 0x7E, 0x02}, "LD A, (IX+sprite.x)"},
+		{"LD (IY-d), n", []byte{0xFD, 0x36, 0xFF, 0x02}, "LD (IY+sprite.prev), $02"},
+		{"BIT b, (IX+d)", []byte{0xDD, 0xCB, 0x02, 0x46}, "BIT 0, (IX+sprite.x)"},
+		{"LD A, (IX+d) unknown", []byte{0xDD, 0x7E, 0x03}, "LD A, (IX+$03)"},
+		{"LD IX, nn", []byte{0xDD, 0x21, 0x00, 0x80}, "LD IX, _main"},
+		{"LD BC, (nn)", []byte{0xED, 0x4B, 0x34, 0x12}, "LD BC, (print)"},
+		{"JP nn at FFFF", []byte{0xC3, 0xFF, 0xFF}, "JP top"},
+		{"LD (nn), A at FFFF", []byte{0x32, 0xFF, 0xFF}, "LD (top), A"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := d.Decode(tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Mnemonic != tt.expected {
+				t.Errorf("mnemonic mismatch: got %q, want %q", result.Mnemonic, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+	"github.com/kiltum/emuz80/z80debugger"
+)
+
+// runSlice is the number of T-states run between checks for a stop
+const runSlice = 100000
+
+// Stop replies: SIGTRAP for breakpoints and steps, SIGINT for Ctrl-C
+const (
+	stopTrap      = "T05"
+	stopInterrupt = "T02"
+)
+
+// registerNames lists the registers in GDB z80 order, each 16 bits wide
+var registerNames = []string{"af", "bc", "de", "hl", "sp", "pc", "ix", "iy", "af'", "bc'", "de'", "hl'", "ir"}
+
+// targetXML describes the registers to GDB
+var targetXML = func() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+<architecture>z80</architecture>
+<feature name="org.gnu.gdb.z80.cpu">
+`)
+	for i, name := range registerNames {
+		kind := "int"
+		switch name {
+		case "sp":
+			kind = "data_ptr"
+		case "pc":
+			kind = "code_ptr"
+		}
+		fmt.Fprintf(&sb, "<reg name=\"%s\" bitsize=\"16\" type=\"%s\" regnum=\"%d\"/>\n", name, kind, i)
+	}
+	sb.WriteString("</feature>\n</target>\n")
+	return sb.String()
+}()
+
+// register returns a register by its GDB number
+func register(cpu *z80.CPU, n int) uint16 {
+	switch n {
+	case 0:
+		return cpu.GetAF()
+	case 1:
+		return cpu.GetBC()
+	case 2:
+		return cpu.GetDE()
+	case 3:
+		return cpu.GetHL()
+	case 4:
+		return cpu.SP
+	case 5:
+		return cpu.PC
+	case 6:
+		return cpu.IX
+	case 7:
+		return cpu.IY
+	case 8:
+		return cpu.GetAF_()
+	case 9:
+		return cpu.GetBC_()
+	case 10:
+		return cpu.GetDE_()
+	case 11:
+		return cpu.GetHL_()
+	default:
+		return uint16(cpu.I)<<8 | uint16(cpu.R)
+	}
+}
+
+// setRegister assigns a register by its GDB number
+func setRegister(cpu *z80.CPU, n int, value uint16) {
+	switch n {
+	case 0:
+		cpu.SetAF(value)
+	case 1:
+		cpu.SetBC(value)
+	case 2:
+		cpu.SetDE(value)
+	case 3:
+		cpu.SetHL(value)
+	case 4:
+		cpu.SP = value
+	case 5:
+		cpu.PC = value
+	case 6:
+		cpu.IX = value
+	case 7:
+		cpu.IY = value
+	case 8:
+		cpu.SetAF_(value)
+	case 9:
+		cpu.SetBC_(value)
+	case 10:
+		cpu.SetDE_(value)
+	case 11:
+		cpu.SetHL_(value)
+	default:
+		cpu.I, cpu.R = byte(value>>8), byte(value)
+	}
+}
+
+// encodeWord formats a register in target (little-endian) byte order
+func encodeWord(value uint16) string {
+	return fmt.Sprintf("%02x%02x", byte(value), byte(value>>8))
+}
+
+// decodeWord parses a register in target byte order
+func decodeWord(text string) (uint16, error) {
+	b, err := hex.DecodeString(text)
+	if err != nil || len(b) != 2 {
+		return 0, fmt.Errorf("invalid register value %q", text)
+	}
+	return uint16(b[0]) | uint16(b[1])<<8, nil
+}
+
+// parseHex parses a hex number of at most bits bits
+func parseHex(text string, bits int) (int, error) {
+	value, err := strconv.ParseUint(text, 16, bits)
+	return int(value), err
+}
+
+// handle answers one packet. done ends the session after the reply.
+func (se *session) handle(data string) (reply string, done bool) {
+	if data == "" {
+		return "", false
+	}
+	args := data[1:]
+	switch data[0] {
+	case '?':
+		if se.stop == "" {
+			return stopTrap, false
+		}
+		return se.stop, false
+	case 'g':
+		var sb strings.Builder
+		for n := range registerNames {
+			sb.WriteString(encodeWord(register(se.cpu, n)))
+		}
+		return sb.String(), false
+	case 'G':
+		return se.writeRegisters(args), false
+	case 'p':
+		n, err := parseHex(args, 8)
+		if err != nil || n >= len(registerNames) {
+			return "E01", false
+		}
+		return encodeWord(register(se.cpu, n)), false
+	case 'P':
+		return se.writeRegister(args), false
+	case 'm':
+		return se.readMemory(args), false
+	case 'M':
+		return se.writeMemory(args, false), false
+	case 'X':
+		return se.writeMemory(args, true), false
+	case 'c':
+		if err := se.setPC(args); err != nil {
+			return "E01", false
+		}
+		return se.resume(), false
+	case 's':
+		if err := se.setPC(args); err != nil {
+			return "E01", false
+		}
+		return se.step(), false
+	case 'Z', 'z':
+		return se.breakpoint(data[0] == 'Z', args), false
+	case 'b':
+		return se.reverse(args), false
+	case 'H', 'T':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		return "OK", true
+	case 'q':
+		return se.query(args), false
+	case 'Q':
+		if args == "StartNoAckMode" {
+			se.noAck = true
+			return "OK", false
+		}
+	case 'v':
+		return se.verbose(args)
+	}
+	return "", false
+}
+
+// writeRegisters handles G, which sets every register
+func (se *session) writeRegisters(args string) string {
+	if len(args) != len(registerNames)*4 {
+		return "E01"
+	}
+	values := make([]uint16, len(registerNames))
+	for n := range values {
+		value, err := decodeWord(args[n*4 : n*4+4])
+		if err != nil {
+			return "E01"
+		}
+		values[n] = value
+	}
+	for n, value := range values {
+		setRegister(se.cpu, n, value)
+	}
+	return "OK"
+}
+
+// writeRegister handles P n=value
+func (se *session) writeRegister(args string) string {
+	number, text, ok := strings.Cut(args, "=")
+	if !ok {
+		return "E01"
+	}
+	n, err := parseHex(number, 8)
+	if err != nil || n >= len(registerNames) {
+		return "E01"
+	}
+	value, err := decodeWord(text)
+	if err != nil {
+		return "E01"
+	}
+	setRegister(se.cpu, n, value)
+	return "OK"
+}
+
+// parseAddressLength parses the addr,length part of m, M, X and Z packets
+func parseAddressLength(text string) (uint16, int, error) {
+	addr, length, ok := strings.Cut(text, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("missing length in %q", text)
+	}
+	address, err := parseHex(addr, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := parseHex(length, 32)
+	if err != nil || n > 0x10000 {
+		return 0, 0, fmt.Errorf("invalid length %q", length)
+	}
+	return uint16(address), n, nil
+}
+
+// readMemory handles m addr,length
+func (se *session) readMemory(args string) string {
+	address, length, err := parseAddressLength(args)
+	if err != nil {
+		return "E01"
+	}
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = se.cpu.Memory.ReadByte(address + uint16(i))
+	}
+	return hex.EncodeToString(data)
+}
+
+// writeMemory handles M addr,length:hex and X addr,length:binary
+func (se *session) writeMemory(args string, binary bool) string {
+	header, payload, ok := strings.Cut(args, ":")
+	if !ok {
+		return "E01"
+	}
+	address, length, err := parseAddressLength(header)
+	if err != nil {
+		return "E01"
+	}
+	var data []byte
+	if binary {
+		data = unescape(payload)
+	} else if data, err = hex.DecodeString(payload); err != nil {
+		return "E01"
+	}
+	if len(data) != length {
+		return "E01"
+	}
+	for i, b := range data {
+		se.cpu.Memory.WriteByte(address+uint16(i), b)
+	}
+	return "OK"
+}
+
+// setPC handles the optional resume address of c and s
+func (se *session) setPC(args string) error {
+	if args == "" {
+		return nil
+	}
+	address, err := parseHex(args, 16)
+	if err != nil {
+		return err
+	}
+	se.cpu.PC = uint16(address)
+	return nil
+}
+
+// resume runs until a breakpoint, a watchpoint or Ctrl-C
+func (se *session) resume() string {
+	// Only the first slice resumes past a breakpoint at PC; the next ones
+	// stop at a breakpoint right at their start
+	execute := se.server.Debugger.Continue
+	for ; ; execute = se.server.Debugger.Run {
+		_, reason, hit := execute(runSlice)
+		switch reason {
+		case z80.StopBudget:
+			continue
+		case z80.StopRequested:
+			se.stop = stopInterrupt
+		default:
+			se.stop = se.server.stopReply(hit)
+		}
+		return se.stop
+	}
+}
+
+// step executes one instruction
+func (se *session) step() string {
+	_, hit := se.server.Debugger.Step()
+	se.stop = se.server.stopReply(hit)
+	return se.stop
+}
+
+// stopHistoryBegin tells GDB that reverse execution reached the oldest
+// recorded step
+const stopHistoryBegin = stopTrap + "replaylog:begin;"
+
+// reverse handles bs (reverse step) and bc (reverse continue)
+func (se *session) reverse(args string) string {
+	h := se.server.History
+	if h == nil || (args != "s" && args != "c") {
+		return ""
+	}
+	d := se.server.Debugger
+	if args == "s" {
+		hit, ok := d.ReverseStep(h)
+		if !ok {
+			se.stop = stopHistoryBegin
+		} else {
+			se.stop = se.server.stopReply(hit)
+		}
+		return se.stop
+	}
+	if _, hit := d.ReverseRun(h); hit != nil {
+		se.stop = se.server.stopReply(hit)
+	} else {
+		se.stop = stopHistoryBegin
+	}
+	return se.stop
+}
+
+// stopReply describes a hit, telling GDB which data address a watchpoint saw
+func (s *Server) stopReply(hit *debugger.Hit) string {
+	if hit == nil || hit.Breakpoint.Kind == debugger.Exec {
+		return stopTrap
+	}
+	kind := "watch"
+	for key, ids := range s.breakpoints {
+		if key.kind == '4' && contains(ids, hit.Breakpoint.ID) {
+			kind = "awatch"
+		}
+	}
+	if kind == "watch" && hit.Breakpoint.Kind == debugger.MemRead {
+		kind = "rwatch"
+	}
+	return fmt.Sprintf("%s%s:%x;", stopTrap, kind, hit.Address)
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// breakpoint handles Z and z: 0 and 1 are execution breakpoints, 2 write,
+// 3 read and 4 access watchpoints over length bytes
+func (se *session) breakpoint(insert bool, args string) string {
+	args, _, _ = strings.Cut(args, ";") // Conditions evaluated by GDB are not supported
+	kind, rest, ok := strings.Cut(args, ",")
+	if !ok || len(kind) != 1 || kind[0] < '0' || kind[0] > '4' {
+		return ""
+	}
+	address, length, err := parseAddressLength(rest)
+	if err != nil {
+		return "E01"
+	}
+	if length == 0 {
+		length = 1
+	}
+	key := breakpointKey{kind: kind[0], address: address, length: uint16(length)}
+
+	if !insert {
+		return se.server.remove(key)
+	}
+	if _, exists := se.server.breakpoints[key]; exists {
+		return "OK"
+	}
+
+	d := se.server.Debugger
+	end := address + uint16(length-1)
+	var kinds []debugger.Kind
+	switch key.kind {
+	case '2':
+		kinds = []debugger.Kind{debugger.MemWrite}
+	case '3':
+		kinds = []debugger.Kind{debugger.MemRead}
+	case '4':
+		kinds = []debugger.Kind{debugger.MemRead, debugger.MemWrite}
+	}
+
+	var ids []int
+	if len(kinds) == 0 {
+		b, err := d.AddBreakpoint(address, "")
+		if err != nil {
+			return "E01"
+		}
+		ids = append(ids, b.ID)
+	}
+	for _, k := range kinds {
+		b, err := d.AddWatchpoint(k, address, end, "")
+		if err != nil {
+			return "E01"
+		}
+		ids = append(ids, b.ID)
+	}
+	se.server.breakpoints[key] = ids
+	return "OK"
+}
+
+// remove deletes the debugger breakpoints behind a Z packet
+func (s *Server) remove(key breakpointKey) string {
+	ids, ok := s.breakpoints[key]
+	if !ok {
+		return "E01"
+	}
+	for _, id := range ids {
+		s.Debugger.Remove(id)
+	}
+	delete(s.breakpoints, key)
+	return "OK"
+}
+
+// removeAll deletes every breakpoint a client set, when its session ends
+func (s *Server) removeAll() {
+	for key := range s.breakpoints {
+		s.remove(key)
+	}
+}
+
+// query handles the q packets GDB needs to connect
+func (se *session) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		features := "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+		if se.server.History != nil {
+			features += ";ReverseStep+;ReverseContinue+"
+		}
+		return features
+	case args == "Attached":
+		return "1"
+	case args == "C":
+		return "QC1"
+	case args == "fThreadInfo":
+		return "m1"
+	case args == "sThreadInfo":
+		return "l"
+	case args == "Symbol::":
+		return "OK"
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		return readAnnex(targetXML, strings.TrimPrefix(args, "Xfer:features:read:target.xml:"))
+	}
+	return ""
+}
+
+// readAnnex returns the offset,length window of an annex for qXfer
+func readAnnex(annex, window string) string {
+	offset, length, ok := strings.Cut(window, ",")
+	if !ok {
+		return "E01"
+	}
+	start, err := parseHex(offset, 32)
+	if err != nil {
+		return "E01"
+	}
+	n, err := parseHex(length, 32)
+	if err != nil {
+		return "E01"
+	}
+	if start >= len(annex) {
+		return "l"
+	}
+	if start+n >= len(annex) {
+		return "l" + annex[start:]
+	}
+	return "m" + annex[start:start+n]
+}
+
+// verbose handles the v packets: vCont resumes and vKill ends the session
+func (se *session) verbose(args string) (string, bool) {
+	switch {
+	case args == "Cont?":
+		return "vCont;c;C;s;S", false
+	case strings.HasPrefix(args, "Cont;"):
+		// There is one thread, so the first action decides
+		action, _, _ := strings.Cut(strings.TrimPrefix(args, "Cont;"), ";")
+		action, _, _ = strings.Cut(action, ":")
+		switch {
+		case strings.HasPrefix(action, "c"), strings.HasPrefix(action, "C"):
+			return se.resume(), false
+		case strings.HasPrefix(action, "s"), strings.HasPrefix(action, "S"):
+			return se.step(), false
+		}
+		return "E01", false
+	case strings.HasPrefix(args, "Kill"):
+		return "OK", true
+	}
+	return "", false
+}
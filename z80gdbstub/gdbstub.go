@@ -0,0 +1,221 @@
+// Package gdbstub serves the GDB remote serial protocol for a z80.CPU, so
+// that GDB and IDEs built on it can debug programs running on the emulator.
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+	"github.com/kiltum/emuz80/z80debugger"
+)
+
+// Server serves GDB sessions for one CPU, one client at a time
+type Server struct {
+	Debugger *debugger.Debugger
+	History  *debugger.History                // Optional, enables reverse step and continue
+	Logf     func(format string, args ...any) // Optional packet log
+
+	// breakpoints maps a Z packet type and address to debugger IDs
+	breakpoints map[breakpointKey][]int
+}
+
+// breakpointKey identifies a breakpoint set by a Z packet
+type breakpointKey struct {
+	kind    byte // '0' software, '1' hardware, '2' write, '3' read, '4' access
+	address uint16
+	length  uint16
+}
+
+// New creates a server driving the CPU through the debugger
+func New(d *debugger.Debugger) *Server {
+	return &Server{Debugger: d, breakpoints: make(map[breakpointKey][]int)}
+}
+
+// ListenAndServe accepts connections on a TCP address such as
+// "localhost:1234" and serves them one after another
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		if err := s.Serve(conn); err != nil {
+			s.logf("session ended: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// Serve runs one session over the connection until the client detaches,
+// kills the target or disconnects
+func (s *Server) Serve(conn io.ReadWriter) error {
+	se := &session{
+		server:  s,
+		cpu:     s.Debugger.CPU,
+		out:     bufio.NewWriter(conn),
+		packets: make(chan packet),
+		done:    make(chan struct{}),
+	}
+	defer close(se.done)
+	defer s.removeAll()
+	go se.read(bufio.NewReader(conn))
+	return se.loop()
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// packet is a packet received from the client
+type packet struct {
+	data string
+	ok   bool // Checksum matched
+	err  error
+}
+
+// session is the state of one client connection
+type session struct {
+	server  *Server
+	cpu     *z80.CPU
+	out     *bufio.Writer
+	packets chan packet
+	done    chan struct{} // Closed when the session ends
+	noAck   bool
+	stop    string // Reply to ?, the reason of the last stop
+}
+
+// read parses packets from the client. A Ctrl-C byte or the end of the
+// connection stops a running CPU at once.
+func (se *session) read(in *bufio.Reader) {
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			se.fail(err)
+			return
+		}
+		switch c {
+		case 0x03:
+			se.cpu.Stop()
+		case '$':
+			data, err := in.ReadString('#')
+			if err != nil {
+				se.fail(err)
+				return
+			}
+			data = data[:len(data)-1]
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(in, sum); err != nil {
+				se.fail(err)
+				return
+			}
+			want, err := strconv.ParseUint(string(sum), 16, 8)
+			select {
+			case se.packets <- packet{data: data, ok: err == nil && byte(want) == checksum(data)}:
+			case <-se.done:
+				return
+			}
+		}
+		// '+' and '-' acknowledgements are not needed over TCP
+	}
+}
+
+// fail stops a running CPU and hands a read error to the session
+func (se *session) fail(err error) {
+	se.cpu.Stop()
+	select {
+	case se.packets <- packet{err: err}:
+	case <-se.done:
+	}
+}
+
+// loop answers packets until the session ends
+func (se *session) loop() error {
+	for {
+		p := <-se.packets
+		if p.err != nil {
+			if p.err == io.EOF {
+				return nil
+			}
+			return p.err
+		}
+		if !se.noAck {
+			if !p.ok {
+				se.out.WriteByte('-')
+				se.out.Flush()
+				continue
+			}
+			// Acknowledge before a possibly long continue
+			se.out.WriteByte('+')
+			se.out.Flush()
+		}
+		se.server.logf("<- %s", p.data)
+
+		reply, done := se.handle(p.data)
+		if err := se.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// send writes a reply packet
+func (se *session) send(data string) error {
+	se.server.logf("-> %s", data)
+	fmt.Fprintf(se.out, "$%s#%02x", escape(data), checksum(escape(data)))
+	return se.out.Flush()
+}
+
+// checksum is the modulo 256 sum of the packet data
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// escape protects the characters that have a meaning in packet framing
+func escape(data string) string {
+	if !strings.ContainsAny(data, "$#}*") {
+		return data
+	}
+	var sb strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '$', '#', '}', '*':
+			sb.WriteByte('}')
+			sb.WriteByte(c ^ 0x20)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// unescape decodes the binary data of an X packet
+func unescape(data string) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+		} else {
+			out = append(out, data[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,290 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiltum/emuz80/z80"
+	"github.com/kiltum/emuz80/z80debugger"
+)
+
+// ram is a flat 64K memory.
+type ram [65536]byte
+
+func (m *ram) ReadByte(address uint16) byte         { return m[address] }
+func (m *ram) WriteByte(address uint16, value byte) { m[address] = value }
+func (m *ram) ReadWord(address uint16) uint16 {
+	return uint16(m[address]) | uint16(m[address+1])<<8
+}
+func (m *ram) WriteWord(address uint16, value uint16) {
+	m[address] = byte(value)
+	m[address+1] = byte(value >> 8)
+}
+
+// ports ignores all I/O.
+type ports struct{}
+
+func (ports) ReadPort(port uint16) byte         { return 0xFF }
+func (ports) WritePort(port uint16, value byte) {}
+func (ports) CheckInterrupt() bool              { return false }
+
+// client is the GDB end of a session.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Reader
+	done chan error
+}
+
+// connect starts a session over a pipe for a CPU running the program.
+func connect(t *testing.T, program ...byte) (*client, *z80.CPU, *ram) {
+	t.Helper()
+	mem := &ram{}
+	copy(mem[:], program)
+	cpu := z80.New(mem, ports{})
+	cpu.SP = 0xFFFF
+	return serve(t, New(debugger.New(cpu))), cpu, mem
+}
+
+// serve starts a session with the server over a pipe.
+func serve(t *testing.T, server *Server) *client {
+	t.Helper()
+	serverEnd, clientEnd := net.Pipe()
+	c := &client{t: t, conn: clientEnd, in: bufio.NewReader(clientEnd), done: make(chan error, 1)}
+	go func() { c.done <- server.Serve(serverEnd) }()
+	t.Cleanup(func() { clientEnd.Close() })
+	return c
+}
+
+// send sends a packet and returns the reply, checking the acknowledgement.
+func (c *client) send(data string) string {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c.conn, "$%s#%02x", data, checksum(data))
+	if ack, err := c.in.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: ack %q, %v", data, ack, err)
+	}
+	return c.reply()
+}
+
+// reply reads one packet and verifies its checksum.
+func (c *client) reply() string {
+	c.t.Helper()
+	if _, err := c.in.ReadString('$'); err != nil {
+		c.t.Fatalf("reading reply: %v", err)
+	}
+	data, err := c.in.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("reading reply: %v", err)
+	}
+	data = data[:len(data)-1]
+	sum := make([]byte, 2)
+	if _, err := c.in.Read(sum); err != nil {
+		c.t.Fatalf("reading checksum: %v", err)
+	}
+	if string(sum) != fmt.Sprintf("%02x", checksum(data)) {
+		c.t.Errorf("bad checksum %s for %q", sum, data)
+	}
+	return string(unescape(data))
+}
+
+func (c *client) expect(data, want string) {
+	c.t.Helper()
+	if got := c.send(data); got != want {
+		c.t.Errorf("%s: got %q, want %q", data, got, want)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	c, cpu, _ := connect(t)
+	cpu.SetAF(0x1234)
+	cpu.SetHL_(0xBEEF)
+	cpu.I, cpu.R = 0x3F, 0x05
+
+	regs := c.send("g")
+	if len(regs) != 13*4 {
+		t.Fatalf("g returned %d digits", len(regs))
+	}
+	if !strings.HasPrefix(regs, "3412") || !strings.HasSuffix(regs, "efbe053f") {
+		t.Errorf("g = %s", regs)
+	}
+
+	c.expect("P5=0080", "OK")
+	if cpu.PC != 0x8000 {
+		t.Errorf("PC = %04X after P", cpu.PC)
+	}
+	c.expect("p5", "0080")
+	c.expect("pd", "E01")
+
+	c.expect("G"+strings.Repeat("0100", 13), "OK")
+	if cpu.GetBC() != 1 || cpu.I != 0 || cpu.R != 1 {
+		t.Errorf("G did not set registers: BC=%04X I=%02X R=%02X", cpu.GetBC(), cpu.I, cpu.R)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	c, _, mem := connect(t, 0xDE, 0xAD, 0xBE, 0xEF)
+
+	c.expect("m0,4", "deadbeef")
+	c.expect("mfffe,4", "0000dead")
+	c.expect("M100,2:1234", "OK")
+	if mem[0x100] != 0x12 || mem[0x101] != 0x34 {
+		t.Errorf("M wrote %02X %02X", mem[0x100], mem[0x101])
+	}
+	// Binary data with an escaped '#'
+	c.expect("X200,2:}\x03A", "OK")
+	if mem[0x200] != '#' || mem[0x201] != 'A' {
+		t.Errorf("X wrote %02X %02X", mem[0x200], mem[0x201])
+	}
+	c.expect("M0,2:12", "E01")
+}
+
+func TestBreakpointAndStep(t *testing.T) {
+	// 0000 NOP; INC A; JR -3
+	c, cpu, _ := connect(t, 0x00, 0x3C, 0x18, 0xFD)
+
+	c.expect("Z0,1,1", "OK")
+	c.expect("c", "T05")
+	if cpu.PC != 1 {
+		t.Fatalf("stopped at %04X", cpu.PC)
+	}
+	c.expect("c", "T05")
+	if cpu.A != 1 {
+		t.Errorf("A = %d after one loop", cpu.A)
+	}
+	c.expect("z0,1,1", "OK")
+	c.expect("z0,1,1", "E01")
+
+	c.expect("s", "T05")
+	if cpu.PC != 2 {
+		t.Errorf("PC = %04X after step", cpu.PC)
+	}
+	c.expect("vCont;s:1", "T05")
+	c.expect("?", "T05")
+}
+
+// A breakpoint reached exactly at the end of a run slice still stops.
+func TestBreakpointAtSliceBoundary(t *testing.T) {
+	c, cpu, _ := connect(t) // Memory is all NOPs
+	boundary := uint16(runSlice / 4)
+
+	c.expect(fmt.Sprintf("Z0,%x,1", boundary), "OK")
+	c.expect("c", "T05")
+	if cpu.PC != boundary {
+		t.Errorf("stopped at %04X, want %04X", cpu.PC, boundary)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	// LD (8000),A; LD A,(8001); JR -8
+	c, _, _ := connect(t, 0x32, 0x00, 0x80, 0x3A, 0x01, 0x80, 0x18, 0xF8)
+
+	c.expect("Z2,8000,1", "OK")
+	c.expect("Z4,8001,1", "OK")
+	c.expect("c", "T05watch:8000;")
+	c.expect("c", "T05awatch:8001;")
+	c.expect("z2,8000,1", "OK")
+	c.expect("z4,8001,1", "OK")
+	c.expect("Z3,8001,1", "OK")
+	c.expect("c", "T05rwatch:8001;")
+}
+
+func TestReverse(t *testing.T) {
+	// 0000 INC A; LD (8000),A; JR -6
+	mem := &ram{}
+	copy(mem[:], []byte{0x3C, 0x32, 0x00, 0x80, 0x18, 0xFA})
+	cpu := z80.New(mem, ports{})
+	server := New(debugger.New(cpu))
+	c := serve(t, server)
+
+	c.expect("bs", "")
+	server.History = debugger.NewHistory(cpu, 100)
+	if got := c.send("qSupported"); !strings.Contains(got, "ReverseStep+;ReverseContinue+") {
+		t.Errorf("qSupported = %q", got)
+	}
+	c.expect("bc", "T05replaylog:begin;")
+
+	c.expect("Z0,4,1", "OK")
+	c.expect("c", "T05")
+	c.expect("c", "T05")
+	if cpu.A != 2 || mem[0x8000] != 2 {
+		t.Fatalf("A = %d, (8000) = %d after two loops", cpu.A, mem[0x8000])
+	}
+	c.expect("bs", "T05")
+	if cpu.PC != 1 || mem[0x8000] != 1 {
+		t.Errorf("PC = %04X, (8000) = %d after reverse step", cpu.PC, mem[0x8000])
+	}
+	c.expect("Z2,8000,1", "OK")
+	c.expect("bc", "T05")
+	if cpu.PC != 4 {
+		t.Errorf("PC = %04X at reverse breakpoint", cpu.PC)
+	}
+	c.expect("z0,4,1", "OK")
+	c.expect("bc", "T05watch:8000;")
+	if cpu.PC != 1 || cpu.A != 1 || mem[0x8000] != 0 {
+		t.Errorf("PC = %04X, A = %d, (8000) = %d at reverse watch", cpu.PC, cpu.A, mem[0x8000])
+	}
+	c.expect("bc", "T05replaylog:begin;")
+	if cpu.PC != 0 || cpu.A != 0 {
+		t.Errorf("PC = %04X, A = %d at start of history", cpu.PC, cpu.A)
+	}
+}
+
+// Ctrl-C interrupts a program that never stops by itself.
+func TestInterrupt(t *testing.T) {
+	c, cpu, _ := connect(t, 0x18, 0xFE) // JR $
+
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c.conn, "$c#%02x", checksum("c"))
+	if ack, _ := c.in.ReadByte(); ack != '+' {
+		t.Fatalf("ack %q", ack)
+	}
+	time.Sleep(10 * time.Millisecond)
+	c.conn.Write([]byte{0x03})
+	if got := c.reply(); got != "T02" {
+		t.Errorf("reply to Ctrl-C = %q", got)
+	}
+	if cpu.TotalTStates == 0 {
+		t.Errorf("CPU did not run")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	c, _, _ := connect(t)
+
+	if got := c.send("qSupported:multiprocess+;swbreak+"); !strings.Contains(got, "qXfer:features:read+") {
+		t.Errorf("qSupported = %q", got)
+	}
+	c.expect("qAttached", "1")
+	c.expect("vMustReplyEmpty", "")
+	c.expect("Hg0", "OK")
+
+	xml := c.send("qXfer:features:read:target.xml:0,fff")
+	if !strings.HasPrefix(xml, "l<?xml") || !strings.Contains(xml, `<reg name="ir"`) {
+		t.Errorf("target.xml = %q", xml)
+	}
+	part := c.send("qXfer:features:read:target.xml:0,10")
+	if part != "m"+targetXML[:0x10] {
+		t.Errorf("first chunk = %q", part)
+	}
+
+	// A corrupted packet is rejected with '-'
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(c.conn, "$g#00")
+	if nak, _ := c.in.ReadByte(); nak != '-' {
+		t.Errorf("bad checksum answered with %q", nak)
+	}
+
+	c.expect("QStartNoAckMode", "OK")
+	fmt.Fprintf(c.conn, "$D#%02x", checksum("D"))
+	if got := c.reply(); got != "OK" {
+		t.Errorf("D = %q", got)
+	}
+	if err := <-c.done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Intel HEX record types
+const (
+	hexData           = 0x00
+	hexEnd            = 0x01
+	hexSegment        = 0x02 // Base address is the value times 16
+	hexStartSegment   = 0x03 // CS:IP of the entry point
+	hexLinear         = 0x04 // Upper 16 bits of the address
+	hexStartLinear    = 0x05 // 32-bit entry point
+	hexRecordDataSize = 16
+)
+
+// ReadHex reads an Intel HEX file. Checksums are verified, extended
+// segment and linear address records are applied and start address records
+// set the entry point. Reading stops at the end of file record, which is
+// required.
+func ReadHex(r io.Reader) (*Image, error) {
+	b := &builder{}
+	base := 0
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		kind, address, data, err := parseHexRecord(line)
+		if err == nil {
+			switch kind {
+			case hexData:
+				err = b.add(base+address, data)
+			case hexEnd:
+				return &b.image, nil
+			case hexSegment, hexLinear:
+				if len(data) != 2 {
+					err = fmt.Errorf("extended address record with %d bytes", len(data))
+				} else if kind == hexSegment {
+					base = (int(data[0])<<8 | int(data[1])) << 4
+				} else {
+					base = (int(data[0])<<8 | int(data[1])) << 16
+				}
+			case hexStartSegment, hexStartLinear:
+				if len(data) != 4 {
+					err = fmt.Errorf("start address record with %d bytes", len(data))
+				} else if kind == hexStartSegment {
+					err = b.start((int(data[0])<<8|int(data[1]))<<4 + (int(data[2])<<8 | int(data[3])))
+				} else {
+					err = b.start(int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+				}
+			default:
+				err = fmt.Errorf("unknown record type %02X", kind)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("missing end of file record")
+}
+
+// parseHexRecord decodes one :LLAAAATT...CC line and verifies its checksum
+func parseHexRecord(line string) (kind byte, address int, data []byte, err error) {
+	if line[0] != ':' {
+		return 0, 0, nil, fmt.Errorf("record does not start with ':'")
+	}
+	raw, err := hex.DecodeString(line[1:])
+	if err != nil || len(raw) < 5 || len(raw) != int(raw[0])+5 {
+		return 0, 0, nil, fmt.Errorf("malformed record %q", line)
+	}
+	var sum byte
+	for _, v := range raw {
+		sum += v
+	}
+	if sum != 0 {
+		return 0, 0, nil, fmt.Errorf("checksum %02X, want %02X", raw[len(raw)-1], raw[len(raw)-1]-sum)
+	}
+	return raw[3], int(raw[1])<<8 | int(raw[2]), raw[4 : len(raw)-1], nil
+}
+
+// WriteHex writes the image as Intel HEX with 16 data bytes per record, a
+// start linear address record if it has an entry point, and an end of file
+// record. Addresses fit 16 bits, so no extended address records are needed.
+func (img *Image) WriteHex(w io.Writer) error {
+	var b strings.Builder
+	for _, block := range img.Blocks {
+		for i := 0; i < len(block.Data); i += hexRecordDataSize {
+			chunk := block.Data[i:min(len(block.Data), i+hexRecordDataSize)]
+			writeHexRecord(&b, hexData, int(block.Address)+i, chunk)
+		}
+	}
+	if img.HasStart {
+		writeHexRecord(&b, hexStartLinear, 0, []byte{0, 0, byte(img.Start >> 8), byte(img.Start)})
+	}
+	writeHexRecord(&b, hexEnd, 0, nil)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeHexRecord formats one record with its checksum
+func writeHexRecord(b *strings.Builder, kind byte, address int, data []byte) {
+	raw := append([]byte{byte(len(data)), byte(address >> 8), byte(address), kind}, data...)
+	var sum byte
+	for _, v := range raw {
+		sum += v
+	}
+	fmt.Fprintf(b, ":%s%02X\n", strings.ToUpper(hex.EncodeToString(raw)), -sum)
+}
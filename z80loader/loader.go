@@ -0,0 +1,158 @@
+// Package loader reads and writes program images in Intel HEX, Motorola
+// S-record, raw binary and CP/M .COM formats, and loads them into any
+// z80.Memory.
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// Block is a run of bytes at consecutive addresses
+type Block struct {
+	Address uint16
+	Data    []byte
+}
+
+// Image is a program: its blocks in file order and where it starts
+type Image struct {
+	Blocks   []Block
+	Start    uint16 // Entry point, if HasStart
+	HasStart bool
+}
+
+// memorySize is the address space of the Z80
+const memorySize = 0x10000
+
+// comOrigin is where CP/M loads .COM files and starts them
+const comOrigin = 0x100
+
+// builder collects blocks and rejects data beyond 64K or written twice
+type builder struct {
+	image Image
+	used  [memorySize]bool
+}
+
+// add adds bytes at an address, which may be beyond 64K in the formats
+// with wider addresses
+func (b *builder) add(address int, data []byte) error {
+	if address < 0 || address+len(data) > memorySize {
+		return fmt.Errorf("%d bytes at $%X do not fit below $10000", len(data), address)
+	}
+	for i := range data {
+		if b.used[address+i] {
+			return fmt.Errorf("data at $%04X overlaps earlier data", address+i)
+		}
+		b.used[address+i] = true
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if n := len(b.image.Blocks); n > 0 {
+		last := &b.image.Blocks[n-1]
+		if int(last.Address)+len(last.Data) == address {
+			last.Data = append(last.Data, data...)
+			return nil
+		}
+	}
+	b.image.Blocks = append(b.image.Blocks, Block{Address: uint16(address), Data: append([]byte(nil), data...)})
+	return nil
+}
+
+// start sets the entry point
+func (b *builder) start(address int) error {
+	if address < 0 || address >= memorySize {
+		return fmt.Errorf("start address $%X beyond $FFFF", address)
+	}
+	b.image.Start, b.image.HasStart = uint16(address), true
+	return nil
+}
+
+// ReadBinary returns raw bytes loaded at an address
+func ReadBinary(data []byte, address uint16) (*Image, error) {
+	b := &builder{}
+	if err := b.add(int(address), data); err != nil {
+		return nil, err
+	}
+	return &b.image, nil
+}
+
+// ReadCOM returns a CP/M .COM program, which loads and starts at $0100
+func ReadCOM(data []byte) (*Image, error) {
+	image, err := ReadBinary(data, comOrigin)
+	if err != nil {
+		return nil, err
+	}
+	image.Start, image.HasStart = comOrigin, true
+	return image, nil
+}
+
+// ReadFile reads an image, choosing the format by extension: .hex, .ihx and
+// .ihex are Intel HEX, .srec, .s19, .s28, .s37 and .mot are S-records and
+// .com is a CP/M program. Raw binaries need ReadBinary, since they do not
+// say where they load.
+func ReadFile(name string) (*Image, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var image *Image
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".hex", ".ihx", ".ihex":
+		image, err = ReadHex(strings.NewReader(string(data)))
+	case ".srec", ".s19", ".s28", ".s37", ".mot":
+		image, err = ReadSRecord(strings.NewReader(string(data)))
+	case ".com":
+		image, err = ReadCOM(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown image format", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return image, nil
+}
+
+// Load writes the image into memory
+func (img *Image) Load(memory z80.Memory) {
+	for _, block := range img.Blocks {
+		for i, v := range block.Data {
+			memory.WriteByte(block.Address+uint16(i), v)
+		}
+	}
+}
+
+// Bytes returns the image as one block from its lowest to its highest
+// address, with gaps filled with zeros, for writing a raw binary
+func (img *Image) Bytes() (uint16, []byte) {
+	if len(img.Blocks) == 0 {
+		return 0, nil
+	}
+	low, high := memorySize, 0
+	for _, block := range img.Blocks {
+		low = min(low, int(block.Address))
+		high = max(high, int(block.Address)+len(block.Data))
+	}
+	data := make([]byte, high-low)
+	for _, block := range img.Blocks {
+		copy(data[int(block.Address)-low:], block.Data)
+	}
+	return uint16(low), data
+}
+
+// Save returns the bytes of memory from address to address+size-1 as an
+// image, for writing with WriteHex or WriteSRecord
+func Save(memory z80.Memory, address uint16, size int) (*Image, error) {
+	if size < 0 || int(address)+size > memorySize {
+		return nil, fmt.Errorf("%d bytes at $%04X do not fit below $10000", size, address)
+	}
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = memory.ReadByte(address + uint16(i))
+	}
+	return &Image{Blocks: []Block{{Address: address, Data: data}}}, nil
+}
@@ -0,0 +1,257 @@
+package loader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ram is a flat 64K memory.
+type ram [65536]byte
+
+func (m *ram) ReadByte(address uint16) byte         { return m[address] }
+func (m *ram) WriteByte(address uint16, value byte) { m[address] = value }
+func (m *ram) ReadWord(address uint16) uint16 {
+	return uint16(m[address]) | uint16(m[address+1])<<8
+}
+func (m *ram) WriteWord(address uint16, value uint16) {
+	m[address] = byte(value)
+	m[address+1] = byte(value >> 8)
+}
+
+func assertEq[T comparable](t *testing.T, got, want T, msg string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %v, want %v", msg, got, want)
+	}
+}
+
+// assertError checks that err is set and contains text
+func assertError(t *testing.T, err error, text string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), text) {
+		t.Errorf("got error %v, want one containing %q", err, text)
+	}
+}
+
+func TestReadHex(t *testing.T) {
+	image, err := ReadHex(strings.NewReader(`
+:0B0010006164647265737320676170A7
+:020000020100FB
+:0200000041AA13
+:040000050000ABCD7F
+:00000001FF
+:0100000099 ignored after the end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, len(image.Blocks), 2, "blocks")
+	assertEq(t, image.Blocks[0].Address, uint16(0x0010), "first block address")
+	assertEq(t, string(image.Blocks[0].Data), "address gap", "first block data")
+	assertEq(t, image.Blocks[1].Address, uint16(0x1000), "segment block address")
+	assertEq(t, image.HasStart, true, "has start")
+	assertEq(t, image.Start, uint16(0xABCD), "start")
+
+	var mem ram
+	image.Load(&mem)
+	assertEq(t, mem[0x0010], byte('a'), "loaded byte")
+	assertEq(t, mem.ReadWord(0x1000), uint16(0xAA41), "loaded word")
+}
+
+func TestReadHexErrors(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"checksum", ":0B0010006164647265737320676170A8\n:00000001FF", "line 1: checksum A8, want A7"},
+		{"malformed", ":0B00100061\n", "line 1: malformed record"},
+		{"no colon", "0B0010006164647265737320676170A7\n", "line 1: record does not start with ':'"},
+		{"overlap", ":0100100041AE\n:02001000424369\n:00000001FF", "line 2: data at $0010 overlaps earlier data"},
+		{"beyond 64K linear", ":020000040001F9\n:0100000041BE\n:00000001FF", "line 2: 1 bytes at $10000 do not fit below $10000"},
+		{"beyond 64K segment", ":02000002F0000C\n:02FFF00041428C\n:00000001FF", "line 2: 2 bytes at $FFFF0 do not fit"},
+		{"wrapping", ":02FFFF0041427D\n:00000001FF", "line 1: 2 bytes at $FFFF do not fit"},
+		{"unknown type", ":0000000AF6\n", "line 1: unknown record type 0A"},
+		{"no end", ":0100100041AE\n", "missing end of file record"},
+	}
+	for _, tt := range tests {
+		_, err := ReadHex(strings.NewReader(tt.source))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWriteHex(t *testing.T) {
+	data := make([]byte, 20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	image := &Image{Blocks: []Block{{0x8000, data}, {0x0000, []byte{0xC3, 0x00, 0x80}}}, Start: 0x8000, HasStart: true}
+	var b strings.Builder
+	if err := image.WriteHex(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := ":10800000000102030405060708090A0B0C0D0E0FF8\n" +
+		":048010001011121326\n" +
+		":03000000C30080BA\n" +
+		":040000050000800077\n" +
+		":00000001FF\n"
+	assertEq(t, b.String(), want, "hex")
+
+	back, err := ReadHex(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, len(back.Blocks), 2, "blocks read back")
+	assertEq(t, bytes.Equal(back.Blocks[0].Data, data), true, "data read back")
+	assertEq(t, back.Start, uint16(0x8000), "start read back")
+
+	// Without an entry point no start record is written
+	image.HasStart = false
+	b.Reset()
+	if err := image.WriteHex(&b); err != nil {
+		t.Fatal(err)
+	}
+	back, err = ReadHex(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, back.HasStart, false, "no start read back")
+}
+
+func TestReadSRecord(t *testing.T) {
+	image, err := ReadSRecord(strings.NewReader(`S00F000068656C6C6F202020202000003C
+S11F00007C0802A6900100049421FFF07C6C1B787C8C23783C6000003863000026
+S11F001C4BFFFFE5398000007D83637880010014382100107C0803A64E800020E9
+S111003848656C6C6F20776F726C642E0A0042
+S5030003F9
+S9030000FC
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, len(image.Blocks), 1, "contiguous records join")
+	assertEq(t, len(image.Blocks[0].Data), 0x46, "data length")
+	assertEq(t, string(image.Blocks[0].Data[0x38:0x45]), "Hello world.\n", "data")
+	assertEq(t, image.HasStart, true, "has start")
+}
+
+func TestReadSRecordErrors(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"checksum", "S111003848656C6C6F20776F726C642E0A0043\n", "line 1: checksum 43, want 42"},
+		{"count", "S111003848656C6C6F20776F726C642E0A0042\nS5030002FA\n", "line 2: record count 2, read 1 records"},
+		{"type", "S4030000FC\n", "line 1: unknown record type S4"},
+		{"beyond 64K", "S20501000041B8\n", "line 1: 1 bytes at $10000 do not fit"},
+		{"overlap", "S104001041AA\nS104001041AA\n", "line 2: data at $0010 overlaps earlier data"},
+		{"start", "S70500010000F9\n", "line 1: start address $10000 beyond $FFFF"},
+		{"no termination", "S104001041AA\n", "missing termination record"},
+	}
+	for _, tt := range tests {
+		_, err := ReadSRecord(strings.NewReader(tt.source))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWriteSRecord(t *testing.T) {
+	image := &Image{Blocks: []Block{{0x0100, []byte("Hello")}}, Start: 0x0100, HasStart: true}
+	var b strings.Builder
+	if err := image.WriteSRecord(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "S0030000FC\nS108010048656C6C6F02\nS5030001FB\nS9030100FB\n"
+	assertEq(t, b.String(), want, "srec")
+
+	back, err := ReadSRecord(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, string(back.Blocks[0].Data), "Hello", "data read back")
+	assertEq(t, back.Start, uint16(0x0100), "start read back")
+	assertEq(t, back.HasStart, true, "has start read back")
+
+	// An entry point of 0 survives the round trip
+	image.Start = 0
+	b.Reset()
+	if err := image.WriteSRecord(&b); err != nil {
+		t.Fatal(err)
+	}
+	back, err = ReadSRecord(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, back.HasStart, true, "zero start read back")
+	assertEq(t, back.Start, uint16(0), "zero start")
+
+	// Without an entry point S9 carries 0
+	image = &Image{Blocks: []Block{{0x0100, []byte("Hello")}}, Start: 0x1234}
+	b.Reset()
+	if err := image.WriteSRecord(&b); err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, strings.HasSuffix(b.String(), "S9030000FC\n"), true, "S9 without start")
+}
+
+func TestBinaryAndCOM(t *testing.T) {
+	image, err := ReadBinary([]byte{1, 2, 3}, 0xFFFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, image.HasStart, false, "binary has no start")
+	_, err = ReadBinary([]byte{1, 2, 3}, 0xFFFE)
+	assertError(t, err, "3 bytes at $FFFE do not fit below $10000")
+
+	image, err = ReadCOM([]byte{0xC3, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, image.Blocks[0].Address, uint16(0x0100), "COM address")
+	assertEq(t, image.Start, uint16(0x0100), "COM start")
+	_, err = ReadCOM(make([]byte, 0xFF01))
+	assertError(t, err, "do not fit")
+}
+
+func TestSaveAndBytes(t *testing.T) {
+	var mem ram
+	copy(mem[0x4000:], "ZX")
+	image, err := Save(&mem, 0x4000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image.Blocks = append(image.Blocks, Block{0x4004, []byte{9}})
+	address, data := image.Bytes()
+	assertEq(t, address, uint16(0x4000), "lowest address")
+	assertEq(t, bytes.Equal(data, []byte{'Z', 'X', 0, 0, 9}), true, "bytes with gap")
+
+	_, err = Save(&mem, 0xFFFF, 2)
+	assertError(t, err, "do not fit")
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"prog.ihx": ":0100100041AE\n:00000001FF\n",
+		"prog.s19": "S104001041AA\nS9030000FC\n",
+		"prog.com": "A",
+		"prog.bin": "A",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, address := range map[string]uint16{"prog.ihx": 0x10, "prog.s19": 0x10, "prog.com": 0x100} {
+		image, err := ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		assertEq(t, image.Blocks[0].Address, address, name)
+	}
+	_, err := ReadFile(filepath.Join(dir, "prog.bin"))
+	assertError(t, err, "unknown image format")
+}
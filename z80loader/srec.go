@@ -0,0 +1,116 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// srecDataSize is the number of data bytes per written S1 record
+const srecDataSize = 16
+
+// srecAddressSize is the address length of each S-record type
+var srecAddressSize = map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2}
+
+// ReadSRecord reads a Motorola S-record file. Checksums are verified, S5
+// and S6 counts must match the data records read so far, and the S7, S8 or
+// S9 termination record, which is required, ends the file. Its address is
+// the entry point, except that address 0 means the image has none.
+func ReadSRecord(r io.Reader) (*Image, error) {
+	b := &builder{}
+	records := 0
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		kind, address, data, err := parseSRecord(line)
+		if err == nil {
+			switch kind {
+			case '0':
+			case '1', '2', '3':
+				err = b.add(address, data)
+				records++
+			case '5', '6':
+				if address != records {
+					err = fmt.Errorf("record count %d, read %d records", address, records)
+				}
+			case '7', '8', '9':
+				if err = b.start(address); err == nil {
+					return &b.image, nil
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("missing termination record")
+}
+
+// parseSRecord decodes one STCCAAAA...SS line and verifies its checksum
+func parseSRecord(line string) (kind byte, address int, data []byte, err error) {
+	if len(line) < 2 || line[0] != 'S' && line[0] != 's' {
+		return 0, 0, nil, fmt.Errorf("record does not start with 'S'")
+	}
+	kind = line[1]
+	size, ok := srecAddressSize[kind]
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("unknown record type S%c", kind)
+	}
+	raw, err := hex.DecodeString(line[2:])
+	if err != nil || len(raw) < size+2 || len(raw) != int(raw[0])+1 {
+		return 0, 0, nil, fmt.Errorf("malformed record %q", line)
+	}
+	var sum byte
+	for _, v := range raw[:len(raw)-1] {
+		sum += v
+	}
+	if want := ^sum; raw[len(raw)-1] != want {
+		return 0, 0, nil, fmt.Errorf("checksum %02X, want %02X", raw[len(raw)-1], want)
+	}
+	for _, v := range raw[1 : 1+size] {
+		address = address<<8 | int(v)
+	}
+	return kind, address, raw[1+size : len(raw)-1], nil
+}
+
+// WriteSRecord writes the image as S-records: an empty S0 header, S1 data
+// records of 16 bytes, an S5 count and an S9 record with the entry point.
+// S-records cannot leave the entry point out, so an image without one is
+// written with entry point 0.
+func (img *Image) WriteSRecord(w io.Writer) error {
+	var b strings.Builder
+	writeSRecord(&b, '0', 0, nil)
+	records := 0
+	for _, block := range img.Blocks {
+		for i := 0; i < len(block.Data); i += srecDataSize {
+			writeSRecord(&b, '1', int(block.Address)+i, block.Data[i:min(len(block.Data), i+srecDataSize)])
+			records++
+		}
+	}
+	writeSRecord(&b, '5', records, nil)
+	start := 0
+	if img.HasStart {
+		start = int(img.Start)
+	}
+	writeSRecord(&b, '9', start, nil)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSRecord formats one record with a 16-bit address and its checksum
+func writeSRecord(b *strings.Builder, kind byte, address int, data []byte) {
+	raw := append([]byte{byte(len(data) + 3), byte(address >> 8), byte(address)}, data...)
+	var sum byte
+	for _, v := range raw {
+		sum += v
+	}
+	fmt.Fprintf(b, "S%c%s%02X\n", kind, strings.ToUpper(hex.EncodeToString(raw)), ^sum)
+}
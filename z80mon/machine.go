@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// Memory64K is a flat 64KB RAM
+type Memory64K struct {
+	data [0x10000]byte
+}
+
+// ReadByte reads a byte from memory
+func (m *Memory64K) ReadByte(address uint16) byte {
+	return m.data[address]
+}
+
+// WriteByte writes a byte to memory
+func (m *Memory64K) WriteByte(address uint16, value byte) {
+	m.data[address] = value
+}
+
+// ReadWord reads a little-endian word from memory
+func (m *Memory64K) ReadWord(address uint16) uint16 {
+	return uint16(m.data[address]) | uint16(m.data[address+1])<<8
+}
+
+// WriteWord writes a little-endian word to memory
+func (m *Memory64K) WriteWord(address uint16, value uint16) {
+	m.data[address] = byte(value)
+	m.data[address+1] = byte(value >> 8)
+}
+
+// bdosPort is the port the CP/M stub writes to when a program calls BDOS
+const bdosPort = 0xFF
+
+// cpmStub is placed at 0x0000 when a .COM file is loaded: a warm boot halts
+// the CPU and CALL 5 reaches OUT (FF),A; RET, which lets Console run the
+// BDOS function while C and DE still hold its arguments
+var cpmStub = []byte{0x76, 0x00, 0x00, 0x00, 0x00, 0xD3, bdosPort, 0xC9}
+
+// Console is the I/O device of the monitor. Ports read as 0xFF and writes
+// are ignored, except for the CP/M BDOS console functions when enabled.
+type Console struct {
+	CPU *z80.CPU
+	CPM bool      // Handle BDOS calls made through the CP/M stub
+	Out io.Writer // Where CP/M console output goes
+}
+
+// ReadPort reads from an I/O port
+func (c *Console) ReadPort(port uint16) byte {
+	return 0xFF
+}
+
+// WritePort writes to an I/O port
+func (c *Console) WritePort(port uint16, value byte) {
+	if !c.CPM || port&0xFF != bdosPort {
+		return
+	}
+	switch c.CPU.C {
+	case 2: // Print character in E
+		fmt.Fprint(c.Out, string(rune(c.CPU.E)))
+	case 9: // Print string at DE terminated by '$', at most all of memory
+		addr := c.CPU.GetDE()
+		for range 0x10000 {
+			char := c.CPU.Memory.ReadByte(addr)
+			if char == '$' {
+				break
+			}
+			fmt.Fprint(c.Out, string(rune(char)))
+			addr++
+		}
+	}
+}
+
+// CheckInterrupt checks for interrupts
+func (c *Console) CheckInterrupt() bool {
+	return false
+}
+
+// loadFile loads a raw binary at address. A .COM file is always loaded at
+// 0x100 under the CP/M stub, with PC and SP set up to run it; for other files
+// PC is set to the load address. It returns the number of bytes loaded.
+func (m *Monitor) loadFile(filename string, address uint16) (int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file %s: %v", filename, err)
+	}
+
+	com := strings.EqualFold(filepath.Ext(filename), ".com")
+	if com {
+		address = 0x100
+	}
+	if int(address)+len(data) > 0x10000 {
+		return 0, fmt.Errorf("%s does not fit at %04X (%d bytes)", filename, address, len(data))
+	}
+	copy(m.memory.data[address:], data)
+
+	m.console.CPM = com
+	if com {
+		copy(m.memory.data[:], cpmStub)
+		m.memory.WriteWord(0xFFFE, 0x0000) // RET from the program warm boots
+		m.cpu.SP = 0xFFFE
+	}
+	m.cpu.PC = address
+	return len(data), nil
+}
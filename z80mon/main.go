@@ -0,0 +1,76 @@
+// Command z80mon is an interactive monitor for inspecting and debugging Z80
+// programs running on the emulator
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/kiltum/emuz80/z80gdbstub"
+)
+
+func main() {
+	org := flag.String("org", "0", "load address for raw binaries (hex)")
+	limit := flag.Int("limit", 1<<30, "T-states a single run command may take")
+	gdb := flag.String("gdb", "", "serve the GDB remote protocol on this address, e.g. localhost:1234")
+	history := flag.Int("history", 0, "instructions to record for stepping backwards, 0 disables")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: z80mon [-org addr] [-limit tstates] [-history n] [-gdb addr] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	monitor := NewMonitor(os.Stdout)
+	monitor.Limit = *limit
+	if *history > 0 {
+		monitor.EnableHistory(*history)
+	}
+	if flag.NArg() > 0 {
+		address, err := parseWord(*org)
+		if err == nil {
+			_, err = monitor.loadFile(flag.Arg(0), address)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *gdb != "" {
+		fmt.Printf("waiting for GDB on %s\n", *gdb)
+		server := gdbstub.New(monitor.debugger)
+		server.History = monitor.history
+		if err := server.ListenAndServe(*gdb); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Ctrl-C stops a running program instead of the monitor
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			monitor.Interrupt()
+		}
+	}()
+
+	monitor.showState()
+	input := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !input.Scan() {
+			fmt.Println()
+			return
+		}
+		if err := monitor.Execute(input.Text()); err == errQuit {
+			return
+		} else if err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+}
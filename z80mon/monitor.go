@@ -0,0 +1,785 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+	"github.com/kiltum/emuz80/z80debugger"
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// runSlice is the number of T-states Run executes between checks for a
+// halted CPU and the run limit
+const runSlice = 100000
+
+// helpText lists the monitor commands. Addresses and values are hex.
+const helpText = `s [n]                         step n instructions
+n                             step over CALL, RST and repeating block instructions
+o                             step out of the current subroutine
+g [addr]                      run until a breakpoint, or until PC reaches addr
+r [reg=value ...]             show or set registers
+d [addr [len]]                dump memory
+e addr data...                write bytes or "text" to memory
+f start end data...           fill memory with a pattern
+sr start end data...          search memory for bytes or "text"
+u [addr [n]]                  disassemble n instructions
+b [addr [if cond]]            set an execution breakpoint, or list breakpoints
+w read|write|in|out start [end] [if cond]
+                              set a memory or port watchpoint
+bd id                         delete a breakpoint or watchpoint
+l file [addr]                 load a raw binary at addr, or a .COM file at 0100
+bs [n]                        step back n instructions (needs -history)
+bg                            run backwards to a breakpoint or write watchpoint
+who addr                      show the last recorded instruction that wrote addr
+q                             quit
+Numbers are hex ($, 0x and h are accepted); conditions use the debugger
+expression syntax, e.g. b 8000 if A==0x3F && HL>0x8000`
+
+// Monitor is an interactive debugger for a Z80 with 64KB of RAM
+type Monitor struct {
+	Limit int // T-states a single g, n or o may run before giving up
+
+	cpu      *z80.CPU
+	memory   *Memory64K
+	console  *Console
+	debugger *debugger.Debugger
+	disasm   *disasm.Disassembler
+	history  *debugger.History // Nil unless EnableHistory was called
+	out      io.Writer
+
+	dumpNext  uint16 // Where a bare d continues
+	listNext  uint16 // Where a bare u continues
+	listValid bool   // listNext is set by a previous u
+}
+
+// NewMonitor creates a monitor writing to out
+func NewMonitor(out io.Writer) *Monitor {
+	memory := &Memory64K{}
+	console := &Console{Out: out}
+	cpu := z80.New(memory, console)
+	cpu.SP = 0xFFFF
+	console.CPU = cpu
+	return &Monitor{
+		Limit:    1 << 30,
+		cpu:      cpu,
+		memory:   memory,
+		console:  console,
+		debugger: debugger.New(cpu),
+		disasm:   disasm.New(),
+		out:      out,
+	}
+}
+
+// EnableHistory records the last limit instructions so they can be stepped
+// backwards
+func (m *Monitor) EnableHistory(limit int) {
+	m.history = debugger.NewHistory(m.cpu, limit)
+}
+
+// Interrupt stops a running g, n or o. It may be called from another
+// goroutine, for example a signal handler.
+func (m *Monitor) Interrupt() {
+	m.cpu.Stop()
+}
+
+// errQuit is returned by Execute for the quit command
+var errQuit = fmt.Errorf("quit")
+
+// Execute runs one command line
+func (m *Monitor) Execute(line string) error {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return err
+	}
+	cmd, args := strings.ToLower(args[0]), args[1:]
+	if cmd != "u" {
+		m.listValid = false
+	}
+
+	switch cmd {
+	case "s":
+		return m.cmdStep(args)
+	case "n":
+		return m.cmdStepOver()
+	case "o":
+		return m.cmdStepOut()
+	case "g":
+		return m.cmdGo(args)
+	case "r":
+		return m.cmdRegisters(args)
+	case "d":
+		return m.cmdDump(args)
+	case "e":
+		return m.cmdEdit(args)
+	case "f":
+		return m.cmdFill(args)
+	case "sr":
+		return m.cmdSearch(args)
+	case "u":
+		return m.cmdDisassemble(args)
+	case "b":
+		return m.cmdBreak(args)
+	case "w":
+		return m.cmdWatch(args)
+	case "bd":
+		return m.cmdDelete(args)
+	case "l":
+		return m.cmdLoad(args)
+	case "bs":
+		return m.cmdStepBack(args)
+	case "bg":
+		return m.cmdReverseGo()
+	case "who":
+		return m.cmdWho(args)
+	case "h", "?", "help":
+		fmt.Fprintln(m.out, helpText)
+		return nil
+	case "q", "quit":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command %q, h for help", cmd)
+	}
+}
+
+// cmdStep executes n instructions, stopping early on a watchpoint hit
+func (m *Monitor) cmdStep(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid step count %q", args[0])
+		}
+		n = count
+	}
+	for i := 0; i < n; i++ {
+		if _, hit := m.debugger.Step(); hit != nil {
+			fmt.Fprintln(m.out, hit)
+			break
+		}
+	}
+	m.showState()
+	return nil
+}
+
+// cmdStepOver runs a CALL, RST or repeating block instruction to completion,
+// and steps any other instruction
+func (m *Monitor) cmdStepOver() error {
+	length, over := m.stepsOver(m.cpu.PC)
+	if !over {
+		return m.cmdStep(nil)
+	}
+	next, sp := m.cpu.PC+uint16(length), m.cpu.SP
+	m.run(func(cpu *z80.CPU) bool {
+		return cpu.PC == next && cpu.SP >= sp
+	})
+	return nil
+}
+
+// cmdStepOut runs until a return leaves the current subroutine
+func (m *Monitor) cmdStepOut() error {
+	sp := m.cpu.SP
+	returning := m.isReturn(m.cpu.PC)
+	m.run(func(cpu *z80.CPU) bool {
+		if returning && cpu.SP > sp {
+			return true
+		}
+		returning = m.isReturn(cpu.PC)
+		return false
+	})
+	return nil
+}
+
+// cmdGo runs until a breakpoint, or until PC reaches the given address
+func (m *Monitor) cmdGo(args []string) error {
+	if len(args) == 0 {
+		m.run(nil)
+		return nil
+	}
+	target, err := parseWord(args[0])
+	if err != nil {
+		return err
+	}
+	m.run(func(cpu *z80.CPU) bool {
+		return cpu.PC == target
+	})
+	return nil
+}
+
+// until is a Breaker that stops when done returns true, and otherwise
+// defers to the debugger breakpoints
+type until struct {
+	*debugger.Debugger
+	done func(cpu *z80.CPU) bool
+}
+
+// Break implements z80.Breaker
+func (u until) Break(cpu *z80.CPU) bool {
+	return u.done(cpu) || u.Debugger.Break(cpu)
+}
+
+// run executes until a breakpoint, done, an interrupt, a HALT that can never
+// end or the run limit, then shows where the CPU stopped
+func (m *Monitor) run(done func(cpu *z80.CPU) bool) {
+	if done != nil {
+		m.cpu.Breaker = until{m.debugger, done}
+		defer func() { m.cpu.Breaker = m.debugger }()
+	}
+
+	// Only the first slice resumes past a breakpoint at PC; the next ones
+	// stop at a breakpoint right at their start
+	execute := m.debugger.Continue
+	for total := 0; ; execute = m.debugger.Run {
+		used, reason, hit := execute(runSlice)
+		total += used
+		if reason == z80.StopBudget {
+			if m.cpu.HALT && !m.cpu.IFF1 {
+				fmt.Fprintln(m.out, "halted with interrupts disabled")
+			} else if total < m.Limit {
+				continue
+			} else {
+				fmt.Fprintf(m.out, "stopped after %d T-states\n", total)
+			}
+		}
+		if reason == z80.StopRequested {
+			fmt.Fprintln(m.out, "interrupted")
+		}
+		if hit != nil {
+			fmt.Fprintln(m.out, hit)
+		}
+		break
+	}
+	m.showState()
+}
+
+// stepsOver reports the length of the instruction at address and whether
+// step over should run it to completion instead of stepping into it
+func (m *Monitor) stepsOver(address uint16) (int, bool) {
+	op := m.memory.ReadByte(address)
+	switch {
+	case op == 0xCD, op&0xC7 == 0xC4: // CALL nn, CALL cc,nn
+		return 3, true
+	case op&0xC7 == 0xC7: // RST p
+		return 1, true
+	case op == 0xED:
+		next := m.memory.ReadByte(address + 1)
+		// LDIR, CPIR, INIR, OTIR, LDDR, CPDR, INDR, OTDR
+		return 2, next&0xF4 == 0xB0
+	}
+	return 0, false
+}
+
+// isReturn reports whether the instruction at address is RET, RET cc,
+// RETN or RETI
+func (m *Monitor) isReturn(address uint16) bool {
+	op := m.memory.ReadByte(address)
+	if op == 0xC9 || op&0xC7 == 0xC0 {
+		return true
+	}
+	return op == 0xED && m.memory.ReadByte(address+1)&0xC7 == 0x45
+}
+
+// showState prints the registers and the next instruction
+func (m *Monitor) showState() {
+	cpu := m.cpu
+	fmt.Fprintf(m.out, "AF=%04X BC=%04X DE=%04X HL=%04X IX=%04X IY=%04X SP=%04X PC=%04X\n",
+		cpu.GetAF(), cpu.GetBC(), cpu.GetDE(), cpu.GetHL(), cpu.IX, cpu.IY, cpu.SP, cpu.PC)
+	fmt.Fprintf(m.out, "AF'=%04X BC'=%04X DE'=%04X HL'=%04X I=%02X R=%02X IM=%d IFF=%d%d %s T=%d\n",
+		cpu.GetAF_(), cpu.GetBC_(), cpu.GetDE_(), cpu.GetHL_(), cpu.I, cpu.R, cpu.IM,
+		boolToInt(cpu.IFF1), boolToInt(cpu.IFF2), flagString(cpu.F), cpu.TotalTStates)
+	if cpu.HALT {
+		fmt.Fprintln(m.out, "HALT")
+	}
+	m.disassemble(cpu.PC, 1)
+}
+
+// flagString shows the flags as SZ5H3PNC with clear bits as dashes
+func flagString(f byte) string {
+	const names = "SZ5H3PNC"
+	var sb strings.Builder
+	for i := range 8 {
+		if f&(0x80>>i) != 0 {
+			sb.WriteByte(names[i])
+		} else {
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// cmdRegisters shows the registers or assigns them from reg=value pairs
+func (m *Monitor) cmdRegisters(args []string) error {
+	for _, arg := range args {
+		name, text, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("expected reg=value, got %q", arg)
+		}
+		value, err := parseNumber(text)
+		if err != nil {
+			return err
+		}
+		if err := m.setRegister(strings.ToUpper(name), value); err != nil {
+			return err
+		}
+	}
+	m.showState()
+	return nil
+}
+
+// setRegister assigns a register by name
+func (m *Monitor) setRegister(name string, value int) error {
+	if value > 0xFFFF {
+		return fmt.Errorf("value %X out of range for %s", value, name)
+	}
+	cpu := m.cpu
+	regs8 := map[string]*byte{"A": &cpu.A, "F": &cpu.F, "B": &cpu.B, "C": &cpu.C, "D": &cpu.D,
+		"E": &cpu.E, "H": &cpu.H, "L": &cpu.L, "I": &cpu.I, "R": &cpu.R, "IM": &cpu.IM}
+	regs16 := map[string]*uint16{"IX": &cpu.IX, "IY": &cpu.IY, "SP": &cpu.SP, "PC": &cpu.PC}
+	pairs := map[string]func(uint16){"AF": cpu.SetAF, "BC": cpu.SetBC, "DE": cpu.SetDE, "HL": cpu.SetHL,
+		"AF'": cpu.SetAF_, "BC'": cpu.SetBC_, "DE'": cpu.SetDE_, "HL'": cpu.SetHL_}
+	flags := map[string]*bool{"IFF1": &cpu.IFF1, "IFF2": &cpu.IFF2}
+
+	switch {
+	case regs8[name] != nil:
+		if value > 0xFF || (name == "IM" && value > 2) {
+			return fmt.Errorf("value %X out of range for %s", value, name)
+		}
+		*regs8[name] = byte(value)
+	case regs16[name] != nil:
+		*regs16[name] = uint16(value)
+	case pairs[name] != nil:
+		pairs[name](uint16(value))
+	case flags[name] != nil:
+		*flags[name] = value != 0
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+	return nil
+}
+
+// cmdDump shows memory as hex and ASCII, 16 bytes per line
+func (m *Monitor) cmdDump(args []string) error {
+	start, length := m.dumpNext, 128
+	if len(args) > 0 {
+		address, err := parseWord(args[0])
+		if err != nil {
+			return err
+		}
+		start = address
+	}
+	if len(args) > 1 {
+		n, err := parseNumber(args[1])
+		if err != nil {
+			return err
+		}
+		length = n
+	}
+
+	for offset := 0; offset < length; offset += 16 {
+		line := start + uint16(offset)
+		n := min(16, length-offset)
+		var hex, text strings.Builder
+		for i := range n {
+			b := m.memory.ReadByte(line + uint16(i))
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 0x20 && b < 0x7F {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(m.out, "%04X  %-48s %s\n", line, hex.String(), text.String())
+	}
+	m.dumpNext = start + uint16(length)
+	return nil
+}
+
+// cmdEdit writes bytes and strings to memory
+func (m *Monitor) cmdEdit(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: e addr data...")
+	}
+	address, err := parseWord(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := parseData(args[1:])
+	if err != nil {
+		return err
+	}
+	for i, b := range data {
+		m.memory.WriteByte(address+uint16(i), b)
+	}
+	return nil
+}
+
+// cmdFill repeats a pattern over an inclusive address range
+func (m *Monitor) cmdFill(args []string) error {
+	start, end, data, err := parseRange(args)
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= int(end-start); i++ {
+		m.memory.WriteByte(start+uint16(i), data[i%len(data)])
+	}
+	return nil
+}
+
+// cmdSearch lists every address in an inclusive range where data starts
+func (m *Monitor) cmdSearch(args []string) error {
+	start, end, data, err := parseRange(args)
+	if err != nil {
+		return err
+	}
+	found := 0
+	for i := 0; i <= int(end-start); i++ {
+		address := start + uint16(i)
+		match := true
+		for j, b := range data {
+			if m.memory.ReadByte(address+uint16(j)) != b {
+				match = false
+				break
+			}
+		}
+		if match {
+			fmt.Fprintf(m.out, "%04X\n", address)
+			found++
+		}
+	}
+	if found == 0 {
+		fmt.Fprintln(m.out, "not found")
+	}
+	return nil
+}
+
+// cmdDisassemble lists instructions starting at an address, PC by default
+func (m *Monitor) cmdDisassemble(args []string) error {
+	start, count := m.cpu.PC, 16
+	if m.listValid {
+		start = m.listNext
+	}
+	if len(args) > 0 {
+		address, err := parseWord(args[0])
+		if err != nil {
+			return err
+		}
+		start = address
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid instruction count %q", args[1])
+		}
+		count = n
+	}
+	m.listNext = m.disassemble(start, count)
+	m.listValid = true
+	return nil
+}
+
+// disassemble prints count instructions and returns the address after them
+func (m *Monitor) disassemble(address uint16, count int) uint16 {
+	for range count {
+		data := make([]byte, 4)
+		for i := range data {
+			data[i] = m.memory.ReadByte(address + uint16(i))
+		}
+		mnemonic, length := "", 1
+		inst, err := m.disasm.DecodeAt(data, address)
+		if err == nil && inst.Length > 0 {
+			mnemonic, length = inst.Mnemonic, inst.Length
+		} else {
+			mnemonic = fmt.Sprintf("DB $%02X", data[0])
+		}
+
+		marker := " "
+		for _, b := range m.debugger.Breakpoints() {
+			if b.Kind == debugger.Exec && b.Start == address && b.Enabled {
+				marker = "*"
+			}
+		}
+		fmt.Fprintf(m.out, "%s%04X  %-12s %s\n", marker, address, fmt.Sprintf("% X", data[:length]), mnemonic)
+		address += uint16(length)
+	}
+	return address
+}
+
+// cmdBreak adds an execution breakpoint, or lists all breakpoints
+func (m *Monitor) cmdBreak(args []string) error {
+	if len(args) == 0 {
+		for _, b := range m.debugger.Breakpoints() {
+			fmt.Fprintln(m.out, b)
+		}
+		return nil
+	}
+	address, err := parseWord(args[0])
+	if err != nil {
+		return err
+	}
+	cond, err := parseCondition(args[1:])
+	if err != nil {
+		return err
+	}
+	b, err := m.debugger.AddBreakpoint(address, cond)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(m.out, b)
+	return nil
+}
+
+// watchKinds maps watch command names to watchpoint kinds
+var watchKinds = map[string]debugger.Kind{
+	"read":  debugger.MemRead,
+	"write": debugger.MemWrite,
+	"in":    debugger.PortRead,
+	"out":   debugger.PortWrite,
+}
+
+// cmdWatch adds a memory or port watchpoint
+func (m *Monitor) cmdWatch(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: w read|write|in|out start [end] [if cond]")
+	}
+	kind, ok := watchKinds[strings.ToLower(args[0])]
+	if !ok {
+		return fmt.Errorf("unknown watchpoint kind %q", args[0])
+	}
+	start, err := parseWord(args[1])
+	if err != nil {
+		return err
+	}
+	end, rest := start, args[2:]
+	if len(rest) > 0 && !strings.EqualFold(rest[0], "if") {
+		if end, err = parseWord(rest[0]); err != nil {
+			return err
+		}
+		rest = rest[1:]
+	}
+	cond, err := parseCondition(rest)
+	if err != nil {
+		return err
+	}
+	b, err := m.debugger.AddWatchpoint(kind, start, end, cond)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(m.out, b)
+	return nil
+}
+
+// cmdDelete removes a breakpoint or watchpoint by ID
+func (m *Monitor) cmdDelete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: bd id")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %q", args[0])
+	}
+	return m.debugger.Remove(id)
+}
+
+// cmdLoad loads a raw binary or a .COM file
+func (m *Monitor) cmdLoad(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: l file [addr]")
+	}
+	var address uint16
+	if len(args) == 2 {
+		var err error
+		if address, err = parseWord(args[1]); err != nil {
+			return err
+		}
+	}
+	n, err := m.loadFile(args[0], address)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(m.out, "loaded %d bytes at %04X\n", n, m.cpu.PC)
+	return nil
+}
+
+// splitArgs splits a command line on spaces, keeping "quoted text" together
+// with its quotes so parseData can tell strings from numbers
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			args = append(args, line[:end+2])
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		args = append(args, line[:end])
+		line = line[end:]
+	}
+	return args, nil
+}
+
+// parseNumber parses a hex number written as 1F, $1F, 0x1F or 1Fh
+func parseNumber(text string) (int, error) {
+	digits := strings.TrimPrefix(text, "$")
+	if len(digits) > 2 && (digits[:2] == "0x" || digits[:2] == "0X") {
+		digits = digits[2:]
+	} else if len(digits) > 1 && (strings.HasSuffix(digits, "h") || strings.HasSuffix(digits, "H")) {
+		digits = digits[:len(digits)-1]
+	}
+	value, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	return int(value), nil
+}
+
+// parseWord parses a 16-bit hex number
+func parseWord(text string) (uint16, error) {
+	value, err := parseNumber(text)
+	if err != nil {
+		return 0, err
+	}
+	if value > 0xFFFF {
+		return 0, fmt.Errorf("address %q out of range", text)
+	}
+	return uint16(value), nil
+}
+
+// parseData turns hex bytes and "quoted text" into a byte string
+func parseData(args []string) ([]byte, error) {
+	var data bytes.Buffer
+	for _, arg := range args {
+		if strings.HasPrefix(arg, `"`) {
+			data.WriteString(arg[1 : len(arg)-1])
+			continue
+		}
+		value, err := parseNumber(arg)
+		if err != nil {
+			return nil, err
+		}
+		if value > 0xFF {
+			return nil, fmt.Errorf("byte %q out of range", arg)
+		}
+		data.WriteByte(byte(value))
+	}
+	if data.Len() == 0 {
+		return nil, fmt.Errorf("no data")
+	}
+	return data.Bytes(), nil
+}
+
+// parseRange parses the start end data... arguments of f and sr
+func parseRange(args []string) (uint16, uint16, []byte, error) {
+	if len(args) < 3 {
+		return 0, 0, nil, fmt.Errorf("usage: start end data...")
+	}
+	start, err := parseWord(args[0])
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	end, err := parseWord(args[1])
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if end < start {
+		return 0, 0, nil, fmt.Errorf("end %04X before start %04X", end, start)
+	}
+	data, err := parseData(args[2:])
+	return start, end, data, err
+}
+
+// parseCondition joins the words after "if" into a condition
+func parseCondition(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	if !strings.EqualFold(args[0], "if") || len(args) == 1 {
+		return "", fmt.Errorf("expected if cond, got %q", strings.Join(args, " "))
+	}
+	return strings.Join(args[1:], " "), nil
+}
+
+// errNoHistory is returned by the reverse commands without a history
+var errNoHistory = fmt.Errorf("no history, start z80mon with -history")
+
+// cmdStepBack undoes n instructions, stopping early on a write watchpoint
+func (m *Monitor) cmdStepBack(args []string) error {
+	if m.history == nil {
+		return errNoHistory
+	}
+	n := 1
+	if len(args) > 0 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid step count %q", args[0])
+		}
+		n = count
+	}
+	for i := 0; i < n; i++ {
+		hit, ok := m.debugger.ReverseStep(m.history)
+		if !ok {
+			fmt.Fprintln(m.out, "start of history")
+			break
+		}
+		if hit != nil {
+			fmt.Fprintln(m.out, hit)
+			break
+		}
+	}
+	m.showState()
+	return nil
+}
+
+// cmdReverseGo runs backwards to a breakpoint or write watchpoint
+func (m *Monitor) cmdReverseGo() error {
+	if m.history == nil {
+		return errNoHistory
+	}
+	steps, hit := m.debugger.ReverseRun(m.history)
+	if hit != nil {
+		fmt.Fprintln(m.out, hit)
+	} else {
+		fmt.Fprintln(m.out, "start of history")
+	}
+	fmt.Fprintf(m.out, "%d instructions back\n", steps)
+	m.showState()
+	return nil
+}
+
+// cmdWho shows the last recorded instruction that wrote an address
+func (m *Monitor) cmdWho(args []string) error {
+	if m.history == nil {
+		return errNoHistory
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: who addr")
+	}
+	address, err := parseWord(args[0])
+	if err != nil {
+		return err
+	}
+	pc, ago, value, ok := m.history.LastWrite(address)
+	if !ok {
+		fmt.Fprintf(m.out, "no recorded write to %04X\n", address)
+		return nil
+	}
+	fmt.Fprintf(m.out, "%04X=%02X written %d instructions ago by\n", address, value, ago)
+	m.disassemble(pc, 1)
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMonitor returns a monitor writing into a buffer.
+func testMonitor(t *testing.T, commands ...string) (*Monitor, *bytes.Buffer) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	m := NewMonitor(out)
+	m.Limit = 100000
+	for _, c := range commands {
+		if err := m.Execute(c); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	return m, out
+}
+
+func assertEq[T comparable](t *testing.T, got, want T, msg string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %v, want %v", msg, got, want)
+	}
+}
+
+// program is a main routine calling a subroutine at 0010:
+//
+//	0000 LD A,01; CALL 0010; LD B,A; HALT
+//	0010 INC A; RST 18h; RET
+//	0018 INC A; RET
+var program = "e 0 3E 01 CD 10 00 47 76"
+
+func TestStepOverAndOut(t *testing.T) {
+	m, _ := testMonitor(t, program, "e 10 3C DF C9", "e 18 3C C9")
+
+	m.Execute("s")
+	assertEq(t, m.cpu.PC, uint16(0x0002), "PC after step")
+
+	m.Execute("n")
+	assertEq(t, m.cpu.PC, uint16(0x0005), "PC after step over CALL")
+	assertEq(t, m.cpu.A, byte(3), "A after step over")
+
+	m.Execute("r pc=2 a=1 sp=ffff")
+	m.Execute("s 2")
+	assertEq(t, m.cpu.PC, uint16(0x0011), "PC inside subroutine")
+	m.Execute("n")
+	assertEq(t, m.cpu.PC, uint16(0x0012), "PC after step over RST")
+	m.Execute("r pc=11")
+	m.Execute("s")
+	assertEq(t, m.cpu.PC, uint16(0x0018), "PC inside RST")
+	m.Execute("o")
+	assertEq(t, m.cpu.PC, uint16(0x0012), "PC after step out")
+	assertEq(t, m.cpu.SP, uint16(0xFFFD), "SP after step out")
+}
+
+func TestGo(t *testing.T) {
+	m, out := testMonitor(t, program, "e 10 3C DF C9", "e 18 3C C9")
+
+	m.Execute("g 18")
+	assertEq(t, m.cpu.PC, uint16(0x0018), "PC after run to")
+
+	m.Execute("b 5 if A==3")
+	m.Execute("g")
+	assertEq(t, m.cpu.PC, uint16(0x0005), "PC at breakpoint")
+	if !strings.Contains(out.String(), "breakpoint #1 at 0005") {
+		t.Errorf("breakpoint hit not reported:\n%s", out)
+	}
+
+	m.Execute("g")
+	assertEq(t, m.cpu.HALT, true, "halted")
+	if !strings.Contains(out.String(), "halted with interrupts disabled") {
+		t.Errorf("halt not reported:\n%s", out)
+	}
+}
+
+// Breakpoints and run-to targets reached exactly at the end of a run slice
+// still stop.
+func TestGoSliceBoundary(t *testing.T) {
+	boundary := uint16(runSlice / 4) // Memory is all NOPs
+	m, out := testMonitor(t, fmt.Sprintf("b %X", boundary))
+	m.Limit = 10 * runSlice
+
+	m.Execute("g")
+	assertEq(t, m.cpu.PC, boundary, "PC at breakpoint")
+	if !strings.Contains(out.String(), "breakpoint #1") {
+		t.Errorf("breakpoint hit not reported:\n%s", out)
+	}
+
+	m.Execute("bd 1")
+	m.Execute("r pc=0")
+	m.Execute(fmt.Sprintf("g %X", boundary))
+	assertEq(t, m.cpu.PC, boundary, "PC after run to")
+}
+
+func TestWatch(t *testing.T) {
+	// LD (8000),A; OUT (FE),A; NOP
+	m, out := testMonitor(t, "e 0 32 00 80 D3 FE 00", "r a=7", "w write 8000", "w out 0 ffff if (ADDR & 0xFF) == 0xFE")
+
+	m.Execute("g")
+	assertEq(t, m.cpu.PC, uint16(0x0003), "PC after write watch")
+	m.Execute("g")
+	assertEq(t, m.cpu.PC, uint16(0x0005), "PC after port watch")
+	if !strings.Contains(out.String(), "watchpoint #2 out 07FE=07 at 0003") {
+		t.Errorf("port watch not reported:\n%s", out)
+	}
+
+	out.Reset()
+	m.Execute("b")
+	assertEq(t, out.String(), "#1 write 8000 hits=1\n#2 out 0000-FFFF if (ADDR & 0xFF) == 0xFE hits=1\n", "list")
+	if err := m.Execute("bd 1"); err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, len(m.debugger.Breakpoints()), 1, "breakpoints after delete")
+}
+
+func TestReverse(t *testing.T) {
+	// 0000 INC A; LD (8000),A; JR -6
+	m, out := testMonitor(t, "e 0 3C 32 00 80 18 FA")
+	if err := m.Execute("bs"); err != errNoHistory {
+		t.Errorf("bs without history: %v", err)
+	}
+	m.EnableHistory(100)
+
+	m.Execute("s 9")
+	assertEq(t, m.cpu.A, byte(3), "A after three loops")
+	m.Execute("bs 2")
+	assertEq(t, m.cpu.PC, uint16(0x0001), "PC after step back")
+	assertEq(t, m.memory.ReadByte(0x8000), byte(2), "memory after step back")
+
+	out.Reset()
+	m.Execute("who 8000")
+	if !strings.Contains(out.String(), "8000=02 written 3 instructions ago") {
+		t.Errorf("who not reported:\n%s", out)
+	}
+
+	m.Execute("w write 8000 if VALUE==1")
+	m.Execute("bg")
+	assertEq(t, m.cpu.PC, uint16(0x0001), "PC at reverse watch")
+	assertEq(t, m.cpu.A, byte(1), "A at reverse watch")
+	if !strings.Contains(out.String(), "watchpoint #1 write 8000=01 at 0001") {
+		t.Errorf("reverse watch not reported:\n%s", out)
+	}
+	assertEq(t, m.memory.ReadByte(0x8000), byte(0), "memory restored")
+
+	m.Execute("bg")
+	if !strings.Contains(out.String(), "start of history") {
+		t.Errorf("start of history not reported:\n%s", out)
+	}
+	assertEq(t, m.cpu.PC, uint16(0x0000), "PC at start of history")
+}
+
+func TestMemoryCommands(t *testing.T) {
+	m, out := testMonitor(t, `e 100 "HELLO" 0D 0A`, "f 200 20f AA 55")
+
+	out.Reset()
+	m.Execute("d 100 8")
+	assertEq(t, out.String(), "0100  48 45 4C 4C 4F 0D 0A 00                          HELLO...\n", "dump")
+	assertEq(t, m.memory.ReadByte(0x20E), byte(0xAA), "fill")
+	assertEq(t, m.memory.ReadByte(0x20F), byte(0x55), "fill")
+
+	out.Reset()
+	m.Execute(`sr 0 ffff "LL"`)
+	assertEq(t, out.String(), "0102\n", "search")
+	out.Reset()
+	m.Execute("sr 0 ffff 55 AA")
+	assertEq(t, strings.Count(out.String(), "\n"), 7, "pattern matches")
+}
+
+func TestDisassemble(t *testing.T) {
+	m, out := testMonitor(t, program, "b 5")
+	out.Reset()
+	m.Execute("u 0 4")
+	want := " 0000  3E 01        LD A, $01\n" +
+		" 0002  CD 10 00     CALL $0010\n" +
+		"*0005  47           LD B, A\n" +
+		" 0006  76           HALT\n"
+	assertEq(t, out.String(), want, "listing")
+
+	out.Reset()
+	m.Execute("u")
+	if !strings.HasPrefix(out.String(), " 0007") {
+		t.Errorf("listing does not continue:\n%s", out)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	m, out := testMonitor(t, "r hl=1234 bc'=5678 f=ff iff1=1 im=2")
+	assertEq(t, m.cpu.GetHL(), uint16(0x1234), "HL")
+	assertEq(t, m.cpu.GetBC_(), uint16(0x5678), "BC'")
+	assertEq(t, m.cpu.IFF1, true, "IFF1")
+	if !strings.Contains(out.String(), "SZ5H3PNC") {
+		t.Errorf("flags not shown:\n%s", out)
+	}
+
+	for _, bad := range []string{"r a=100", "r xx=1", "r im=3", "r a"} {
+		if err := m.Execute(bad); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+// .COM files run under the CP/M stub, which prints through BDOS and halts
+// on warm boot.
+func TestLoadCOM(t *testing.T) {
+	// LD DE,0109; LD C,9; CALL 5; RET; "Hi$"
+	code := []byte{0x11, 0x09, 0x01, 0x0E, 0x09, 0xCD, 0x05, 0x00, 0xC9, 'H', 'i', '$'}
+	file := filepath.Join(t.TempDir(), "hi.com")
+	if err := os.WriteFile(file, code, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, out := testMonitor(t, "l "+file)
+	assertEq(t, m.cpu.PC, uint16(0x0100), "PC")
+	m.Execute("g")
+	if !strings.Contains(out.String(), "Hi") {
+		t.Errorf("BDOS output missing:\n%s", out)
+	}
+	assertEq(t, m.cpu.PC, uint16(0x0000), "PC after warm boot")
+	assertEq(t, m.cpu.HALT, true, "halted after warm boot")
+}
+
+// BDOS 9 without a '$' anywhere stops after printing all of memory once.
+func TestBDOSPrintUnterminated(t *testing.T) {
+	m, out := testMonitor(t)
+	m.console.CPM = true
+	m.cpu.C = 9
+	m.cpu.SetDE(0x8000)
+	m.console.WritePort(bdosPort, 0)
+	assertEq(t, out.Len(), 0x10000, "bytes printed")
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`e 100  "a b" 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, strings.Join(args, "|"), `e|100|"a b"|1`, "args")
+	if _, err := splitArgs(`e 0 "open`); err == nil {
+		t.Errorf("unterminated string accepted")
+	}
+}
@@ -0,0 +1,210 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// protoBuffer encodes the protocol buffer messages of a pprof profile
+type protoBuffer struct {
+	data []byte
+}
+
+// varint appends a base 128 varint
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// uint64 appends a varint field, omitted when zero
+func (b *protoBuffer) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(tag) << 3)
+	b.varint(x)
+}
+
+// bool appends a boolean field, omitted when false
+func (b *protoBuffer) bool(tag int, x bool) {
+	if x {
+		b.uint64(tag, 1)
+	}
+}
+
+// packed appends a packed repeated varint field
+func (b *protoBuffer) packed(tag int, xs []uint64) {
+	var inner protoBuffer
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.bytes(tag, inner.data)
+}
+
+// bytes appends a length-delimited field
+func (b *protoBuffer) bytes(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// message appends an embedded message built by fill
+func (b *protoBuffer) message(tag int, fill func(m *protoBuffer)) {
+	var m protoBuffer
+	fill(&m)
+	b.bytes(tag, m.data)
+}
+
+// Field numbers of profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileMapping       = 3
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	profileDefaultSample = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID           = 1
+	mappingMemoryStart  = 2
+	mappingMemoryLimit  = 3
+	mappingFilename     = 5
+	mappingHasFunctions = 7
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionStartLine  = 5
+)
+
+// pprofLocation is a PC inside a subroutine context
+type pprofLocation struct {
+	pc       uint16
+	function int // Context whose subroutine contains the PC
+}
+
+// WritePprof writes a gzipped pprof profile with instruction counts and
+// T-states per call stack, for go tool pprof. Each subroutine becomes a
+// function named like in the call graph, and each PC a location in it.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	strings := []string{""}
+	stringIndex := map[string]uint64{"": 0}
+	str := func(s string) uint64 {
+		i, ok := stringIndex[s]
+		if !ok {
+			i = uint64(len(strings))
+			strings = append(strings, s)
+			stringIndex[s] = i
+		}
+		return i
+	}
+
+	var b protoBuffer
+	valueType := func(tag int, typ, unit string) {
+		b.message(tag, func(m *protoBuffer) {
+			m.uint64(valueTypeType, str(typ))
+			m.uint64(valueTypeUnit, str(unit))
+		})
+	}
+	valueType(profileSampleType, "instructions", "count")
+	valueType(profileSampleType, "tstates", "count")
+
+	// Functions are numbered by name, so contexts of one subroutine share one
+	functionIDs := make(map[string]uint64)
+	var functionOrder []int
+	functionOf := func(context int) uint64 {
+		name := p.contextName(context)
+		id, ok := functionIDs[name]
+		if !ok {
+			id = uint64(len(functionIDs) + 1)
+			functionIDs[name] = id
+			functionOrder = append(functionOrder, context)
+		}
+		return id
+	}
+	locationIDs := make(map[pprofLocation]uint64)
+	var locations []pprofLocation
+	locationOf := func(pc uint16, context int) uint64 {
+		l := pprofLocation{pc: pc, function: int(functionOf(context))}
+		id, ok := locationIDs[l]
+		if !ok {
+			id = uint64(len(locations) + 1)
+			locationIDs[l] = id
+			locations = append(locations, l)
+		}
+		return id
+	}
+
+	for key, cost := range p.samples {
+		stack := []uint64{locationOf(key.pc, key.context)}
+		for i := key.context; i > 0; i = p.contexts[i].parent {
+			c := p.contexts[i]
+			stack = append(stack, locationOf(c.site, c.parent))
+		}
+		b.message(profileSample, func(m *protoBuffer) {
+			m.packed(sampleLocationID, stack)
+			m.packed(sampleValue, []uint64{uint64(cost.count), uint64(cost.tstates)})
+		})
+	}
+
+	b.message(profileMapping, func(m *protoBuffer) {
+		m.uint64(mappingID, 1)
+		m.uint64(mappingMemoryStart, 0)
+		m.uint64(mappingMemoryLimit, 0x10000)
+		m.uint64(mappingFilename, str("z80"))
+		m.bool(mappingHasFunctions, true)
+	})
+	for i, l := range locations {
+		b.message(profileLocation, func(m *protoBuffer) {
+			m.uint64(locationID, uint64(i+1))
+			m.uint64(locationMappingID, 1)
+			m.uint64(locationAddress, uint64(l.pc))
+			m.message(locationLine, func(line *protoBuffer) {
+				line.uint64(lineFunctionID, uint64(l.function))
+			})
+		})
+	}
+	for i, context := range functionOrder {
+		name := str(p.contextName(context))
+		b.message(profileFunction, func(m *protoBuffer) {
+			m.uint64(functionID, uint64(i+1))
+			m.uint64(functionName, name)
+			m.uint64(functionSystemName, name)
+			m.uint64(functionStartLine, uint64(p.contexts[context].entry))
+		})
+	}
+
+	b.uint64(profileTimeNanos, uint64(time.Now().UnixNano()))
+	valueType(profilePeriodType, "tstates", "count")
+	b.uint64(profilePeriod, 1)
+	b.uint64(profileDefaultSample, str("tstates"))
+	for _, s := range strings {
+		b.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,176 @@
+// Package profiler attributes the T-states a z80.CPU spends to the addresses
+// and subroutines that spent them, for hot-spot and call-graph reports and
+// pprof profiles.
+package profiler
+
+import "github.com/kiltum/emuz80/z80"
+
+// Profiler is a z80.Tracer that counts instructions and T-states per PC and
+// per call context. It follows CALL, RST and interrupt entries on a shadow
+// stack; a frame ends when SP rises above the return address it pushed, so
+// RET, RETI, RETN and code that discards its return address all end it.
+type Profiler struct {
+	Symbols map[uint16]string // Optional names for subroutine entry addresses
+	Next    z80.Tracer        // Optional tracer that keeps receiving every step
+
+	cpu      *z80.CPU
+	spots    []spot              // Per PC, indexed by address
+	contexts []context           // Call contexts, 0 is the root
+	children map[childKey]int    // Context index by parent, call site and entry
+	samples  map[sampleKey]*cost // Cost per context and PC
+	stack    []frame
+	total    cost
+
+	// State of the step in progress
+	kind z80.StepKind
+	pc   uint16
+	sp   uint16
+	call bool
+}
+
+// cost is what a step or group of steps spent
+type cost struct {
+	count   int64
+	tstates int64
+}
+
+// add accumulates one step
+func (c *cost) add(tstates int) {
+	c.count++
+	c.tstates += int64(tstates)
+}
+
+// spot is the cost of one address and the opcode seen there first
+type spot struct {
+	cost
+	code [4]byte
+}
+
+// context is one chain of calls from the root down to a subroutine
+type context struct {
+	parent   int    // Context of the caller, -1 for the root
+	site     uint16 // PC of the call, or of the interrupted instruction
+	entry    uint16 // Address called
+	calls    int64  // Times this context was entered
+	external bool   // Entered by an interrupt or NMI
+}
+
+// childKey finds a context from its parent
+type childKey struct {
+	parent      int
+	site, entry uint16
+}
+
+// sampleKey identifies the cost of a PC in a context
+type sampleKey struct {
+	context int
+	pc      uint16
+}
+
+// frame is an active call on the shadow stack
+type frame struct {
+	context int
+	sp      uint16 // SP right after the return address was pushed
+}
+
+// New starts profiling the CPU. It installs itself as cpu.Tracer, chaining
+// the tracer that was attached before. The root context begins at the
+// current PC.
+func New(cpu *z80.CPU) *Profiler {
+	p := &Profiler{Next: cpu.Tracer, cpu: cpu}
+	p.Reset()
+	cpu.Tracer = p
+	return p
+}
+
+// Detach stops profiling and restores the original tracer
+func (p *Profiler) Detach() {
+	if p.cpu.Tracer == p {
+		p.cpu.Tracer = p.Next
+	}
+}
+
+// Reset discards everything collected so far
+func (p *Profiler) Reset() {
+	p.spots = make([]spot, 0x10000)
+	p.contexts = []context{{parent: -1, entry: p.cpu.PC, calls: 1}}
+	p.children = make(map[childKey]int)
+	p.samples = make(map[sampleKey]*cost)
+	p.stack = []frame{{context: 0}}
+	p.total = cost{}
+}
+
+// isCall reports whether an opcode is CALL nn, CALL cc,nn or RST p
+func isCall(opcode byte) bool {
+	return opcode == 0xCD || opcode&0xC7 == 0xC4 || opcode&0xC7 == 0xC7
+}
+
+// BeforeStep implements z80.Tracer
+func (p *Profiler) BeforeStep(cpu *z80.CPU, kind z80.StepKind) {
+	p.kind, p.pc, p.sp = kind, cpu.PC, cpu.SP
+	p.call = kind != z80.StepInstruction
+	if kind == z80.StepInstruction {
+		s := &p.spots[cpu.PC]
+		if s.count == 0 {
+			for i := range s.code {
+				s.code[i] = cpu.Memory.ReadByte(cpu.PC + uint16(i))
+			}
+		}
+		p.call = isCall(cpu.Memory.ReadByte(cpu.PC))
+	}
+	if p.Next != nil {
+		p.Next.BeforeStep(cpu, kind)
+	}
+}
+
+// AfterStep implements z80.Tracer
+func (p *Profiler) AfterStep(cpu *z80.CPU, tstates int) {
+	top := p.stack[len(p.stack)-1]
+	if p.kind == z80.StepInstruction {
+		p.spots[p.pc].add(tstates)
+	}
+	key := sampleKey{context: top.context, pc: p.pc}
+	c := p.samples[key]
+	if c == nil {
+		c = &cost{}
+		p.samples[key] = c
+	}
+	c.add(tstates)
+	p.total.add(tstates)
+
+	// A taken call or an accepted interrupt pushed exactly one return address
+	if p.call && cpu.SP == p.sp-2 {
+		p.enter(top.context, cpu.PC, cpu.SP)
+	}
+	// Compared modulo 64K, since a stack set with LD SP,0 wraps
+	for len(p.stack) > 1 && int16(cpu.SP-p.stack[len(p.stack)-1].sp) > 0 {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	if p.Next != nil {
+		p.Next.AfterStep(cpu, tstates)
+	}
+}
+
+// enter pushes a frame for a call from the parent context to entry
+func (p *Profiler) enter(parent int, entry, sp uint16) {
+	key := childKey{parent: parent, site: p.pc, entry: entry}
+	i, ok := p.children[key]
+	if !ok {
+		i = len(p.contexts)
+		p.contexts = append(p.contexts, context{parent: parent, site: p.pc, entry: entry,
+			external: p.kind != z80.StepInstruction})
+		p.children[key] = i
+	}
+	p.contexts[i].calls++
+	p.stack = append(p.stack, frame{context: i, sp: sp})
+}
+
+// Total returns the number of steps and T-states profiled
+func (p *Profiler) Total() (steps, tstates int64) {
+	return p.total.count, p.total.tstates
+}
+
+// Depth returns the number of active calls on the shadow stack
+func (p *Profiler) Depth() int {
+	return len(p.stack) - 1
+}
@@ -0,0 +1,169 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// ram is a flat 64K memory.
+type ram [65536]byte
+
+func (m *ram) ReadByte(address uint16) byte         { return m[address] }
+func (m *ram) WriteByte(address uint16, value byte) { m[address] = value }
+func (m *ram) ReadWord(address uint16) uint16 {
+	return uint16(m[address]) | uint16(m[address+1])<<8
+}
+func (m *ram) WriteWord(address uint16, value uint16) {
+	m[address] = byte(value)
+	m[address+1] = byte(value >> 8)
+}
+
+// ports raises an interrupt on request.
+type ports struct {
+	interrupt bool
+}
+
+func (p *ports) ReadPort(port uint16) byte         { return 0xFF }
+func (p *ports) WritePort(port uint16, value byte) {}
+func (p *ports) CheckInterrupt() bool              { return p.interrupt }
+
+func assertEq[T comparable](t *testing.T, got, want T, msg string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %v, want %v", msg, got, want)
+	}
+}
+
+// testCPU runs a program calling three levels deep three times:
+//
+//	0000 LD SP,0; LD B,3; loop: CALL 0010; DJNZ loop; IM 1; EI; HALT
+//	0010 CALL 0020; RET
+//	0020 RST 28h; RET
+//	0028 NOP; RET
+//	0038 EI; RETI
+func testCPU(t *testing.T) (*z80.CPU, *Profiler, *ports) {
+	t.Helper()
+	mem := &ram{}
+	copy(mem[:], []byte{0x31, 0x00, 0x00, 0x06, 0x03, 0xCD, 0x10, 0x00, 0x10, 0xFB, 0xED, 0x56, 0xFB, 0x76})
+	copy(mem[0x10:], []byte{0xCD, 0x20, 0x00, 0xC9})
+	copy(mem[0x20:], []byte{0xEF, 0xC9})
+	copy(mem[0x28:], []byte{0x00, 0xC9})
+	copy(mem[0x38:], []byte{0xFB, 0xED, 0x4D})
+	dev := &ports{}
+	cpu := z80.New(mem, dev)
+	p := New(cpu)
+	for !cpu.HALT {
+		cpu.ExecuteOneInstruction()
+	}
+	return cpu, p, dev
+}
+
+func TestCallGraph(t *testing.T) {
+	_, p, _ := testCPU(t)
+	assertEq(t, p.Depth(), 0, "depth after returning")
+
+	functions := make(map[string]Function)
+	for _, f := range p.Functions() {
+		functions[f.Name] = f
+	}
+	_, total := p.Total()
+	assertEq(t, functions["root"].Inclusive, total, "root inclusive")
+	assertEq(t, functions["sub_0010"].Calls, int64(3), "sub_0010 calls")
+	assertEq(t, functions["sub_0010"].Exclusive, int64(3*(17+10)), "sub_0010 exclusive")
+	assertEq(t, functions["sub_0010"].Inclusive, int64(3*(17+10+11+10+4+10)), "sub_0010 inclusive")
+	assertEq(t, functions["sub_0020"].Inclusive, int64(3*(11+10+4+10)), "sub_0020 inclusive")
+	assertEq(t, functions["sub_0028"].Exclusive, int64(3*(4+10)), "sub_0028 exclusive")
+
+	edges := p.Edges()
+	assertEq(t, len(edges), 3, "edges")
+	assertEq(t, edges[0], Edge{Caller: 0x0000, Callee: 0x0010, Calls: 3, TStates: 186}, "first edge")
+	assertEq(t, edges[2], Edge{Caller: 0x0020, Callee: 0x0028, Calls: 3, TStates: 42}, "last edge")
+
+	var out bytes.Buffer
+	if err := p.WriteCallGraph(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"sub_0010  0010  incl 186", "    <- root         calls 3", "    -> sub_0028     calls 3  tstates 42"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("call graph lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHotSpots(t *testing.T) {
+	_, p, _ := testCPU(t)
+	spots := p.HotSpots()
+	assertEq(t, spots[0], HotSpot{Address: 0x0005, Mnemonic: "CALL $0010", Count: 3, TStates: 51}, "hottest")
+
+	var out bytes.Buffer
+	if err := p.WriteHotSpots(&out, 2); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assertEq(t, len(lines), 3, "lines")
+	assertEq(t, lines[1], "        51  16.78%          3  0005  CALL $0010", "first line")
+}
+
+func TestInterrupt(t *testing.T) {
+	cpu, p, dev := testCPU(t)
+	p.Symbols = map[uint16]string{0x0038: "isr"}
+
+	dev.interrupt = true
+	cpu.ExecuteOneInstruction()
+	dev.interrupt = false
+	assertEq(t, cpu.PC, uint16(0x0038), "PC in handler")
+	assertEq(t, p.Depth(), 1, "depth in handler")
+	cpu.ExecuteOneInstruction()
+	cpu.ExecuteOneInstruction()
+	assertEq(t, p.Depth(), 0, "depth after RETI")
+
+	for _, f := range p.Functions() {
+		if f.Name == "isr" {
+			assertEq(t, f.Calls, int64(1), "isr calls")
+			assertEq(t, f.Exclusive, int64(4+14), "isr exclusive")
+			return
+		}
+	}
+	t.Errorf("no isr function in %v", p.Functions())
+}
+
+func TestDetach(t *testing.T) {
+	cpu, p, _ := testCPU(t)
+	steps, _ := p.Total()
+	p.Detach()
+	assertEq(t, cpu.Tracer, z80.Tracer(nil), "tracer after detach")
+	cpu.ExecuteOneInstruction()
+	after, _ := p.Total()
+	assertEq(t, after, steps, "steps after detach")
+
+	p.Reset()
+	after, _ = p.Total()
+	assertEq(t, after, int64(0), "steps after reset")
+	assertEq(t, len(p.HotSpots()), 0, "hot spots after reset")
+}
+
+func TestPprof(t *testing.T) {
+	_, p, _ := testCPU(t)
+	var out bytes.Buffer
+	if err := p.WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tstates", "root", "sub_0010", "sub_0020", "sub_0028"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("profile lacks %q", want)
+		}
+	}
+}
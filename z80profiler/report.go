@@ -0,0 +1,233 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// HotSpot is the cost of one instruction address
+type HotSpot struct {
+	Address  uint16
+	Mnemonic string // Instruction first executed at the address
+	Count    int64  // Times executed
+	TStates  int64
+}
+
+// HotSpots returns every executed address, most T-states first
+func (p *Profiler) HotSpots() []HotSpot {
+	d := disasm.New()
+	var spots []HotSpot
+	for address := range p.spots {
+		s := &p.spots[address]
+		if s.count == 0 {
+			continue
+		}
+		mnemonic := fmt.Sprintf("DB $%02X", s.code[0])
+		if inst, err := d.Decode(s.code[:]); err == nil && inst.Length > 0 {
+			mnemonic = inst.Mnemonic
+		}
+		spots = append(spots, HotSpot{Address: uint16(address), Mnemonic: mnemonic, Count: s.count, TStates: s.tstates})
+	}
+	sort.SliceStable(spots, func(i, j int) bool { return spots[i].TStates > spots[j].TStates })
+	return spots
+}
+
+// Function is the cost of a subroutine, summed over every context it ran in
+type Function struct {
+	Address   uint16
+	Name      string
+	Calls     int64
+	Exclusive int64 // T-states spent in the subroutine itself
+	Inclusive int64 // T-states spent in the subroutine and everything it called
+}
+
+// Edge is the cost of the calls from one subroutine to another
+type Edge struct {
+	Caller, Callee uint16
+	Calls          int64
+	TStates        int64 // Inclusive T-states of the callee when called from the caller
+}
+
+// Name returns the symbol for an entry address, or a name made from it
+func (p *Profiler) Name(address uint16) string {
+	if name, ok := p.Symbols[address]; ok {
+		return name
+	}
+	return fmt.Sprintf("sub_%04X", address)
+}
+
+// contextName names the subroutine of a context; the root is not a subroutine
+func (p *Profiler) contextName(i int) string {
+	if i == 0 {
+		return "root"
+	}
+	if c := p.contexts[i]; c.external {
+		if name, ok := p.Symbols[c.entry]; ok {
+			return name
+		}
+		return fmt.Sprintf("int_%04X", c.entry)
+	}
+	return p.Name(p.contexts[i].entry)
+}
+
+// edgeKey identifies an edge of the call graph
+type edgeKey struct {
+	caller, callee uint16
+}
+
+// callGraph sums the samples per subroutine and per edge. Recursive calls
+// count once towards the inclusive cost of each subroutine and edge.
+func (p *Profiler) callGraph() (map[uint16]*Function, map[edgeKey]*Edge) {
+	functions := make(map[uint16]*Function)
+	edges := make(map[edgeKey]*Edge)
+	function := func(i int) *Function {
+		c := p.contexts[i]
+		f := functions[c.entry]
+		if f == nil {
+			f = &Function{Address: c.entry, Name: p.contextName(i)}
+			functions[c.entry] = f
+		}
+		return f
+	}
+	for i, c := range p.contexts {
+		f := function(i)
+		if i == 0 {
+			continue // The root was not called
+		}
+		f.Calls += c.calls
+		caller := p.contexts[c.parent].entry
+		e := edges[edgeKey{caller, c.entry}]
+		if e == nil {
+			e = &Edge{Caller: caller, Callee: c.entry}
+			edges[edgeKey{caller, c.entry}] = e
+		}
+		e.Calls += c.calls
+	}
+
+	seenFunctions := make(map[uint16]bool)
+	seenEdges := make(map[edgeKey]bool)
+	for key, cost := range p.samples {
+		functions[p.contexts[key.context].entry].Exclusive += cost.tstates
+		clear(seenFunctions)
+		clear(seenEdges)
+		for i := key.context; i >= 0; i = p.contexts[i].parent {
+			c := p.contexts[i]
+			if !seenFunctions[c.entry] {
+				seenFunctions[c.entry] = true
+				functions[c.entry].Inclusive += cost.tstates
+			}
+			if c.parent < 0 {
+				continue
+			}
+			k := edgeKey{p.contexts[c.parent].entry, c.entry}
+			if !seenEdges[k] {
+				seenEdges[k] = true
+				edges[k].TStates += cost.tstates
+			}
+		}
+	}
+	return functions, edges
+}
+
+// Functions returns every subroutine entered, most inclusive T-states first.
+// The one named root is the code that was running when profiling started.
+func (p *Profiler) Functions() []Function {
+	functions, _ := p.callGraph()
+	var list []Function
+	for _, f := range functions {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Inclusive != list[j].Inclusive {
+			return list[i].Inclusive > list[j].Inclusive
+		}
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// Edges returns every caller and callee pair, most T-states first
+func (p *Profiler) Edges() []Edge {
+	_, edges := p.callGraph()
+	var list []Edge
+	for _, e := range edges {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].TStates != list[j].TStates {
+			return list[i].TStates > list[j].TStates
+		}
+		if list[i].Caller != list[j].Caller {
+			return list[i].Caller < list[j].Caller
+		}
+		return list[i].Callee < list[j].Callee
+	})
+	return list
+}
+
+// percent returns part as a percentage of the total T-states
+func (p *Profiler) percent(part int64) float64 {
+	if p.total.tstates == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(p.total.tstates)
+}
+
+// WriteHotSpots writes the n most expensive addresses, all if n is zero:
+//
+//	tstates      %      count  addr  instruction
+//	 123456  45.20%      10288  8003  LDIR
+func (p *Profiler) WriteHotSpots(w io.Writer, n int) error {
+	spots := p.HotSpots()
+	if n > 0 && n < len(spots) {
+		spots = spots[:n]
+	}
+	if _, err := fmt.Fprintf(w, "%10s %7s %10s  %-4s  %s\n", "tstates", "%", "count", "addr", "instruction"); err != nil {
+		return err
+	}
+	for _, s := range spots {
+		if _, err := fmt.Fprintf(w, "%10d %6.2f%% %10d  %04X  %s\n", s.TStates, p.percent(s.TStates), s.Count, s.Address, s.Mnemonic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCallGraph writes every subroutine with its inclusive and exclusive
+// cost, followed by its callers (<-) and callees (->):
+//
+//	sub_8000  8000  incl 120000 (90.10%)  excl 2000 (1.50%)  calls 1
+//	    <- root         calls 1
+//	    -> sub_8100     calls 100  tstates 118000
+func (p *Profiler) WriteCallGraph(w io.Writer) error {
+	functions, _ := p.callGraph()
+	names := make(map[uint16]string, len(functions))
+	for address, f := range functions {
+		names[address] = f.Name
+	}
+	list := p.Edges()
+	for _, f := range p.Functions() {
+		if _, err := fmt.Fprintf(w, "%s  %04X  incl %d (%.2f%%)  excl %d (%.2f%%)  calls %d\n", f.Name, f.Address,
+			f.Inclusive, p.percent(f.Inclusive), f.Exclusive, p.percent(f.Exclusive), f.Calls); err != nil {
+			return err
+		}
+		for _, e := range list {
+			if e.Callee == f.Address {
+				if _, err := fmt.Fprintf(w, "    <- %-12s calls %d\n", names[e.Caller], e.Calls); err != nil {
+					return err
+				}
+			}
+		}
+		for _, e := range list {
+			if e.Caller == f.Address {
+				if _, err := fmt.Fprintf(w, "    -> %-12s calls %d  tstates %d\n", names[e.Callee], e.Calls, e.TStates); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// String formats the record as one line of a text trace
+func (r *Record) String() string {
+	return fmt.Sprintf("%04X  %-11s  %-20s %3d  %s", r.PC, hexBytes(r.Bytes), r.Mnemonic, r.TStates, changeString(r.Changes))
+}
+
+// hexBytes formats opcode bytes separated by spaces
+func hexBytes(b []byte) string {
+	return fmt.Sprintf("% X", b)
+}
+
+// changeString formats changes separated by spaces
+func changeString(changes []Change) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// TextWriter writes records one per line:
+//
+//	0100  3E 12        LD A, $12              7  A=12
+type TextWriter struct {
+	w io.Writer
+}
+
+// NewTextWriter creates a text writer
+func NewTextWriter(w io.Writer) *TextWriter {
+	return &TextWriter{w: w}
+}
+
+// Write implements Writer
+func (t *TextWriter) Write(r *Record) error {
+	_, err := fmt.Fprintln(t.w, strings.TrimRight(r.String(), " "))
+	return err
+}
+
+// csvHeader names the CSV columns
+var csvHeader = []string{"kind", "pc", "bytes", "mnemonic", "class", "tstates", "changes"}
+
+// CSVWriter writes records as CSV with a header row. Flush must be called
+// when tracing is done.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVWriter creates a CSV writer
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// Write implements Writer
+func (c *CSVWriter) Write(r *Record) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{
+		r.Kind.String(),
+		fmt.Sprintf("%04X", r.PC),
+		hexBytes(r.Bytes),
+		r.Mnemonic,
+		r.Class.String(),
+		strconv.Itoa(r.TStates),
+		changeString(r.Changes),
+	})
+}
+
+// Flush writes any buffered rows
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// binaryMagic starts a binary trace, followed by a version byte
+const binaryMagic = "Z80T"
+
+// binaryVersion is the version of the binary trace format
+const binaryVersion = 1
+
+// BinaryWriter writes records in a compact binary format readable with
+// BinaryReader. Each record is the step kind, PC (little-endian), the
+// number of opcode bytes and the bytes, T-states as a uvarint, the number of
+// changes and for each change a register index, old and new value. The
+// mnemonic and class are rebuilt from the bytes when reading.
+type BinaryWriter struct {
+	w      io.Writer
+	header bool
+	buf    []byte
+}
+
+// NewBinaryWriter creates a binary writer
+func NewBinaryWriter(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{w: w}
+}
+
+// Write implements Writer
+func (b *BinaryWriter) Write(r *Record) error {
+	buf := b.buf[:0]
+	if !b.header {
+		b.header = true
+		buf = append(buf, binaryMagic...)
+		buf = append(buf, binaryVersion)
+	}
+	buf = append(buf, byte(r.Kind))
+	buf = binary.LittleEndian.AppendUint16(buf, r.PC)
+	buf = append(buf, byte(len(r.Bytes)))
+	buf = append(buf, r.Bytes...)
+	buf = binary.AppendUvarint(buf, uint64(r.TStates))
+	buf = append(buf, byte(len(r.Changes)))
+	for _, c := range r.Changes {
+		i := registerIndex(c.Register)
+		if i < 0 {
+			return fmt.Errorf("register %q cannot be encoded", c.Register)
+		}
+		buf = append(buf, byte(i))
+		buf = binary.LittleEndian.AppendUint16(buf, c.Old)
+		buf = binary.LittleEndian.AppendUint16(buf, c.New)
+	}
+	b.buf = buf
+	_, err := b.w.Write(buf)
+	return err
+}
+
+// BinaryReader reads records written by BinaryWriter
+type BinaryReader struct {
+	r      *bufio.Reader
+	disasm *disasm.Disassembler
+	header bool
+}
+
+// NewBinaryReader creates a binary reader
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: bufio.NewReader(r), disasm: disasm.New()}
+}
+
+// Read returns the next record, or io.EOF at the end of the trace
+func (b *BinaryReader) Read() (*Record, error) {
+	if !b.header {
+		header := make([]byte, len(binaryMagic)+1)
+		if _, err := io.ReadFull(b.r, header); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("reading trace header: %w", err)
+		}
+		if string(header[:len(binaryMagic)]) != binaryMagic {
+			return nil, fmt.Errorf("not a binary trace")
+		}
+		if header[len(binaryMagic)] != binaryVersion {
+			return nil, fmt.Errorf("unsupported trace version %d", header[len(binaryMagic)])
+		}
+		b.header = true
+	}
+
+	kind, err := b.r.ReadByte()
+	if err != nil {
+		return nil, err // io.EOF between records ends the trace
+	}
+	r := &Record{Kind: z80.StepKind(kind)}
+	if err := b.read(r); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// read decodes the rest of a record after its kind
+func (b *BinaryReader) read(r *Record) error {
+	var fixed [3]byte
+	if _, err := io.ReadFull(b.r, fixed[:]); err != nil {
+		return err
+	}
+	r.PC = binary.LittleEndian.Uint16(fixed[:])
+	code := make([]byte, fixed[2])
+	if _, err := io.ReadFull(b.r, code); err != nil {
+		return err
+	}
+	tstates, err := binary.ReadUvarint(b.r)
+	if err != nil {
+		return err
+	}
+	r.TStates = int(tstates)
+
+	count, err := b.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	for range count {
+		var change [5]byte
+		if _, err := io.ReadFull(b.r, change[:]); err != nil {
+			return err
+		}
+		if int(change[0]) >= len(registers) {
+			return fmt.Errorf("invalid register index %d", change[0])
+		}
+		r.Changes = append(r.Changes, Change{
+			Register: registers[change[0]].name,
+			Old:      binary.LittleEndian.Uint16(change[1:]),
+			New:      binary.LittleEndian.Uint16(change[3:]),
+		})
+	}
+
+	if r.Kind == z80.StepInstruction && len(code) == 0 {
+		return fmt.Errorf("instruction at %04X without opcode bytes", r.PC)
+	}
+	r.describe(b.disasm, code)
+	return nil
+}
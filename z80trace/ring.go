@@ -0,0 +1,66 @@
+package trace
+
+// Ring is a Writer that keeps only the last records, for a post-mortem dump
+// when something goes wrong
+type Ring struct {
+	records []Record
+	next    int  // Slot the next record goes to
+	full    bool // Every slot holds a record
+}
+
+// NewRing creates a ring keeping the last n records
+func NewRing(n int) *Ring {
+	return &Ring{records: make([]Record, n)}
+}
+
+// Write implements Writer
+func (r *Ring) Write(rec *Record) error {
+	if len(r.records) == 0 {
+		return nil
+	}
+	// Reuse the slot's slices, the Tracer's record is only valid during Write
+	slot := &r.records[r.next]
+	bytes, changes := append(slot.Bytes[:0], rec.Bytes...), append(slot.Changes[:0], rec.Changes...)
+	*slot = *rec
+	slot.Bytes, slot.Changes = bytes, changes
+
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+	return nil
+}
+
+// Len returns the number of records held
+func (r *Ring) Len() int {
+	if r.full {
+		return len(r.records)
+	}
+	return r.next
+}
+
+// Records returns the records held, oldest first. They stay valid until the
+// next Write.
+func (r *Ring) Records() []Record {
+	if !r.full {
+		return r.records[:r.next:r.next]
+	}
+	return append(r.records[r.next:len(r.records):len(r.records)], r.records[:r.next]...)
+}
+
+// Dump writes the records held, oldest first
+func (r *Ring) Dump(w Writer) error {
+	for _, rec := range r.Records() {
+		if err := w.Write(&rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset discards all records
+func (r *Ring) Reset() {
+	r.next = 0
+	r.full = false
+}
@@ -0,0 +1,331 @@
+// Package trace records the instructions a z80.CPU executes, with their
+// disassembly, timing and the registers they change.
+package trace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kiltum/emuz80/z80"
+	disasm "github.com/kiltum/emuz80/z80disasm"
+)
+
+// Class is a set of instruction classes used to filter a trace
+type Class uint16
+
+// Class* constants enumerate the instruction classes
+const (
+	ClassLoad       Class = 1 << iota // LD, EX, EXX
+	ClassStack                        // PUSH, POP
+	ClassArithmetic                   // ADD, ADC, SUB, SBC, INC, DEC, CP, NEG, DAA, SCF, CCF
+	ClassLogic                        // AND, OR, XOR, CPL
+	ClassShift                        // Rotates and shifts
+	ClassBit                          // BIT, SET, RES
+	ClassJump                         // JP, JR, DJNZ
+	ClassCall                         // CALL, RST
+	ClassReturn                       // RET, RETI, RETN
+	ClassBlock                        // LDIR, CPIR, INIR, OTIR and their single-step forms
+	ClassIO                           // IN, OUT
+	ClassControl                      // NOP, HALT, DI, EI, IM
+	ClassInterrupt                    // Interrupt and NMI acceptance
+	ClassOther                        // Anything not recognized
+)
+
+// classNames lists the class names in bit order
+var classNames = []string{"load", "stack", "arithmetic", "logic", "shift", "bit", "jump",
+	"call", "return", "block", "io", "control", "interrupt", "other"}
+
+// String lists the classes in the set separated by commas
+func (c Class) String() string {
+	var names []string
+	for i, name := range classNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseClass parses a comma separated list of class names
+func ParseClass(text string) (Class, error) {
+	var c Class
+	for _, name := range strings.Split(text, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		found := false
+		for i, n := range classNames {
+			if n == name {
+				c |= 1 << i
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown instruction class %q", name)
+		}
+	}
+	return c, nil
+}
+
+// operationClasses maps decoded operations to their class
+var operationClasses = map[string]Class{
+	"LD": ClassLoad, "EX": ClassLoad, "EXX": ClassLoad,
+	"PUSH": ClassStack, "POP": ClassStack,
+	"ADD": ClassArithmetic, "ADC": ClassArithmetic, "SUB": ClassArithmetic, "SBC": ClassArithmetic,
+	"INC": ClassArithmetic, "DEC": ClassArithmetic, "CP": ClassArithmetic, "NEG": ClassArithmetic,
+	"DAA": ClassArithmetic, "SCF": ClassArithmetic, "CCF": ClassArithmetic,
+	"AND": ClassLogic, "OR": ClassLogic, "XOR": ClassLogic, "CPL": ClassLogic,
+	"RLCA": ClassShift, "RRCA": ClassShift, "RLA": ClassShift, "RRA": ClassShift,
+	"RLC": ClassShift, "RRC": ClassShift, "RL": ClassShift, "RR": ClassShift,
+	"SLA": ClassShift, "SRA": ClassShift, "SLL": ClassShift, "SRL": ClassShift,
+	"RLD": ClassShift, "RRD": ClassShift,
+	"BIT": ClassBit, "SET": ClassBit, "RES": ClassBit,
+	"JP": ClassJump, "JR": ClassJump, "DJNZ": ClassJump,
+	"CALL": ClassCall, "RST": ClassCall,
+	"RET": ClassReturn, "RETI": ClassReturn, "RETN": ClassReturn,
+	"LDI": ClassBlock, "LDIR": ClassBlock, "LDD": ClassBlock, "LDDR": ClassBlock,
+	"CPI": ClassBlock, "CPIR": ClassBlock, "CPD": ClassBlock, "CPDR": ClassBlock,
+	"INI": ClassBlock, "INIR": ClassBlock, "IND": ClassBlock, "INDR": ClassBlock,
+	"OUTI": ClassBlock, "OTIR": ClassBlock, "OUTD": ClassBlock, "OTDR": ClassBlock,
+	"IN": ClassIO, "OUT": ClassIO,
+	"NOP": ClassControl, "HALT": ClassControl, "DI": ClassControl, "EI": ClassControl, "IM": ClassControl,
+}
+
+// classify returns the class of an operation
+func classify(op string) Class {
+	if c, ok := operationClasses[op]; ok {
+		return c
+	}
+	return ClassOther
+}
+
+// Range is an inclusive address range
+type Range struct {
+	Start, End uint16
+}
+
+// Contains reports whether the address is in the range
+func (r Range) Contains(address uint16) bool {
+	return address >= r.Start && address <= r.End
+}
+
+// Filter selects which steps a Tracer records
+type Filter struct {
+	Ranges  []Range // Addresses of the instructions to record, all if empty
+	Classes Class   // Classes of the instructions to record, all if zero
+}
+
+// Match reports whether the filter keeps the record
+func (f *Filter) Match(r *Record) bool {
+	if f.Classes != 0 && f.Classes&r.Class == 0 {
+		return false
+	}
+	if len(f.Ranges) == 0 {
+		return true
+	}
+	for _, rng := range f.Ranges {
+		if rng.Contains(r.PC) {
+			return true
+		}
+	}
+	return false
+}
+
+// Change is a register that an instruction changed
+type Change struct {
+	Register string
+	Old, New uint16
+}
+
+// String shows the new value, with flags spelled out for F
+func (c Change) String() string {
+	reg := registerByName(c.Register)
+	if c.Register == "F" {
+		return "F=" + flagString(byte(c.New))
+	}
+	if reg.width == 8 {
+		return fmt.Sprintf("%s=%02X", c.Register, c.New)
+	}
+	return fmt.Sprintf("%s=%04X", c.Register, c.New)
+}
+
+// flagString shows the flags as SZ5H3PNC with clear bits as dashes
+func flagString(f byte) string {
+	const names = "SZ5H3PNC"
+	b := []byte("--------")
+	for i := range 8 {
+		if f&(0x80>>i) != 0 {
+			b[i] = names[i]
+		}
+	}
+	return string(b)
+}
+
+// register describes a register compared between steps. PC and R change on
+// every instruction and MEMPTR is internal, so they are not reported.
+type register struct {
+	name  string
+	width int
+	get   func(s *z80.State) uint16
+}
+
+// registers lists the compared registers; the index is used by the binary format
+var registers = []register{
+	{"A", 8, func(s *z80.State) uint16 { return s.AF >> 8 }},
+	{"F", 8, func(s *z80.State) uint16 { return s.AF & 0xFF }},
+	{"B", 8, func(s *z80.State) uint16 { return s.BC >> 8 }},
+	{"C", 8, func(s *z80.State) uint16 { return s.BC & 0xFF }},
+	{"D", 8, func(s *z80.State) uint16 { return s.DE >> 8 }},
+	{"E", 8, func(s *z80.State) uint16 { return s.DE & 0xFF }},
+	{"H", 8, func(s *z80.State) uint16 { return s.HL >> 8 }},
+	{"L", 8, func(s *z80.State) uint16 { return s.HL & 0xFF }},
+	{"IX", 16, func(s *z80.State) uint16 { return s.IX }},
+	{"IY", 16, func(s *z80.State) uint16 { return s.IY }},
+	{"SP", 16, func(s *z80.State) uint16 { return s.SP }},
+	{"AF'", 16, func(s *z80.State) uint16 { return s.AF_ }},
+	{"BC'", 16, func(s *z80.State) uint16 { return s.BC_ }},
+	{"DE'", 16, func(s *z80.State) uint16 { return s.DE_ }},
+	{"HL'", 16, func(s *z80.State) uint16 { return s.HL_ }},
+	{"I", 8, func(s *z80.State) uint16 { return uint16(s.I) }},
+	{"IM", 8, func(s *z80.State) uint16 { return uint16(s.IM) }},
+	{"IFF1", 8, func(s *z80.State) uint16 { return boolToWord(s.IFF1) }},
+	{"IFF2", 8, func(s *z80.State) uint16 { return boolToWord(s.IFF2) }},
+}
+
+func boolToWord(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// registerIndex returns the index of a register in registers, or -1
+func registerIndex(name string) int {
+	for i, r := range registers {
+		if r.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// registerByName returns a register description, 16 bits wide if unknown
+func registerByName(name string) register {
+	if i := registerIndex(name); i >= 0 {
+		return registers[i]
+	}
+	return register{name: name, width: 16}
+}
+
+// diff lists the registers that differ between two states
+func diff(before, after *z80.State) []Change {
+	var changes []Change
+	for _, r := range registers {
+		if old, new := r.get(before), r.get(after); old != new {
+			changes = append(changes, Change{Register: r.name, Old: old, New: new})
+		}
+	}
+	return changes
+}
+
+// Record is one traced step
+type Record struct {
+	Kind     z80.StepKind // Instruction, interrupt or NMI
+	PC       uint16       // PC before the step
+	Bytes    []byte       // Opcode bytes, empty for interrupts
+	Mnemonic string
+	Class    Class
+	TStates  int
+	Changes  []Change
+}
+
+// describe fills in Bytes, Mnemonic and Class from the kind and the code at PC
+func (r *Record) describe(d *disasm.Disassembler, code []byte) {
+	switch r.Kind {
+	case z80.StepInterrupt:
+		r.Bytes, r.Mnemonic, r.Class = nil, "<interrupt>", ClassInterrupt
+		return
+	case z80.StepNMI:
+		r.Bytes, r.Mnemonic, r.Class = nil, "<nmi>", ClassInterrupt
+		return
+	}
+	inst, err := d.DecodeAt(code, r.PC)
+	if err != nil || inst.Length == 0 || inst.Length > len(code) {
+		r.Bytes, r.Mnemonic, r.Class = code[:1], fmt.Sprintf("DB $%02X", code[0]), ClassOther
+		return
+	}
+	r.Bytes, r.Mnemonic, r.Class = code[:inst.Length], inst.Mnemonic, classify(inst.Op)
+}
+
+// Writer receives the records a Tracer keeps
+type Writer interface {
+	Write(r *Record) error
+}
+
+// Tracer is a z80.Tracer that passes a Record for every step matching its
+// Filter to a Writer. The record is only valid during the Write call.
+type Tracer struct {
+	Output Writer
+	Filter Filter
+	Err    error      // First error returned by Output; tracing stops after it
+	Next   z80.Tracer // Optional tracer that keeps receiving every step
+
+	cpu    *z80.CPU
+	disasm *disasm.Disassembler
+	before z80.State
+	record Record
+	keep   bool
+	buffer [4]byte
+}
+
+// New creates a tracer writing to output
+func New(output Writer) *Tracer {
+	return &Tracer{Output: output, disasm: disasm.New()}
+}
+
+// Attach makes the tracer observe the CPU, chaining the tracer that was
+// attached before
+func (t *Tracer) Attach(cpu *z80.CPU) {
+	if cpu.Tracer != t {
+		t.Next = cpu.Tracer
+	}
+	t.cpu = cpu
+	cpu.Tracer = t
+}
+
+// Detach stops tracing and restores the original tracer
+func (t *Tracer) Detach() {
+	if t.cpu != nil && t.cpu.Tracer == t {
+		t.cpu.Tracer = t.Next
+	}
+}
+
+// BeforeStep implements z80.Tracer
+func (t *Tracer) BeforeStep(cpu *z80.CPU, kind z80.StepKind) {
+	t.record = Record{Kind: kind, PC: cpu.PC}
+	if kind == z80.StepInstruction {
+		for i := range t.buffer {
+			t.buffer[i] = cpu.Memory.ReadByte(cpu.PC + uint16(i))
+		}
+	}
+	t.record.describe(t.disasm, t.buffer[:])
+
+	t.keep = t.Err == nil && t.Filter.Match(&t.record)
+	if t.keep {
+		t.before = cpu.Snapshot()
+	}
+	if t.Next != nil {
+		t.Next.BeforeStep(cpu, kind)
+	}
+}
+
+// AfterStep implements z80.Tracer
+func (t *Tracer) AfterStep(cpu *z80.CPU, tstates int) {
+	if t.keep {
+		after := cpu.Snapshot()
+		t.record.TStates = tstates
+		t.record.Changes = diff(&t.before, &after)
+		t.Err = t.Output.Write(&t.record)
+	}
+	if t.Next != nil {
+		t.Next.AfterStep(cpu, tstates)
+	}
+}
@@ -0,0 +1,223 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kiltum/emuz80/z80"
+)
+
+// ram is a flat 64K memory.
+type ram [65536]byte
+
+func (m *ram) ReadByte(address uint16) byte         { return m[address] }
+func (m *ram) WriteByte(address uint16, value byte) { m[address] = value }
+func (m *ram) ReadWord(address uint16) uint16 {
+	return uint16(m[address]) | uint16(m[address+1])<<8
+}
+func (m *ram) WriteWord(address uint16, value uint16) {
+	m[address] = byte(value)
+	m[address+1] = byte(value >> 8)
+}
+
+// ports raises an interrupt on request.
+type ports struct {
+	interrupt bool
+}
+
+func (p *ports) ReadPort(port uint16) byte         { return 0xFF }
+func (p *ports) WritePort(port uint16, value byte) {}
+func (p *ports) CheckInterrupt() bool              { return p.interrupt }
+
+// program: LD A,12; LD HL,8000; CALL 0010; HALT; ... 0010: ADD A,A; RET
+func testCPU(t *testing.T, output Writer) (*z80.CPU, *Tracer, *ports) {
+	t.Helper()
+	mem := &ram{}
+	copy(mem[:], []byte{0x3E, 0x12, 0x21, 0x00, 0x80, 0xCD, 0x10, 0x00, 0x76})
+	copy(mem[0x10:], []byte{0x87, 0xC9})
+	io := &ports{}
+	cpu := z80.New(mem, io)
+	cpu.SP = 0xFFFF
+	tracer := New(output)
+	tracer.Attach(cpu)
+	return cpu, tracer, io
+}
+
+func run(cpu *z80.CPU, steps int) {
+	for range steps {
+		cpu.ExecuteOneInstruction()
+	}
+}
+
+func TestText(t *testing.T) {
+	var out bytes.Buffer
+	cpu, _, _ := testCPU(t, NewTextWriter(&out))
+	run(cpu, 5)
+
+	want := []string{
+		"0000  3E 12        LD A, $12              7  A=12",
+		"0002  21 00 80     LD HL, $8000          10  H=80",
+		"0005  CD 10 00     CALL $0010            17  SP=FFFD",
+		"0010  87           ADD A, A               4  A=24 F=--5-----",
+		"0011  C9           RET                   10  SP=FFFF",
+	}
+	got := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines:\n%s", len(got), out.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d:\n got %q\nwant %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ring := NewRing(10)
+	cpu, tracer, _ := testCPU(t, ring)
+	tracer.Filter = Filter{Ranges: []Range{{0x0010, 0x001F}}}
+	run(cpu, 5)
+	assertMnemonics(t, ring, "ADD A, A", "RET")
+
+	ring.Reset()
+	cpu.PC = 0
+	tracer.Filter = Filter{Classes: ClassCall | ClassReturn}
+	run(cpu, 5)
+	assertMnemonics(t, ring, "CALL $0010", "RET")
+}
+
+func assertMnemonics(t *testing.T, ring *Ring, want ...string) {
+	t.Helper()
+	var got []string
+	for _, r := range ring.Records() {
+		got = append(got, r.Mnemonic)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Two tracers attached to one CPU both see every step, each with its own
+// filter, and detaching the outer one restores the inner one.
+func TestChained(t *testing.T) {
+	inner := NewRing(10)
+	cpu, first, _ := testCPU(t, inner)
+	outer := NewRing(10)
+	second := New(outer)
+	second.Filter = Filter{Classes: ClassCall | ClassReturn}
+	second.Attach(cpu)
+	assertEq(t, second.Next, z80.Tracer(first), "next")
+
+	run(cpu, 5)
+	assertEq(t, inner.Len(), 5, "inner records")
+	assertMnemonics(t, outer, "CALL $0010", "RET")
+
+	second.Detach()
+	assertEq(t, cpu.Tracer, z80.Tracer(first), "tracer after detach")
+}
+
+// The ring keeps the last records, oldest first.
+func TestRing(t *testing.T) {
+	ring := NewRing(3)
+	cpu, _, _ := testCPU(t, ring)
+	run(cpu, 2)
+	assertEq(t, ring.Len(), 2, "length before wrapping")
+	run(cpu, 3)
+	assertEq(t, ring.Len(), 3, "length after wrapping")
+	assertMnemonics(t, ring, "CALL $0010", "ADD A, A", "RET")
+
+	var out bytes.Buffer
+	if err := ring.Dump(NewTextWriter(&out)); err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, strings.Count(out.String(), "\n"), 3, "dumped lines")
+	if ring.Records()[0].Bytes[0] != 0xCD {
+		t.Errorf("ring records share the tracer buffer")
+	}
+}
+
+func TestInterruptRecord(t *testing.T) {
+	ring := NewRing(4)
+	cpu, _, dev := testCPU(t, ring)
+	cpu.IFF1, cpu.IFF2, cpu.IM = true, true, 1
+	dev.interrupt = true
+	run(cpu, 1)
+
+	r := ring.Records()[0]
+	assertEq(t, r.Kind, z80.StepInterrupt, "kind")
+	assertEq(t, r.Mnemonic, "<interrupt>", "mnemonic")
+	assertEq(t, r.Class, ClassInterrupt, "class")
+	assertEq(t, len(r.Bytes), 0, "bytes")
+	assertEq(t, changeString(r.Changes), "SP=FFFD IFF1=00 IFF2=00", "changes")
+}
+
+func TestCSV(t *testing.T) {
+	var out bytes.Buffer
+	w := NewCSVWriter(&out)
+	cpu, _, _ := testCPU(t, w)
+	run(cpu, 1)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "kind,pc,bytes,mnemonic,class,tstates,changes\ninstruction,0000,3E 12,\"LD A, $12\",load,7,A=12\n"
+	assertEq(t, out.String(), want, "csv")
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	var text, data bytes.Buffer
+	ring := NewRing(16)
+	cpu, _, dev := testCPU(t, ring)
+	run(cpu, 5)
+	cpu.IFF1, cpu.IM = true, 1
+	dev.interrupt = true
+	run(cpu, 1)
+
+	bw := NewBinaryWriter(&data)
+	if err := ring.Dump(bw); err != nil {
+		t.Fatal(err)
+	}
+	ring.Dump(NewTextWriter(&text))
+
+	var back bytes.Buffer
+	tw := NewTextWriter(&back)
+	br := NewBinaryReader(&data)
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(r)
+	}
+	assertEq(t, back.String(), text.String(), "text after binary round trip")
+
+	if _, err := NewBinaryReader(strings.NewReader("Z80T\x01\x00\x00")).Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated record: %v", err)
+	}
+	if _, err := NewBinaryReader(strings.NewReader("nope!")).Read(); err == nil {
+		t.Errorf("bad magic accepted")
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	c, err := ParseClass("jump, Call")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(t, c, ClassJump|ClassCall, "classes")
+	assertEq(t, c.String(), "jump,call", "string")
+	if _, err := ParseClass("jump,bogus"); err == nil {
+		t.Errorf("unknown class accepted")
+	}
+}
+
+func assertEq[T comparable](t *testing.T, got, want T, msg string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %v, want %v", msg, got, want)
+	}
+}
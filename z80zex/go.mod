@@ -2,6 +2,14 @@ module z80zex
 
 go 1.25.1
 
-replace z80 => ../z80
+require (
+	github.com/kiltum/emuz80/z80 v0.0.0
+	github.com/kiltum/emuz80/z80loader v0.0.0
+)
 
-require z80 v0.0.0
+replace (
+	github.com/kiltum/emuz80/z80 => ../z80
+	github.com/kiltum/emuz80/z80asm => ../z80asm
+	github.com/kiltum/emuz80/z80disasm => ../z80disasm
+	github.com/kiltum/emuz80/z80loader => ../z80loader
+)
@@ -2,9 +2,9 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 
-	"z80"
+	"github.com/kiltum/emuz80/z80"
+	loader "github.com/kiltum/emuz80/z80loader"
 )
 
 // Memory64K represents 64KB of memory
@@ -93,23 +93,11 @@ func handleBDOSCall(cpu *z80.CPU, memory *Memory64K) {
 
 // loadZEXALL loads the zexall.com file into memory at address 0x100
 func loadZEXALL(memory *Memory64K, filename string) error {
-	// Load file data
-	data, err := ioutil.ReadFile(filename)
+	image, err := loader.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to read file %s: %v", filename, err)
+		return fmt.Errorf("failed to load %s: %v", filename, err)
 	}
-
-	// Load into memory at address 0x100
-	loadAddress := uint16(0x100)
-	for i, b := range data {
-		addr := uint32(loadAddress) + uint32(i)
-		if addr < 0x10000 {
-			memory.data[uint16(addr)] = b
-		} else {
-			break // Memory overflow protection
-		}
-	}
-
+	image.Load(memory)
 	return nil
 }
 